@@ -0,0 +1,29 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// ENV_AUDIT_LOG_FILE names the file that every configuration change is appended
+// to, apart from the log.
+const ENV_AUDIT_LOG_FILE = "AUDIT_LOG_FILE"
+
+// openAuditLog opens the file named in AUDIT_LOG_FILE for appending an entry for
+// every UAPI set operation. The file is never rotated or truncated. It returns
+// nil if the variable is not set.
+func openAuditLog() (*os.File, error) {
+	path := os.Getenv(ENV_AUDIT_LOG_FILE)
+	if path == "" {
+		return nil, nil
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		os.MkdirAll(dir, 0o755)
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+}
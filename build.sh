@@ -34,6 +34,7 @@ show_help() {
     echo "  build         - 构建当前平台"
     echo "  build-all     - 构建所有平台"
     echo "  build-tools   - 构建命令行工具"
+    echo "  build-libwg   - 构建 C 共享库 libwg"
     echo "  clean         - 清理构建文件"
     echo "  test          - 运行测试"
     echo "  deps          - 安装依赖"
@@ -109,6 +110,23 @@ build_tools() {
     cd ../..
 }
 
+# 构建 C 共享库
+build_libwg() {
+    echo -e "${YELLOW}📚 构建 C 共享库...${NC}"
+
+    local lib=libwg.so
+    if [ "$OS" = "darwin" ]; then
+        lib=libwg.dylib
+    fi
+    CGO_ENABLED=1 go build -buildmode=c-shared -o "$lib" ./libwg
+    if [ $? -eq 0 ]; then
+        echo -e "${GREEN}✅ 共享库构建完成: $lib, libwg.h${NC}"
+    else
+        echo -e "${RED}❌ 共享库构建失败${NC}"
+        exit 1
+    fi
+}
+
 # 清理
 clean() {
     echo -e "${YELLOW}🧹 清理构建文件...${NC}"
@@ -116,6 +134,7 @@ clean() {
     rm -f wireguard-go-windows.exe wireguard-go-linux wireguard-go-macos
     rm -f cmd/wg-go/wg-go cmd/wg-go/wg-go.exe
     rm -f cmd/wg-go/wg-go-windows.exe cmd/wg-go/wg-go-linux cmd/wg-go/wg-go-macos
+    rm -f libwg.so libwg.dylib libwg.h
     rm -f *.log
     echo -e "${GREEN}✅ 清理完成${NC}"
 }
@@ -157,6 +176,9 @@ main() {
         "build-tools")
             build_tools
             ;;
+        "build-libwg")
+            build_libwg
+            ;;
         "clean")
             clean
             ;;
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// vendorPrefix starts the keys wireguard-go adds to the configuration
+// protocol; older daemons report and take them without it
+const vendorPrefix = "wggo_"
+
+// Capabilities describes what a daemon supports beyond the configuration
+// protocol, as reported by the capabilities operation. Daemons predating it
+// report nothing, and may support any extension under its unprefixed name.
+type Capabilities struct {
+	Reported     bool
+	Version      string
+	VendorPrefix string
+	Operations   []string
+	GetFilters   []string
+	DeviceKeys   []string // with the vendor prefix
+	PeerKeys     []string // with the vendor prefix
+}
+
+// Get the capabilities of the daemon of an interface
+func getCapabilities(interfaceName string) (*Capabilities, error) {
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	caps := &Capabilities{}
+	response, err := sendUAPICommand(conn, "capabilities=1\n")
+	if err != nil {
+		// daemons without the operation hang up on it
+		return caps, nil
+	}
+	for _, line := range strings.Split(response, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "version":
+			caps.Version = value
+		case "vendor_prefix":
+			caps.VendorPrefix = value
+		case "operation":
+			caps.Operations = append(caps.Operations, value)
+		case "get_filter":
+			caps.GetFilters = append(caps.GetFilters, value)
+		case "device_key":
+			caps.DeviceKeys = append(caps.DeviceKeys, value)
+		case "peer_key":
+			caps.PeerKeys = append(caps.PeerKeys, value)
+		}
+	}
+	caps.Reported = len(caps.Operations) > 0
+	return caps, nil
+}
+
+// Get the capabilities of the daemon of an interface, or exit
+func interfaceCapabilities(interfaceName string) *Capabilities {
+	caps, err := getCapabilities(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+	return caps
+}
+
+// key returns the name to send the extension key name under
+func (c *Capabilities) key(name string) string {
+	return c.VendorPrefix + name
+}
+
+// hasKey reports whether the daemon may take or report the extension key name,
+// of the device or of its peers
+func (c *Capabilities) hasKey(name string) bool {
+	if !c.Reported {
+		return true
+	}
+	return slices.Contains(c.DeviceKeys, c.key(name)) || slices.Contains(c.PeerKeys, c.key(name))
+}
+
+// hasOperation reports whether the daemon may support the operation
+func (c *Capabilities) hasOperation(operation string) bool {
+	return !c.Reported || slices.Contains(c.Operations, operation)
+}
+
+// Exit unless the daemon of an interface may support a feature
+func (c *Capabilities) require(interfaceName, feature string, supported bool) {
+	if supported {
+		return
+	}
+	daemon := "the daemon"
+	if c.Version != "" {
+		daemon = "wireguard-go " + c.Version
+	}
+	fmt.Fprintf(os.Stderr, "❌ %s is not supported by %s running %s\n", feature, daemon, interfaceName)
+	os.Exit(1)
+}
+
+// Handle 'capabilities' command - show what the daemon of an interface supports
+func handleCapabilities(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go capabilities <interface>\n")
+		os.Exit(1)
+	}
+	interfaceName := args[0]
+
+	caps := interfaceCapabilities(interfaceName)
+	if !caps.Reported {
+		fmt.Printf("%s: the daemon does not report its capabilities (older than the capabilities operation)\n", interfaceName)
+		return
+	}
+	version := caps.Version
+	if version == "" {
+		version = "unknown"
+	}
+	fmt.Printf("interface: %s\n", interfaceName)
+	fmt.Printf("  daemon version: %s\n", version)
+	fmt.Printf("  vendor prefix: %s\n", caps.VendorPrefix)
+	printWrapped("operations", caps.Operations)
+	printWrapped("get filters", caps.GetFilters)
+	printWrapped("device keys", caps.DeviceKeys)
+	printWrapped("peer keys", caps.PeerKeys)
+}
+
+// Print a labeled list, wrapped to fit a terminal
+func printWrapped(label string, items []string) {
+	if len(items) == 0 {
+		return
+	}
+	line := fmt.Sprintf("  %s:", label)
+	for i, item := range items {
+		if i < len(items)-1 {
+			item += ","
+		}
+		if len(line)+1+len(item) > 78 {
+			fmt.Println(line)
+			line = "   "
+		}
+		line += " " + item
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Handle 'capture' command - record the packets of an interface to a pcapng file
+func handleCapture(args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go capture <interface> [-w FILE] [--peer KEY]... [--outer] [--max-bytes SIZE] [--duration SECONDS]\n")
+		os.Exit(1)
+	}
+	if len(args) < 1 || strings.HasPrefix(args[0], "-") {
+		usage()
+	}
+	interfaceName := args[0]
+
+	var file string
+	var peers []string
+	var outer bool
+	var maxBytes uint64
+	var duration time.Duration
+	for i := 1; i < len(args); i++ {
+		option := args[i]
+		if option == "--outer" {
+			outer = true
+			continue
+		}
+		if i+1 >= len(args) {
+			usage()
+		}
+		i++
+		value := args[i]
+		switch option {
+		case "-w":
+			file = value
+		case "--peer":
+			key, err := parsePeerKey(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Invalid peer public key '%s': %v\n", value, err)
+				os.Exit(1)
+			}
+			peers = append(peers, "peer:"+key.Hex())
+		case "--max-bytes":
+			size, err := parseByteSize(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Invalid size '%s': %v\n", value, err)
+				os.Exit(1)
+			}
+			maxBytes = size
+		case "--duration":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				fmt.Fprintf(os.Stderr, "Error: Invalid duration '%s'\n", value)
+				os.Exit(1)
+			}
+			duration = time.Duration(seconds) * time.Second
+		default:
+			usage()
+		}
+	}
+
+	if file == "" {
+		file = fmt.Sprintf("%s-%s.pcapng", interfaceName, time.Now().Format("20060102-150405"))
+	}
+	// The daemon opens the file, so relative paths would resolve against its
+	// working directory
+	file, err := filepath.Abs(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	filter := peers
+	if outer {
+		filter = append(filter, "outer")
+	}
+
+	caps := interfaceCapabilities(interfaceName)
+	caps.require(interfaceName, "Packet capture", caps.hasKey("capture_file"))
+
+	var command strings.Builder
+	command.WriteString("set=1\n")
+	command.WriteString(fmt.Sprintf("%s=%s\n", caps.key("capture_file"), file))
+	command.WriteString(fmt.Sprintf("%s=%s\n", caps.key("capture_filter"), strings.Join(filter, ",")))
+	command.WriteString(fmt.Sprintf("%s=%d\n", caps.key("capture_max_bytes"), maxBytes))
+	if err := sendCaptureCommand(interfaceName, command.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to start capture on %s: %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Capturing %s to %s (Ctrl+C to stop)...\n", interfaceName, file)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var deadline <-chan time.Time
+	if duration > 0 {
+		deadline = time.After(duration)
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// The daemon stops by itself at the size limit, on write errors, or when
+	// another capture replaces this one
+	var last *CaptureInfo
+	for running := true; running; {
+		select {
+		case <-signals:
+			running = false
+		case <-deadline:
+			running = false
+		case <-ticker.C:
+			info, err := getDeviceInfo(interfaceName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ Lost interface %s: %v\n", interfaceName, err)
+				os.Exit(1)
+			}
+			if info.Capture == nil || info.Capture.File != file {
+				fmt.Printf("Capture stopped by the daemon\n")
+				printCaptureSummary(file, last)
+				return
+			}
+			last = info.Capture
+		}
+	}
+	signal.Stop(signals)
+
+	if info, err := getDeviceInfo(interfaceName); err == nil {
+		if info.Capture == nil || info.Capture.File != file {
+			fmt.Printf("Capture stopped by the daemon\n")
+			printCaptureSummary(file, last)
+			return
+		}
+		last = info.Capture
+	}
+	if err := sendCaptureCommand(interfaceName, fmt.Sprintf("set=1\n%s=\n", caps.key("capture_file"))); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to stop capture on %s: %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+	printCaptureSummary(file, last)
+}
+
+// Send a capture command to the daemon of an interface
+func sendCaptureCommand(interfaceName, command string) error {
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = sendUAPICommand(conn, command)
+	return err
+}
+
+// Print how much a finished capture recorded
+func printCaptureSummary(file string, info *CaptureInfo) {
+	if stat, err := os.Stat(file); err == nil && info != nil {
+		fmt.Printf("✅ Captured %d packets (%s) to %s\n", info.Packets, formatBytes(stat.Size()), file)
+	} else {
+		fmt.Printf("✅ Capture written to %s\n", file)
+	}
+}
+
+// Print the capture in progress on an interface, if any
+func printCapture(info *InterfaceInfo) {
+	c := info.Capture
+	if c == nil {
+		return
+	}
+	line := fmt.Sprintf("  capture: writing to %s (%d packets, %s", c.File, c.Packets, formatBytes(c.Bytes))
+	if c.MaxBytes != 0 {
+		line += " of " + formatBytes(c.MaxBytes)
+	}
+	line += ")"
+	if c.Filter != "" {
+		line += ", filter " + c.Filter
+	}
+	fmt.Println(line)
+}
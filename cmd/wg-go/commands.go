@@ -6,9 +6,12 @@ import (
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 )
 
 // Handle 'genkey' command - generate a new private key
@@ -80,13 +83,26 @@ func handleShow(args []string) {
 		return
 	}
 
+	showStats := false
+	if len(args) > 0 && args[0] == "--stats" {
+		showStats = true
+		args = args[1:]
+	}
+	if len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go show [--stats] [interface [peer]]\n")
+		os.Exit(1)
+	}
+
 	if len(args) == 0 {
 		// Show all interfaces
-		showAllInterfaces()
-	} else {
+		showAllInterfaces(showStats)
+	} else if len(args) == 1 {
 		// Show specific interface
 		interfaceName := args[0]
-		showInterface(interfaceName)
+		showInterface(interfaceName, showStats)
+	} else {
+		// Show one peer of an interface
+		showPeer(args[0], args[1], showStats)
 	}
 }
 
@@ -144,14 +160,14 @@ func checkWireGuardAccessWindows() bool {
 		fmt.Printf("   To start an interface: %s\n", getStartCommand())
 		return false
 	}
-	
+
 	if len(interfaces) == 0 {
 		fmt.Println("🚫 No WireGuard interfaces found")
 		fmt.Println("💡 Make sure WireGuard interfaces are running.")
 		fmt.Printf("   To start an interface: %s\n", getStartCommand())
 		return false
 	}
-	
+
 	return true
 }
 
@@ -277,7 +293,7 @@ func handleShowconf(args []string) {
 }
 
 // Show all WireGuard interfaces
-func showAllInterfaces() {
+func showAllInterfaces(showStats bool) {
 	interfaces, err := discoverInterfaces()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error discovering interfaces: %v\n", err)
@@ -301,12 +317,12 @@ func showAllInterfaces() {
 			continue
 		}
 
-		printInterfaceInfo(info, false)
+		printInterfaceInfo(info, false, showStats)
 	}
 }
 
 // Show specific WireGuard interface
-func showInterface(name string) {
+func showInterface(name string, showStats bool) {
 	info, err := getInterfaceInfo(name)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error getting interface info: %v\n", err)
@@ -314,12 +330,35 @@ func showInterface(name string) {
 		return
 	}
 
-	printInterfaceInfo(info, false)
+	printInterfaceInfo(info, false, showStats)
+}
+
+// Show one peer of a WireGuard interface, without reading the others
+func showPeer(name, peerKey string, showStats bool) {
+	key, err := parsePeerKey(peerKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: Invalid peer public key '%s': %v\n", peerKey, err)
+		os.Exit(1)
+	}
+
+	info, err := getPeerInfo(name, key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error getting interface info: %v\n", err)
+		fmt.Printf("Make sure interface '%s' is running with: %s\n", name, strings.Replace(getStartCommand(), "<interface-name>", name, 1))
+		return
+	}
+	if len(info.Peers) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: Interface '%s' has no peer %s\n", name, key.String())
+		os.Exit(1)
+	}
+
+	printInterfaceInfo(info, false, showStats)
 }
 
 // Configuration structures
 type Config struct {
 	Interface InterfaceConfig
+	Firewall  *FirewallConfig // nil without a [Firewall] section
 	Peers     []PeerConfig
 }
 
@@ -336,12 +375,37 @@ type InterfaceConfig struct {
 	PostDown   []string
 }
 
+type FirewallConfig struct {
+	Policy string   // accept or drop
+	Rules  []string // validated by the daemon
+}
+
 type PeerConfig struct {
 	PublicKey           string
 	PresharedKey        string
 	Endpoint            string
 	AllowedIPs          []string
 	PersistentKeepalive int
+	TxRateLimit         uint64 // bits per second, 0 if unlimited
+	RxRateLimit         uint64 // bits per second, 0 if unlimited
+	DailyQuota          uint64 // bytes, 0 if unlimited
+	MonthlyQuota        uint64 // bytes, 0 if unlimited
+}
+
+// Check that the daemon supports the extensions the configuration uses
+func (config *Config) checkCapabilities(caps *Capabilities) error {
+	if config.Firewall != nil && !caps.hasKey("firewall_policy") {
+		return fmt.Errorf("[Firewall] is not supported by the daemon")
+	}
+	for _, peer := range config.Peers {
+		if (peer.TxRateLimit > 0 || peer.RxRateLimit > 0) && !caps.hasKey("tx_rate_limit") {
+			return fmt.Errorf("TxRateLimit and RxRateLimit are not supported by the daemon")
+		}
+		if (peer.DailyQuota > 0 || peer.MonthlyQuota > 0) && !caps.hasKey("daily_quota") {
+			return fmt.Errorf("DailyQuota and MonthlyQuota are not supported by the daemon")
+		}
+	}
+	return nil
 }
 
 // Parse WireGuard configuration file
@@ -371,6 +435,9 @@ func parseConfigFile(filename string) (*Config, error) {
 			section := strings.ToLower(line[1 : len(line)-1])
 			currentSection = section
 
+			if section == "firewall" && config.Firewall == nil {
+				config.Firewall = &FirewallConfig{Policy: "accept"}
+			}
 			if section == "peer" {
 				currentPeer = &PeerConfig{}
 				config.Peers = append(config.Peers, *currentPeer)
@@ -394,6 +461,11 @@ func parseConfigFile(filename string) (*Config, error) {
 			if err != nil {
 				return nil, fmt.Errorf("error parsing interface option %s: %v", key, err)
 			}
+		case "firewall":
+			err := parseFirewallOption(config.Firewall, key, value)
+			if err != nil {
+				return nil, fmt.Errorf("error parsing firewall option %s: %v", key, err)
+			}
 		case "peer":
 			if currentPeer != nil {
 				err := parsePeerOption(currentPeer, key, value)
@@ -411,6 +483,20 @@ func parseConfigFile(filename string) (*Config, error) {
 	return config, nil
 }
 
+// Parse firewall configuration option
+func parseFirewallOption(firewall *FirewallConfig, key, value string) error {
+	switch strings.ToLower(key) {
+	case "policy":
+		if value != "accept" && value != "drop" {
+			return fmt.Errorf("invalid policy %q", value)
+		}
+		firewall.Policy = value
+	case "rule":
+		firewall.Rules = append(firewall.Rules, value)
+	}
+	return nil
+}
+
 // Parse interface configuration option
 func parseInterfaceOption(iface *InterfaceConfig, key, value string) error {
 	switch strings.ToLower(key) {
@@ -492,96 +578,109 @@ func parsePeerOption(peer *PeerConfig, key, value string) error {
 			return fmt.Errorf("invalid persistent keepalive value: %v", err)
 		}
 		peer.PersistentKeepalive = keepalive
+	case "txratelimit":
+		rate, err := parseBitRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid TxRateLimit value: %v", err)
+		}
+		peer.TxRateLimit = rate
+	case "rxratelimit":
+		rate, err := parseBitRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid RxRateLimit value: %v", err)
+		}
+		peer.RxRateLimit = rate
+	case "dailyquota":
+		size, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid DailyQuota value: %v", err)
+		}
+		peer.DailyQuota = size
+	case "monthlyquota":
+		size, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid MonthlyQuota value: %v", err)
+		}
+		peer.MonthlyQuota = size
 	}
 	return nil
 }
 
 // Handle 'dns' command - manage DNS monitoring settings
 func handleDNS(args []string) {
+	const usage = "Usage: wg-go dns <interface> [show|resolve [peer]|interval_seconds]\n"
+
 	if len(args) < 1 {
 		fmt.Fprintf(os.Stderr, "Error: DNS command requires interface name\n")
-		fmt.Fprintf(os.Stderr, "Usage: wg-go dns <interface> [show|interval_seconds]\n")
+		fmt.Fprint(os.Stderr, usage)
 		os.Exit(1)
 	}
 
 	interfaceName := args[0]
-
-	// Connect to the interface via UAPI
-	conn, err := connectToInterface(interfaceName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+	caps := interfaceCapabilities(interfaceName)
+	if !caps.hasKey("dns_monitor_interval") {
+		fmt.Fprintf(os.Stderr, "❌ DNS monitoring not supported: the daemon running %s does not include\n", interfaceName)
+		fmt.Fprintf(os.Stderr, "   dynamic DNS functionality.\n")
 		os.Exit(1)
 	}
-	defer conn.Close()
 
 	if len(args) == 1 || (len(args) == 2 && args[1] == "show") {
 		// Show current DNS monitoring status
-		showDNSMonitoringStatus(conn, interfaceName)
-	} else if len(args) == 2 {
-		// Set DNS monitoring interval
-		intervalStr := args[1]
-		interval, err := strconv.Atoi(intervalStr)
-		if err != nil || interval <= 0 {
-			fmt.Fprintf(os.Stderr, "Error: Invalid interval '%s'. Must be a positive number of seconds.\n", intervalStr)
+		showDNSMonitoringStatus(interfaceName)
+		return
+	}
+
+	if args[1] == "resolve" {
+		if len(args) > 3 {
+			fmt.Fprintf(os.Stderr, "Error: Too many arguments for DNS resolve command\n")
+			fmt.Fprint(os.Stderr, usage)
 			os.Exit(1)
 		}
+		var peerKey string
+		if len(args) == 3 {
+			peerKey = args[2]
+		}
+		resolveDNSNow(caps, interfaceName, peerKey)
+		return
+	}
 
-		setDNSMonitoringInterval(conn, interfaceName, interval)
-	} else {
+	if len(args) > 2 {
 		fmt.Fprintf(os.Stderr, "Error: Too many arguments for DNS command\n")
-		fmt.Fprintf(os.Stderr, "Usage: wg-go dns <interface> [show|interval_seconds]\n")
+		fmt.Fprint(os.Stderr, usage)
 		os.Exit(1)
 	}
-}
 
-// Show DNS monitoring status for an interface
-func showDNSMonitoringStatus(conn net.Conn, interfaceName string) {
-	fmt.Printf("DNS Monitoring Status for %s:\n", interfaceName)
-	fmt.Println(strings.Repeat("=", 40))
+	// Set DNS monitoring interval
+	intervalStr := args[1]
+	interval, err := strconv.Atoi(intervalStr)
+	if err != nil || interval <= 0 {
+		fmt.Fprintf(os.Stderr, "Error: Invalid interval '%s'. Must be a positive number of seconds.\n", intervalStr)
+		os.Exit(1)
+	}
 
-	// Request interface status via UAPI
-	_, err := fmt.Fprintf(conn, "get=1\n\n")
+	// Connect to the interface via UAPI
+	conn, err := connectToInterface(interfaceName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error requesting status: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
 	}
+	defer conn.Close()
 
-	// Parse response
-	scanner := bufio.NewScanner(conn)
-	var dnsInterval, monitoredPeers int
-	var hasDNSInfo bool
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if line == "" {
-			break // End of response
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
+	setDNSMonitoringInterval(conn, caps, interfaceName, interval)
+}
 
-		key, value := parts[0], parts[1]
-		switch key {
-		case "dns_monitor_interval":
-			if interval, err := strconv.Atoi(value); err == nil {
-				dnsInterval = interval
-				hasDNSInfo = true
-			}
-		case "dns_monitored_peers":
-			if peers, err := strconv.Atoi(value); err == nil {
-				monitoredPeers = peers
-			}
-		}
+// Show DNS monitoring status for an interface
+func showDNSMonitoringStatus(interfaceName string) {
+	info, err := getInterfaceInfo(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
 	}
 
-	if err := scanner.Err(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading response: %v\n", err)
-		return
-	}
+	fmt.Printf("DNS Monitoring Status for %s:\n", interfaceName)
+	fmt.Println(strings.Repeat("=", 40))
 
-	if !hasDNSInfo {
+	if info.DNSMonitorInterval == 0 {
 		fmt.Printf("❌ DNS monitoring is not available for this interface\n")
 		fmt.Printf("   This may be because the interface is not running or\n")
 		fmt.Printf("   DNS monitoring is not enabled.\n")
@@ -590,26 +689,131 @@ func showDNSMonitoringStatus(conn net.Conn, interfaceName string) {
 
 	// Display DNS monitoring information
 	fmt.Printf("✅ DNS monitoring is active\n")
-	fmt.Printf("📅 Check interval: %d seconds\n", dnsInterval)
-	fmt.Printf("👥 Monitored peers: %d\n", monitoredPeers)
+	fmt.Printf("📅 Check interval: %d seconds\n", info.DNSMonitorInterval)
+	fmt.Printf("👥 Monitored peers: %d\n", info.DNSMonitoredPeers)
 
-	if monitoredPeers == 0 {
+	if info.DNSMonitoredPeers == 0 {
 		fmt.Printf("\n💡 No peers with domain endpoints are currently configured.\n")
 		fmt.Printf("   To monitor domain endpoints, add peers with domain names like:\n")
 		fmt.Printf("   Endpoint = vpn.example.com:51820\n")
+		return
+	}
+
+	fmt.Println()
+	printDNSPeerTable(info)
+
+	fmt.Printf("\n🔄 To re-check now, use: wg-go dns %s resolve [peer]\n", interfaceName)
+}
+
+// Print a table of the DNS monitor state of each monitored peer
+func printDNSPeerTable(info *InterfaceInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PEER\tHOSTNAME\tPORT\tRESOLVED IP\tCURRENT ENDPOINT\tLAST CHECK\tFAILURES")
+
+	for _, peer := range info.Peers {
+		if peer.DNS == nil {
+			continue
+		}
+
+		peerKey := peer.PublicKey
+		if key, err := parsePeerKey(peer.PublicKey); err == nil {
+			peerKey = key.String()
+		}
+
+		resolvedIP := peer.DNS.LastResolvedIP
+		if resolvedIP == "" {
+			resolvedIP = "(unresolved)"
+		}
+
+		endpoint := peer.Endpoint
+		if endpoint == "" {
+			endpoint = "(none)"
+		}
+
+		lastCheck := "never"
+		if t := peer.DNS.LastCheckTime(); !t.IsZero() {
+			lastCheck = fmt.Sprintf("%s ago", formatDuration(time.Since(t)))
+		}
+
+		failures := strconv.Itoa(peer.DNS.ResolutionFailures)
+		if peer.DNS.ResolutionFailures > 0 {
+			failures += " ⚠️"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			peerKey, peer.DNS.Hostname, peer.DNS.Port, resolvedIP, endpoint, lastCheck, failures)
+	}
+
+	w.Flush()
+}
+
+// Trigger an immediate DNS re-check for one peer, or for all monitored peers
+func resolveDNSNow(caps *Capabilities, interfaceName, peerKey string) {
+	var command strings.Builder
+	command.WriteString("set=1\n")
+
+	if peerKey == "" {
+		fmt.Printf("Re-resolving all DNS monitored peers on %s...\n", interfaceName)
+		command.WriteString(fmt.Sprintf("%s=true\n", caps.key("dns_resolve")))
 	} else {
-		fmt.Printf("\n🔍 Monitoring %d peer(s) with domain-based endpoints\n", monitoredPeers)
+		key, err := parsePeerKey(peerKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid peer public key '%s': %v\n", peerKey, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Re-resolving DNS endpoint of peer %s on %s...\n", key.String(), interfaceName)
+		command.WriteString(fmt.Sprintf("public_key=%s\nupdate_only=true\n%s=true\n", key.Hex(), caps.key("dns_resolve")))
+	}
+	command.WriteString("\n")
+
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := io.WriteString(conn, command.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending command: %v\n", err)
+		os.Exit(1)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "errno=") {
+			errno := strings.TrimPrefix(line, "errno=")
+			if errno != "0" {
+				if peerKey != "" {
+					fmt.Fprintf(os.Stderr, "❌ Peer does not exist or has no DNS monitored endpoint (errno=%s)\n", errno)
+				} else {
+					fmt.Fprintf(os.Stderr, "❌ DNS re-resolution failed: errno=%s\n", errno)
+				}
+				os.Exit(1)
+			}
+			break
+		}
 	}
 
-	fmt.Printf("\n📊 For detailed monitoring, use: wg-go monitor %s\n", interfaceName)
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading response: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The daemon resolves in the background, so the status may not show the
+	// result yet
+	fmt.Printf("✅ DNS check requested\n\n")
+	showDNSMonitoringStatus(interfaceName)
 }
 
 // Set DNS monitoring interval
-func setDNSMonitoringInterval(conn net.Conn, interfaceName string, intervalSeconds int) {
+func setDNSMonitoringInterval(conn net.Conn, caps *Capabilities, interfaceName string, intervalSeconds int) {
 	fmt.Printf("Setting DNS monitoring interval for %s to %d seconds...\n", interfaceName, intervalSeconds)
 
 	// Send UAPI command to set DNS monitoring interval
-	_, err := fmt.Fprintf(conn, "set=1\ndns_monitor_interval=%d\n\n", intervalSeconds)
+	_, err := fmt.Fprintf(conn, "set=1\n%s=%d\n\n", caps.key("dns_monitor_interval"), intervalSeconds)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error sending configuration: %v\n", err)
 		return
@@ -622,13 +826,12 @@ func setDNSMonitoringInterval(conn net.Conn, interfaceName string, intervalSecon
 		if strings.HasPrefix(line, "errno=") {
 			errno := strings.TrimPrefix(line, "errno=")
 			if errno != "0" {
-				if errno == "-22" {
-					fmt.Fprintf(os.Stderr, "❌ DNS monitoring not supported: This interface was not created with DNS monitoring support.\n")
-					fmt.Fprintf(os.Stderr, "   The interface may have been created by a different WireGuard implementation\n")
-					fmt.Fprintf(os.Stderr, "   that doesn't include dynamic DNS functionality.\n")
-					fmt.Fprintf(os.Stderr, "\n💡 To use DNS monitoring:\n")
-					fmt.Fprintf(os.Stderr, "   1. Stop the current interface\n")
-					fmt.Fprintf(os.Stderr, "   2. Create a new interface with this enhanced wg-go version\n")
+				if errno == "-22" && caps.Reported {
+					fmt.Fprintf(os.Stderr, "❌ Invalid DNS monitoring interval: the daemon requires at least 10 seconds\n")
+				} else if errno == "-22" {
+					// daemons not reporting their capabilities may lack DNS monitoring
+					fmt.Fprintf(os.Stderr, "❌ The daemon refused the interval: it requires at least 10 seconds,\n")
+					fmt.Fprintf(os.Stderr, "   or does not include dynamic DNS functionality.\n")
 				} else {
 					fmt.Fprintf(os.Stderr, "Error setting DNS monitoring interval: errno=%s\n", errno)
 				}
@@ -645,3 +848,46 @@ func setDNSMonitoringInterval(conn net.Conn, interfaceName string, intervalSecon
 
 	fmt.Printf("✅ DNS monitoring interval successfully set to %d seconds\n", intervalSeconds)
 }
+
+func handleInterface(args []string) {
+	const usage = "Usage: wg-go interface [list|create <name> [config-file]|destroy <name>]\n"
+
+	var request string
+	switch {
+	case len(args) == 0 || (len(args) == 1 && args[0] == "list"):
+		request = "list=1\n"
+	case (len(args) == 2 || len(args) == 3) && args[0] == "create":
+		request = fmt.Sprintf("create=%s\n", args[1])
+		if len(args) == 3 {
+			// the daemon does not share our working directory
+			configFile, err := filepath.Abs(args[2])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			request += fmt.Sprintf("config=%s\n", configFile)
+		}
+	case len(args) == 2 && args[0] == "destroy":
+		request = fmt.Sprintf("destroy=%s\n", args[1])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(1)
+	}
+
+	names, err := sendControlRequest(request)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	switch {
+	case len(args) > 0 && args[0] == "create":
+		fmt.Printf("✅ Created interface %s\n", names[0])
+	case len(args) > 0 && args[0] == "destroy":
+		fmt.Printf("✅ Destroyed interface %s\n", args[1])
+	default:
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	}
+}
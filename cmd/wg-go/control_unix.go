@@ -0,0 +1,57 @@
+//go:build !windows
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultControlSocket is the control socket of a `wireguard-go --multi` daemon.
+var DefaultControlSocket = filepath.Join(DefaultSocketDir, "wireguard-go.ctl")
+
+// sendControlRequest sends a request to the multi-interface daemon and returns the
+// interface names in its response.
+func sendControlRequest(request string) ([]string, error) {
+	conn, err := net.DialTimeout("unix", DefaultControlSocket, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to multi-interface daemon at %s: %v", DefaultControlSocket, err)
+	}
+	defer conn.Close()
+
+	// creating an interface may resolve endpoints of its configuration
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	if _, err := io.WriteString(conn, request+"\n"); err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+
+	var names []string
+	var errorMessage string
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "interface":
+			names = append(names, value)
+		case "error":
+			errorMessage = value
+		case "errno":
+			if value != "0" {
+				if errorMessage == "" {
+					errorMessage = "errno=" + value
+				}
+				return nil, fmt.Errorf("%s", errorMessage)
+			}
+			return names, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	return nil, fmt.Errorf("incomplete response from multi-interface daemon")
+}
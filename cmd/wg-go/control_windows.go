@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "errors"
+
+func sendControlRequest(request string) ([]string, error) {
+	return nil, errors.New("the multi-interface daemon is not supported on Windows")
+}
@@ -113,6 +113,22 @@ func parsePublicKey(s string) (PublicKey, error) {
 	return key, nil
 }
 
+// Parse public key from either base64 (config format) or hex (UAPI format)
+func parsePeerKey(s string) (PublicKey, error) {
+	var key PublicKey
+
+	if len(s) == hex.EncodedLen(PublicKeySize) {
+		decoded, err := hex.DecodeString(s)
+		if err != nil {
+			return key, fmt.Errorf("invalid hex encoding: %v", err)
+		}
+		copy(key[:], decoded)
+		return key, nil
+	}
+
+	return parsePublicKey(s)
+}
+
 // Parse preshared key from base64 string
 func parsePresharedKey(s string) (PresharedKey, error) {
 	var key PresharedKey
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handle 'events' command - print the events of an interface as they happen
+func handleEvents(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go events <interface>\n")
+		os.Exit(1)
+	}
+	interfaceName := args[0]
+
+	caps := interfaceCapabilities(interfaceName)
+	caps.require(interfaceName, "Events", caps.hasOperation("subscribe"))
+
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, "subscribe=1\n\n"); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending command: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Listening for events on %s (Ctrl+C to stop)...\n", interfaceName)
+	record := make(map[string]string)
+	received := false
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(record) > 0 {
+				printEvent(record)
+				received = true
+				record = make(map[string]string)
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		if key == "errno" {
+			if value != "0" {
+				fmt.Fprintf(os.Stderr, "❌ Subscription failed: errno=%s\n", value)
+				os.Exit(1)
+			}
+			fmt.Printf("Interface %s closed\n", interfaceName)
+			return
+		}
+		record[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading events: %v\n", err)
+		os.Exit(1)
+	}
+	if received {
+		// The daemon exited without ending the stream
+		fmt.Printf("Connection to %s closed\n", interfaceName)
+		return
+	}
+	// Daemons without event support close the connection right away
+	fmt.Fprintf(os.Stderr, "❌ Connection closed; the daemon may not support events\n")
+	os.Exit(1)
+}
+
+// Print one event record of a UAPI subscription on a line
+func printEvent(record map[string]string) {
+	sec, _ := strconv.ParseInt(record["time_sec"], 10, 64)
+	nsec, _ := strconv.ParseInt(record["time_nsec"], 10, 64)
+	line := fmt.Sprintf("%s  %-20s", time.Unix(sec, nsec).Format("2006-01-02 15:04:05.000"), record["event"])
+
+	if hexKey, ok := record["public_key"]; ok {
+		if key, err := parsePeerKey(hexKey); err == nil {
+			line += " peer=" + key.String()
+		} else {
+			line += " peer=" + hexKey
+		}
+	}
+	for _, key := range []string{"endpoint", "reason", "attempt", "mtu", "lost"} {
+		if value, ok := record[key]; ok {
+			line += fmt.Sprintf(" %s=%s", key, value)
+		}
+	}
+	fmt.Println(line)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Handle 'log' command - print the recent log of an interface, kept by the
+// daemon in memory whatever its log file and levels
+func handleLog(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go log <interface> [--follow] [--since DURATION|TIME]\n")
+		fmt.Fprintf(os.Stderr, "--since takes a duration like 10m for that long ago, or an RFC 3339 time\n")
+		os.Exit(1)
+	}
+	interfaceName := args[0]
+
+	follow := false
+	var since time.Time
+	for i := 1; i < len(args); i++ {
+		switch args[i] {
+		case "--follow", "-f":
+			follow = true
+		case "--since":
+			if i+1 >= len(args) {
+				fmt.Fprintf(os.Stderr, "Error: --since needs a duration or time\n")
+				os.Exit(1)
+			}
+			i++
+			var err error
+			since, err = parseSince(args[i])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: Invalid --since '%s': %v\n", args[i], err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Fprintf(os.Stderr, "Error: Unknown option '%s'\n", args[i])
+			os.Exit(1)
+		}
+	}
+
+	caps := interfaceCapabilities(interfaceName)
+	caps.require(interfaceName, "The in-memory log", caps.hasOperation("log"))
+
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	var request strings.Builder
+	request.WriteString("log=1\n")
+	if !since.IsZero() {
+		request.WriteString(fmt.Sprintf("since_sec=%d\nsince_nsec=%d\n", since.Unix(), since.Nanosecond()))
+	}
+	if follow {
+		request.WriteString("follow=true\n")
+	}
+	request.WriteString("\n")
+	if _, err := io.WriteString(conn, request.String()); err != nil {
+		fmt.Fprintf(os.Stderr, "Error sending command: %v\n", err)
+		os.Exit(1)
+	}
+
+	record := make(map[string]string)
+	received := false
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			if len(record) > 0 {
+				printLogRecord(record)
+				received = true
+				record = make(map[string]string)
+			}
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		if key == "errno" {
+			if value != "0" {
+				fmt.Fprintf(os.Stderr, "❌ Failed to read the log: errno=%s\n", value)
+				os.Exit(1)
+			}
+			if follow {
+				fmt.Printf("Interface %s closed\n", interfaceName)
+			}
+			return
+		}
+		record[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading the log: %v\n", err)
+		os.Exit(1)
+	}
+	if received {
+		// The daemon exited without ending the stream
+		fmt.Printf("Connection to %s closed\n", interfaceName)
+		return
+	}
+	// Daemons without a log ring close the connection right away
+	fmt.Fprintf(os.Stderr, "❌ Connection closed; the daemon may not support reading its log\n")
+	os.Exit(1)
+}
+
+// Parse the argument of --since, a duration before now or an RFC 3339 time
+func parseSince(text string) (time.Time, error) {
+	if d, err := time.ParseDuration(text); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, text)
+}
+
+// Print one record of the UAPI log operation on a line
+func printLogRecord(record map[string]string) {
+	if lost, ok := record["lost"]; ok {
+		fmt.Printf("... %s records lost\n", lost)
+	}
+	sec, _ := strconv.ParseInt(record["time_sec"], 10, 64)
+	nsec, _ := strconv.ParseInt(record["time_nsec"], 10, 64)
+	line := fmt.Sprintf("%s  %-5s  %-9s", time.Unix(sec, nsec).Format("2006-01-02 15:04:05.000"),
+		strings.ToUpper(record["level"]), record["subsystem"])
+
+	if hexKey, ok := record["public_key"]; ok {
+		if key, err := parsePeerKey(hexKey); err == nil {
+			line += " peer=" + key.String()
+		} else {
+			line += " peer=" + hexKey
+		}
+	}
+	fmt.Println(line + " " + record["message"])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Handle 'loglevel' command - show or change the log levels of an interface
+func handleLogLevel(args []string) {
+	if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: wg-go loglevel <interface> [LEVEL] [SUBSYSTEM:LEVEL]... [--peer KEY:LEVEL]...\n")
+		fmt.Fprintf(os.Stderr, "Levels: error, warn, info, debug, trace; an empty level removes a subsystem or peer level\n")
+		os.Exit(1)
+	}
+	interfaceName := args[0]
+
+	caps := interfaceCapabilities(interfaceName)
+	caps.require(interfaceName, "Log levels", caps.hasKey("log_level"))
+
+	var command strings.Builder
+	for i := 1; i < len(args); i++ {
+		if args[i] != "--peer" {
+			command.WriteString(fmt.Sprintf("%s=%s\n", caps.key("log_level"), args[i]))
+			continue
+		}
+		if i+1 >= len(args) {
+			fmt.Fprintf(os.Stderr, "Error: --peer needs KEY:LEVEL\n")
+			os.Exit(1)
+		}
+		i++
+		text, level, _ := strings.Cut(args[i], ":")
+		key, err := parsePeerKey(text)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: Invalid peer public key '%s': %v\n", text, err)
+			os.Exit(1)
+		}
+		command.WriteString(fmt.Sprintf("%s=%s:%s\n", caps.key("log_peer"), key.Hex(), level))
+	}
+
+	if command.Len() > 0 {
+		conn, err := connectToInterface(interfaceName)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error connecting to interface '%s': %v\n", interfaceName, err)
+			os.Exit(1)
+		}
+		_, err = sendUAPICommand(conn, "set=1\n"+command.String())
+		conn.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to set log levels of %s: %v\n", interfaceName, err)
+			os.Exit(1)
+		}
+	}
+
+	info, err := getDeviceInfo(interfaceName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if len(info.LogLevels) == 0 {
+		fmt.Printf("%s: log levels are not supported by the daemon\n", interfaceName)
+		return
+	}
+	fmt.Printf("%s: log level %s\n", interfaceName, info.LogLevels[0])
+	for _, level := range info.LogLevels[1:] {
+		subsystem, name, _ := strings.Cut(level, ":")
+		fmt.Printf("  %-10s %s\n", subsystem, name)
+	}
+	for _, level := range info.LogPeers {
+		text, name, _ := strings.Cut(level, ":")
+		fmt.Printf("  peer %s %s\n", text, name)
+	}
+}
@@ -35,8 +35,20 @@ func main() {
 		handleShowconf(args)
 	case "monitor":
 		handleMonitor(args)
+	case "capture":
+		handleCapture(args)
+	case "loglevel":
+		handleLogLevel(args)
+	case "log":
+		handleLog(args)
+	case "events":
+		handleEvents(args)
 	case "dns":
 		handleDNS(args)
+	case "interface":
+		handleInterface(args)
+	case "capabilities":
+		handleCapabilities(args)
 	case "help", "--help", "-h":
 		printUsage()
 	default:
@@ -56,25 +68,48 @@ Commands:
     genkey                          Generate a new private key
     pubkey                          Calculate public key from private key (stdin)
     genpsk                          Generate a new preshared key
-    show [interface]                Show current WireGuard configuration
+    show [--stats] [interface [peer]]
+                                    Show current WireGuard configuration (--stats: packet,
+                                    drop and handshake counters of each peer)
     set <interface> <options>       Set WireGuard configuration
     setconf <interface> <file>      Set WireGuard configuration from file
     addconf <interface> <file>      Add peers from configuration file
     syncconf <interface> <file>     Synchronize configuration with file
     showconf <interface>            Show current configuration in config format
     monitor [interface] [interval]  Monitor interface status (live updates)
+    events <interface>              Print events (handshakes, roaming, ...) as they happen
+    capture <interface> [options]   Record tunnel packets to a pcapng file (-w FILE,
+                                    --peer KEY, --outer, --max-bytes SIZE, --duration SECONDS)
+    loglevel <interface> [levels]   Show or set log levels (LEVEL, SUBSYSTEM:LEVEL,
+                                    --peer KEY:LEVEL)
+    log <interface> [options]       Print the recent log kept in memory by the daemon
+                                    (--follow, --since DURATION|TIME)
     dns <interface> [show|interval] DNS monitoring management
+    dns <interface> resolve [peer]  Re-check DNS endpoints immediately
+    interface [list]                List interfaces of a --multi daemon
+    interface create <name> [file]  Create an interface in a --multi daemon
+    interface destroy <name>        Destroy an interface of a --multi daemon
+    capabilities <interface>        Show the daemon version and the extensions it supports
 
 Examples:
     wg-go genkey                    Generate a private key
     wg-go genkey | wg-go pubkey     Generate a key pair
     wg-go show                      Show all WireGuard interfaces
     wg-go show wg0                  Show wg0 interface details
+    wg-go show --stats wg0          Show wg0 with per-peer counters
+    wg-go show wg0 <public-key>     Show a single peer of wg0
     wg-go setconf wg0 wg0.conf      Apply configuration file to wg0
     wg-go monitor                   Monitor all interfaces (live)
     wg-go monitor utun2 10          Monitor utun2 every 10 seconds
+    wg-go events wg0                Follow handshakes and endpoint changes of wg0
+    wg-go capture wg0 -w /tmp/wg0.pcapng --outer  Capture wg0 until Ctrl+C
+    wg-go loglevel wg0 info handshake:debug  Log wg0 at info, its handshakes at debug
+    wg-go log wg0 --since 10m -f    Print the last 10 minutes of log of wg0 and follow it
     wg-go dns wg0 show              Show DNS monitoring status for wg0
     wg-go dns wg0 30                Set DNS monitoring interval to 30 seconds
+    wg-go dns wg0 resolve           Re-resolve all domain endpoints of wg0
+    wg-go interface create wg1 wg1.conf  Add wg1 to a --multi daemon
+    wg-go capabilities wg0          Show what the daemon of wg0 supports
 
 For more information, visit: https://www.wireguard.com/
 `)
@@ -2,8 +2,10 @@ package main
 
 import (
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
+	"slices"
 	"strings"
 	"time"
 )
@@ -27,6 +29,10 @@ func handleMonitor(args []string) {
 	fmt.Println("Press Ctrl+C to exit")
 	fmt.Println(strings.Repeat("=", 60))
 
+	// the interfaces as of the last update, so that updates only ask for
+	// the peers changed since
+	cache := make(map[string]*InterfaceInfo)
+
 	for {
 		// Clear screen
 		clearScreen()
@@ -37,10 +43,10 @@ func handleMonitor(args []string) {
 
 		if interfaceName != "" {
 			// Monitor specific interface
-			monitorSpecificInterface(interfaceName)
+			monitorSpecificInterface(cache, interfaceName)
 		} else {
 			// Monitor all interfaces
-			monitorAllInterfaces()
+			monitorAllInterfaces(cache)
 		}
 
 		fmt.Printf("\nNext update in %v... (Press Ctrl+C to exit)\n", refresh)
@@ -49,8 +55,8 @@ func handleMonitor(args []string) {
 }
 
 // Monitor specific interface
-func monitorSpecificInterface(name string) {
-	info, err := getInterfaceInfo(name)
+func monitorSpecificInterface(cache map[string]*InterfaceInfo, name string) {
+	info, err := updateInterfaceInfo(cache, name)
 	if err != nil {
 		fmt.Printf("❌ Error monitoring interface '%s': %v\n", name, err)
 		fmt.Printf("💡 Make sure the interface is running: %s\n", strings.Replace(getStartCommand(), "<interface-name>", name, 1))
@@ -61,7 +67,7 @@ func monitorSpecificInterface(name string) {
 }
 
 // Monitor all interfaces
-func monitorAllInterfaces() {
+func monitorAllInterfaces(cache map[string]*InterfaceInfo) {
 	interfaces, err := discoverInterfaces()
 	if err != nil {
 		fmt.Printf("❌ Error discovering interfaces: %v\n", err)
@@ -76,12 +82,16 @@ func monitorAllInterfaces() {
 
 	fmt.Printf("📡 Found %d WireGuard interface(s):\n\n", len(interfaces))
 
+	maps.DeleteFunc(cache, func(name string, _ *InterfaceInfo) bool {
+		return !slices.Contains(interfaces, name)
+	})
+
 	for i, interfaceName := range interfaces {
 		if i > 0 {
 			fmt.Println()
 		}
 
-		info, err := getInterfaceInfo(interfaceName)
+		info, err := updateInterfaceInfo(cache, interfaceName)
 		if err != nil {
 			fmt.Printf("interface: %s ❌ (error: %v)\n", interfaceName, err)
 			continue
@@ -91,6 +101,23 @@ func monitorAllInterfaces() {
 	}
 }
 
+// Get the interface, asking only for the peers changed since it is in cache
+func updateInterfaceInfo(cache map[string]*InterfaceInfo, name string) (*InterfaceInfo, error) {
+	var info *InterfaceInfo
+	var err error
+	if cached := cache[name]; cached != nil {
+		info, err = refreshInterfaceInfo(cached, "omit=stats\n")
+	} else {
+		info, err = getInterfaceInfo(name)
+	}
+	if err != nil {
+		delete(cache, name)
+		return nil, err
+	}
+	cache[name] = info
+	return info, nil
+}
+
 // Print interface information with enhanced statistics
 func printInterfaceInfoWithStats(info *InterfaceInfo) {
 	// Interface header
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -12,12 +18,45 @@ const (
 
 // InterfaceInfo contains information about a WireGuard interface
 type InterfaceInfo struct {
-	Name       string
-	PrivateKey string
-	PublicKey  string
-	ListenPort int
-	FwMark     int
-	Peers      []PeerInfo
+	Name               string
+	PrivateKey         string
+	PublicKey          string
+	ListenPort         int
+	FwMark             int
+	DNSMonitorInterval int // DNS monitoring interval in seconds (0 if not reported)
+	DNSMonitoredPeers  int
+	Firewall           *FirewallInfo // nil if the firewall is off
+	Capture            *CaptureInfo  // nil if not capturing
+	LogLevels          []string      // LEVEL, then SUBSYSTEM:LEVEL
+	LogPeers           []string      // KEY:LEVEL
+	Generation         uint64        // cursor for since_generation, from a filtered get
+	PeerCount          int           // peers of the interface, -1 if not reported
+	RemainingPeers     int           // peers after the last one of a page
+	Peers              []PeerInfo
+}
+
+// FirewallInfo contains the rules of the firewall and their counters
+type FirewallInfo struct {
+	Policy        string
+	Rules         []FirewallRuleInfo
+	PolicyPackets int64 // packets that matched no rule
+	Flows         int   // connections tracked
+}
+
+// FirewallRuleInfo contains a firewall rule and how much traffic it matched
+type FirewallRuleInfo struct {
+	Rule    string
+	Packets int64
+	Bytes   int64
+}
+
+// CaptureInfo contains the packet capture in progress
+type CaptureInfo struct {
+	File     string
+	Filter   string
+	MaxBytes int64 // 0 if unlimited
+	Packets  int64
+	Bytes    int64
 }
 
 // PeerInfo contains information about a peer
@@ -31,6 +70,96 @@ type PeerInfo struct {
 	TxBytes                     int64
 	RxBytes                     int64
 	PersistentKeepaliveInterval int
+	TxRateLimit                 uint64 // bits per second, 0 if unlimited
+	RxRateLimit                 uint64 // bits per second, 0 if unlimited
+	DailyQuota                  uint64 // bytes, 0 if unlimited
+	DailyQuotaRemaining         uint64
+	MonthlyQuota                uint64 // bytes, 0 if unlimited
+	MonthlyQuotaRemaining       uint64
+	QuotaSuspended              bool
+	CountersOnly                bool         // only the counters were reported, as nothing else changed
+	DNS                         *PeerDNSInfo // nil if the peer's endpoint is not DNS monitored
+	Stats                       *PeerStats   // nil if the daemon does not report extended counters
+}
+
+// PeerStats contains the extended counters of a peer
+type PeerStats struct {
+	TxPackets            int64
+	RxPackets            int64
+	LastRxTimeSec        int64
+	LastRxTimeNsec       int64
+	RxDropReplay         int64
+	RxDropAllowedIPs     int64
+	RxDropDecrypt        int64
+	RxDropMalformed      int64
+	TxDropStagedOverflow int64
+	TxDropNotRunning     int64
+	TxDropRateLimit      int64
+	RxDropRateLimit      int64
+	TxDropQuota          int64
+	RxDropQuota          int64
+	TxDropFirewall       int64
+	RxDropFirewall       int64
+	HandshakeInitiations int64
+	HandshakeRetries     int64
+	HandshakeResponses   int64
+	KeypairAgeSec        int64 // -1 if there is no current keypair
+}
+
+// Get the counter for a UAPI get key, or nil if the key is not one of them
+func (s *PeerStats) counter(key string) *int64 {
+	switch key {
+	case "tx_packets":
+		return &s.TxPackets
+	case "rx_packets":
+		return &s.RxPackets
+	case "last_rx_time_sec":
+		return &s.LastRxTimeSec
+	case "last_rx_time_nsec":
+		return &s.LastRxTimeNsec
+	case "rx_drop_replay":
+		return &s.RxDropReplay
+	case "rx_drop_allowed_ips":
+		return &s.RxDropAllowedIPs
+	case "rx_drop_decrypt":
+		return &s.RxDropDecrypt
+	case "rx_drop_malformed":
+		return &s.RxDropMalformed
+	case "tx_drop_staged_overflow":
+		return &s.TxDropStagedOverflow
+	case "tx_drop_not_running":
+		return &s.TxDropNotRunning
+	case "tx_drop_rate_limit":
+		return &s.TxDropRateLimit
+	case "rx_drop_rate_limit":
+		return &s.RxDropRateLimit
+	case "tx_drop_quota":
+		return &s.TxDropQuota
+	case "rx_drop_quota":
+		return &s.RxDropQuota
+	case "tx_drop_firewall":
+		return &s.TxDropFirewall
+	case "rx_drop_firewall":
+		return &s.RxDropFirewall
+	case "handshake_initiations":
+		return &s.HandshakeInitiations
+	case "handshake_retries":
+		return &s.HandshakeRetries
+	case "handshake_responses":
+		return &s.HandshakeResponses
+	case "keypair_age_sec":
+		return &s.KeypairAgeSec
+	}
+	return nil
+}
+
+// PeerDNSInfo contains the DNS monitor state of a peer with a domain endpoint
+type PeerDNSInfo struct {
+	Hostname           string
+	Port               string
+	LastResolvedIP     string
+	LastCheckTimeSec   int64
+	ResolutionFailures int
 }
 
 // Get formatted last handshake time
@@ -41,6 +170,569 @@ func (p PeerInfo) LastHandshakeTime() time.Time {
 	return time.Unix(p.LastHandshakeTimeSec, p.LastHandshakeTimeNsec)
 }
 
+// Get time of the last packet received from the peer
+func (s PeerStats) LastRxTime() time.Time {
+	if s.LastRxTimeSec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(s.LastRxTimeSec, s.LastRxTimeNsec)
+}
+
+// Get last DNS check time
+func (d PeerDNSInfo) LastCheckTime() time.Time {
+	if d.LastCheckTimeSec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(d.LastCheckTimeSec, 0)
+}
+
+// Parse the response of a UAPI get operation
+func parseInterfaceInfo(interfaceName, response string) *InterfaceInfo {
+	info := &InterfaceInfo{
+		Name:      interfaceName,
+		PeerCount: -1,
+		Peers:     []PeerInfo{},
+	}
+
+	var currentPeer *PeerInfo
+
+	// Get the DNS info of the current peer, creating it on first use
+	peerDNS := func() *PeerDNSInfo {
+		if currentPeer.DNS == nil {
+			currentPeer.DNS = &PeerDNSInfo{}
+		}
+		return currentPeer.DNS
+	}
+
+	// Get the extended counters of the current peer, creating them on first use
+	peerStats := func() *PeerStats {
+		if currentPeer.Stats == nil {
+			currentPeer.Stats = &PeerStats{KeypairAgeSec: -1}
+		}
+		return currentPeer.Stats
+	}
+
+	lines := strings.Split(strings.TrimSpace(response), "\n")
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		key, value := strings.TrimPrefix(parts[0], vendorPrefix), parts[1]
+
+		switch key {
+		case "private_key":
+			info.PrivateKey = value
+		case "public_key":
+			// This starts a new peer
+			peer := PeerInfo{
+				PublicKey:  value,
+				AllowedIPs: []string{},
+			}
+			info.Peers = append(info.Peers, peer)
+			currentPeer = &info.Peers[len(info.Peers)-1]
+		case "listen_port":
+			if port, err := strconv.Atoi(value); err == nil {
+				info.ListenPort = port
+			}
+		case "fwmark":
+			if mark, err := strconv.Atoi(value); err == nil {
+				info.FwMark = mark
+			}
+		case "generation":
+			if generation, err := strconv.ParseUint(value, 10, 64); err == nil {
+				info.Generation = generation
+			}
+		case "peer_count":
+			if count, err := strconv.Atoi(value); err == nil {
+				info.PeerCount = count
+			}
+		case "remaining_peers":
+			if remaining, err := strconv.Atoi(value); err == nil {
+				info.RemainingPeers = remaining
+			}
+		case "dns_monitor_interval":
+			if interval, err := strconv.Atoi(value); err == nil {
+				info.DNSMonitorInterval = interval
+			}
+		case "dns_monitored_peers":
+			if peers, err := strconv.Atoi(value); err == nil {
+				info.DNSMonitoredPeers = peers
+			}
+		case "firewall_policy":
+			info.Firewall = &FirewallInfo{Policy: value}
+		case "firewall_rule":
+			if info.Firewall != nil {
+				info.Firewall.Rules = append(info.Firewall.Rules, FirewallRuleInfo{Rule: value})
+			}
+		case "firewall_rule_packets", "firewall_rule_bytes":
+			if info.Firewall != nil && len(info.Firewall.Rules) > 0 {
+				rule := &info.Firewall.Rules[len(info.Firewall.Rules)-1]
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					if key == "firewall_rule_packets" {
+						rule.Packets = n
+					} else {
+						rule.Bytes = n
+					}
+				}
+			}
+		case "firewall_policy_packets":
+			if info.Firewall != nil {
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					info.Firewall.PolicyPackets = n
+				}
+			}
+		case "firewall_flows":
+			if info.Firewall != nil {
+				if n, err := strconv.Atoi(value); err == nil {
+					info.Firewall.Flows = n
+				}
+			}
+		case "log_level":
+			info.LogLevels = append(info.LogLevels, value)
+		case "log_peer":
+			info.LogPeers = append(info.LogPeers, value)
+		case "capture_file":
+			info.Capture = &CaptureInfo{File: value}
+		case "capture_filter":
+			if info.Capture != nil {
+				info.Capture.Filter = value
+			}
+		case "capture_max_bytes", "capture_packets", "capture_bytes":
+			if info.Capture != nil {
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					switch key {
+					case "capture_max_bytes":
+						info.Capture.MaxBytes = n
+					case "capture_packets":
+						info.Capture.Packets = n
+					default:
+						info.Capture.Bytes = n
+					}
+				}
+			}
+		case "preshared_key":
+			if currentPeer != nil {
+				currentPeer.PresharedKey = value
+			}
+		case "endpoint":
+			if currentPeer != nil {
+				currentPeer.Endpoint = value
+			}
+		case "last_handshake_time_sec":
+			if currentPeer != nil {
+				if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+					currentPeer.LastHandshakeTimeSec = sec
+				}
+			}
+		case "last_handshake_time_nsec":
+			if currentPeer != nil {
+				if nsec, err := strconv.ParseInt(value, 10, 64); err == nil {
+					currentPeer.LastHandshakeTimeNsec = nsec
+				}
+			}
+		case "tx_bytes":
+			if currentPeer != nil {
+				if bytes, err := strconv.ParseInt(value, 10, 64); err == nil {
+					currentPeer.TxBytes = bytes
+				}
+			}
+		case "rx_bytes":
+			if currentPeer != nil {
+				if bytes, err := strconv.ParseInt(value, 10, 64); err == nil {
+					currentPeer.RxBytes = bytes
+				}
+			}
+		case "persistent_keepalive_interval":
+			if currentPeer != nil {
+				if interval, err := strconv.Atoi(value); err == nil {
+					currentPeer.PersistentKeepaliveInterval = interval
+				}
+			}
+		case "tx_rate_limit":
+			if currentPeer != nil {
+				if rate, err := strconv.ParseUint(value, 10, 64); err == nil {
+					currentPeer.TxRateLimit = rate
+				}
+			}
+		case "rx_rate_limit":
+			if currentPeer != nil {
+				if rate, err := strconv.ParseUint(value, 10, 64); err == nil {
+					currentPeer.RxRateLimit = rate
+				}
+			}
+		case "daily_quota", "daily_quota_remaining", "monthly_quota", "monthly_quota_remaining":
+			if currentPeer != nil {
+				if bytes, err := strconv.ParseUint(value, 10, 64); err == nil {
+					switch key {
+					case "daily_quota":
+						currentPeer.DailyQuota = bytes
+					case "daily_quota_remaining":
+						currentPeer.DailyQuotaRemaining = bytes
+					case "monthly_quota":
+						currentPeer.MonthlyQuota = bytes
+					case "monthly_quota_remaining":
+						currentPeer.MonthlyQuotaRemaining = bytes
+					}
+				}
+			}
+		case "quota_suspended":
+			if currentPeer != nil {
+				currentPeer.QuotaSuspended = value == "true"
+			}
+		case "counters_only":
+			if currentPeer != nil {
+				currentPeer.CountersOnly = value == "true"
+			}
+		case "allowed_ip":
+			if currentPeer != nil {
+				currentPeer.AllowedIPs = append(currentPeer.AllowedIPs, value)
+			}
+		case "dns_hostname":
+			if currentPeer != nil {
+				peerDNS().Hostname = value
+			}
+		case "dns_port":
+			if currentPeer != nil {
+				peerDNS().Port = value
+			}
+		case "dns_last_resolved_ip":
+			if currentPeer != nil {
+				peerDNS().LastResolvedIP = value
+			}
+		case "dns_last_check_time_sec":
+			if currentPeer != nil {
+				if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
+					peerDNS().LastCheckTimeSec = sec
+				}
+			}
+		case "dns_resolution_failures":
+			if currentPeer != nil {
+				if fails, err := strconv.Atoi(value); err == nil {
+					peerDNS().ResolutionFailures = fails
+				}
+			}
+		default:
+			if currentPeer != nil && (&PeerStats{}).counter(key) != nil {
+				if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+					*peerStats().counter(key) = n
+				}
+			}
+		}
+	}
+
+	// Calculate public key from private key if available
+	if info.PrivateKey != "" && info.PrivateKey != "(none)" {
+		if privateKey, err := parsePrivateKey(info.PrivateKey); err == nil {
+			publicKey := privateKey.PublicKey()
+			info.PublicKey = publicKey.String()
+		}
+	}
+
+	return info
+}
+
+// peerPageSize is how many peers are asked for at a time, so that the daemon
+// of a large interface never holds its peers long for one request
+const peerPageSize = 1000
+
+// Get interface information via UAPI
+func getInterfaceInfo(interfaceName string) (*InterfaceInfo, error) {
+	info, err := getPeersPaged(interfaceName, "")
+	if err == errFilteredGetUnsupported {
+		return getInterfaceInfoUnfiltered(interfaceName)
+	}
+	return info, err
+}
+
+// Get interface information without peers, for commands that only need the
+// interface settings
+func getDeviceInfo(interfaceName string) (*InterfaceInfo, error) {
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	info, err := filteredGet(conn, interfaceName, "limit=0\n")
+	if err == errFilteredGetUnsupported {
+		return getInterfaceInfoUnfiltered(interfaceName)
+	}
+	return info, err
+}
+
+// Get interface information with a single peer; info.Peers is empty if the
+// interface has no such peer
+func getPeerInfo(interfaceName string, key PublicKey) (*InterfaceInfo, error) {
+	info, err := getPeersPaged(interfaceName, fmt.Sprintf("public_key=%s\n", key.Hex()))
+	if err != errFilteredGetUnsupported {
+		return info, err
+	}
+	if info, err = getInterfaceInfoUnfiltered(interfaceName); err != nil {
+		return nil, err
+	}
+	info.Peers = slices.DeleteFunc(info.Peers, func(peer PeerInfo) bool {
+		return peer.PublicKey != key.Hex()
+	})
+	return info, nil
+}
+
+// Get interface information again, asking only for the peers changed since
+// info was got, with the extra lines of query. The changed peers are merged
+// with those of info in the order of their public keys, taking only the
+// counters of those whose counters alone changed; all of them are got again
+// when some were removed.
+func refreshInterfaceInfo(info *InterfaceInfo, query string) (*InterfaceInfo, error) {
+	if info.PeerCount < 0 {
+		// the daemon does not support filtered gets
+		return getInterfaceInfoUnfiltered(info.Name)
+	}
+	changed, err := getPeersPaged(info.Name, fmt.Sprintf("since_generation=%d\n%s", info.Generation, query))
+	if err == errFilteredGetUnsupported {
+		return getInterfaceInfoUnfiltered(info.Name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	peers := make(map[string]PeerInfo, len(info.Peers))
+	for _, peer := range info.Peers {
+		peers[peer.PublicKey] = peer
+	}
+	for _, peer := range changed.Peers {
+		if peer.CountersOnly {
+			old, ok := peers[peer.PublicKey]
+			if !ok {
+				return getPeersPaged(info.Name, query)
+			}
+			peer = old.withCounters(peer)
+		}
+		peers[peer.PublicKey] = peer
+	}
+	if len(peers) != changed.PeerCount {
+		return getPeersPaged(info.Name, query)
+	}
+	changed.Peers = slices.SortedFunc(maps.Values(peers), func(a, b PeerInfo) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+	return changed, nil
+}
+
+// Get the peer with the counters of counters, a peer reported with only its
+// counters
+func (p PeerInfo) withCounters(counters PeerInfo) PeerInfo {
+	p.TxBytes, p.RxBytes = counters.TxBytes, counters.RxBytes
+	p.DailyQuota, p.DailyQuotaRemaining = counters.DailyQuota, counters.DailyQuotaRemaining
+	p.MonthlyQuota, p.MonthlyQuotaRemaining = counters.MonthlyQuota, counters.MonthlyQuotaRemaining
+	p.QuotaSuspended = counters.QuotaSuspended
+	if counters.Stats != nil {
+		p.Stats = counters.Stats
+	}
+	return p
+}
+
+// errFilteredGetUnsupported is returned for a daemon that predates filtered
+// gets, and refuses them
+var errFilteredGetUnsupported = errors.New("filtered get not supported by the daemon")
+
+// Get the interface with the peers that query selects, a page at a time in the
+// order of their public keys
+func getPeersPaged(interfaceName, query string) (*InterfaceInfo, error) {
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var info *InterfaceInfo
+	page := fmt.Sprintf("limit=%d\n", peerPageSize)
+	for {
+		next, err := filteredGet(conn, interfaceName, query+page)
+		if err != nil {
+			return nil, err
+		}
+		if info == nil {
+			info = next
+		} else {
+			info.Peers = append(info.Peers, next.Peers...)
+		}
+		if next.RemainingPeers == 0 || len(next.Peers) == 0 {
+			info.RemainingPeers = 0
+			return info, nil
+		}
+		page = fmt.Sprintf("limit=%d\nafter_public_key=%s\n", peerPageSize, next.Peers[len(next.Peers)-1].PublicKey)
+	}
+}
+
+// Send a filtered get with the lines of query
+func filteredGet(conn net.Conn, interfaceName, query string) (*InterfaceInfo, error) {
+	response, err := sendUAPICommand(conn, "get=1\n"+query)
+	if err != nil {
+		// a daemon without filtered gets refuses anything after get=1
+		if strings.HasPrefix(err.Error(), "UAPI error") {
+			return nil, errFilteredGetUnsupported
+		}
+		return nil, err
+	}
+	info := parseInterfaceInfo(interfaceName, response)
+	if info.PeerCount < 0 {
+		return nil, errFilteredGetUnsupported
+	}
+	return info, nil
+}
+
+// Get interface information with all peers at once, from daemons without
+// filtered gets
+func getInterfaceInfoUnfiltered(interfaceName string) (*InterfaceInfo, error) {
+	conn, err := connectToInterface(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Send get command
+	response, err := sendUAPICommand(conn, "get=1")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseInterfaceInfo(interfaceName, response), nil
+}
+
+// Print the extended counters of a peer, for show --stats
+func printPeerStats(peer PeerInfo) {
+	stats := peer.Stats
+	if stats == nil {
+		fmt.Printf("  stats: not reported by this daemon\n")
+		return
+	}
+	fmt.Printf("  packets: %d received, %d sent\n", stats.RxPackets, stats.TxPackets)
+	if lastRx := stats.LastRxTime(); !lastRx.IsZero() {
+		fmt.Printf("  latest packet received: %s\n", lastRx.Format("2006-01-02 15:04:05"))
+	}
+	fmt.Printf("  handshakes: %d initiations sent (%d retries), %d responses received\n",
+		stats.HandshakeInitiations, stats.HandshakeRetries, stats.HandshakeResponses)
+	if stats.KeypairAgeSec >= 0 {
+		fmt.Printf("  current keypair age: %s\n", formatDuration(time.Duration(stats.KeypairAgeSec)*time.Second))
+	}
+	fmt.Printf("  receive drops: %d replayed, %d disallowed source, %d decryption failed, %d malformed\n",
+		stats.RxDropReplay, stats.RxDropAllowedIPs, stats.RxDropDecrypt, stats.RxDropMalformed)
+	fmt.Printf("  send drops: %d staged queue overflow, %d peer not running\n",
+		stats.TxDropStagedOverflow, stats.TxDropNotRunning)
+	if peer.TxRateLimit != 0 || peer.RxRateLimit != 0 || stats.TxDropRateLimit != 0 || stats.RxDropRateLimit != 0 {
+		fmt.Printf("  rate limit drops: %d received, %d sent\n", stats.RxDropRateLimit, stats.TxDropRateLimit)
+	}
+	if stats.TxDropFirewall != 0 || stats.RxDropFirewall != 0 {
+		fmt.Printf("  firewall drops: %d received, %d sent\n", stats.RxDropFirewall, stats.TxDropFirewall)
+	}
+	if peer.DailyQuota != 0 || peer.MonthlyQuota != 0 || stats.TxDropQuota != 0 || stats.RxDropQuota != 0 {
+		fmt.Printf("  quota drops: %d received, %d sent\n", stats.RxDropQuota, stats.TxDropQuota)
+	}
+}
+
+// Print the firewall rules of an interface, if the firewall is on
+func printFirewall(info *InterfaceInfo) {
+	fw := info.Firewall
+	if fw == nil {
+		return
+	}
+	fmt.Printf("  firewall: %s by default (%d packets), %d connections tracked\n", fw.Policy, fw.PolicyPackets, fw.Flows)
+	for i, rule := range fw.Rules {
+		fmt.Printf("    rule %d: %s (%d packets, %s)\n", i+1, rule.Rule, rule.Packets, formatBytes(rule.Bytes))
+	}
+}
+
+// Print the rate limits of a peer, if any
+func printRateLimits(peer PeerInfo) {
+	if peer.TxRateLimit != 0 {
+		fmt.Printf("  send rate limit: %s/s\n", formatBitRate(peer.TxRateLimit))
+	}
+	if peer.RxRateLimit != 0 {
+		fmt.Printf("  receive rate limit: %s/s\n", formatBitRate(peer.RxRateLimit))
+	}
+}
+
+// Print the traffic quotas of a peer and what is left of them, if any
+func printQuotas(peer PeerInfo) {
+	if peer.DailyQuota != 0 {
+		fmt.Printf("  daily quota: %s of %s remaining\n",
+			formatBytes(int64(peer.DailyQuotaRemaining)), formatBytes(int64(peer.DailyQuota)))
+	}
+	if peer.MonthlyQuota != 0 {
+		fmt.Printf("  monthly quota: %s of %s remaining\n",
+			formatBytes(int64(peer.MonthlyQuotaRemaining)), formatBytes(int64(peer.MonthlyQuota)))
+	}
+	if peer.QuotaSuspended {
+		fmt.Printf("  suspended: quota used up\n")
+	}
+}
+
+// Format a rate in bits per second the way it is written in config files
+func formatBitRate(rate uint64) string {
+	for _, unit := range []struct {
+		suffix string
+		scale  uint64
+	}{{"Gbit", 1e9}, {"Mbit", 1e6}, {"Kbit", 1e3}} {
+		if rate >= unit.scale {
+			return strconv.FormatFloat(float64(rate)/float64(unit.scale), 'f', -1, 64) + unit.suffix
+		}
+	}
+	return strconv.FormatUint(rate, 10) + "bit"
+}
+
+// Parse a rate in bits per second such as 20Mbit, 512Kbit or 1000000, as in the
+// TxRateLimit and RxRateLimit config keys. Zero and "off" mean unlimited.
+func parseBitRate(value string) (uint64, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	number, scale := strings.ToLower(value), 1.0
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{{"gbit", 1e9}, {"mbit", 1e6}, {"kbit", 1e3}, {"bit", 1}} {
+		if n, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, scale = n, unit.scale
+			break
+		}
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || rate < 0 || rate*scale >= 1<<63 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return uint64(rate * scale), nil
+}
+
+// Parse a size in bytes such as 50GB, 1.5TiB or 1000000, as in the DailyQuota
+// and MonthlyQuota config keys. Zero and "off" mean unlimited.
+func parseByteSize(value string) (uint64, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	number, scale := strings.ToLower(value), 1.0
+	for _, unit := range []struct {
+		suffix string
+		scale  float64
+	}{
+		{"tib", 1 << 40}, {"gib", 1 << 30}, {"mib", 1 << 20}, {"kib", 1 << 10},
+		{"tb", 1e12}, {"gb", 1e9}, {"mb", 1e6}, {"kb", 1e3}, {"b", 1},
+	} {
+		if n, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, scale = n, unit.scale
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size < 0 || size*scale >= 1<<63 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return uint64(size * scale), nil
+}
+
 // Format bytes in human readable format
 func formatBytes(bytes int64) string {
 	const unit = 1024
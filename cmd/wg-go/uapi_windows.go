@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"net"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -180,123 +179,21 @@ func sendUAPICommand(conn net.Conn, command string) (string, error) {
 	return response.String(), nil
 }
 
-// Get interface information via UAPI
-func getInterfaceInfo(interfaceName string) (*InterfaceInfo, error) {
+// Set configuration via UAPI
+func setInterfaceConfig(interfaceName string, config *Config) error {
 	conn, err := connectToInterface(interfaceName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer conn.Close()
 
-	// Send get command
-	response, err := sendUAPICommand(conn, "get=1")
+	caps, err := getCapabilities(interfaceName)
 	if err != nil {
-		return nil, err
-	}
-
-	// Parse response
-	info := &InterfaceInfo{
-		Name:  interfaceName,
-		Peers: []PeerInfo{},
-	}
-
-	var currentPeer *PeerInfo
-
-	lines := strings.Split(strings.TrimSpace(response), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
-		key, value := parts[0], parts[1]
-
-		switch key {
-		case "private_key":
-			info.PrivateKey = value
-		case "public_key":
-			// This starts a new peer
-			peer := PeerInfo{
-				PublicKey:  value,
-				AllowedIPs: []string{},
-			}
-			info.Peers = append(info.Peers, peer)
-			currentPeer = &info.Peers[len(info.Peers)-1]
-		case "listen_port":
-			if port, err := strconv.Atoi(value); err == nil {
-				info.ListenPort = port
-			}
-		case "fwmark":
-			if mark, err := strconv.Atoi(value); err == nil {
-				info.FwMark = mark
-			}
-		case "preshared_key":
-			if currentPeer != nil {
-				currentPeer.PresharedKey = value
-			}
-		case "endpoint":
-			if currentPeer != nil {
-				currentPeer.Endpoint = value
-			}
-		case "last_handshake_time_sec":
-			if currentPeer != nil {
-				if sec, err := strconv.ParseInt(value, 10, 64); err == nil {
-					currentPeer.LastHandshakeTimeSec = sec
-				}
-			}
-		case "last_handshake_time_nsec":
-			if currentPeer != nil {
-				if nsec, err := strconv.ParseInt(value, 10, 64); err == nil {
-					currentPeer.LastHandshakeTimeNsec = nsec
-				}
-			}
-		case "tx_bytes":
-			if currentPeer != nil {
-				if bytes, err := strconv.ParseInt(value, 10, 64); err == nil {
-					currentPeer.TxBytes = bytes
-				}
-			}
-		case "rx_bytes":
-			if currentPeer != nil {
-				if bytes, err := strconv.ParseInt(value, 10, 64); err == nil {
-					currentPeer.RxBytes = bytes
-				}
-			}
-		case "persistent_keepalive_interval":
-			if currentPeer != nil {
-				if interval, err := strconv.Atoi(value); err == nil {
-					currentPeer.PersistentKeepaliveInterval = interval
-				}
-			}
-		case "allowed_ip":
-			if currentPeer != nil {
-				currentPeer.AllowedIPs = append(currentPeer.AllowedIPs, value)
-			}
-		}
-	}
-
-	// Calculate public key from private key if available
-	if info.PrivateKey != "" && info.PrivateKey != "(none)" {
-		if privateKey, err := parsePrivateKey(info.PrivateKey); err == nil {
-			publicKey := privateKey.PublicKey()
-			info.PublicKey = publicKey.String()
-		}
+		return err
 	}
-
-	return info, nil
-}
-
-// Set configuration via UAPI
-func setInterfaceConfig(interfaceName string, config *Config) error {
-	conn, err := connectToInterface(interfaceName)
-	if err != nil {
+	if err := config.checkCapabilities(caps); err != nil {
 		return err
 	}
-	defer conn.Close()
 
 	// Build UAPI configuration string
 	var configStr strings.Builder
@@ -315,6 +212,14 @@ func setInterfaceConfig(interfaceName string, config *Config) error {
 		configStr.WriteString(fmt.Sprintf("listen_port=%d\n", config.Interface.ListenPort))
 	}
 
+	if config.Firewall != nil {
+		configStr.WriteString(fmt.Sprintf("%s=%s\n", caps.key("firewall_policy"), config.Firewall.Policy))
+		configStr.WriteString(fmt.Sprintf("%s=true\n", caps.key("replace_firewall_rules")))
+		for _, rule := range config.Firewall.Rules {
+			configStr.WriteString(fmt.Sprintf("%s=%s\n", caps.key("firewall_rule"), rule))
+		}
+	}
+
 	// Peer configurations
 	for _, peer := range config.Peers {
 		// Convert base64 public key to hex for UAPI
@@ -358,6 +263,19 @@ func setInterfaceConfig(interfaceName string, config *Config) error {
 			configStr.WriteString(fmt.Sprintf("persistent_keepalive_interval=%d\n", peer.PersistentKeepalive))
 		}
 
+		if peer.TxRateLimit > 0 {
+			configStr.WriteString(fmt.Sprintf("%s=%d\n", caps.key("tx_rate_limit"), peer.TxRateLimit))
+		}
+		if peer.RxRateLimit > 0 {
+			configStr.WriteString(fmt.Sprintf("%s=%d\n", caps.key("rx_rate_limit"), peer.RxRateLimit))
+		}
+		if peer.DailyQuota > 0 {
+			configStr.WriteString(fmt.Sprintf("%s=%d\n", caps.key("daily_quota"), peer.DailyQuota))
+		}
+		if peer.MonthlyQuota > 0 {
+			configStr.WriteString(fmt.Sprintf("%s=%d\n", caps.key("monthly_quota"), peer.MonthlyQuota))
+		}
+
 		// Clear existing allowed IPs first
 		configStr.WriteString("replace_allowed_ips=true\n")
 
@@ -442,7 +360,7 @@ func resolveEndpoint(endpoint string) (string, error) {
 }
 
 // Print interface information in a nice format
-func printInterfaceInfo(info *InterfaceInfo, showPrivateKey bool) {
+func printInterfaceInfo(info *InterfaceInfo, showPrivateKey, showStats bool) {
 	fmt.Printf("interface: %s\n", info.Name)
 
 	if info.PublicKey != "" {
@@ -463,6 +381,9 @@ func printInterfaceInfo(info *InterfaceInfo, showPrivateKey bool) {
 		fmt.Printf("  fwmark: 0x%x\n", info.FwMark)
 	}
 
+	printFirewall(info)
+	printCapture(info)
+
 	for i, peer := range info.Peers {
 		if i > 0 {
 			fmt.Println()
@@ -494,6 +415,13 @@ func printInterfaceInfo(info *InterfaceInfo, showPrivateKey bool) {
 		if peer.PersistentKeepaliveInterval > 0 {
 			fmt.Printf("  persistent keepalive: every %d seconds\n", peer.PersistentKeepaliveInterval)
 		}
+
+		printRateLimits(peer)
+		printQuotas(peer)
+
+		if showStats {
+			printPeerStats(peer)
+		}
 	}
 
 	fmt.Println()
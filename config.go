@@ -0,0 +1,571 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// A daemonConfig is a configuration file in the wg(8) format, as passed with --config.
+// Keys that are only meaningful to wg-quick(8), such as Address or DNS, are ignored.
+type daemonConfig struct {
+	privateKey *[32]byte
+	listenPort *uint16
+	fwmark     *uint32
+	firewall   *daemonFirewallConfig // nil without a [Firewall] section
+	peers      []daemonPeerConfig
+}
+
+// A daemonFirewallConfig is the [Firewall] section, with a Policy of accept or drop
+// and the rules in the syntax of device.FirewallRule.
+type daemonFirewallConfig struct {
+	accept bool
+	rules  []string // canonical
+}
+
+func (fw *daemonFirewallConfig) equal(other *daemonFirewallConfig) bool {
+	if fw == nil || other == nil {
+		return fw == other
+	}
+	return fw.accept == other.accept && slices.Equal(fw.rules, other.rules)
+}
+
+type daemonPeerConfig struct {
+	publicKey           [32]byte
+	presharedKey        [32]byte
+	endpoint            string
+	persistentKeepalive uint16
+	txRateLimit         uint64 // bits per second, 0 if unlimited
+	rxRateLimit         uint64
+	dailyQuota          uint64 // bytes, 0 if unlimited
+	monthlyQuota        uint64
+	allowedIPs          []netip.Prefix
+}
+
+func (peer *daemonPeerConfig) equal(other *daemonPeerConfig) bool {
+	return peer.publicKey == other.publicKey &&
+		peer.presharedKey == other.presharedKey &&
+		peer.endpoint == other.endpoint &&
+		peer.persistentKeepalive == other.persistentKeepalive &&
+		peer.txRateLimit == other.txRateLimit &&
+		peer.rxRateLimit == other.rxRateLimit &&
+		peer.dailyQuota == other.dailyQuota &&
+		peer.monthlyQuota == other.monthlyQuota &&
+		slices.Equal(peer.allowedIPs, other.allowedIPs)
+}
+
+// equalLive reports whether the peer is configured as live, the same peer as
+// the device runs it. Its endpoint, which may have roamed, is not compared, nor
+// the order of its allowed IPs.
+func (peer *daemonPeerConfig) equalLive(live *daemonPeerConfig) bool {
+	configured, running := *peer, *live
+	running.endpoint = peer.endpoint
+	configured.allowedIPs, running.allowedIPs = sortedPrefixes(peer.allowedIPs), sortedPrefixes(live.allowedIPs)
+	return configured.equal(&running)
+}
+
+func sortedPrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	sorted := slices.SortedFunc(slices.Values(prefixes), func(a, b netip.Prefix) int {
+		return cmp.Or(a.Addr().Compare(b.Addr()), cmp.Compare(a.Bits(), b.Bits()))
+	})
+	return slices.Compact(sorted)
+}
+
+func (config *daemonConfig) lookupPeer(publicKey [32]byte) *daemonPeerConfig {
+	for i := range config.peers {
+		if config.peers[i].publicKey == publicKey {
+			return &config.peers[i]
+		}
+	}
+	return nil
+}
+
+func parseConfigKey(value string) (*[32]byte, error) {
+	var key [32]byte
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 key: %w", err)
+	}
+	if len(decoded) != len(key) {
+		return nil, fmt.Errorf("invalid key length %d", len(decoded))
+	}
+	copy(key[:], decoded)
+	return &key, nil
+}
+
+// loadConfig reads and validates the configuration file at path.
+func loadConfig(path string) (*daemonConfig, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	config := new(daemonConfig)
+	var section string
+	var peer *daemonPeerConfig
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line[1 : len(line)-1])
+			switch section {
+			case "interface":
+			case "firewall":
+				if config.firewall != nil {
+					return nil, fmt.Errorf("%s:%d: duplicate section %q", path, lineNumber, line)
+				}
+				config.firewall = &daemonFirewallConfig{accept: true}
+			case "peer":
+				config.peers = append(config.peers, daemonPeerConfig{})
+				peer = &config.peers[len(config.peers)-1]
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown section %q", path, lineNumber, line)
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: failed to parse line %q", path, lineNumber, line)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch section {
+		case "interface":
+			err = config.parseInterfaceLine(key, value)
+		case "firewall":
+			err = config.firewall.parseFirewallLine(key, value)
+		case "peer":
+			err = peer.parsePeerLine(key, value)
+		default:
+			err = fmt.Errorf("key outside of a section")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[[32]byte]bool, len(config.peers))
+	for i := range config.peers {
+		publicKey := config.peers[i].publicKey
+		if publicKey == ([32]byte{}) {
+			return nil, fmt.Errorf("%s: peer %d is missing PublicKey", path, i+1)
+		}
+		if seen[publicKey] {
+			return nil, fmt.Errorf("%s: duplicate peer %s", path, base64.StdEncoding.EncodeToString(publicKey[:]))
+		}
+		seen[publicKey] = true
+	}
+
+	return config, nil
+}
+
+func (config *daemonConfig) parseInterfaceLine(key, value string) error {
+	switch key {
+	case "privatekey":
+		privateKey, err := parseConfigKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid PrivateKey: %w", err)
+		}
+		config.privateKey = privateKey
+
+	case "listenport":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid ListenPort: %w", err)
+		}
+		listenPort := uint16(port)
+		config.listenPort = &listenPort
+
+	case "fwmark":
+		var mark uint64
+		if value != "off" {
+			var err error
+			mark, err = strconv.ParseUint(value, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid FwMark: %w", err)
+			}
+		}
+		fwmark := uint32(mark)
+		config.fwmark = &fwmark
+
+	case "address", "dns", "mtu", "table", "preup", "postup", "predown", "postdown", "saveconfig":
+		// wg-quick(8) keys, handled outside of the daemon
+
+	default:
+		return fmt.Errorf("unknown interface key %q", key)
+	}
+	return nil
+}
+
+func (peer *daemonPeerConfig) parsePeerLine(key, value string) error {
+	switch key {
+	case "publickey":
+		publicKey, err := parseConfigKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid PublicKey: %w", err)
+		}
+		peer.publicKey = *publicKey
+
+	case "presharedkey":
+		presharedKey, err := parseConfigKey(value)
+		if err != nil {
+			return fmt.Errorf("invalid PresharedKey: %w", err)
+		}
+		peer.presharedKey = *presharedKey
+
+	case "endpoint":
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			return fmt.Errorf("invalid Endpoint: %w", err)
+		}
+		peer.endpoint = value
+
+	case "persistentkeepalive":
+		var interval uint64
+		if value != "off" {
+			var err error
+			interval, err = strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid PersistentKeepalive: %w", err)
+			}
+		}
+		peer.persistentKeepalive = uint16(interval)
+
+	case "txratelimit":
+		rate, err := parseBitRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid TxRateLimit: %w", err)
+		}
+		peer.txRateLimit = rate
+
+	case "rxratelimit":
+		rate, err := parseBitRate(value)
+		if err != nil {
+			return fmt.Errorf("invalid RxRateLimit: %w", err)
+		}
+		peer.rxRateLimit = rate
+
+	case "dailyquota":
+		size, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid DailyQuota: %w", err)
+		}
+		peer.dailyQuota = size
+
+	case "monthlyquota":
+		size, err := parseByteSize(value)
+		if err != nil {
+			return fmt.Errorf("invalid MonthlyQuota: %w", err)
+		}
+		peer.monthlyQuota = size
+
+	case "allowedips":
+		for _, s := range strings.Split(value, ",") {
+			s = strings.TrimSpace(s)
+			if s == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return fmt.Errorf("invalid AllowedIPs: %w", err)
+			}
+			peer.allowedIPs = append(peer.allowedIPs, prefix.Masked())
+		}
+
+	default:
+		return fmt.Errorf("unknown peer key %q", key)
+	}
+	return nil
+}
+
+func (fw *daemonFirewallConfig) parseFirewallLine(key, value string) error {
+	switch key {
+	case "policy":
+		if value != "accept" && value != "drop" {
+			return fmt.Errorf("invalid Policy %q", value)
+		}
+		fw.accept = value == "accept"
+
+	case "rule":
+		rule, err := device.ParseFirewallRule(value)
+		if err != nil {
+			return fmt.Errorf("invalid Rule: %w", err)
+		}
+		fw.rules = append(fw.rules, rule.String())
+
+	default:
+		return fmt.Errorf("unknown firewall key %q", key)
+	}
+	return nil
+}
+
+// bitRateUnits are the suffixes of rates, as used by tc(8).
+var bitRateUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"gbit", 1e9},
+	{"mbit", 1e6},
+	{"kbit", 1e3},
+	{"bit", 1},
+}
+
+// parseBitRate parses a rate in bits per second such as 20Mbit, 512kbit or
+// 1000000. Zero and "off" mean unlimited.
+func parseBitRate(value string) (uint64, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	number, scale := strings.ToLower(value), 1.0
+	for _, unit := range bitRateUnits {
+		if n, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, scale = n, unit.scale
+			break
+		}
+	}
+	rate, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || rate < 0 || rate*scale >= 1<<63 {
+		return 0, fmt.Errorf("invalid rate %q", value)
+	}
+	return uint64(rate * scale), nil
+}
+
+// byteSizeUnits are the suffixes of sizes, decimal and binary.
+var byteSizeUnits = []struct {
+	suffix string
+	scale  float64
+}{
+	{"tib", 1 << 40},
+	{"gib", 1 << 30},
+	{"mib", 1 << 20},
+	{"kib", 1 << 10},
+	{"tb", 1e12},
+	{"gb", 1e9},
+	{"mb", 1e6},
+	{"kb", 1e3},
+	{"b", 1},
+}
+
+// parseByteSize parses a size in bytes such as 50GB, 1.5TiB or 1000000. Zero
+// and "off" mean unlimited.
+func parseByteSize(value string) (uint64, error) {
+	if value == "off" {
+		return 0, nil
+	}
+	number, scale := strings.ToLower(value), 1.0
+	for _, unit := range byteSizeUnits {
+		if n, ok := strings.CutSuffix(number, unit.suffix); ok {
+			number, scale = n, unit.scale
+			break
+		}
+	}
+	size, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || size < 0 || size*scale >= 1<<63 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return uint64(size * scale), nil
+}
+
+// uapi returns the UAPI set operation that moves a device running the
+// configuration live to this one, where old is the configuration file applied
+// last. Peers configured as in live are left untouched, so that their sessions
+// survive, and an endpoint is only set for new peers or when the file changed
+// it, so that roamed endpoints survive too. Peers in live that are not part of
+// this configuration are removed, a FwMark no longer set is reset to 0, and so
+// is a ListenPort that was set in old. Nil old and live configurations produce
+// the full configuration.
+func (config *daemonConfig) uapi(old, live *daemonConfig) string {
+	var b strings.Builder
+	if old == nil {
+		old = new(daemonConfig)
+	}
+	if live == nil {
+		live = new(daemonConfig)
+	}
+
+	if config.privateKey != nil && (live.privateKey == nil || clampedPrivateKey(*live.privateKey) != clampedPrivateKey(*config.privateKey)) {
+		fmt.Fprintf(&b, "private_key=%s\n", hex.EncodeToString(config.privateKey[:]))
+	}
+	if config.listenPort != nil && (live.listenPort == nil || *live.listenPort != *config.listenPort) {
+		fmt.Fprintf(&b, "listen_port=%d\n", *config.listenPort)
+	} else if config.listenPort == nil && old.listenPort != nil && *old.listenPort != 0 {
+		// without a ListenPort the device picks a port, so the live one is kept
+		b.WriteString("listen_port=0\n")
+	}
+	if config.fwmark != nil && (live.fwmark == nil || *live.fwmark != *config.fwmark) {
+		fmt.Fprintf(&b, "fwmark=%d\n", *config.fwmark)
+	} else if config.fwmark == nil && live.fwmark != nil && *live.fwmark != 0 {
+		b.WriteString("fwmark=0\n")
+	}
+	if !config.firewall.equal(live.firewall) {
+		fw := config.firewall
+		if fw == nil {
+			fw = &daemonFirewallConfig{accept: true}
+		}
+		if fw.accept {
+			b.WriteString("wggo_firewall_policy=accept\n")
+		} else {
+			b.WriteString("wggo_firewall_policy=drop\n")
+		}
+		b.WriteString("wggo_replace_firewall_rules=true\n")
+		for _, rule := range fw.rules {
+			fmt.Fprintf(&b, "wggo_firewall_rule=%s\n", rule)
+		}
+	}
+
+	for _, livePeer := range live.peers {
+		if config.lookupPeer(livePeer.publicKey) == nil {
+			fmt.Fprintf(&b, "public_key=%s\nremove=true\n", hex.EncodeToString(livePeer.publicKey[:]))
+		}
+	}
+
+	for i := range config.peers {
+		peer := &config.peers[i]
+		livePeer := live.lookupPeer(peer.publicKey)
+		endpointChanged := true
+		if oldPeer := old.lookupPeer(peer.publicKey); oldPeer != nil && livePeer != nil {
+			endpointChanged = oldPeer.endpoint != peer.endpoint
+		}
+		if livePeer != nil && !endpointChanged && peer.equalLive(livePeer) {
+			continue
+		}
+		fmt.Fprintf(&b, "public_key=%s\n", hex.EncodeToString(peer.publicKey[:]))
+		fmt.Fprintf(&b, "preshared_key=%s\n", hex.EncodeToString(peer.presharedKey[:]))
+		if peer.endpoint != "" && endpointChanged {
+			fmt.Fprintf(&b, "endpoint=%s\n", peer.endpoint)
+		}
+		fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", peer.persistentKeepalive)
+		fmt.Fprintf(&b, "wggo_tx_rate_limit=%d\nwggo_rx_rate_limit=%d\n", peer.txRateLimit, peer.rxRateLimit)
+		fmt.Fprintf(&b, "wggo_daily_quota=%d\nwggo_monthly_quota=%d\n", peer.dailyQuota, peer.monthlyQuota)
+		b.WriteString("replace_allowed_ips=true\n")
+		for _, prefix := range peer.allowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", prefix)
+		}
+	}
+
+	return b.String()
+}
+
+// clampedPrivateKey returns key clamped for Curve25519, as the device keeps it.
+func clampedPrivateKey(key [32]byte) [32]byte {
+	key[0] &= 248
+	key[31] = (key[31] & 127) | 64
+	return key
+}
+
+// liveConfig reads the configuration dev runs, in the terms of a configuration
+// file: endpoints are those the peers roamed to, and a firewall that accepts
+// everything is no firewall.
+func liveConfig(dev *device.Device) (*daemonConfig, error) {
+	state, err := dev.IpcGet()
+	if err != nil {
+		return nil, err
+	}
+	config := new(daemonConfig)
+	var peer *daemonPeerConfig
+	for _, line := range strings.Split(state, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		n, parseErr := strconv.ParseUint(value, 10, 64)
+		switch {
+		case key == "private_key":
+			config.privateKey = new([32]byte)
+			_, err = hex.Decode(config.privateKey[:], []byte(value))
+		case key == "listen_port":
+			port := uint16(n)
+			config.listenPort, err = &port, parseErr
+		case key == "fwmark":
+			mark := uint32(n)
+			config.fwmark, err = &mark, parseErr
+		case key == "firewall_policy":
+			config.firewall = &daemonFirewallConfig{accept: value == "accept"}
+		case key == "firewall_rule" && config.firewall != nil:
+			config.firewall.rules = append(config.firewall.rules, value)
+		case key == "public_key":
+			config.peers = append(config.peers, daemonPeerConfig{})
+			peer = &config.peers[len(config.peers)-1]
+			_, err = hex.Decode(peer.publicKey[:], []byte(value))
+		case peer == nil:
+		case key == "preshared_key":
+			_, err = hex.Decode(peer.presharedKey[:], []byte(value))
+		case key == "endpoint":
+			peer.endpoint = value
+		case key == "persistent_keepalive_interval":
+			peer.persistentKeepalive, err = uint16(n), parseErr
+		case key == "tx_rate_limit":
+			peer.txRateLimit, err = n, parseErr
+		case key == "rx_rate_limit":
+			peer.rxRateLimit, err = n, parseErr
+		case key == "daily_quota":
+			peer.dailyQuota, err = n, parseErr
+		case key == "monthly_quota":
+			peer.monthlyQuota, err = n, parseErr
+		case key == "allowed_ip":
+			var prefix netip.Prefix
+			prefix, err = netip.ParsePrefix(value)
+			peer.allowedIPs = append(peer.allowedIPs, prefix)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %w", line, err)
+		}
+	}
+	if fw := config.firewall; fw != nil && fw.accept && len(fw.rules) == 0 {
+		config.firewall = nil
+	}
+	return config, nil
+}
+
+// reloadConfig re-reads the configuration file and reconciles the device with it.
+// It returns the configuration that is now in effect; on failure that is nil, so that
+// the next reload reapplies everything.
+func reloadConfig(dev *device.Device, logger *device.Logger, path string, old *daemonConfig) *daemonConfig {
+	logger.Verbosef("Reloading configuration from %s", path)
+
+	config, err := loadConfig(path)
+	if err != nil {
+		logger.Errorf("Failed to reload configuration, keeping current one: %v", err)
+		return old
+	}
+
+	live, err := liveConfig(dev)
+	if err != nil {
+		logger.Errorf("Failed to read current configuration: %v", err)
+		return old
+	}
+
+	if err := dev.IpcSet(config.uapi(old, live)); err != nil {
+		logger.Errorf("Failed to apply reloaded configuration: %v", err)
+		return nil
+	}
+
+	logger.Verbosef("Configuration reloaded")
+	return config
+}
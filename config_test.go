@@ -0,0 +1,334 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func testKey(b byte) [32]byte {
+	var key [32]byte
+	for i := range key {
+		key[i] = b
+	}
+	return key
+}
+
+func testKeyBase64(b byte) string {
+	key := testKey(b)
+	return base64.StdEncoding.EncodeToString(key[:])
+}
+
+func testKeyHex(b byte) string {
+	key := testKey(b)
+	return hex.EncodeToString(key[:])
+}
+
+func writeTestConfig(t *testing.T, dir, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, "wg0.conf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeTestConfig(t, t.TempDir(), `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+Address = 10.0.0.1/24 # handled by wg-quick
+ListenPort = 51820
+DNS = 1.1.1.1
+
+[Peer]
+PublicKey = `+testKeyBase64(2)+`
+Endpoint = vpn.example.com:51820
+AllowedIPs = 10.0.0.2/32, 192.168.1.1/24
+PersistentKeepalive = 25
+TxRateLimit = 20Mbit
+RxRateLimit = 1.5Gbit
+DailyQuota = 5GB
+MonthlyQuota = 1TiB
+`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if config.privateKey == nil || *config.privateKey != testKey(1) {
+		t.Errorf("unexpected private key")
+	}
+	if config.listenPort == nil || *config.listenPort != 51820 {
+		t.Errorf("unexpected listen port %v", config.listenPort)
+	}
+	if len(config.peers) != 1 {
+		t.Fatalf("expected 1 peer, got %d", len(config.peers))
+	}
+	peer := config.peers[0]
+	if peer.publicKey != testKey(2) || peer.endpoint != "vpn.example.com:51820" || peer.persistentKeepalive != 25 {
+		t.Errorf("unexpected peer %+v", peer)
+	}
+	if peer.txRateLimit != 20_000_000 || peer.rxRateLimit != 1_500_000_000 {
+		t.Errorf("unexpected rate limits %d, %d", peer.txRateLimit, peer.rxRateLimit)
+	}
+	if peer.dailyQuota != 5_000_000_000 || peer.monthlyQuota != 1<<40 {
+		t.Errorf("unexpected quotas %d, %d", peer.dailyQuota, peer.monthlyQuota)
+	}
+	if len(peer.allowedIPs) != 2 || peer.allowedIPs[1].String() != "192.168.1.0/24" {
+		t.Errorf("unexpected allowed ips %v", peer.allowedIPs)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown key":        "[Interface]\nListenPort = 1\nBogus = 1\n",
+		"bad key":            "[Peer]\nPublicKey = notbase64\n",
+		"missing public key": "[Peer]\nAllowedIPs = 10.0.0.0/8\n",
+		"duplicate peer":     "[Peer]\nPublicKey = " + testKeyBase64(2) + "\n[Peer]\nPublicKey = " + testKeyBase64(2) + "\n",
+		"bad allowed ip":     "[Peer]\nPublicKey = " + testKeyBase64(2) + "\nAllowedIPs = 10.0.0.300/8\n",
+		"outside section":    "ListenPort = 1\n",
+		"bad rate limit":     "[Peer]\nPublicKey = " + testKeyBase64(2) + "\nTxRateLimit = 20MB\n",
+		"bad firewall rule":  "[Firewall]\nRule = allow out\n",
+		"bad quota":          "[Peer]\nPublicKey = " + testKeyBase64(2) + "\nDailyQuota = 5Gbit\n",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			path := writeTestConfig(t, t.TempDir(), content)
+			if _, err := loadConfig(path); err == nil {
+				t.Errorf("expected error loading config:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestConfigFirewall(t *testing.T) {
+	dir := t.TempDir()
+	config, err := loadConfig(writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+
+[Firewall]
+Policy = drop
+Rule = accept out peer `+testKeyBase64(2)+` proto tcp to 10.0.0.5 dport 443
+Rule = accept in proto icmp log
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "wggo_firewall_policy=drop\nwggo_replace_firewall_rules=true\n" +
+		"wggo_firewall_rule=accept out peer " + testKeyBase64(2) + " proto tcp to 10.0.0.5/32 dport 443\n" +
+		"wggo_firewall_rule=accept in proto icmp log\n"
+	if got := config.uapi(nil, nil); !strings.Contains(got, want) {
+		t.Errorf("full configuration lacks the firewall:\n%s\nexpected:\n%s", got, want)
+	}
+	if got := config.uapi(config, config); strings.Contains(got, "firewall") {
+		t.Errorf("unchanged firewall reconfigured:\n%s", got)
+	}
+
+	removed, err := loadConfig(writeTestConfig(t, dir, "[Interface]\nPrivateKey = "+testKeyBase64(1)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := removed.uapi(config, config); got != "wggo_firewall_policy=accept\nwggo_replace_firewall_rules=true\n" {
+		t.Errorf("unexpected operation removing the firewall:\n%s", got)
+	}
+}
+
+func TestParseBitRate(t *testing.T) {
+	for value, want := range map[string]uint64{
+		"off":      0,
+		"0":        0,
+		"1000000":  1_000_000,
+		"512kbit":  512_000,
+		"20Mbit":   20_000_000,
+		"2.5 Gbit": 2_500_000_000,
+		"64bit":    64,
+	} {
+		if got, err := parseBitRate(value); err != nil || got != want {
+			t.Errorf("parseBitRate(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "fast", "-1Mbit", "20MB", "1e30"} {
+		if _, err := parseBitRate(value); err == nil {
+			t.Errorf("parseBitRate(%q) succeeded", value)
+		}
+	}
+}
+
+func TestParseByteSize(t *testing.T) {
+	for value, want := range map[string]uint64{
+		"off":     0,
+		"0":       0,
+		"1000000": 1_000_000,
+		"512KB":   512_000,
+		"512KiB":  512 << 10,
+		"50GB":    50_000_000_000,
+		"1.5 TiB": 3 << 39,
+		"64B":     64,
+	} {
+		if got, err := parseByteSize(value); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "lots", "-1GB", "5Gbit", "1e30"} {
+		if _, err := parseByteSize(value); err == nil {
+			t.Errorf("parseByteSize(%q) succeeded", value)
+		}
+	}
+}
+
+func TestConfigUAPIReconcile(t *testing.T) {
+	dir := t.TempDir()
+	old, err := loadConfig(writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+ListenPort = 51820
+
+[Peer]
+PublicKey = `+testKeyBase64(2)+`
+AllowedIPs = 10.0.0.2/32
+
+[Peer]
+PublicKey = `+testKeyBase64(3)+`
+AllowedIPs = 10.0.0.3/32
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := old.uapi(nil, nil)
+	for _, expected := range []string{"private_key=", "listen_port=51820\n", "public_key=" + testKeyHex(2), "public_key=" + testKeyHex(3)} {
+		if !strings.Contains(full, expected) {
+			t.Errorf("expected %q in full configuration:\n%s", expected, full)
+		}
+	}
+
+	updated, err := loadConfig(writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+ListenPort = 51820
+
+[Peer]
+PublicKey = `+testKeyBase64(2)+`
+AllowedIPs = 10.0.0.2/32
+
+[Peer]
+PublicKey = `+testKeyBase64(4)+`
+AllowedIPs = 10.0.0.4/32
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := updated.uapi(old, old)
+	expected := "public_key=" + testKeyHex(3) + "\nremove=true\n" +
+		"public_key=" + testKeyHex(4) + "\n" +
+		"preshared_key=" + strings.Repeat("0", 64) + "\n" +
+		"persistent_keepalive_interval=0\n" +
+		"wggo_tx_rate_limit=0\nwggo_rx_rate_limit=0\n" +
+		"wggo_daily_quota=0\nwggo_monthly_quota=0\n" +
+		"replace_allowed_ips=true\n" +
+		"allowed_ip=10.0.0.4/32\n"
+	if diff != expected {
+		t.Errorf("unexpected reconcile operation:\n%s\nexpected:\n%s", diff, expected)
+	}
+
+	// settings removed from the file go back to their defaults
+	mark := uint32(0x1234)
+	updated.fwmark = &mark
+	removed, err := loadConfig(writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := *updated
+	live.peers = nil
+	if diff := removed.uapi(updated, &live); diff != "listen_port=0\nfwmark=0\n" {
+		t.Errorf("unexpected operation removing ListenPort and FwMark:\n%s", diff)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+
+[Peer]
+PublicKey = `+testKeyBase64(2)+`
+AllowedIPs = 10.0.0.2/32
+`)
+	config, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := device.NewLogger(device.LogLevelError, "")
+	dev := device.NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], logger)
+	defer dev.Close()
+	if err := dev.IpcSet(config.uapi(nil, nil)); err != nil {
+		t.Fatalf("failed to apply configuration: %v", err)
+	}
+
+	// A peer added at runtime is removed on reload, a broken file keeps the current state.
+	if err := dev.IpcSet("public_key=" + testKeyHex(5) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, dir, "[Interface]\nBogus = 1\n")
+	if reloaded := reloadConfig(dev, logger, path, config); reloaded != config {
+		t.Errorf("expected broken configuration to be ignored")
+	}
+
+	writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+
+[Peer]
+PublicKey = `+testKeyBase64(3)+`
+AllowedIPs = 10.0.0.3/32
+`)
+	config = reloadConfig(dev, logger, path, config)
+	if config == nil {
+		t.Fatal("failed to reload configuration")
+	}
+
+	live, err := liveConfig(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(live.peers) != 1 || live.peers[0].publicKey != testKey(3) {
+		t.Errorf("unexpected peers after reload: %+v", live.peers)
+	}
+
+	// A peer changed at runtime is configured as in the file again, although
+	// the file did not change.
+	if err := dev.IpcSet("public_key=" + testKeyHex(3) + "\npersistent_keepalive_interval=25\nallowed_ip=10.0.0.4/32\n"); err != nil {
+		t.Fatal(err)
+	}
+	if config = reloadConfig(dev, logger, path, config); config == nil {
+		t.Fatal("failed to reload configuration")
+	}
+	if live, err = liveConfig(dev); err != nil {
+		t.Fatal(err)
+	}
+	if !live.peers[0].equalLive(&config.peers[0]) {
+		t.Errorf("peer changed at runtime not reconfigured: %+v", live.peers[0])
+	}
+	if diff := config.uapi(config, live); diff != "" {
+		t.Errorf("unexpected operation for an unchanged device:\n%s", diff)
+	}
+}
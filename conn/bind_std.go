@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"os"
 	"runtime"
 	"strconv"
 	"sync"
@@ -46,6 +47,8 @@ type StdNetBind struct {
 
 	blackhole4 bool
 	blackhole6 bool
+
+	inherited []*net.UDPConn // sockets of another process, for the next Open
 }
 
 func NewStdNetBind() Bind {
@@ -82,9 +85,34 @@ type StdNetEndpoint struct {
 	src []byte
 }
 
+// NewStdNetBindFromFiles returns a StdNetBind whose next Open resumes the sockets
+// in files, as returned by SocketFiles in another process, instead of opening new
+// ones, as long as it is asked for their port or for any port. The caller may close
+// files afterwards.
+func NewStdNetBindFromFiles(files []*os.File) (Bind, error) {
+	s := NewStdNetBind().(*StdNetBind)
+	for _, file := range files {
+		c, err := net.FilePacketConn(file)
+		if err == nil {
+			if udp, ok := c.(*net.UDPConn); ok {
+				s.inherited = append(s.inherited, udp)
+				continue
+			}
+			c.Close()
+			err = fmt.Errorf("%s is not a UDP socket", file.Name())
+		}
+		for _, udp := range s.inherited {
+			udp.Close()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
 var (
-	_ Bind     = (*StdNetBind)(nil)
-	_ Endpoint = &StdNetEndpoint{}
+	_ Bind        = (*StdNetBind)(nil)
+	_ Endpoint    = &StdNetEndpoint{}
+	_ SocketFiles = (*StdNetBind)(nil)
 )
 
 func (*StdNetBind) ParseEndpoint(s string) (Endpoint, error) {
@@ -148,13 +176,17 @@ func (s *StdNetBind) Open(uport uint16) ([]ReceiveFunc, uint16, error) {
 		return nil, 0, ErrBindAlreadyOpen
 	}
 
+	if s.inherited != nil {
+		if v4conn, v6conn, port := s.takeInheritedLocked(uport); v4conn != nil || v6conn != nil {
+			return s.useLocked(v4conn, v6conn), uint16(port), nil
+		}
+	}
+
 	// Attempt to open ipv4 and ipv6 listeners on the same port.
 	// If uport is 0, we can retry on failure.
 again:
 	port := int(uport)
 	var v4conn, v6conn *net.UDPConn
-	var v4pc *ipv4.PacketConn
-	var v6pc *ipv6.PacketConn
 
 	v4conn, port, err = listenNet("udp4", port)
 	if err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
@@ -172,6 +204,19 @@ again:
 		v4conn.Close()
 		return nil, 0, err
 	}
+	fns := s.useLocked(v4conn, v6conn)
+	if len(fns) == 0 {
+		return nil, 0, syscall.EAFNOSUPPORT
+	}
+
+	return fns, uint16(port), nil
+}
+
+// useLocked makes v4conn and v6conn, either of which may be nil, the sockets of
+// the bind and returns their receive functions.
+func (s *StdNetBind) useLocked(v4conn, v6conn *net.UDPConn) []ReceiveFunc {
+	var v4pc *ipv4.PacketConn
+	var v6pc *ipv6.PacketConn
 	var fns []ReceiveFunc
 	if v4conn != nil {
 		s.ipv4TxOffload, s.ipv4RxOffload = supportsUDPOffload(v4conn)
@@ -191,11 +236,64 @@ again:
 		fns = append(fns, s.makeReceiveIPv6(v6pc, v6conn, s.ipv6RxOffload))
 		s.ipv6 = v6conn
 	}
-	if len(fns) == 0 {
-		return nil, 0, syscall.EAFNOSUPPORT
+	return fns
+}
+
+// UDPOffloads reports whether the sockets of the bind segment outgoing (UDP_SEGMENT)
+// and coalesce incoming (UDP_GRO) datagrams in the kernel. Transmit offload is
+// turned off again if sending fails with it.
+func (s *StdNetBind) UDPOffloads() (tx, rx bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ipv4 != nil {
+		return s.ipv4TxOffload, s.ipv4RxOffload
 	}
+	return s.ipv6TxOffload, s.ipv6RxOffload
+}
 
-	return fns, uint16(port), nil
+// takeInheritedLocked returns the sockets passed to NewStdNetBindFromFiles if they
+// are bound to uport, or uport is zero, and closes them otherwise. Either way, they
+// are only considered once.
+func (s *StdNetBind) takeInheritedLocked(uport uint16) (v4conn, v6conn *net.UDPConn, port int) {
+	inherited := s.inherited
+	s.inherited = nil
+	for _, c := range inherited {
+		addr, ok := c.LocalAddr().(*net.UDPAddr)
+		switch {
+		case !ok || (uport != 0 && addr.Port != int(uport)) || (port != 0 && addr.Port != port):
+			c.Close()
+		case addr.IP.To4() != nil && v4conn == nil:
+			v4conn, port = c, addr.Port
+		case addr.IP.To4() == nil && v6conn == nil:
+			v6conn, port = c, addr.Port
+		default:
+			c.Close()
+		}
+	}
+	return v4conn, v6conn, port
+}
+
+// SocketFiles returns duplicates of the open sockets, for another process to
+// resume with NewStdNetBindFromFiles.
+func (s *StdNetBind) SocketFiles() ([]*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*os.File
+	for _, c := range []*net.UDPConn{s.ipv4, s.ipv6} {
+		if c == nil {
+			continue
+		}
+		file, err := c.File()
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
 }
 
 func (s *StdNetBind) putMessages(msgs *[]ipv6.Message) {
@@ -313,6 +411,10 @@ func (s *StdNetBind) Close() error {
 		s.ipv6 = nil
 		s.ipv6PC = nil
 	}
+	for _, c := range s.inherited {
+		c.Close()
+	}
+	s.inherited = nil
 	s.blackhole4 = false
 	s.blackhole6 = false
 	s.ipv4TxOffload = false
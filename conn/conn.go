@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"os"
 	"reflect"
 	"runtime"
 	"strings"
@@ -29,8 +30,8 @@ type ReceiveFunc func(packets [][]byte, sizes []int, eps []Endpoint) (n int, err
 
 // A Bind listens on a port for both IPv6 and IPv4 UDP traffic.
 //
-// A Bind interface may also be a PeekLookAtSocketFd or BindSocketToInterface,
-// depending on the platform-specific implementation.
+// A Bind interface may also be a PeekLookAtSocketFd, BindSocketToInterface or
+// SocketFiles, depending on the platform-specific implementation.
 type Bind interface {
 	// Open puts the Bind into a listening state on a given port and reports the actual
 	// port that it bound to. Passing zero results in a random selection.
@@ -71,6 +72,12 @@ type PeekLookAtSocketFd interface {
 	PeekLookAtSocketFd6() (fd int, err error)
 }
 
+// SocketFiles is implemented by Bind objects whose open sockets can be taken over
+// by another process. Used by live upgrades of the daemon.
+type SocketFiles interface {
+	SocketFiles() ([]*os.File, error)
+}
+
 // An Endpoint maintains the source/destination caching for a peer.
 //
 //	dst: the remote address of a peer ("endpoint" in uapi terminology)
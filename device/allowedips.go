@@ -303,6 +303,25 @@ func (table *AllowedIPs) Insert(prefix netip.Prefix, peer *Peer) {
 	}
 }
 
+// owner returns the peer with exactly prefix among its allowed IPs, if any.
+func (table *AllowedIPs) owner(prefix netip.Prefix) *Peer {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	var node *trieEntry
+	var exact bool
+	if prefix.Addr().Is6() {
+		ip := prefix.Addr().As16()
+		node, exact = table.IPv6.nodePlacement(ip[:], uint8(prefix.Bits()))
+	} else {
+		ip := prefix.Addr().As4()
+		node, exact = table.IPv4.nodePlacement(ip[:], uint8(prefix.Bits()))
+	}
+	if !exact || node == nil {
+		return nil
+	}
+	return node.peer
+}
+
 func (table *AllowedIPs) Lookup(ip []byte) *Peer {
 	table.mutex.RLock()
 	defer table.mutex.RUnlock()
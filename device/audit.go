@@ -0,0 +1,252 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AuditCaller identifies the process at the other end of a UAPI connection, as
+// the kernel reports it when the connection is accepted.
+type AuditCaller struct {
+	Pid int    `json:"pid"`
+	Uid int    `json:"uid"`
+	Gid int    `json:"gid"`
+	Exe string `json:"exe,omitempty"` // empty if it could not be read
+}
+
+// An auditLog records the UAPI set operations of a device.
+type auditLog struct {
+	w    io.Writer
+	name string
+}
+
+// SetAuditLog makes the device append an entry to w for every UAPI set
+// operation, whether from a UAPI connection or in-process, as when applying a
+// configuration file. Each entry is a line of JSON naming the interface, the
+// caller where known, the outcome and, for each configuration key the
+// operation changed, its old and new values, with private and preshared keys
+// redacted. Each entry is a single Write, so several devices may share a file
+// opened for appending. A nil w stops recording.
+func (device *Device) SetAuditLog(w io.Writer, name string) {
+	if w == nil {
+		device.auditLog.Store(nil)
+		return
+	}
+	device.auditLog.Store(&auditLog{w: w, name: name})
+}
+
+// An auditEntry is one line of the audit log.
+type auditEntry struct {
+	Time      time.Time     `json:"time"`
+	Interface string        `json:"interface,omitempty"`
+	Source    string        `json:"source"`           // "uapi" or "internal"
+	Caller    *AuditCaller  `json:"caller,omitempty"` // nil unless known
+	DryRun    bool          `json:"dry_run,omitempty"`
+	Outcome   string        `json:"outcome"` // "ok" or "error"
+	Errno     int64         `json:"errno,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Changes   []auditChange `json:"changes"`
+}
+
+// An auditChange is a configuration key whose value changed. A key with several
+// values, like allowed_ip, has a change for each value added or removed.
+type auditChange struct {
+	Peer string `json:"peer,omitempty"` // public key in base64, for peer keys
+	Key  string `json:"key"`
+	Old  string `json:"old"` // empty if unset
+	New  string `json:"new"` // empty if unset
+}
+
+// auditKeys are the keys of the UAPI get operation that hold configuration, as
+// opposed to counters and other state, with whether each may have several
+// values and whether their order matters.
+var auditKeys = map[string]struct{ multi, ordered bool }{
+	"private_key":                   {},
+	"listen_port":                   {},
+	"fwmark":                        {},
+	"dns_monitor_interval":          {},
+	"firewall_policy":               {},
+	"firewall_rule":                 {multi: true, ordered: true},
+	"capture_file":                  {},
+	"capture_filter":                {},
+	"capture_max_bytes":             {},
+	"log_level":                     {multi: true},
+	"log_peer":                      {multi: true},
+	"preshared_key":                 {},
+	"endpoint":                      {},
+	"dns_hostname":                  {},
+	"dns_port":                      {},
+	"persistent_keepalive_interval": {},
+	"tx_rate_limit":                 {},
+	"rx_rate_limit":                 {},
+	"daily_quota":                   {},
+	"monthly_quota":                 {},
+	"allowed_ip":                    {multi: true},
+}
+
+// An auditSnapshot is the configuration of a device: the values of auditKeys
+// for the device, under the zero key, and for each peer.
+type auditSnapshot map[NoisePublicKey]map[string][]string
+
+// auditSnapshot reads the configuration of the device and of peers, or of all
+// peers if nil. The caller must hold the ipcMutex.
+func (device *Device) auditSnapshot(peers map[NoisePublicKey]bool) auditSnapshot {
+	var buf bytes.Buffer
+	device.ipcGetOperation(&buf, &ipcGetQuery{peers: peers, omitStats: true})
+	snapshot := auditSnapshot{{}: {}}
+	values := snapshot[NoisePublicKey{}]
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		if key == "public_key" {
+			var peer NoisePublicKey
+			hex.Decode(peer[:], []byte(value))
+			values = make(map[string][]string)
+			snapshot[peer] = values
+			continue
+		}
+		if key == "preshared_key" && strings.Trim(value, "0") == "" {
+			continue // no preshared key
+		}
+		if _, ok := auditKeys[key]; ok {
+			values[key] = append(values[key], value)
+		}
+	}
+	return snapshot
+}
+
+// auditChanges lists the changes from before to after, the device first, then
+// the peers in the order of their public keys.
+func auditChanges(before, after auditSnapshot) []auditChange {
+	var peers []NoisePublicKey
+	for peer := range before {
+		peers = append(peers, peer)
+	}
+	for peer := range after {
+		if _, ok := before[peer]; !ok {
+			peers = append(peers, peer)
+		}
+	}
+	slices.SortFunc(peers, func(a, b NoisePublicKey) int {
+		return bytes.Compare(a[:], b[:])
+	})
+
+	keys := slices.Sorted(maps.Keys(auditKeys))
+	changes := []auditChange{}
+	for _, peer := range peers {
+		var name string
+		if !peer.IsZero() {
+			// a peer added or removed changes its public key from or to unset
+			name = base64.StdEncoding.EncodeToString(peer[:])
+			_, existed := before[peer]
+			_, exists := after[peer]
+			switch {
+			case existed && !exists:
+				changes = append(changes, auditChange{Peer: name, Key: "public_key", Old: name})
+			case !existed && exists:
+				changes = append(changes, auditChange{Peer: name, Key: "public_key", New: name})
+			}
+		}
+		for _, key := range keys {
+			changes = auditValueChanges(changes, name, key, before[peer][key], after[peer][key])
+		}
+	}
+	return changes
+}
+
+// auditValueChanges appends the changes of a key from the values old to new.
+func auditValueChanges(changes []auditChange, peer, key string, old, new []string) []auditChange {
+	kind := auditKeys[key]
+	switch {
+	case slices.Equal(old, new):
+		return changes
+	case !kind.multi:
+		change := auditChange{Peer: peer, Key: key}
+		if len(old) > 0 {
+			change.Old = old[0]
+		}
+		if len(new) > 0 {
+			change.New = new[0]
+		}
+		return append(changes, redactAuditChange(change))
+	case kind.ordered:
+		// list everything, as the order changed if nothing else
+		for _, value := range old {
+			changes = append(changes, auditChange{Peer: peer, Key: key, Old: value})
+		}
+		for _, value := range new {
+			changes = append(changes, auditChange{Peer: peer, Key: key, New: value})
+		}
+		return changes
+	}
+	for _, value := range old {
+		if !slices.Contains(new, value) {
+			changes = append(changes, auditChange{Peer: peer, Key: key, Old: value})
+		}
+	}
+	for _, value := range new {
+		if !slices.Contains(old, value) {
+			changes = append(changes, auditChange{Peer: peer, Key: key, New: value})
+		}
+	}
+	return changes
+}
+
+// redactAuditChange hides the values of secret keys, keeping whether they are
+// set.
+func redactAuditChange(change auditChange) auditChange {
+	if change.Key != "private_key" && change.Key != "preshared_key" {
+		return change
+	}
+	if change.Old != "" {
+		change.Old = "(redacted)"
+	}
+	if change.New != "" {
+		change.New = "(redacted)"
+	}
+	return change
+}
+
+// write appends the entry for a set operation with the outcome err, which
+// changed the configuration from before to after, or would have for a dry run.
+func (l *auditLog) write(device *Device, caller *AuditCaller, source string, dryRun bool, before, after auditSnapshot, err error) {
+	entry := auditEntry{
+		Time:      time.Now(),
+		Interface: l.name,
+		Source:    source,
+		Caller:    caller,
+		DryRun:    dryRun,
+		Outcome:   "ok",
+		Changes:   auditChanges(before, after),
+	}
+	if err != nil {
+		entry.Outcome = "error"
+		entry.Error = err.Error()
+		var status *IPCError
+		if errors.As(err, &status) {
+			entry.Errno = status.ErrorCode()
+		}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		device.logs.uapi.Errorf("Audit: failed to encode entry: %v", err)
+		return
+	}
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		device.logs.uapi.Errorf("Audit: failed to write entry: %v", err)
+	}
+}
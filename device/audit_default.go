@@ -0,0 +1,14 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "net"
+
+func ipcCaller(_ net.Conn) *AuditCaller {
+	return nil
+}
@@ -0,0 +1,38 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ipcCaller identifies the process at the other end of a UAPI unix socket with
+// SO_PEERCRED, or returns nil for other connections.
+func ipcCaller(socket net.Conn) *AuditCaller {
+	conn, ok := socket.(*net.UnixConn)
+	if !ok {
+		return nil
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *unix.Ucred
+	raw.Control(func(fd uintptr) {
+		cred, err = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil
+	}
+	caller := &AuditCaller{Pid: int(cred.Pid), Uid: int(cred.Uid), Gid: int(cred.Gid)}
+	// unreadable once privileges are dropped, unless the caller runs as the same user
+	caller.Exe, _ = os.Readlink("/proc/" + strconv.Itoa(caller.Pid) + "/exe")
+	return caller
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestAuditLog(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	var audit lockedBuffer
+	dev.SetAuditLog(&audit, "wg0")
+
+	var peer NoisePublicKey
+	peer[0] = 1
+	peerName := base64.StdEncoding.EncodeToString(peer[:])
+	entries := func() []auditEntry {
+		var entries []auditEntry
+		for _, line := range strings.Split(strings.TrimSpace(audit.String()), "\n") {
+			var entry auditEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("%q: %v", line, err)
+			}
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+	last := func() auditEntry {
+		entries := entries()
+		return entries[len(entries)-1]
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("private_key=%064x\npublic_key=%x\npreshared_key=%064x\nallowed_ip=10.0.0.1/32\nallowed_ip=10.0.0.2/32\n", 7, peer[:], 9)); err != nil {
+		t.Fatal(err)
+	}
+	entry := last()
+	if entry.Interface != "wg0" || entry.Source != "internal" || entry.Caller != nil || entry.Outcome != "ok" {
+		t.Errorf("entry %+v", entry)
+	}
+	want := []auditChange{
+		{Key: "private_key", New: "(redacted)"},
+		{Peer: peerName, Key: "public_key", New: peerName},
+		{Peer: peerName, Key: "allowed_ip", New: "10.0.0.1/32"},
+		{Peer: peerName, Key: "allowed_ip", New: "10.0.0.2/32"},
+		{Peer: peerName, Key: "persistent_keepalive_interval", New: "0"},
+		{Peer: peerName, Key: "preshared_key", New: "(redacted)"},
+	}
+	if fmt.Sprint(entry.Changes) != fmt.Sprint(want) {
+		t.Errorf("changes %v, want %v", entry.Changes, want)
+	}
+	if strings.Contains(audit.String(), fmt.Sprintf("%064x", 7)) || strings.Contains(audit.String(), fmt.Sprintf("%064x", 9)) {
+		t.Errorf("secret in the audit log: %s", audit.String())
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nreplace_allowed_ips=true\nallowed_ip=10.0.0.2/32\nallowed_ip=10.0.0.3/32\n", peer[:])); err != nil {
+		t.Fatal(err)
+	}
+	want = []auditChange{
+		{Peer: peerName, Key: "allowed_ip", Old: "10.0.0.1/32"},
+		{Peer: peerName, Key: "allowed_ip", New: "10.0.0.3/32"},
+	}
+	if entry := last(); fmt.Sprint(entry.Changes) != fmt.Sprint(want) {
+		t.Errorf("changes %v, want %v", entry.Changes, want)
+	}
+
+	if err := dev.IpcSet("listen_port=x\n"); err == nil {
+		t.Fatal("invalid listen port accepted")
+	}
+	if entry := last(); entry.Outcome != "error" || entry.Errno != -22 || entry.Error == "" || len(entry.Changes) != 0 {
+		t.Errorf("failed set recorded as %+v", entry)
+	}
+
+	// over a UAPI socket, with the caller identified on Linux
+	listener, err := net.Listen("unix", filepath.Join(t.TempDir(), "wg0.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		if socket, err := listener.Accept(); err == nil {
+			dev.IpcHandle(socket)
+		}
+	}()
+	client, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	fmt.Fprintf(client, "set=1\npublic_key=%x\nremove=true\n\n", peer[:])
+	if status, _ := bufio.NewReader(client).ReadString('\n'); status != "errno=0\n" {
+		t.Fatalf("set returned %q", status)
+	}
+	entry = last()
+	if entry.Source != "uapi" || len(entry.Changes) != 5 || entry.Changes[0] != (auditChange{Peer: peerName, Key: "public_key", Old: peerName}) {
+		t.Errorf("entry %+v", entry)
+	}
+	if runtime.GOOS == "linux" {
+		exe, _ := os.Executable()
+		if c := entry.Caller; c == nil || c.Pid != os.Getpid() || c.Uid != os.Getuid() || c.Gid != os.Getgid() || c.Exe != exe {
+			t.Errorf("caller %+v, want pid %d, exe %s", entry.Caller, os.Getpid(), exe)
+		}
+	}
+	if n := len(entries()); n != 4 {
+		t.Errorf("%d entries, want 4", n)
+	}
+}
@@ -0,0 +1,315 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// A CaptureFilter selects what a capture records: the decrypted packets of the
+// listed peers, or of all peers if none are listed, and with Outer also their
+// encrypted datagrams. It is written as a comma separated list of peer:<key>
+// terms, with the key in base64 or hex, and outer.
+type CaptureFilter struct {
+	Peers []NoisePublicKey
+	Outer bool
+}
+
+// ParseCaptureFilter parses a filter in the syntax described at CaptureFilter.
+func ParseCaptureFilter(text string) (CaptureFilter, error) {
+	var filter CaptureFilter
+	if text == "" {
+		return filter, nil
+	}
+	for _, term := range strings.Split(text, ",") {
+		if key, ok := strings.CutPrefix(term, "peer:"); ok {
+			peer, err := parsePublicKeyText(key)
+			if err != nil {
+				return filter, fmt.Errorf("invalid peer %q in capture filter: %w", key, err)
+			}
+			filter.Peers = append(filter.Peers, *peer)
+			continue
+		}
+		if term != "outer" {
+			return filter, fmt.Errorf("unknown term %q in capture filter", term)
+		}
+		filter.Outer = true
+	}
+	return filter, nil
+}
+
+func (filter CaptureFilter) String() string {
+	var terms []string
+	for _, peer := range filter.Peers {
+		terms = append(terms, "peer:"+base64.StdEncoding.EncodeToString(peer[:]))
+	}
+	if filter.Outer {
+		terms = append(terms, "outer")
+	}
+	return strings.Join(terms, ",")
+}
+
+// pcapng interfaces of a capture
+const (
+	captureInner = iota // decrypted packets, as raw IP
+	captureOuter        // encrypted datagrams, with made up IP and UDP headers
+)
+
+// A packetCapture writes packets of the device to a pcapng file, each with a
+// comment naming the peer and the direction. It stops by itself when the file
+// would grow beyond maxBytes, or if writing fails.
+type packetCapture struct {
+	path     string
+	filter   CaptureFilter
+	maxBytes uint64 // 0 if unlimited
+	port     uint16 // listen port when the capture started, for outer datagrams
+	packets  atomic.Uint64
+
+	mu      sync.Mutex
+	file    *os.File // nil once stopped
+	written uint64
+	block   []byte
+}
+
+// openCapture creates a capture to a new file at path, which captures nothing
+// until installCapture.
+func (device *Device) openCapture(path string, filter CaptureFilter, maxBytes uint64) (*packetCapture, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	c := &packetCapture{path: path, filter: filter, maxBytes: maxBytes, file: file}
+	device.net.RLock()
+	c.port = device.net.port
+	device.net.RUnlock()
+
+	// section header, then the interfaces, which use the default resolution of
+	// microseconds and no snapshot length
+	b := pcapngBlock(nil, 0x0a0d0d0a, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0x1a2b3c4d)
+		b = binary.LittleEndian.AppendUint16(b, 1)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, ^uint64(0)) // section length unknown
+		b = pcapngOption(b, 4, []byte("wireguard-go"))      // shb_userappl
+		return pcapngOption(b, 0, nil)
+	})
+	for _, name := range []string{"inner", "outer"} {
+		b = pcapngBlock(b, 1, func(b []byte) []byte {
+			b = binary.LittleEndian.AppendUint16(b, 101) // LINKTYPE_RAW
+			b = binary.LittleEndian.AppendUint16(b, 0)
+			b = binary.LittleEndian.AppendUint32(b, 0)
+			b = pcapngOption(b, 2, []byte(name)) // if_name
+			return pcapngOption(b, 0, nil)
+		})
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return nil, err
+	}
+	c.written = uint64(len(b))
+	return c, nil
+}
+
+// installCapture starts the capture c, replacing any capture in progress.
+func (device *Device) installCapture(c *packetCapture) {
+	if old := device.capture.Swap(c); old != nil {
+		old.stop()
+	}
+	device.logs.capture.Infof("Capture: Started writing to %s", c.path)
+}
+
+// stopCapture stops the capture in progress, if any.
+func (device *Device) stopCapture() {
+	if c := device.capture.Swap(nil); c != nil {
+		c.stop()
+		device.logs.capture.Infof("Capture: Stopped writing to %s after %d packets", c.path, c.packets.Load())
+	}
+}
+
+func (c *packetCapture) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+	}
+}
+
+func (c *packetCapture) matches(peer *Peer) bool {
+	if len(c.filter.Peers) == 0 {
+		return true
+	}
+	return peer != nil && slices.Contains(c.filter.Peers, peer.handshake.remoteStatic)
+}
+
+// captureInner records a decrypted packet on its way to or from peer.
+func (device *Device) captureInner(c *packetCapture, peer *Peer, packet []byte, inbound bool) {
+	if !c.matches(peer) {
+		return
+	}
+	device.writeCapture(c, captureInner, peer, inbound, func(b []byte) []byte {
+		return append(b, packet...)
+	})
+}
+
+// captureOuter records an encrypted datagram sent to or received from endpoint.
+// The peer is nil for received handshake messages, whose sender is not known
+// yet.
+func (device *Device) captureOuter(c *packetCapture, peer *Peer, datagram []byte, endpoint conn.Endpoint, inbound bool) {
+	if !c.filter.Outer || !c.matches(peer) || endpoint == nil {
+		return
+	}
+	remote, err := netip.ParseAddrPort(endpoint.DstToString())
+	if err != nil {
+		return
+	}
+	remote = netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port())
+	local := endpoint.SrcIP().Unmap()
+	if !local.IsValid() || local.Is4() != remote.Addr().Is4() {
+		local = netip.IPv4Unspecified()
+		if remote.Addr().Is6() {
+			local = netip.IPv6Unspecified()
+		}
+	}
+	src, dst := netip.AddrPortFrom(local, c.port), remote
+	if inbound {
+		src, dst = dst, src
+	}
+	device.writeCapture(c, captureOuter, peer, inbound, func(b []byte) []byte {
+		return appendUDPHeaders(b, src, dst, datagram)
+	})
+}
+
+// appendUDPHeaders appends an IP packet carrying payload in a UDP datagram.
+func appendUDPHeaders(b []byte, src, dst netip.AddrPort, payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	if src.Addr().Is4() {
+		start := len(b)
+		b = append(b, 0x45, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(20+udpLen))
+		b = append(b, 0, 0, 0, 0, 64, protoUDP, 0, 0)
+		b = append(b, src.Addr().AsSlice()...)
+		b = append(b, dst.Addr().AsSlice()...)
+		var sum uint32
+		for i := start; i < start+20; i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		for sum > 0xffff {
+			sum = sum>>16 + sum&0xffff
+		}
+		binary.BigEndian.PutUint16(b[start+10:], ^uint16(sum))
+	} else {
+		b = append(b, 0x60, 0, 0, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+		b = append(b, protoUDP, 64)
+		b = append(b, src.Addr().AsSlice()...)
+		b = append(b, dst.Addr().AsSlice()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, src.Port())
+	b = binary.BigEndian.AppendUint16(b, dst.Port())
+	b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+	b = append(b, 0, 0) // no checksum
+	return append(b, payload...)
+}
+
+// writeCapture writes an enhanced packet block with the data that appendData
+// appends, on the given pcapng interface.
+func (device *Device) writeCapture(c *packetCapture, iface uint32, peer *Peer, inbound bool, appendData func([]byte) []byte) {
+	comment, flags := "out to", uint32(2)
+	if inbound {
+		comment, flags = "in from", 1
+	}
+	if peer != nil {
+		comment += " peer " + base64.StdEncoding.EncodeToString(peer.handshake.remoteStatic[:])
+	} else {
+		comment += " unknown peer"
+	}
+	micros := uint64(time.Now().UnixMicro())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return
+	}
+	c.block = pcapngBlock(c.block[:0], 6, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, iface)
+		b = binary.LittleEndian.AppendUint32(b, uint32(micros>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(micros))
+		lengths := len(b)
+		b = append(b, make([]byte, 8)...)
+		b = appendData(b)
+		n := len(b) - lengths - 8
+		binary.LittleEndian.PutUint32(b[lengths:], uint32(n))
+		binary.LittleEndian.PutUint32(b[lengths+4:], uint32(n))
+		b = append(b, make([]byte, -n&3)...)
+		b = pcapngOption(b, 1, []byte(comment)) // opt_comment
+		b = pcapngOption(b, 2, binary.LittleEndian.AppendUint32(nil, flags))
+		return pcapngOption(b, 0, nil)
+	})
+
+	if c.maxBytes != 0 && c.written+uint64(len(c.block)) > c.maxBytes {
+		c.file.Close()
+		c.file = nil
+		device.capture.CompareAndSwap(c, nil)
+		device.logs.capture.Infof("Capture: Stopped writing to %s at the limit of %d bytes", c.path, c.maxBytes)
+		return
+	}
+	if _, err := c.file.Write(c.block); err != nil {
+		c.file.Close()
+		c.file = nil
+		device.capture.CompareAndSwap(c, nil)
+		device.logs.capture.Errorf("Capture: Failed to write to %s: %v", c.path, err)
+		return
+	}
+	c.written += uint64(len(c.block))
+	c.packets.Add(1)
+}
+
+// pcapngBlock appends a block of the given type, whose body appendBody appends.
+func pcapngBlock(b []byte, blockType uint32, appendBody func([]byte) []byte) []byte {
+	start := len(b)
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = appendBody(b)
+	length := uint32(len(b) - start + 4)
+	binary.LittleEndian.PutUint32(b[start+4:], length)
+	return binary.LittleEndian.AppendUint32(b, length)
+}
+
+// pcapngOption appends an option, padded to 32 bits. Code 0 ends the options.
+func pcapngOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	b = append(b, value...)
+	return append(b, make([]byte, -len(value)&3)...)
+}
+
+// writeConfig writes the capture in progress for the UAPI get operation.
+func (c *packetCapture) writeConfig(sendf func(format string, args ...any)) {
+	c.mu.Lock()
+	written := c.written
+	c.mu.Unlock()
+	sendf("capture_file=%s", c.path)
+	if filter := c.filter.String(); filter != "" {
+		sendf("capture_filter=%s", filter)
+	}
+	if c.maxBytes != 0 {
+		sendf("capture_max_bytes=%d", c.maxBytes)
+	}
+	sendf("capture_packets=%d", c.packets.Load())
+	sendf("capture_bytes=%d", written)
+}
@@ -0,0 +1,167 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseCaptureFilter(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, NoisePublicKeySize))
+	for text, want := range map[string]string{
+		"":                                   "",
+		"outer":                              "outer",
+		"peer:" + strings.Repeat("00", 32):   "peer:" + key,
+		"outer,peer:" + key + ",peer:" + key: "peer:" + key + ",peer:" + key + ",outer",
+	} {
+		filter, err := ParseCaptureFilter(text)
+		if err != nil {
+			t.Errorf("ParseCaptureFilter(%q): %v", text, err)
+			continue
+		}
+		if got := filter.String(); got != want {
+			t.Errorf("ParseCaptureFilter(%q) = %q, want %q", text, got, want)
+		}
+	}
+	for _, text := range []string{"inner", "peer:", "peer:nokey", "outer,"} {
+		if _, err := ParseCaptureFilter(text); err == nil {
+			t.Errorf("ParseCaptureFilter(%q) succeeded", text)
+		}
+	}
+}
+
+// pcapngPacket is an enhanced packet block read back from a capture.
+type pcapngPacket struct {
+	iface   uint32
+	data    []byte
+	comment string
+}
+
+func readPcapng(t *testing.T, path string) []pcapngPacket {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) < 12 || binary.LittleEndian.Uint32(b) != 0x0a0d0d0a || binary.LittleEndian.Uint32(b[8:]) != 0x1a2b3c4d {
+		t.Fatal("no pcapng section header")
+	}
+	var packets []pcapngPacket
+	interfaces := 0
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("truncated block of %d bytes", len(b))
+		}
+		blockType, length := binary.LittleEndian.Uint32(b), int(binary.LittleEndian.Uint32(b[4:]))
+		if length%4 != 0 || length > len(b) || binary.LittleEndian.Uint32(b[length-4:]) != uint32(length) {
+			t.Fatalf("block of type %d with invalid length %d", blockType, length)
+		}
+		switch blockType {
+		case 1:
+			interfaces++
+		case 6:
+			body := b[8 : length-4]
+			p := pcapngPacket{iface: binary.LittleEndian.Uint32(body)}
+			n := int(binary.LittleEndian.Uint32(body[12:]))
+			p.data = body[20 : 20+n]
+			options := body[20+(n+3)&^3:]
+			for len(options) >= 4 {
+				code, size := binary.LittleEndian.Uint16(options), int(binary.LittleEndian.Uint16(options[2:]))
+				if code == 1 {
+					p.comment = string(options[4 : 4+size])
+				}
+				options = options[4+(size+3)&^3:]
+			}
+			packets = append(packets, p)
+		}
+		b = b[length:]
+	}
+	if interfaces != 2 {
+		t.Errorf("got %d interfaces, want 2", interfaces)
+	}
+	return packets
+}
+
+func TestCapture(t *testing.T) {
+	pair := genTestPair(t, false)
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	sender := pair[1].dev.staticIdentity.publicKey
+	if err := pair[0].dev.IpcSet(fmt.Sprintf("capture_file=%s\ncapture_filter=peer:%x,outer\n", path, sender[:])); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	config, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("capture_file=%s\ncapture_filter=peer:%s,outer\n", path, base64.StdEncoding.EncodeToString(sender[:]))
+	if !strings.Contains(config, want) {
+		t.Errorf("get lacks %q:\n%s", want, config)
+	}
+	if err := pair[0].dev.IpcSet("capture_file=\n"); err != nil {
+		t.Fatal(err)
+	}
+	if pair[0].dev.capture.Load() != nil {
+		t.Error("still capturing")
+	}
+
+	inner, outer := 0, 0
+	for _, p := range readPcapng(t, path) {
+		if !strings.Contains(p.comment, base64.StdEncoding.EncodeToString(sender[:])) && p.comment != "in from unknown peer" {
+			t.Errorf("packet with comment %q", p.comment)
+		}
+		switch p.iface {
+		case captureInner:
+			inner++
+			if !strings.HasPrefix(p.comment, "in from") || p.data[0]>>4 != 4 {
+				t.Errorf("inner packet %q: %x", p.comment, p.data)
+			}
+		case captureOuter:
+			outer++
+			if p.data[0] != 0x45 || p.data[9] != protoUDP {
+				t.Errorf("outer datagram without IPv4 and UDP headers: %x", p.data)
+			}
+		}
+	}
+	// the handshake initiation may precede the capture
+	if inner != 1 || outer < 2 {
+		t.Errorf("captured %d inner packets and %d outer datagrams, want the ping and its datagram at least", inner, outer)
+	}
+}
+
+func TestCaptureMaxBytes(t *testing.T) {
+	pair := genTestPair(t, false)
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	if err := pair[0].dev.IpcSet(fmt.Sprintf("capture_file=%s\ncapture_max_bytes=300\n", path)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Ping, nil)
+	if pair[0].dev.capture.Load() != nil {
+		t.Error("still capturing beyond the limit")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 300 {
+		t.Errorf("capture of %d bytes, beyond the limit", info.Size())
+	}
+	if n := len(readPcapng(t, path)); n != 1 {
+		t.Errorf("captured %d packets, want 1", n)
+	}
+
+	if err := pair[0].dev.IpcSet("capture_file=" + filepath.Join(path, "not-a-directory") + "\n"); err == nil {
+		t.Error("capture to an impossible path started")
+	}
+}
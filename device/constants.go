@@ -38,3 +38,17 @@ const (
 	UnderLoadAfterTime = time.Second // how long does the device remain under load after detected
 	MaxPeers           = 1 << 16     // maximum number of configured peers
 )
+
+const (
+	RateLimitBurst   = 50 * time.Millisecond  // traffic a rate-limited peer may send at once, in time at its rate
+	RateLimitBacklog = 250 * time.Millisecond // traffic held back before dropping, in time at its rate
+)
+
+const (
+	FirewallMaxFlows        = 1 << 12                // maximum number of connections tracked with each peer
+	FirewallMaxFragments    = 1 << 8                 // maximum number of fragmented packets tracked with each peer
+	FirewallFlowTimeoutTCP  = 30 * time.Minute       // how long an idle TCP connection stays established
+	FirewallFlowTimeout     = 30 * time.Second       // how long other idle connections stay established
+	FirewallFragmentTimeout = 5 * time.Second        // how long the later fragments of an accepted packet are accepted
+	FirewallLogInterval     = 100 * time.Millisecond // minimum time between log lines of a rule
+)
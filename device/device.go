@@ -6,6 +6,7 @@
 package device
 
 import (
+	"errors"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -58,12 +59,28 @@ type Device struct {
 		keyMap       map[NoisePublicKey]*Peer
 	}
 
+	// generations number the changes to peers, for UAPI get with
+	// since_generation. See changedSince.
+	generations struct {
+		sync.Mutex // protects current and the generation of each peer
+		current    uint64
+	}
+
 	rate struct {
 		underLoadUntil atomic.Int64
 		limiter        ratelimiter.Ratelimiter
 	}
 
 	allowedips    AllowedIPs
+	firewall      atomic.Pointer[firewall]        // nil if disabled
+	capture       atomic.Pointer[packetCapture]   // nil if not capturing
+	keyLog        atomic.Pointer[keyLog]          // nil unless exporting session keys
+	keepKeys      atomic.Bool                     // sessions keep their keys for PrepareHandoff
+	auditLog      atomic.Pointer[auditLog]        // nil unless recording set operations
+	version       string                          // of the program, for UAPI capabilities; protected by ipcMutex
+	logLevels     atomic.Pointer[deviceLogLevels] // nil if the Logger alone decides
+	quotasRefused atomic.Pointer[string]          // why quotas cannot be set, nil if they can
+	logLevelsMu   sync.Mutex                      // serializes changes of logLevels
 	indexTable    IndexTable
 	cookieChecker CookieChecker
 
@@ -86,10 +103,27 @@ type Device struct {
 		mtu    atomic.Int32
 	}
 
+	// handoff is set while PrepareHandoff hands the device over to another
+	// process. See waitHandoff.
+	handoff struct {
+		sync.Mutex
+		pending chan struct{} // closed when the handoff ends; nil without one
+	}
+
+	// health tracks the routines that must keep running for the device to pass traffic.
+	// See CheckLiveness.
+	health struct {
+		tunReader atomic.Bool  // RoutineReadFromTUN is running
+		receivers atomic.Int32 // number of running RoutineReceiveIncoming
+	}
+
 	ipcMutex   sync.RWMutex
 	closed     chan struct{}
-	log        *Logger
+	log        *Logger // as given to NewDevice, see logs
+	logs       subsystemLoggers
 	dnsMonitor *DNSMonitor // DNS monitor for dynamic endpoint resolution
+	events     eventBus    // see SubscribeEvents
+	logRing    logRing     // see LogRecords
 }
 
 // deviceState represents the state of a Device.
@@ -137,8 +171,14 @@ func removePeerLocked(device *Device, peer *Peer, key NoisePublicKey) {
 		device.dnsMonitor.RemovePeer(key)
 	}
 
+	// forget its connections through the firewall
+	if fw := device.firewall.Load(); fw != nil {
+		fw.flows.Delete(peer)
+	}
+
 	// remove from peer map
 	delete(device.peers.keyMap, key)
+	device.emit(Event{Type: EventPeerRemoved, Peer: key})
 }
 
 // changeState attempts to change the device state to match want.
@@ -148,7 +188,7 @@ func (device *Device) changeState(want deviceState) (err error) {
 	old := device.deviceState()
 	if old == deviceStateClosed {
 		// once closed, always closed
-		device.log.Verbosef("Interface closed, ignored requested state %s", want)
+		device.logs.tun.Infof("Interface closed, ignored requested state %s", want)
 		return nil
 	}
 	switch want {
@@ -168,7 +208,15 @@ func (device *Device) changeState(want deviceState) (err error) {
 			err = errDown
 		}
 	}
-	device.log.Verbosef("Interface state was %s, requested %s, now %s", old, want, device.deviceState())
+	now := device.deviceState()
+	device.logs.tun.Infof("Interface state was %s, requested %s, now %s", old, want, now)
+	switch {
+	case now == old:
+	case now == deviceStateUp:
+		device.emit(Event{Type: EventDeviceUp})
+	case old == deviceStateUp:
+		device.emit(Event{Type: EventDeviceDown})
+	}
 	return
 }
 
@@ -176,7 +224,7 @@ func (device *Device) changeState(want deviceState) (err error) {
 // The caller must hold device.state.mu and is responsible for updating device.state.state.
 func (device *Device) upLocked() error {
 	if err := device.BindUpdate(); err != nil {
-		device.log.Errorf("Unable to update bind: %v", err)
+		device.logs.bind.Errorf("Unable to update bind: %v", err)
 		return err
 	}
 
@@ -207,7 +255,7 @@ func (device *Device) upLocked() error {
 func (device *Device) downLocked() error {
 	err := device.BindClose()
 	if err != nil {
-		device.log.Errorf("Bind close failed: %v", err)
+		device.logs.bind.Errorf("Bind close failed: %v", err)
 	}
 
 	// Stop DNS monitoring
@@ -299,15 +347,21 @@ func (device *Device) SetPrivateKey(sk NoisePrivateKey) error {
 }
 
 func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
+	return NewDeviceWithPools(tunDevice, bind, logger, nil)
+}
+
+// NewDeviceWithPools is like NewDevice, but takes its buffers from pools shared
+// with other devices. A nil pools gives the device pools of its own.
+func NewDeviceWithPools(tunDevice tun.Device, bind conn.Bind, logger *Logger, pools *Pools) *Device {
 	device := new(Device)
 	device.state.state.Store(uint32(deviceStateDown))
 	device.closed = make(chan struct{})
-	device.log = logger
+	device.initLoggers(logger)
 	device.net.bind = bind
 	device.tun.device = tunDevice
 	mtu, err := device.tun.device.MTU()
 	if err != nil {
-		device.log.Errorf("Trouble determining MTU, assuming default: %v", err)
+		device.logs.tun.Errorf("Trouble determining MTU, assuming default: %v", err)
 		mtu = DefaultMTU
 	}
 	device.tun.mtu.Store(int32(mtu))
@@ -319,6 +373,11 @@ func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
 	device.dnsMonitor = NewDNSMonitor(device, 60*time.Second)
 
 	device.PopulatePools()
+	if pools != nil {
+		device.pool.messageBuffers = pools.messageBuffers
+		device.pool.inboundElements = pools.inboundElements
+		device.pool.outboundElements = pools.outboundElements
+	}
 
 	// create queues
 
@@ -339,6 +398,7 @@ func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
 
 	device.state.stopping.Add(1)      // RoutineReadFromTUN
 	device.queue.encryption.wg.Add(1) // RoutineReadFromTUN
+	device.health.tunReader.Store(true)
 	go device.RoutineReadFromTUN()
 	go device.RoutineTUNEventReader()
 
@@ -395,15 +455,20 @@ func (device *Device) Close() {
 	if device.isClosed() {
 		return
 	}
+	wasUp := device.isUp()
 	device.state.state.Store(uint32(deviceStateClosed))
-	device.log.Verbosef("Device closing")
+	device.logs.device.Infof("Device closing")
 
 	device.tun.device.Close()
+	device.endHandoff()
 	device.downLocked()
 
 	// Remove peers before closing queues,
 	// because peers assume that queues are active.
 	device.RemoveAllPeers()
+	if wasUp {
+		device.emit(Event{Type: EventDeviceDown})
+	}
 
 	// We kept a reference to the encryption and decryption queues,
 	// in case we started any new peers that might write to them.
@@ -414,8 +479,11 @@ func (device *Device) Close() {
 	device.state.stopping.Wait()
 
 	device.rate.limiter.Close()
+	device.stopCapture()
 
-	device.log.Verbosef("Device closed")
+	device.logs.device.Infof("Device closed")
+	device.closeEvents()
+	device.closeLogRing()
 	close(device.closed)
 }
 
@@ -423,6 +491,24 @@ func (device *Device) Wait() chan struct{} {
 	return device.closed
 }
 
+// CheckLiveness reports an error if a routine that the device needs to pass traffic
+// has exited: the TUN reader, or all of the UDP receive routines while the device is up.
+// It waits for pending state changes, so it blocks if the device is deadlocked.
+func (device *Device) CheckLiveness() error {
+	device.state.Lock()
+	defer device.state.Unlock()
+
+	switch {
+	case device.isClosed():
+		return errors.New("device is closed")
+	case !device.health.tunReader.Load():
+		return errors.New("TUN reader is not running")
+	case device.isUp() && device.health.receivers.Load() == 0:
+		return errors.New("no UDP receive routine is running")
+	}
+	return nil
+}
+
 // SetDNSMonitorInterval sets the interval for DNS monitoring
 func (device *Device) SetDNSMonitorInterval(interval time.Duration) {
 	if device.dnsMonitor != nil {
@@ -559,12 +645,13 @@ func (device *Device) BindUpdate() error {
 	device.net.stopping.Add(len(recvFns))
 	device.queue.decryption.wg.Add(len(recvFns)) // each RoutineReceiveIncoming goroutine writes to device.queue.decryption
 	device.queue.handshake.wg.Add(len(recvFns))  // each RoutineReceiveIncoming goroutine writes to device.queue.handshake
+	device.health.receivers.Add(int32(len(recvFns)))
 	batchSize := netc.bind.BatchSize()
 	for _, fn := range recvFns {
 		go device.RoutineReceiveIncoming(batchSize, fn)
 	}
 
-	device.log.Verbosef("UDP bind has been updated")
+	device.logs.bind.Infof("UDP bind has been updated")
 	return nil
 }
 
@@ -15,6 +15,8 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -203,6 +205,67 @@ func TestTwoDevicePing(t *testing.T) {
 	})
 }
 
+// peerStat returns a counter of the only peer of dev, as reported by UAPI get.
+func peerStat(tb testing.TB, dev *Device, key string) uint64 {
+	tb.Helper()
+	config, err := dev.IpcGet()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for _, line := range strings.Split(config, "\n") {
+		if value, ok := strings.CutPrefix(line, key+"="); ok {
+			n, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				tb.Fatalf("%s=%q: %v", key, value, err)
+			}
+			return n
+		}
+	}
+	tb.Fatalf("no %s in UAPI get", key)
+	return 0
+}
+
+func TestPeerStats(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	// pair[1] initiated the handshake by sending the ping
+	for key, want := range map[string]uint64{
+		"handshake_initiations": 1,
+		"handshake_retries":     0,
+		"handshake_responses":   1,
+		"keypair_age_sec":       0,
+		"rx_drop_replay":        0,
+	} {
+		if got := peerStat(t, pair[1].dev, key); got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+	// initiation and ping sent, response and pong received
+	for _, key := range []string{"tx_packets", "rx_packets"} {
+		if got := peerStat(t, pair[1].dev, key); got < 2 {
+			t.Errorf("%s = %d, want at least 2", key, got)
+		}
+	}
+	if got := peerStat(t, pair[0].dev, "handshake_initiations"); got != 0 {
+		t.Errorf("responder sent %d initiations", got)
+	}
+	if peerStat(t, pair[0].dev, "last_rx_time_sec") == 0 {
+		t.Error("no time of last received packet")
+	}
+
+	// a source address not allowed for pair[1] at pair[0]
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, netip.AddrFrom4([4]byte{1, 0, 0, 99}))
+	deadline := time.Now().Add(5 * time.Second)
+	for peerStat(t, pair[0].dev, "rx_drop_allowed_ips") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("packet with disallowed source address not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func TestUpDown(t *testing.T) {
 	goroutineLeakCheck(t)
 	const itrials = 50
@@ -240,6 +303,37 @@ func TestUpDown(t *testing.T) {
 	}
 }
 
+func TestCheckLiveness(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+	if err := dev.CheckLiveness(); err != nil {
+		t.Fatalf("running device reported dead: %v", err)
+	}
+
+	// Simulate the receive routines exiting while the device is up.
+	receivers := dev.health.receivers.Swap(0)
+	if err := dev.CheckLiveness(); err == nil {
+		t.Error("device without receive routines reported alive")
+	}
+	dev.health.receivers.Add(receivers)
+
+	if err := dev.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.CheckLiveness(); err != nil {
+		t.Errorf("down device reported dead: %v", err)
+	}
+	if n := dev.health.receivers.Load(); n != 0 {
+		t.Errorf("expected no receive routines after down, got %d", n)
+	}
+
+	dev.Close()
+	if err := dev.CheckLiveness(); err == nil {
+		t.Error("closed device reported alive")
+	}
+}
+
 // TestConcurrencySafety does other things concurrently with tunnel use.
 // It is intended to be used with the race detector to catch data races.
 func TestConcurrencySafety(t *testing.T) {
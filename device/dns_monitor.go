@@ -18,9 +18,18 @@ type DNSMonitor struct {
 	device   *Device
 	peers    map[NoisePublicKey]*monitoredPeer // Map of public key to monitored peer info
 	mu       sync.RWMutex                      // Protects peers map and interval
-	stopCh   chan struct{}                     // Channel to stop monitoring
+	stopCh   chan struct{}                     // Channel to stop monitoring, nil while stopped
 	interval time.Duration                     // How often to check DNS resolution
-	logger   *Logger                           // Logger for DNS monitor events
+	logger   *subsystemLogger                  // Logger for DNS monitor events
+
+	requests   chan resolveRequest                                      // Immediate checks for the monitor loop to run
+	lookupHost func(ctx context.Context, host string) ([]string, error) // Resolver, replaced in tests
+}
+
+// resolveRequest asks the monitor loop to check one peer, or all of them
+type resolveRequest struct {
+	publicKey NoisePublicKey
+	all       bool
 }
 
 // monitoredPeer stores information about a peer with a domain-based endpoint
@@ -31,6 +40,7 @@ type monitoredPeer struct {
 	lastResolvedIP  string         // Last successfully resolved IP address
 	lastCheckTime   time.Time      // Last time we checked DNS resolution
 	resolutionFails int            // Number of consecutive DNS resolution failures
+	totalFails      uint64         // Number of DNS resolution failures since monitoring began
 }
 
 // NewDNSMonitor creates a new DNS monitor for the given device
@@ -40,24 +50,39 @@ func NewDNSMonitor(device *Device, interval time.Duration) *DNSMonitor {
 	}
 
 	return &DNSMonitor{
-		device:   device,
-		peers:    make(map[NoisePublicKey]*monitoredPeer),
-		stopCh:   make(chan struct{}),
-		interval: interval,
-		logger:   device.log,
+		device:     device,
+		peers:      make(map[NoisePublicKey]*monitoredPeer),
+		interval:   interval,
+		logger:     device.logs.dns,
+		requests:   make(chan resolveRequest, 16),
+		lookupHost: net.DefaultResolver.LookupHost,
 	}
 }
 
-// Start begins DNS monitoring in a background goroutine
+// Start begins DNS monitoring in a background goroutine.
+// It is a no-op if monitoring is already running.
 func (dm *DNSMonitor) Start() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.stopCh != nil {
+		return
+	}
+	dm.stopCh = make(chan struct{})
 	dm.logger.Verbosef("DNS Monitor: Starting with %v interval", dm.interval)
-	go dm.monitorLoop()
+	go dm.monitorLoop(dm.stopCh, dm.interval)
 }
 
-// Stop terminates DNS monitoring
+// Stop terminates DNS monitoring. The monitor may be started again later,
+// which happens every time the device goes down and comes back up.
 func (dm *DNSMonitor) Stop() {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	if dm.stopCh == nil {
+		return
+	}
 	dm.logger.Verbosef("DNS Monitor: Stopping")
 	close(dm.stopCh)
+	dm.stopCh = nil
 }
 
 // AddPeer adds a peer with a domain-based endpoint to be monitored
@@ -74,11 +99,13 @@ func (dm *DNSMonitor) AddPeer(publicKey NoisePublicKey, endpoint string) error {
 	}
 
 	// Resolve the domain to get initial IP
+	var totalFails uint64
 	initialIP, err := dm.resolveDomain(host)
 	if err != nil {
-		dm.logger.Verbosef("DNS Monitor: Failed to resolve initial IP for %s: %v", host, err)
+		dm.logger.Warnf("DNS Monitor: Failed to resolve initial IP for %s: %v", host, err)
 		// Still add to monitoring list in case DNS becomes available later
 		initialIP = ""
+		totalFails = 1
 	}
 
 	dm.mu.Lock()
@@ -91,6 +118,7 @@ func (dm *DNSMonitor) AddPeer(publicKey NoisePublicKey, endpoint string) error {
 		lastResolvedIP:  initialIP,
 		lastCheckTime:   time.Now(),
 		resolutionFails: 0,
+		totalFails:      totalFails,
 	}
 
 	dm.logger.Verbosef("DNS Monitor: Added peer %s with domain %s (resolved to %s)",
@@ -110,6 +138,38 @@ func (dm *DNSMonitor) RemovePeer(publicKey NoisePublicKey) {
 	}
 }
 
+// restoreResolvedIP records a previously resolved address for a monitored peer
+// that has not resolved its domain yet.
+func (dm *DNSMonitor) restoreResolvedIP(publicKey NoisePublicKey, ip string) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	if monPeer, exists := dm.peers[publicKey]; exists && monPeer.lastResolvedIP == "" {
+		monPeer.lastResolvedIP = ip
+	}
+}
+
+// restorePeer monitors a peer handed over by another process, which already
+// resolved its domain, so unlike AddPeer it does not block on a lookup.
+func (dm *DNSMonitor) restorePeer(publicKey NoisePublicKey, endpoint, resolvedIP string) error {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return err
+	}
+
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	dm.peers[publicKey] = &monitoredPeer{
+		publicKey:      publicKey,
+		originalHost:   host,
+		port:           port,
+		lastResolvedIP: resolvedIP,
+		lastCheckTime:  time.Now(),
+	}
+	return nil
+}
+
 // UpdateMonitorInterval changes the DNS monitoring interval
 func (dm *DNSMonitor) UpdateMonitorInterval(interval time.Duration) {
 	if interval <= 0 {
@@ -129,18 +189,36 @@ func (dm *DNSMonitor) GetMonitorInterval() time.Duration {
 }
 
 // monitorLoop is the main monitoring loop that runs in a background goroutine
-func (dm *DNSMonitor) monitorLoop() {
-	ticker := time.NewTicker(dm.interval)
+func (dm *DNSMonitor) monitorLoop(stopCh chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-dm.stopCh:
+		case <-stopCh:
 			dm.logger.Verbosef("DNS Monitor: Monitor loop stopped")
 			return
 
+		case request := <-dm.requests:
+			if request.all {
+				dm.checkAllPeers()
+				break
+			}
+			dm.mu.RLock()
+			monPeer, exists := dm.peers[request.publicKey]
+			dm.mu.RUnlock()
+			if exists {
+				dm.checkPeerDNS(request.publicKey, monPeer)
+			}
+
 		case <-ticker.C:
 			dm.checkAllPeers()
+
+			// Pick up interval changes made through UAPI
+			if current := dm.GetMonitorInterval(); current != interval {
+				interval = current
+				ticker.Reset(interval)
+			}
 		}
 	}
 }
@@ -165,6 +243,42 @@ func (dm *DNSMonitor) checkAllPeers() {
 	}
 }
 
+// ResolveNow asks the monitor loop to re-check DNS resolution for a single
+// monitored peer, updating its endpoint if the address changed. It does not
+// wait for the lookup, so it may be called with the UAPI lock held. It returns
+// false if the peer is not being monitored.
+func (dm *DNSMonitor) ResolveNow(publicKey NoisePublicKey) bool {
+	dm.mu.RLock()
+	_, exists := dm.peers[publicKey]
+	dm.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+
+	dm.logger.Verbosef("DNS Monitor: Immediate check requested for peer %s", publicKey.Hex()[:8])
+	dm.request(resolveRequest{publicKey: publicKey})
+	return true
+}
+
+// ResolveAllNow asks the monitor loop to re-check DNS resolution for all
+// monitored peers, without waiting for the lookups.
+func (dm *DNSMonitor) ResolveAllNow() {
+	dm.logger.Verbosef("DNS Monitor: Immediate check requested for all peers")
+	dm.request(resolveRequest{all: true})
+}
+
+// request queues an immediate check for the monitor loop, which runs it once
+// the device is up. When too many are pending, the request is dropped, and the
+// next periodic check covers it.
+func (dm *DNSMonitor) request(request resolveRequest) {
+	select {
+	case dm.requests <- request:
+	default:
+		dm.logger.Verbosef("DNS Monitor: Too many pending checks, leaving it to the next periodic one")
+	}
+}
+
 // checkPeerDNS checks DNS resolution for a single peer and updates endpoint if changed
 func (dm *DNSMonitor) checkPeerDNS(publicKey NoisePublicKey, monPeer *monitoredPeer) {
 	currentIP, err := dm.resolveDomain(monPeer.originalHost)
@@ -176,14 +290,15 @@ func (dm *DNSMonitor) checkPeerDNS(publicKey NoisePublicKey, monPeer *monitoredP
 	if err != nil {
 		dm.mu.Lock()
 		monPeer.resolutionFails++
+		monPeer.totalFails++
 		dm.mu.Unlock()
 
-		dm.logger.Verbosef("DNS Monitor: Failed to resolve %s for peer %s (failure #%d): %v",
+		dm.logger.Warnf("DNS Monitor: Failed to resolve %s for peer %s (failure #%d): %v",
 			monPeer.originalHost, publicKey.Hex()[:8], monPeer.resolutionFails, err)
 
 		// If we've had too many consecutive failures, log a warning
 		if monPeer.resolutionFails == 5 {
-			dm.logger.Verbosef("DNS Monitor: Warning - Domain %s has failed resolution 5 times",
+			dm.logger.Warnf("DNS Monitor: Warning - Domain %s has failed resolution 5 times",
 				monPeer.originalHost)
 		}
 		return
@@ -196,18 +311,18 @@ func (dm *DNSMonitor) checkPeerDNS(publicKey NoisePublicKey, monPeer *monitoredP
 
 	// Check if the IP address has changed
 	if currentIP != monPeer.lastResolvedIP && monPeer.lastResolvedIP != "" {
-		dm.logger.Verbosef("DNS Monitor: IP change detected for %s: %s -> %s",
+		dm.logger.Infof("DNS Monitor: IP change detected for %s: %s -> %s",
 			monPeer.originalHost, monPeer.lastResolvedIP, currentIP)
 
 		// Update the peer's endpoint
 		err := dm.updatePeerEndpoint(publicKey, currentIP, monPeer.port)
 		if err != nil {
-			dm.logger.Verbosef("DNS Monitor: Failed to update endpoint for peer %s: %v",
+			dm.logger.Warnf("DNS Monitor: Failed to update endpoint for peer %s: %v",
 				publicKey.Hex()[:8], err)
 			return
 		}
 
-		dm.logger.Verbosef("DNS Monitor: Successfully updated endpoint for peer %s to %s:%s",
+		dm.logger.Infof("DNS Monitor: Successfully updated endpoint for peer %s to %s:%s",
 			publicKey.Hex()[:8], currentIP, monPeer.port)
 	}
 
@@ -223,7 +338,7 @@ func (dm *DNSMonitor) resolveDomain(domain string) (string, error) {
 	defer cancel()
 
 	// Use LookupHost for better control over resolution
-	ips, err := net.DefaultResolver.LookupHost(ctx, domain)
+	ips, err := dm.lookupHost(ctx, domain)
 	if err != nil {
 		return "", err
 	}
@@ -269,7 +384,6 @@ func (dm *DNSMonitor) updatePeerEndpoint(publicKey NoisePublicKey, newIP, port s
 
 	// Update the peer's endpoint
 	peer.endpoint.Lock()
-	defer peer.endpoint.Unlock()
 
 	// Clear the old endpoint source to force reconnection
 	if peer.endpoint.val != nil {
@@ -277,8 +391,12 @@ func (dm *DNSMonitor) updatePeerEndpoint(publicKey NoisePublicKey, newIP, port s
 	}
 
 	peer.endpoint.val = endpoint
+	peer.endpoint.Unlock()
+
+	peer.emit(Event{Type: EventEndpointChanged, Endpoint: endpoint.DstToString(), Reason: "dns"})
 
-	// Trigger a new handshake to establish connection with the new endpoint
+	// Trigger a new handshake to establish connection with the new endpoint,
+	// which sends through the endpoint and so must not hold its lock
 	peer.SendHandshakeInitiation(false)
 
 	return nil
@@ -297,6 +415,7 @@ func (dm *DNSMonitor) GetMonitoredPeers() map[NoisePublicKey]*MonitoredPeerInfo
 			LastResolvedIP:  monPeer.lastResolvedIP,
 			LastCheckTime:   monPeer.lastCheckTime,
 			ResolutionFails: monPeer.resolutionFails,
+			TotalFails:      monPeer.totalFails,
 		}
 	}
 	return result
@@ -309,6 +428,7 @@ type MonitoredPeerInfo struct {
 	LastResolvedIP  string    // Last resolved IP address
 	LastCheckTime   time.Time // Last DNS check time
 	ResolutionFails int       // Number of consecutive DNS failures
+	TotalFails      uint64    // Number of DNS failures since monitoring began
 }
 
 // IsDomainEndpoint checks if an endpoint string contains a domain name
@@ -6,7 +6,10 @@
 package device
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"sync"
 	"testing"
 	"time"
 )
@@ -72,6 +75,7 @@ func TestDNSMonitorCreation(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 
 	// Test creating DNS monitor with valid interval
 	monitor := NewDNSMonitor(device, 30*time.Second)
@@ -98,6 +102,7 @@ func TestResolveDomain(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 
 	monitor := NewDNSMonitor(device, 60*time.Second)
 
@@ -129,6 +134,7 @@ func TestDNSMonitorUpdateInterval(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 
 	monitor := NewDNSMonitor(device, 60*time.Second)
 
@@ -146,3 +152,68 @@ func TestDNSMonitorUpdateInterval(t *testing.T) {
 		t.Errorf("Invalid interval should be ignored, expected %v, got %v", newInterval, monitor.interval)
 	}
 }
+
+func TestDNSMonitorAddressChange(t *testing.T) {
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+	var peer *Peer
+	for _, p := range dev.peers.keyMap {
+		peer = p
+	}
+
+	var mu sync.Mutex
+	address := "127.0.0.1"
+	monitor := dev.dnsMonitor
+	monitor.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []string{address}, nil
+	}
+	if err := monitor.AddPeer(peer.handshake.remoteStatic, fmt.Sprintf("peer.test:%d", pair[1].dev.net.port)); err != nil {
+		t.Fatal(err)
+	}
+	events, unsubscribe := dev.SubscribeEvents(16)
+	defer unsubscribe()
+
+	mu.Lock()
+	address = "127.0.0.2"
+	mu.Unlock()
+
+	// The set must not wait for the lookup, and nothing may deadlock once the
+	// monitor moves the endpoint and sends a handshake through it
+	ipc := func(name string, f func() error) {
+		t.Helper()
+		done := make(chan error, 1)
+		go func() { done <- f() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not return", name)
+		}
+	}
+	ipc("set", func() error { return dev.IpcSet(uapiCfg("dns_resolve", "true")) })
+
+	deadline := time.After(5 * time.Second)
+	for changed := false; !changed; {
+		select {
+		case event := <-events:
+			changed = event.Type == EventEndpointChanged && event.Reason == "dns"
+		case <-deadline:
+			t.Fatal("no endpoint change event after the address changed")
+		}
+	}
+	for monitor.GetMonitoredPeers()[peer.handshake.remoteStatic].LastResolvedIP != "127.0.0.2" {
+		select {
+		case <-deadline:
+			t.Fatal("monitor did not record the new address")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	ipc("get", func() error {
+		_, err := dev.IpcGet()
+		return err
+	})
+}
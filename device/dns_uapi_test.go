@@ -7,6 +7,8 @@ package device
 
 import (
 	"bytes"
+	"encoding/hex"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -20,10 +22,11 @@ func TestDNSMonitorUAPIIntegration(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 	device.dnsMonitor = NewDNSMonitor(device, 60*time.Second)
 
 	// Test setting DNS monitor interval via UAPI
-	err := device.handleDeviceLine("dns_monitor_interval", "120")
+	err := device.IpcSet("dns_monitor_interval=120\n")
 	if err != nil {
 		t.Errorf("Failed to set DNS monitor interval: %v", err)
 	}
@@ -36,13 +39,13 @@ func TestDNSMonitorUAPIIntegration(t *testing.T) {
 	}
 
 	// Test invalid interval (too small)
-	err = device.handleDeviceLine("dns_monitor_interval", "5")
+	err = device.IpcSet("dns_monitor_interval=5\n")
 	if err == nil {
 		t.Error("Expected error for interval less than 10 seconds, but got none")
 	}
 
 	// Test invalid interval (non-numeric)
-	err = device.handleDeviceLine("dns_monitor_interval", "invalid")
+	err = device.IpcSet("dns_monitor_interval=invalid\n")
 	if err == nil {
 		t.Error("Expected error for non-numeric interval, but got none")
 	}
@@ -56,6 +59,7 @@ func TestDNSMonitorUAPIOutput(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 	device.dnsMonitor = NewDNSMonitor(device, 90*time.Second)
 
 	// Add a mock peer to DNS monitoring
@@ -77,13 +81,14 @@ func TestDNSMonitorUAPIOutput(t *testing.T) {
 	outputStr := output.String()
 
 	// Check if DNS monitor interval is present in output
-	if !strings.Contains(outputStr, "dns_monitor_interval=90") {
+	lines := strings.Split(outputStr, "\n")
+	if !slices.Contains(lines, "dns_monitor_interval=90") {
 		t.Errorf("Expected 'dns_monitor_interval=90' in UAPI output, got:\n%s", outputStr)
 	}
 
 	// If we successfully added a peer, check if monitored peers count is present
 	if err == nil {
-		if !strings.Contains(outputStr, "dns_monitored_peers=1") {
+		if !slices.Contains(lines, "dns_monitored_peers=1") {
 			t.Logf("Note: 'dns_monitored_peers=1' not found in output (DNS resolution may have failed)")
 			t.Logf("UAPI output:\n%s", outputStr)
 		}
@@ -97,10 +102,11 @@ func TestDNSMonitorUAPIMinimumInterval(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 	device.dnsMonitor = NewDNSMonitor(device, 60*time.Second)
 
 	// Test setting minimum valid interval (10 seconds)
-	err := device.handleDeviceLine("dns_monitor_interval", "10")
+	err := device.IpcSet("dns_monitor_interval=10\n")
 	if err != nil {
 		t.Errorf("Failed to set minimum valid interval: %v", err)
 	}
@@ -112,7 +118,7 @@ func TestDNSMonitorUAPIMinimumInterval(t *testing.T) {
 	}
 
 	// Test setting interval just below minimum (9 seconds) - should fail
-	err = device.handleDeviceLine("dns_monitor_interval", "9")
+	err = device.IpcSet("dns_monitor_interval=9\n")
 	if err == nil {
 		t.Error("Expected error for interval below minimum (9 seconds), but got none")
 	}
@@ -131,6 +137,7 @@ func TestDNSMonitorThreadSafety(t *testing.T) {
 			Errorf:   func(format string, args ...any) {}, // Silent logger for testing
 		},
 	}
+	device.initLoggers(device.log)
 	device.dnsMonitor = NewDNSMonitor(device, 60*time.Second)
 
 	// Test concurrent access to DNS monitor interval
@@ -159,3 +166,64 @@ func TestDNSMonitorThreadSafety(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestDNSMonitorUAPIPeerStatus(t *testing.T) {
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+
+	var peerKey NoisePublicKey
+	for k := range dev.peers.keyMap {
+		peerKey = k
+	}
+	peerHex := hex.EncodeToString(peerKey[:])
+
+	// A peer with an IP endpoint cannot be re-resolved
+	err := dev.IpcSet(uapiCfg(
+		"public_key", peerHex,
+		"dns_resolve", "true",
+	))
+	if err == nil {
+		t.Error("Expected error re-resolving a peer without a domain endpoint, but got none")
+	}
+
+	err = dev.IpcSet(uapiCfg(
+		"public_key", peerHex,
+		"endpoint", "localhost:51820",
+	))
+	if err != nil {
+		t.Skipf("Could not set domain endpoint (DNS resolution may be unavailable): %v", err)
+	}
+
+	err = dev.IpcSet(uapiCfg(
+		"public_key", peerHex,
+		"dns_resolve", "true",
+	))
+	if err != nil {
+		t.Errorf("Failed to re-resolve peer: %v", err)
+	}
+	if err := dev.IpcSet(uapiCfg("dns_resolve", "true")); err != nil {
+		t.Errorf("Failed to re-resolve all peers: %v", err)
+	}
+
+	outputStr, err := dev.IpcGet()
+	if err != nil {
+		t.Fatalf("Failed to get UAPI output: %v", err)
+	}
+
+	for _, expected := range []string{
+		"dns_monitored_peers=1\n",
+		"dns_hostname=localhost\n",
+		"dns_port=51820\n",
+		"dns_last_check_time_sec=",
+		"dns_resolution_failures=0\n",
+	} {
+		if !strings.Contains(outputStr, expected) {
+			t.Errorf("Expected %q in UAPI output, got:\n%s", expected, outputStr)
+		}
+	}
+
+	// The DNS keys must appear inside the monitored peer's section
+	if strings.Index(outputStr, "dns_hostname=") < strings.Index(outputStr, "public_key=") {
+		t.Errorf("Expected dns_hostname after public_key in UAPI output, got:\n%s", outputStr)
+	}
+}
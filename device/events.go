@@ -0,0 +1,209 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	EventPeerCreated        EventType = iota + 1
+	EventPeerRemoved                  // also for each peer when the device closes
+	EventHandshakeInitiated           // an initiation was sent
+	EventHandshakeCompleted           // a session was established
+	EventHandshakeFailed              // initiations went unanswered until giving up
+	EventKeypairRotated               // a new session replaced an existing one
+	EventEndpointChanged              // by roaming or by the DNS monitor
+	EventDeviceUp
+	EventDeviceDown
+	EventMTUUpdated
+	EventPeerSuspended // a quota was used up
+	EventPeerResumed   // a new period began or the quota was raised
+	EventLost          // events were dropped because the subscriber fell behind
+)
+
+var eventTypeNames = [...]string{
+	EventPeerCreated:        "peer_created",
+	EventPeerRemoved:        "peer_removed",
+	EventHandshakeInitiated: "handshake_initiated",
+	EventHandshakeCompleted: "handshake_completed",
+	EventHandshakeFailed:    "handshake_failed",
+	EventKeypairRotated:     "keypair_rotated",
+	EventEndpointChanged:    "endpoint_changed",
+	EventDeviceUp:           "device_up",
+	EventDeviceDown:         "device_down",
+	EventMTUUpdated:         "mtu_updated",
+	EventPeerSuspended:      "peer_suspended",
+	EventPeerResumed:        "peer_resumed",
+	EventLost:               "lost",
+}
+
+// String returns the name of the event type in UAPI records.
+func (t EventType) String() string {
+	if t > 0 && int(t) < len(eventTypeNames) {
+		return eventTypeNames[t]
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a change of state of a device. Fields that do not apply to the type
+// are zero.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Peer NoisePublicKey // zero for events of the device as a whole
+
+	// Endpoint is the new endpoint, for EventEndpointChanged.
+	Endpoint string
+	// Reason is the cause of EventEndpointChanged, "roaming" or "dns", the role
+	// of the device in EventHandshakeCompleted, "initiator" or "responder", or
+	// the quota used up for EventPeerSuspended, "daily_quota" or "monthly_quota".
+	Reason string
+	// Attempt counts the initiations sent, for EventHandshakeInitiated and
+	// EventHandshakeFailed.
+	Attempt int
+	// MTU is the new MTU, for EventMTUUpdated.
+	MTU int
+	// Lost is the number of events dropped just before this one, for EventLost.
+	Lost uint64
+}
+
+type eventSubscriber struct {
+	c    chan Event
+	lost uint64
+}
+
+// eventBus fans events out to subscribers without ever blocking the device.
+type eventBus struct {
+	sync.Mutex
+	subscribers map[*eventSubscriber]struct{}
+	count       atomic.Int32 // len(subscribers), read without the lock
+	closed      bool
+}
+
+// SubscribeEvents returns a channel receiving the events of the device, buffered
+// to hold size events, and a function ending the subscription. The channel is
+// closed when the subscription ends or the device closes. A subscriber that
+// falls behind loses events, and receives an EventLost telling how many once
+// there is room again.
+func (device *Device) SubscribeEvents(size int) (<-chan Event, func()) {
+	sub := &eventSubscriber{c: make(chan Event, max(size, 1))}
+	bus := &device.events
+	bus.Lock()
+	defer bus.Unlock()
+	if bus.closed {
+		close(sub.c)
+		return sub.c, func() {}
+	}
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[*eventSubscriber]struct{})
+	}
+	bus.subscribers[sub] = struct{}{}
+	bus.count.Add(1)
+	return sub.c, func() {
+		bus.Lock()
+		defer bus.Unlock()
+		if _, ok := bus.subscribers[sub]; ok {
+			delete(bus.subscribers, sub)
+			bus.count.Add(-1)
+			close(sub.c)
+		}
+	}
+}
+
+// hasEventSubscribers reports whether events are wanted, so that the hot path
+// can skip working out whether something changed.
+func (device *Device) hasEventSubscribers() bool {
+	return device.events.count.Load() > 0
+}
+
+func (device *Device) emit(event Event) {
+	if !device.hasEventSubscribers() {
+		return
+	}
+	event.Time = time.Now()
+	bus := &device.events
+	bus.Lock()
+	defer bus.Unlock()
+	for sub := range bus.subscribers {
+		if sub.lost > 0 {
+			select {
+			case sub.c <- Event{Type: EventLost, Time: event.Time, Lost: sub.lost}:
+				sub.lost = 0
+			default:
+				sub.lost++
+				continue
+			}
+		}
+		select {
+		case sub.c <- event:
+		default:
+			sub.lost++
+		}
+	}
+}
+
+func (peer *Peer) emit(event Event) {
+	event.Peer = peer.handshake.remoteStatic
+	peer.device.emit(event)
+}
+
+// closeEvents ends all subscriptions, for when the device closes.
+func (device *Device) closeEvents() {
+	bus := &device.events
+	bus.Lock()
+	defer bus.Unlock()
+	for sub := range bus.subscribers {
+		close(sub.c)
+	}
+	bus.subscribers = nil
+	bus.count.Store(0)
+	bus.closed = true
+}
+
+// writeEventRecord writes event as key=value lines ended by an empty line, the
+// record format of the UAPI subscribe operation.
+func writeEventRecord(w io.Writer, event Event) error {
+	var b []byte
+	add := func(key, value string) {
+		b = append(b, key...)
+		b = append(b, '=')
+		b = append(b, value...)
+		b = append(b, '\n')
+	}
+	add("event", event.Type.String())
+	add("time_sec", strconv.FormatInt(event.Time.Unix(), 10))
+	add("time_nsec", strconv.Itoa(event.Time.Nanosecond()))
+	if !event.Peer.IsZero() {
+		add("public_key", fmt.Sprintf("%x", event.Peer[:]))
+	}
+	if event.Endpoint != "" {
+		add("endpoint", event.Endpoint)
+	}
+	if event.Reason != "" {
+		add("reason", event.Reason)
+	}
+	if event.Attempt != 0 {
+		add("attempt", strconv.Itoa(event.Attempt))
+	}
+	if event.MTU != 0 {
+		add("mtu", strconv.Itoa(event.MTU))
+	}
+	if event.Lost != 0 {
+		add("lost", strconv.FormatUint(event.Lost, 10))
+	}
+	b = append(b, '\n')
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,138 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitEvent returns the next event of type want, skipping others.
+func waitEvent(t *testing.T, events <-chan Event, want EventType) Event {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatalf("subscription ended waiting for %v", want)
+			}
+			if event.Type == want {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %v event", want)
+		}
+	}
+}
+
+func TestEvents(t *testing.T) {
+	pair := genTestPair(t, false)
+	responder, unsubscribe := pair[0].dev.SubscribeEvents(64)
+	defer unsubscribe()
+	initiator, _ := pair[1].dev.SubscribeEvents(64)
+	peerOfInitiator := pair[1].dev.staticIdentity.publicKey
+	peerOfResponder := pair[0].dev.staticIdentity.publicKey
+
+	pair.Send(t, Ping, nil)
+	if event := waitEvent(t, initiator, EventHandshakeInitiated); event.Peer != peerOfResponder || event.Attempt != 1 {
+		t.Errorf("initiated: peer %v, attempt %d", event.Peer, event.Attempt)
+	}
+	if event := waitEvent(t, initiator, EventHandshakeCompleted); event.Reason != "initiator" || event.Time.IsZero() {
+		t.Errorf("completed by initiator: %+v", event)
+	}
+	if event := waitEvent(t, responder, EventHandshakeCompleted); event.Peer != peerOfInitiator || event.Reason != "responder" {
+		t.Errorf("completed by responder: %+v", event)
+	}
+
+	pair[0].dev.Down()
+	waitEvent(t, responder, EventDeviceDown)
+	pair[0].dev.Up()
+	waitEvent(t, responder, EventDeviceUp)
+	pair[0].dev.RemovePeer(peerOfInitiator)
+	if event := waitEvent(t, responder, EventPeerRemoved); event.Peer != peerOfInitiator {
+		t.Errorf("removed peer %v", event.Peer)
+	}
+
+	pair[1].dev.Close()
+	waitEvent(t, initiator, EventDeviceDown)
+	for range initiator {
+		// drained until closing the device ends the subscription
+	}
+	if events, _ := pair[1].dev.SubscribeEvents(1); !isClosed(events) {
+		t.Error("subscribed to a closed device")
+	}
+}
+
+func isClosed(events <-chan Event) bool {
+	select {
+	case _, ok := <-events:
+		return !ok
+	default:
+		return false
+	}
+}
+
+func TestEventsLost(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	events, unsubscribe := dev.SubscribeEvents(1)
+	for range 3 {
+		dev.emit(Event{Type: EventMTUUpdated, MTU: 1280})
+	}
+	if event := <-events; event.Type != EventMTUUpdated {
+		t.Fatalf("got %v, want %v", event.Type, EventMTUUpdated)
+	}
+	dev.emit(Event{Type: EventMTUUpdated, MTU: 1280})
+	if event := <-events; event.Type != EventLost || event.Lost != 2 {
+		t.Errorf("got %v with %d lost, want %v with 2", event.Type, event.Lost, EventLost)
+	}
+	unsubscribe()
+	unsubscribe()
+	if !isClosed(events) {
+		t.Error("channel open after unsubscribing")
+	}
+	if dev.hasEventSubscribers() {
+		t.Error("subscriber still counted")
+	}
+}
+
+func TestIpcSubscribe(t *testing.T) {
+	dev := randDevice(t)
+	// the channel TUN brings the device up by itself
+	for !dev.isUp() {
+		time.Sleep(time.Millisecond)
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	if _, err := io.WriteString(client, "subscribe=1\n\n"); err != nil {
+		t.Fatal(err)
+	}
+	// wait until subscribed before causing events
+	for !dev.hasEventSubscribers() {
+		time.Sleep(time.Millisecond)
+	}
+
+	dev.Down()
+	dev.Up()
+	dev.Close()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	records, err := io.ReadAll(bufio.NewReader(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(records)
+	for _, want := range []string{"event=device_down\ntime_sec=", "event=device_up\n", "\n\nerrno=0\n\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stream lacks %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,708 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"container/list"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A FirewallRule filters the packets inside the tunnel. It is written as
+//
+//	accept out peer <key> proto tcp to 10.0.0.5/32 dport 443 log
+//
+// starting with the action, accept or drop. The other terms are optional, and a
+// packet must match all of them: the direction, in from a peer or out to it; the
+// peer, by its public key in base64 or hex; the protocol, tcp, udp, icmp (of
+// either IP version) or a number; the source and destination, from and to, as
+// comma separated addresses or prefixes; the ports, sport and dport, as comma
+// separated ports or ranges such as 1024-65535; and the ICMP types, icmp-type.
+// Packets matching a rule with log are logged.
+type FirewallRule struct {
+	accept       bool
+	direction    firewallDirection
+	peer         *NoisePublicKey
+	proto        int // -1 for any
+	from, to     []netip.Prefix
+	sport, dport []portRange
+	icmpTypes    []uint8
+	log          bool
+}
+
+type firewallDirection uint8
+
+const (
+	firewallBoth firewallDirection = iota
+	firewallIn                     // from the peer to the TUN device
+	firewallOut                    // from the TUN device to the peer
+)
+
+type portRange struct {
+	first, last uint16
+}
+
+const (
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// ParseFirewallRule parses a rule in the syntax described at FirewallRule.
+func ParseFirewallRule(text string) (FirewallRule, error) {
+	rule := FirewallRule{proto: -1}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return rule, errors.New("empty firewall rule")
+	}
+	switch fields[0] {
+	case "accept":
+		rule.accept = true
+	case "drop":
+	default:
+		return rule, fmt.Errorf("invalid firewall action %q", fields[0])
+	}
+
+	seen := make(map[string]bool)
+	for i := 1; i < len(fields); i++ {
+		term := fields[i]
+		if term == "in" || term == "out" {
+			if seen["direction"] {
+				return rule, errors.New("duplicate direction in firewall rule")
+			}
+			seen["direction"] = true
+			rule.direction = firewallIn
+			if term == "out" {
+				rule.direction = firewallOut
+			}
+			continue
+		}
+		if seen[term] {
+			return rule, fmt.Errorf("duplicate %q in firewall rule", term)
+		}
+		seen[term] = true
+		if term == "log" {
+			rule.log = true
+			continue
+		}
+
+		if i+1 >= len(fields) {
+			return rule, fmt.Errorf("missing value after %q in firewall rule", term)
+		}
+		i++
+		value := fields[i]
+		var err error
+		switch term {
+		case "peer":
+			rule.peer, err = parsePublicKeyText(value)
+		case "proto":
+			rule.proto, err = parseFirewallProto(value)
+		case "from":
+			rule.from, err = parseFirewallPrefixes(value)
+		case "to":
+			rule.to, err = parseFirewallPrefixes(value)
+		case "sport":
+			rule.sport, err = parseFirewallPorts(value)
+		case "dport":
+			rule.dport, err = parseFirewallPorts(value)
+		case "icmp-type":
+			for _, s := range strings.Split(value, ",") {
+				var n uint64
+				n, err = strconv.ParseUint(s, 10, 8)
+				if err != nil {
+					break
+				}
+				rule.icmpTypes = append(rule.icmpTypes, uint8(n))
+			}
+		default:
+			return rule, fmt.Errorf("unknown term %q in firewall rule", term)
+		}
+		if err != nil {
+			return rule, fmt.Errorf("invalid %s %q in firewall rule: %w", term, value, err)
+		}
+	}
+
+	if (rule.sport != nil || rule.dport != nil) && rule.proto != protoTCP && rule.proto != protoUDP {
+		return rule, errors.New("ports in firewall rule require proto tcp or udp")
+	}
+	if rule.icmpTypes != nil && rule.proto != protoICMP && rule.proto != protoICMPv6 {
+		return rule, errors.New("icmp-type in firewall rule requires proto icmp")
+	}
+	return rule, nil
+}
+
+// parsePublicKeyText parses a public key written by a person, in base64 as in
+// configuration files or in hex as in the UAPI.
+func parsePublicKeyText(value string) (*NoisePublicKey, error) {
+	var key NoisePublicKey
+	if len(value) == len(key)*2 {
+		if err := key.FromHex(value); err != nil {
+			return nil, err
+		}
+		return &key, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != len(key) {
+		return nil, fmt.Errorf("key of %d bytes", len(b))
+	}
+	copy(key[:], b)
+	return &key, nil
+}
+
+func parseFirewallProto(value string) (int, error) {
+	switch value {
+	case "tcp":
+		return protoTCP, nil
+	case "udp":
+		return protoUDP, nil
+	case "icmp":
+		return protoICMP, nil
+	}
+	n, err := strconv.ParseUint(value, 10, 8)
+	return int(n), err
+}
+
+func parseFirewallPrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(value, ",") {
+		if addr, err := netip.ParseAddr(s); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func parseFirewallPorts(value string) ([]portRange, error) {
+	var ports []portRange
+	for _, s := range strings.Split(value, ",") {
+		firstText, lastText, isRange := strings.Cut(s, "-")
+		first, err := strconv.ParseUint(firstText, 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.ParseUint(lastText, 10, 16); err != nil {
+				return nil, err
+			}
+			if last < first {
+				return nil, fmt.Errorf("empty range %s", s)
+			}
+		}
+		ports = append(ports, portRange{uint16(first), uint16(last)})
+	}
+	return ports, nil
+}
+
+// String returns the rule in its canonical form.
+func (rule FirewallRule) String() string {
+	var b strings.Builder
+	if rule.accept {
+		b.WriteString("accept")
+	} else {
+		b.WriteString("drop")
+	}
+	switch rule.direction {
+	case firewallIn:
+		b.WriteString(" in")
+	case firewallOut:
+		b.WriteString(" out")
+	}
+	if rule.peer != nil {
+		b.WriteString(" peer ")
+		b.WriteString(base64.StdEncoding.EncodeToString(rule.peer[:]))
+	}
+	if rule.proto >= 0 {
+		b.WriteString(" proto ")
+		b.WriteString(protoName(uint8(rule.proto)))
+	}
+	list := func(term string, n int, item func(i int) string) {
+		if n == 0 {
+			return
+		}
+		b.WriteString(" " + term + " ")
+		for i := range n {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(item(i))
+		}
+	}
+	list("from", len(rule.from), func(i int) string { return rule.from[i].String() })
+	list("to", len(rule.to), func(i int) string { return rule.to[i].String() })
+	list("sport", len(rule.sport), func(i int) string { return rule.sport[i].String() })
+	list("dport", len(rule.dport), func(i int) string { return rule.dport[i].String() })
+	list("icmp-type", len(rule.icmpTypes), func(i int) string { return strconv.Itoa(int(rule.icmpTypes[i])) })
+	if rule.log {
+		b.WriteString(" log")
+	}
+	return b.String()
+}
+
+func (r portRange) String() string {
+	if r.first == r.last {
+		return strconv.Itoa(int(r.first))
+	}
+	return fmt.Sprintf("%d-%d", r.first, r.last)
+}
+
+func protoName(proto uint8) string {
+	switch proto {
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	case protoICMP:
+		return "icmp"
+	}
+	return strconv.Itoa(int(proto))
+}
+
+func (rule *FirewallRule) matches(peer *Peer, p *firewallPacket, inbound bool) bool {
+	switch {
+	case rule.direction == firewallIn && !inbound, rule.direction == firewallOut && inbound:
+		return false
+	case rule.peer != nil && *rule.peer != peer.handshake.remoteStatic:
+		return false
+	case rule.proto >= 0 && int(p.proto) != rule.proto && (rule.proto != protoICMP || p.proto != protoICMPv6):
+		return false
+	case !matchPrefixes(rule.from, p.src), !matchPrefixes(rule.to, p.dst):
+		return false
+	}
+	if rule.sport == nil && rule.dport == nil && rule.icmpTypes == nil {
+		return true
+	}
+	if !p.l4 {
+		return false // a fragment, or truncated
+	}
+	if rule.icmpTypes != nil {
+		return slices.Contains(rule.icmpTypes, p.icmpType)
+	}
+	return matchPorts(rule.sport, p.sport) && matchPorts(rule.dport, p.dport)
+}
+
+func matchPrefixes(prefixes []netip.Prefix, addr netip.Addr) bool {
+	if prefixes == nil {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPorts(ports []portRange, port uint16) bool {
+	if ports == nil {
+		return true
+	}
+	for _, r := range ports {
+		if port >= r.first && port <= r.last {
+			return true
+		}
+	}
+	return false
+}
+
+// A firewallPacket is what the firewall looks at in an IP packet.
+type firewallPacket struct {
+	src, dst     netip.Addr
+	proto        uint8
+	l4           bool   // the transport header is present
+	ports        bool   // sport and dport identify a connection
+	sport, dport uint16 // the identifier of ICMP echo messages
+	icmpType     uint8
+	icmpError    []byte // the packet an ICMP error message is about
+	fragment     bool   // part of a fragmented packet, with fragmentID
+	later        bool   // a fragment other than the first
+	fragmentID   uint32
+	length       int
+}
+
+// parseFirewallPacket parses the headers of packet, and reports false if they
+// are malformed.
+func parseFirewallPacket(packet []byte) (firewallPacket, bool) {
+	p := firewallPacket{length: len(packet)}
+	var payload []byte
+	switch {
+	case len(packet) >= 20 && packet[0]>>4 == 4:
+		headerLen := int(packet[0]&0xf) * 4
+		if headerLen < 20 || headerLen > len(packet) {
+			return p, false
+		}
+		p.src = netip.AddrFrom4([4]byte(packet[IPv4offsetSrc:]))
+		p.dst = netip.AddrFrom4([4]byte(packet[IPv4offsetDst:]))
+		p.proto = packet[9]
+		flags := binary.BigEndian.Uint16(packet[6:])
+		p.fragment, p.later = flags&0x3fff != 0, flags&0x1fff != 0
+		p.fragmentID = uint32(binary.BigEndian.Uint16(packet[4:]))
+		if !p.later {
+			payload = packet[headerLen:]
+		}
+
+	case len(packet) >= 40 && packet[0]>>4 == 6:
+		p.src = netip.AddrFrom16([16]byte(packet[IPv6offsetSrc:]))
+		p.dst = netip.AddrFrom16([16]byte(packet[IPv6offsetDst:]))
+		next, offset := packet[6], 40
+	extensions:
+		for offset+8 <= len(packet) {
+			switch next {
+			case 0, 43, 60: // hop-by-hop, routing and destination options
+				next, offset = packet[offset], offset+(int(packet[offset+1])+1)*8
+			case 44: // fragment
+				p.fragment, p.later = true, binary.BigEndian.Uint16(packet[offset+2:])>>3 != 0
+				p.fragmentID = binary.BigEndian.Uint32(packet[offset+4:])
+				next, offset = packet[offset], offset+8
+			default:
+				break extensions
+			}
+		}
+		p.proto = next
+		if !p.later && offset <= len(packet) {
+			payload = packet[offset:]
+		}
+
+	default:
+		return p, false
+	}
+
+	switch p.proto {
+	case protoTCP, protoUDP:
+		if len(payload) >= 4 {
+			p.l4, p.ports = true, true
+			p.sport = binary.BigEndian.Uint16(payload)
+			p.dport = binary.BigEndian.Uint16(payload[2:])
+		}
+	case protoICMP, protoICMPv6:
+		if len(payload) >= 1 {
+			p.l4 = true
+			p.icmpType = payload[0]
+		}
+		echo := p.icmpType == 8 || p.icmpType == 0
+		if p.proto == protoICMPv6 {
+			echo = p.icmpType == 128 || p.icmpType == 129
+		}
+		if echo && len(payload) >= 6 {
+			p.ports = true
+			p.sport = binary.BigEndian.Uint16(payload[4:])
+			p.dport = p.sport
+		}
+		if isICMPError(p.proto, p.icmpType) && len(payload) >= 8 {
+			p.icmpError = payload[8:]
+		}
+	default:
+		p.ports = true // tracked by addresses alone
+	}
+	return p, true
+}
+
+func (p *firewallPacket) String() string {
+	if !p.ports || p.sport == 0 && p.dport == 0 {
+		return fmt.Sprintf("%s %v -> %v", protoName(p.proto), p.src, p.dst)
+	}
+	return fmt.Sprintf("%s %v -> %v", protoName(p.proto), netip.AddrPortFrom(p.src, p.sport), netip.AddrPortFrom(p.dst, p.dport))
+}
+
+// isICMPError reports whether an ICMP message reports an error about a packet,
+// which it quotes: destination unreachable, which includes fragmentation
+// needed, packet too big, time exceeded and parameter problem.
+func isICMPError(proto, icmpType uint8) bool {
+	if proto == protoICMPv6 {
+		return icmpType >= 1 && icmpType <= 4
+	}
+	return icmpType == 3 || icmpType == 11 || icmpType == 12
+}
+
+// A firewallFlow identifies a connection with a peer through the tunnel, the
+// same way in both directions.
+type firewallFlow struct {
+	proto         uint8
+	local, remote netip.AddrPort // local is on the side of the TUN device
+}
+
+func (p *firewallPacket) flow(inbound bool) firewallFlow {
+	src, dst := netip.AddrPortFrom(p.src, p.sport), netip.AddrPortFrom(p.dst, p.dport)
+	if inbound {
+		return firewallFlow{p.proto, dst, src}
+	}
+	return firewallFlow{p.proto, src, dst}
+}
+
+// A firewallFragment identifies the fragments of a packet.
+type firewallFragment struct {
+	src, dst netip.Addr
+	proto    uint8
+	id       uint32
+}
+
+// firewallFlows tracks the connections with a peer that the firewall accepted,
+// so that the rest of their packets, replies in particular, are accepted as
+// well, along with the fragments of their packets.
+type firewallFlows struct {
+	sync.Mutex
+	conns     expiringSet[firewallFlow]
+	fragments expiringSet[firewallFragment]
+}
+
+func flowTimeout(proto uint8) int64 {
+	if proto == protoTCP {
+		return int64(FirewallFlowTimeoutTCP)
+	}
+	return int64(FirewallFlowTimeout)
+}
+
+// established reports whether flow is tracked, and keeps it alive if so.
+func (flows *firewallFlows) established(flow firewallFlow, now int64) bool {
+	flows.Lock()
+	defer flows.Unlock()
+	return flows.conns.get(flow, now, flowTimeout(flow.proto))
+}
+
+// related reports whether flow is tracked, without keeping it alive.
+func (flows *firewallFlows) related(flow firewallFlow, now int64) bool {
+	flows.Lock()
+	defer flows.Unlock()
+	return flows.conns.get(flow, now, 0)
+}
+
+func (flows *firewallFlows) add(flow firewallFlow, now int64) {
+	flows.Lock()
+	defer flows.Unlock()
+	flows.conns.add(flow, now, flowTimeout(flow.proto), FirewallMaxFlows)
+}
+
+// addFragment lets the later fragments of the packet p through.
+func (flows *firewallFlows) addFragment(p *firewallPacket, now int64) {
+	flows.Lock()
+	defer flows.Unlock()
+	flows.fragments.add(firewallFragment{p.src, p.dst, p.proto, p.fragmentID}, now, int64(FirewallFragmentTimeout), FirewallMaxFragments)
+}
+
+// fragmentOf reports whether p is a later fragment of an accepted packet.
+func (flows *firewallFlows) fragmentOf(p *firewallPacket, now int64) bool {
+	flows.Lock()
+	defer flows.Unlock()
+	return flows.fragments.get(firewallFragment{p.src, p.dst, p.proto, p.fragmentID}, now, 0)
+}
+
+func (flows *firewallFlows) len() int {
+	flows.Lock()
+	defer flows.Unlock()
+	return flows.conns.lru.Len()
+}
+
+// An expiringSet holds keys until they expire. Each addition drops a few
+// expired keys, and the least recently used one if the set is full, so that no
+// operation ever scans the whole set.
+type expiringSet[K comparable] struct {
+	entries map[K]*list.Element
+	lru     list.List // of *expiringKey[K], most recently used first
+}
+
+type expiringKey[K comparable] struct {
+	key    K
+	expiry int64 // unix nanoseconds
+}
+
+// get reports whether key is in the set and unexpired. If so and timeout is not
+// zero, the key is marked as used and kept for timeout from now.
+func (set *expiringSet[K]) get(key K, now, timeout int64) bool {
+	elem, ok := set.entries[key]
+	if !ok {
+		return false
+	}
+	entry := elem.Value.(*expiringKey[K])
+	if entry.expiry < now {
+		set.remove(elem)
+		return false
+	}
+	if timeout != 0 {
+		entry.expiry = now + timeout
+		set.lru.MoveToFront(elem)
+	}
+	return true
+}
+
+func (set *expiringSet[K]) add(key K, now, timeout int64, max int) {
+	if elem, ok := set.entries[key]; ok {
+		elem.Value.(*expiringKey[K]).expiry = now + timeout
+		set.lru.MoveToFront(elem)
+		return
+	}
+	for range 2 {
+		elem := set.lru.Back()
+		if elem == nil || elem.Value.(*expiringKey[K]).expiry >= now {
+			break
+		}
+		set.remove(elem)
+	}
+	if set.lru.Len() >= max {
+		set.remove(set.lru.Back())
+	}
+	if set.entries == nil {
+		set.entries = make(map[K]*list.Element)
+	}
+	set.entries[key] = set.lru.PushFront(&expiringKey[K]{key, now + timeout})
+}
+
+func (set *expiringSet[K]) remove(elem *list.Element) {
+	delete(set.entries, elem.Value.(*expiringKey[K]).key)
+	set.lru.Remove(elem)
+}
+
+// A firewall filters the packets between the TUN device and the peers. Packets
+// of tracked connections are accepted; others are decided by the first rule
+// they match, or by the default policy. Accepted packets start connections. A
+// firewall never changes: new rules replace it, which resets the connections.
+type firewall struct {
+	accept        bool // the default policy
+	rules         []firewallRuleState
+	policyPackets atomic.Uint64 // decided by the default policy
+	flows         sync.Map      // *Peer to *firewallFlows
+}
+
+type firewallRuleState struct {
+	FirewallRule
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+	lastLog atomic.Int64 // unix nanoseconds
+}
+
+func newFirewall(accept bool, rules []FirewallRule) *firewall {
+	fw := &firewall{
+		accept: accept,
+		rules:  make([]firewallRuleState, len(rules)),
+	}
+	for i, rule := range rules {
+		fw.rules[i].FirewallRule = rule
+	}
+	return fw
+}
+
+// peerFlows returns the connections tracked with peer.
+func (fw *firewall) peerFlows(peer *Peer) *firewallFlows {
+	if flows, ok := fw.flows.Load(peer); ok {
+		return flows.(*firewallFlows)
+	}
+	flows, _ := fw.flows.LoadOrStore(peer, new(firewallFlows))
+	return flows.(*firewallFlows)
+}
+
+// filter reports whether packet may pass between the TUN device and peer.
+func (device *Device) filter(fw *firewall, peer *Peer, packet []byte, inbound bool) bool {
+	p, ok := parseFirewallPacket(packet)
+	if !ok {
+		return false
+	}
+	now := time.Now().UnixNano()
+	flows := fw.peerFlows(peer)
+	if p.ports && flows.established(p.flow(inbound), now) {
+		if p.fragment {
+			flows.addFragment(&p, now)
+		}
+		return true
+	}
+	if p.later && flows.fragmentOf(&p, now) {
+		return true
+	}
+	if p.icmpError != nil {
+		// the quoted packet went the other way
+		if quoted, ok := parseFirewallPacket(p.icmpError); ok && quoted.ports && flows.related(quoted.flow(!inbound), now) {
+			return true
+		}
+	}
+
+	accept := fw.accept
+	matched := false
+	for i := range fw.rules {
+		rule := &fw.rules[i]
+		if !rule.matches(peer, &p, inbound) {
+			continue
+		}
+		rule.packets.Add(1)
+		rule.bytes.Add(uint64(p.length))
+		if rule.log {
+			if last := rule.lastLog.Load(); now-last >= int64(FirewallLogInterval) && rule.lastLog.CompareAndSwap(last, now) {
+				verdict, direction := "Dropped", "to"
+				if rule.accept {
+					verdict = "Accepted"
+				}
+				if inbound {
+					direction = "from"
+				}
+				device.logs.firewall.Infof("Firewall: %s %v %s %v by rule %d (%v)", verdict, &p, direction, peer, i+1, &rule.FirewallRule)
+			}
+		}
+		accept, matched = rule.accept, true
+		break
+	}
+	if !matched {
+		fw.policyPackets.Add(1)
+	}
+	if accept && p.ports {
+		flows.add(p.flow(inbound), now)
+	}
+	if accept && p.fragment && !p.later {
+		flows.addFragment(&p, now)
+	}
+	return accept
+}
+
+// setFirewall replaces the firewall. Accepting by default without rules turns it
+// off.
+func (device *Device) setFirewall(accept bool, rules []FirewallRule) {
+	if accept && len(rules) == 0 {
+		device.firewall.Store(nil)
+		return
+	}
+	device.firewall.Store(newFirewall(accept, rules))
+}
+
+func (fw *firewall) policy() string {
+	if fw.accept {
+		return "accept"
+	}
+	return "drop"
+}
+
+// writeConfig writes the firewall for the UAPI get operation, with counters
+// after each rule.
+func (fw *firewall) writeConfig(sendf func(format string, args ...any)) {
+	sendf("firewall_policy=%s", fw.policy())
+	for i := range fw.rules {
+		rule := &fw.rules[i]
+		sendf("firewall_rule=%v", &rule.FirewallRule)
+		sendf("firewall_rule_packets=%d", rule.packets.Load())
+		sendf("firewall_rule_bytes=%d", rule.bytes.Load())
+	}
+	sendf("firewall_policy_packets=%d", fw.policyPackets.Load())
+	n := 0
+	fw.flows.Range(func(_, flows any) bool {
+		n += flows.(*firewallFlows).len()
+		return true
+	})
+	sendf("firewall_flows=%d", n)
+}
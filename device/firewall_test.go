@@ -0,0 +1,207 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestParseFirewallRule(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, NoisePublicKeySize))
+	for text, want := range map[string]string{
+		"accept":                                "accept",
+		"drop in proto udp dport 53,5353 log":   "drop in proto udp dport 53,5353 log",
+		"accept  out to 10.0.0.5 proto tcp":     "accept out proto tcp to 10.0.0.5/32",
+		"accept from 10.1.2.3/8,fd00::/64":      "accept from 10.0.0.0/8,fd00::/64",
+		"accept proto tcp sport 1024-65535":     "accept proto tcp sport 1024-65535",
+		"accept proto icmp icmp-type 8,0":       "accept proto icmp icmp-type 8,0",
+		"accept proto 47":                       "accept proto 47",
+		"drop peer " + strings.Repeat("00", 32): "drop peer " + key,
+		"drop peer " + key + " in":              "drop in peer " + key,
+	} {
+		rule, err := ParseFirewallRule(text)
+		if err != nil {
+			t.Errorf("ParseFirewallRule(%q): %v", text, err)
+			continue
+		}
+		if got := rule.String(); got != want {
+			t.Errorf("ParseFirewallRule(%q) = %q, want %q", text, got, want)
+		}
+	}
+
+	for _, text := range []string{
+		"",
+		"allow",
+		"accept in out",
+		"accept proto",
+		"accept proto sctp",
+		"accept to 10.0.0.300",
+		"accept dport 443",
+		"accept proto tcp dport 80-20",
+		"accept proto udp icmp-type 8",
+		"accept peer notakey",
+		"accept log log",
+		"accept established",
+	} {
+		if _, err := ParseFirewallRule(text); err == nil {
+			t.Errorf("ParseFirewallRule(%q) succeeded", text)
+		}
+	}
+}
+
+func testIPv4Packet(proto uint8, src, dst string, sport, dport uint16) []byte {
+	packet := make([]byte, 28)
+	packet[0] = 0x45
+	packet[9] = proto
+	copy(packet[IPv4offsetSrc:], netip.MustParseAddr(src).AsSlice())
+	copy(packet[IPv4offsetDst:], netip.MustParseAddr(dst).AsSlice())
+	binary.BigEndian.PutUint16(packet[20:], sport)
+	binary.BigEndian.PutUint16(packet[22:], dport)
+	return packet
+}
+
+// testFragment makes packet a fragment of the packet with the given id, at the
+// given offset in units of 8 bytes.
+func testFragment(packet []byte, id, offset uint16, more bool) []byte {
+	binary.BigEndian.PutUint16(packet[4:], id)
+	if more {
+		offset |= 0x2000
+	}
+	binary.BigEndian.PutUint16(packet[6:], offset)
+	return packet
+}
+
+// testFragNeeded returns an ICMP fragmentation needed message about quoted.
+func testFragNeeded(src, dst string, quoted []byte) []byte {
+	packet := testIPv4Packet(protoICMP, src, dst, 0, 0)
+	packet[20], packet[21] = 3, 4
+	return append(packet, quoted...)
+}
+
+func TestFirewallFilter(t *testing.T) {
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+	peer := dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	other := base64.StdEncoding.EncodeToString(make([]byte, NoisePublicKeySize))
+
+	var rules []FirewallRule
+	for _, text := range []string{
+		"accept out proto tcp to 10.0.0.5 dport 443",
+		"accept in peer " + other,
+		"accept in proto icmp icmp-type 8",
+	} {
+		rule, err := ParseFirewallRule(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	fw := newFirewall(false, rules)
+
+	for _, step := range []struct {
+		name    string
+		packet  []byte
+		inbound bool
+		accept  bool
+	}{
+		{"allowed connection", testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 40000, 443), false, true},
+		{"reply", testIPv4Packet(protoTCP, "10.0.0.5", "10.0.0.1", 443, 40000), true, true},
+		{"unsolicited", testIPv4Packet(protoTCP, "10.0.0.5", "10.0.0.1", 443, 40001), true, false},
+		{"other port", testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 40000, 80), false, false},
+		{"other peer", testIPv4Packet(protoUDP, "10.0.0.5", "10.0.0.1", 53, 40000), true, false},
+		{"echo request", tuntest.Ping(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.5")), true, true},
+		{"same echo back", tuntest.Ping(netip.MustParseAddr("10.0.0.5"), netip.MustParseAddr("10.0.0.1")), false, true},
+		{"truncated", []byte{0x45, 0, 0}, false, false},
+		{"frag needed", testFragNeeded("10.0.0.5", "10.0.0.1", testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 40000, 443)), true, true},
+		{"unrelated frag needed", testFragNeeded("10.0.0.5", "10.0.0.1", testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 40001, 443)), true, false},
+		{"first fragment", testFragment(testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 40000, 443), 7, 0, true), false, true},
+		{"later fragment", testFragment(testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 0, 0), 7, 185, false), false, true},
+		{"unknown fragment", testFragment(testIPv4Packet(protoTCP, "10.0.0.1", "10.0.0.5", 0, 0), 8, 185, false), false, false},
+	} {
+		if accept := dev.filter(fw, peer, step.packet, step.inbound); accept != step.accept {
+			t.Errorf("%s: accepted %v, want %v", step.name, accept, step.accept)
+		}
+	}
+
+	if n := fw.rules[0].packets.Load(); n != 1 {
+		t.Errorf("first rule matched %d packets, want 1", n)
+	}
+	if n := fw.rules[2].packets.Load(); n != 1 {
+		t.Errorf("ICMP rule matched %d packets, want 1", n)
+	}
+	if n := fw.policyPackets.Load(); n != 5 {
+		t.Errorf("policy decided %d packets, want 5", n)
+	}
+}
+
+func TestFirewall(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+
+	// pair[0] receives the pings from pair[1]
+	if err := pair[0].dev.IpcSet("firewall_policy=drop\n"); err != nil {
+		t.Fatal(err)
+	}
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+	deadline := time.Now().Add(5 * time.Second)
+	for peerStat(t, pair[0].dev, "rx_drop_firewall") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("ping not dropped by the firewall")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pair[0].dev.IpcSet(fmt.Sprintf("firewall_rule=accept in proto icmp from %v\n", pair[1].ip)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	config, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("firewall_policy=drop\nfirewall_rule=accept in proto icmp from %v/32\nfirewall_rule_packets=1\n", pair[1].ip)
+	if !strings.Contains(config, want) {
+		t.Errorf("get lacks %q:\n%s", want, config)
+	}
+
+	if err := pair[0].dev.IpcSet("firewall_rule=accept bogus\n"); err == nil {
+		t.Error("invalid rule accepted")
+	}
+	if err := pair[0].dev.IpcSet("firewall_policy=accept\nreplace_firewall_rules=true\n"); err != nil {
+		t.Fatal(err)
+	}
+	if pair[0].dev.firewall.Load() != nil {
+		t.Error("firewall still on without rules and accepting")
+	}
+}
+
+func TestExpiringSet(t *testing.T) {
+	var set expiringSet[int]
+	set.add(1, 0, 10, 2)
+	set.add(2, 0, 10, 2)
+	if !set.get(1, 5, 10) {
+		t.Fatal("key 1 not held")
+	}
+	set.add(3, 5, 10, 2) // full: drops 2, the least recently used
+	if set.get(2, 5, 0) || !set.get(1, 5, 0) || !set.get(3, 5, 0) {
+		t.Error("least recently used key not dropped")
+	}
+	if set.get(1, 20, 10) || set.lru.Len() != 1 {
+		t.Errorf("expired key held, %d keys", set.lru.Len())
+	}
+	set.add(4, 30, 10, 2) // drops the expired 3
+	if set.lru.Len() != 1 {
+		t.Errorf("%d keys, want 1", set.lru.Len())
+	}
+}
@@ -0,0 +1,394 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// Handoff is what another process needs to take over a running device without
+// new handshakes: its configuration and the sessions of its peers. It contains
+// private and session keys, so it must only ever be passed to that process
+// directly, and never be stored.
+type Handoff struct {
+	Config string        `json:"config"` // UAPI set operation recreating the configuration
+	Peers  []PeerHandoff `json:"peers"`
+}
+
+// PeerHandoff is the runtime state of a peer, including its sessions.
+type PeerHandoff struct {
+	PeerState
+	DNSEndpoint       string           `json:"dns_endpoint,omitempty"` // host:port monitored by the DNS monitor
+	LastHandshakeNano int64            `json:"last_handshake_nano"`
+	Keypairs          []KeypairHandoff `json:"keypairs"`
+}
+
+// KeypairHandoff is an established session of a peer.
+type KeypairHandoff struct {
+	Slot         string        `json:"slot"` // current, previous or next
+	SendKey      []byte        `json:"send_key"`
+	ReceiveKey   []byte        `json:"receive_key"`
+	SendNonce    uint64        `json:"send_nonce"`
+	ReplayFilter []byte        `json:"replay_filter"`
+	IsInitiator  bool          `json:"is_initiator"`
+	Age          time.Duration `json:"age"`
+	LocalIndex   uint32        `json:"local_index"`
+	RemoteIndex  uint32        `json:"remote_index"`
+}
+
+// EnableHandoff makes sessions established from now on keep their keys, which
+// PrepareHandoff needs to hand them over. Otherwise, only the AEADs hold the keys,
+// so this should only be called by programs that may hand the device over.
+func (device *Device) EnableHandoff() {
+	device.keepKeys.Store(true)
+}
+
+// PrepareHandoff stops the peers of the device, keeping their sessions, closes its
+// UDP sockets and returns its state along with duplicates of the sockets, for
+// another process to take the device over with ApplyHandoff. From then on, the
+// device passes no traffic until ResumeAfterHandoff: it reads no more packets
+// from its TUN device, leaving them to the other process, and set operations
+// fail rather than change a configuration that was handed over already. It
+// fails unless EnableHandoff was called.
+func (device *Device) PrepareHandoff() (*Handoff, []*os.File, error) {
+	device.state.Lock()
+	defer device.state.Unlock()
+	if device.isClosed() {
+		return nil, nil, errors.New("device closed")
+	}
+	if !device.keepKeys.Load() {
+		return nil, nil, errors.New("sessions do not keep their keys for a handoff")
+	}
+	up := device.isUp()
+
+	// Fail before disturbing anything if the sockets cannot be handed over.
+	var files []*os.File
+	if up {
+		device.net.RLock()
+		socketFiles, ok := device.net.bind.(conn.SocketFiles)
+		var err error
+		if !ok {
+			err = errors.New("bind does not support handing over its sockets")
+		} else {
+			files, err = socketFiles.SocketFiles()
+		}
+		device.net.RUnlock()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	device.ipcMutex.Lock()
+	defer device.ipcMutex.Unlock()
+
+	if up {
+		if device.dnsMonitor != nil {
+			device.dnsMonitor.Stop()
+		}
+		device.peers.RLock()
+		for _, peer := range device.peers.keyMap {
+			peer.stop(false)
+		}
+		device.peers.RUnlock()
+	}
+
+	handoff := device.handoffState()
+	device.beginHandoff()
+
+	if up {
+		if err := device.BindClose(); err != nil {
+			device.logs.bind.Errorf("Bind close failed: %v", err)
+		}
+	}
+	device.logs.device.Verbosef("Prepared handoff of %d peers", len(handoff.Peers))
+	return handoff, files, nil
+}
+
+// handoffState serializes the configuration and the peers of a device whose peers
+// are stopped. The caller must hold device.ipcMutex.
+func (device *Device) handoffState() *Handoff {
+	device.net.RLock()
+	defer device.net.RUnlock()
+
+	device.staticIdentity.RLock()
+	defer device.staticIdentity.RUnlock()
+
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+
+	var config strings.Builder
+	setf := func(format string, args ...any) {
+		fmt.Fprintf(&config, format, args...)
+		config.WriteByte('\n')
+	}
+
+	if !device.staticIdentity.privateKey.IsZero() {
+		setf("private_key=%s", hex.EncodeToString(device.staticIdentity.privateKey[:]))
+	}
+	setf("listen_port=%d", device.net.port)
+	setf("fwmark=%d", device.net.fwmark)
+	fw := device.firewall.Load()
+	if fw == nil {
+		fw = newFirewall(true, nil)
+	}
+	setf("firewall_policy=%s", fw.policy())
+	setf("replace_firewall_rules=true")
+	for i := range fw.rules {
+		setf("firewall_rule=%v", &fw.rules[i].FirewallRule)
+	}
+
+	var monitoredPeers map[NoisePublicKey]*MonitoredPeerInfo
+	if device.dnsMonitor != nil {
+		setf("dns_monitor_interval=%d", int(device.dnsMonitor.GetMonitorInterval().Seconds()))
+		monitoredPeers = device.dnsMonitor.GetMonitoredPeers()
+	}
+	setf("replace_peers=true")
+
+	now := time.Now()
+	handoff := &Handoff{Peers: make([]PeerHandoff, 0, len(device.peers.keyMap))}
+	for key, peer := range device.peers.keyMap {
+		state := PeerHandoff{
+			PeerState: PeerState{
+				PublicKey: base64.StdEncoding.EncodeToString(key[:]),
+				RxBytes:   peer.rxBytes.Load(),
+				TxBytes:   peer.txBytes.Load(),
+				Quota:     peer.quotaUsage(),
+			},
+			LastHandshakeNano: peer.lastHandshakeNano.Load(),
+		}
+
+		peer.handshake.mutex.RLock()
+		setf("public_key=%s", hex.EncodeToString(key[:]))
+		setf("preshared_key=%s", hex.EncodeToString(peer.handshake.presharedKey[:]))
+		peer.handshake.mutex.RUnlock()
+
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			state.Endpoint = peer.endpoint.val.DstToString()
+			setf("endpoint=%s", state.Endpoint)
+		}
+		peer.endpoint.Unlock()
+
+		setf("persistent_keepalive_interval=%d", peer.persistentKeepaliveInterval.Load())
+		setf("tx_rate_limit=%d", peer.shaping.tx.rate.Load())
+		setf("rx_rate_limit=%d", peer.shaping.rx.rate.Load())
+		daily, monthly := peer.quotas()
+		setf("daily_quota=%d", daily)
+		setf("monthly_quota=%d", monthly)
+		setf("replace_allowed_ips=true")
+		device.allowedips.EntriesForPeer(peer, func(prefix netip.Prefix) bool {
+			setf("allowed_ip=%s", prefix.String())
+			return true
+		})
+
+		if info, ok := monitoredPeers[key]; ok {
+			state.DNSEndpoint = info.OriginalHost + ":" + info.Port
+			state.ResolvedIP = info.LastResolvedIP
+		}
+
+		peer.keypairs.RLock()
+		for _, slot := range []struct {
+			name    string
+			keypair *Keypair
+		}{
+			{"current", peer.keypairs.current},
+			{"previous", peer.keypairs.previous},
+			{"next", peer.keypairs.next.Load()},
+		} {
+			if slot.keypair != nil {
+				state.Keypairs = append(state.Keypairs, slot.keypair.handoff(slot.name, now))
+			}
+		}
+		peer.keypairs.RUnlock()
+
+		handoff.Peers = append(handoff.Peers, state)
+	}
+	handoff.Config = config.String()
+	return handoff
+}
+
+func (keypair *Keypair) handoff(slot string, now time.Time) KeypairHandoff {
+	replayFilter, _ := keypair.replayFilter.MarshalBinary()
+	return KeypairHandoff{
+		Slot:         slot,
+		SendKey:      append([]byte(nil), keypair.sendKey[:]...),
+		ReceiveKey:   append([]byte(nil), keypair.receiveKey[:]...),
+		SendNonce:    keypair.sendNonce.Load(),
+		ReplayFilter: replayFilter,
+		IsInitiator:  keypair.isInitiator,
+		Age:          now.Sub(keypair.created),
+		LocalIndex:   keypair.localIndex,
+		RemoteIndex:  keypair.remoteIndex,
+	}
+}
+
+// beginHandoff holds the TUN reader and fails set operations until endHandoff.
+func (device *Device) beginHandoff() {
+	device.handoff.Lock()
+	defer device.handoff.Unlock()
+	if device.handoff.pending == nil {
+		device.handoff.pending = make(chan struct{})
+	}
+}
+
+func (device *Device) endHandoff() {
+	device.handoff.Lock()
+	defer device.handoff.Unlock()
+	if device.handoff.pending != nil {
+		close(device.handoff.pending)
+		device.handoff.pending = nil
+	}
+}
+
+func (device *Device) handingOver() bool {
+	device.handoff.Lock()
+	defer device.handoff.Unlock()
+	return device.handoff.pending != nil
+}
+
+// waitHandoff blocks while the device is being handed over.
+func (device *Device) waitHandoff() {
+	device.handoff.Lock()
+	pending := device.handoff.pending
+	device.handoff.Unlock()
+	if pending != nil {
+		<-pending
+	}
+}
+
+// ResumeAfterHandoff undoes PrepareHandoff, for when the other process failed to
+// take the device over. The sessions are discarded, since the other process may
+// have used them, and peers that had one are sent a handshake right away, rather
+// than waiting for them to notice that their session is gone.
+func (device *Device) ResumeAfterHandoff() error {
+	device.state.Lock()
+	defer device.state.Unlock()
+	device.endHandoff()
+	if !device.isUp() {
+		return nil
+	}
+
+	var handshake []*Peer
+	device.peers.RLock()
+	for _, peer := range device.peers.keyMap {
+		if peer.keypairs.Current() != nil {
+			handshake = append(handshake, peer)
+		}
+		peer.ZeroAndFlushAll()
+	}
+	device.peers.RUnlock()
+
+	device.logs.device.Verbosef("Resuming after failed handoff")
+	if err := device.upLocked(); err != nil {
+		return err
+	}
+	for _, peer := range handshake {
+		peer.SendHandshakeInitiation(false)
+	}
+	return nil
+}
+
+// ApplyHandoff configures a new device like the one PrepareHandoff was called on
+// and installs the sessions of its peers, so that they carry on without new
+// handshakes once the device is up. Its bind should resume the sockets that
+// PrepareHandoff returned.
+func (device *Device) ApplyHandoff(handoff *Handoff) error {
+	if err := device.IpcSet(handoff.Config); err != nil {
+		return err
+	}
+
+	states := make([]PeerState, 0, len(handoff.Peers))
+	for i := range handoff.Peers {
+		state := &handoff.Peers[i]
+		states = append(states, state.PeerState)
+
+		b, err := base64.StdEncoding.DecodeString(state.PublicKey)
+		if err != nil || len(b) != NoisePublicKeySize {
+			return fmt.Errorf("invalid public key in handoff: %q", state.PublicKey)
+		}
+		peer := device.LookupPeer(NoisePublicKey(b))
+		if peer == nil {
+			continue
+		}
+
+		if state.DNSEndpoint != "" && device.dnsMonitor != nil {
+			if err := device.dnsMonitor.restorePeer(peer.handshake.remoteStatic, state.DNSEndpoint, state.ResolvedIP); err != nil {
+				device.logs.device.Errorf("%v - Failed to monitor handed over endpoint %s: %v", peer, state.DNSEndpoint, err)
+			}
+		}
+		peer.lastHandshakeNano.Store(state.LastHandshakeNano)
+		if err := peer.installKeypairs(state.Keypairs); err != nil {
+			return fmt.Errorf("%v: %w", peer, err)
+		}
+	}
+	device.RestoreState(states)
+	return nil
+}
+
+// installKeypairs installs sessions handed over by another process under the
+// indices that process assigned to them.
+func (peer *Peer) installKeypairs(handoffs []KeypairHandoff) error {
+	device := peer.device
+	now := time.Now()
+
+	keypairs := &peer.keypairs
+	keypairs.Lock()
+	defer keypairs.Unlock()
+
+	for _, h := range handoffs {
+		keypair := new(Keypair)
+		if len(h.SendKey) != len(keypair.sendKey) || len(h.ReceiveKey) != len(keypair.receiveKey) {
+			return errors.New("invalid session key length")
+		}
+		if err := keypair.replayFilter.UnmarshalBinary(h.ReplayFilter); err != nil {
+			return err
+		}
+		keypair.send, _ = chacha20poly1305.New(h.SendKey)
+		keypair.receive, _ = chacha20poly1305.New(h.ReceiveKey)
+		if device.keepKeys.Load() {
+			copy(keypair.sendKey[:], h.SendKey)
+			copy(keypair.receiveKey[:], h.ReceiveKey)
+		}
+		keypair.sendNonce.Store(h.SendNonce)
+		keypair.isInitiator = h.IsInitiator
+		keypair.created = now.Add(-h.Age)
+		keypair.localIndex = h.LocalIndex
+		keypair.remoteIndex = h.RemoteIndex
+
+		var slot **Keypair
+		switch h.Slot {
+		case "current":
+			slot = &keypairs.current
+		case "previous":
+			slot = &keypairs.previous
+		case "next":
+		default:
+			return fmt.Errorf("invalid keypair slot %q", h.Slot)
+		}
+
+		if !device.indexTable.insertKeypair(h.LocalIndex, peer, keypair) {
+			device.logs.device.Errorf("%v - Index of handed over %s keypair is already in use, dropping it", peer, h.Slot)
+			continue
+		}
+		if slot == nil {
+			device.DeleteKeypair(keypairs.next.Swap(keypair))
+		} else {
+			device.DeleteKeypair(*slot)
+			*slot = keypair
+		}
+	}
+	return nil
+}
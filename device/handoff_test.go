@@ -0,0 +1,142 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestHandoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the default bind cannot hand over its sockets on Windows")
+	}
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, true)
+	pair[0].dev.EnableHandoff()
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	old := pair[0].dev
+	remote := pair[1].dev.LookupPeer(old.staticIdentity.publicKey)
+	lastHandshake := remote.lastHandshakeNano.Load()
+
+	handoff, files, err := old.PrepareHandoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no sockets handed over")
+	}
+	if len(handoff.Peers) != 1 || len(handoff.Peers[0].Keypairs) == 0 {
+		t.Fatalf("handoff has no sessions: %+v", handoff.Peers)
+	}
+
+	// the state crosses a process boundary as JSON
+	b, err := json.Marshal(handoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handoff = new(Handoff)
+	if err := json.Unmarshal(b, handoff); err != nil {
+		t.Fatal(err)
+	}
+
+	bind, err := conn.NewStdNetBindFromFiles(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file.Close()
+	}
+	pair[0].tun = tuntest.NewChannelTUN()
+	pair[0].dev = NewDevice(pair[0].tun.TUN(), bind, NewLogger(LogLevelVerbose, "dev0b: "))
+	t.Cleanup(pair[0].dev.Close)
+	if err := pair[0].dev.ApplyHandoff(handoff); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[0].dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	old.Close()
+
+	if port := pair[0].dev.net.port; port != old.net.port {
+		t.Errorf("new device listens on port %d, want %d", port, old.net.port)
+	}
+	for range 10 {
+		pair.Send(t, Ping, nil)
+		pair.Send(t, Pong, nil)
+	}
+	if got := remote.lastHandshakeNano.Load(); got != lastHandshake {
+		t.Error("peer handshook again after the handoff")
+	}
+	if peer := pair[0].dev.LookupPeer(remote.device.staticIdentity.publicKey); peer.txBytes.Load() == 0 {
+		t.Error("transfer counters were not handed over")
+	}
+}
+
+func TestResumeAfterHandoff(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the default bind cannot hand over its sockets on Windows")
+	}
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, true)
+	pair[0].dev.EnableHandoff()
+	pair.Send(t, Ping, nil)
+	remote := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	lastHandshake := remote.lastHandshakeNano.Load()
+
+	_, files, err := pair[0].dev.PrepareHandoff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		file.Close()
+	}
+	if err := pair[0].dev.IpcSet(""); err == nil {
+		t.Error("set accepted while the device is handed over")
+	}
+	if err := pair[0].dev.ResumeAfterHandoff(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[0].dev.IpcSet(""); err != nil {
+		t.Errorf("set after resuming: %v", err)
+	}
+
+	// packets sent with the discarded session are lost until the new handshake
+	deadline := time.Now().Add(5 * time.Second)
+	for remote.lastHandshakeNano.Load() == lastHandshake {
+		if time.Now().After(deadline) {
+			t.Fatal("peer did not handshake again after resuming")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+}
+
+func TestHandoffDisabled(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, true)
+	pair.Send(t, Ping, nil)
+
+	keypair := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey).keypairs.Current()
+	if keypair == nil {
+		t.Fatal("no session established")
+	}
+	if !isZero(keypair.sendKey[:]) || !isZero(keypair.receiveKey[:]) {
+		t.Error("session keeps its keys although handoff is disabled")
+	}
+	if _, _, err := pair[0].dev.PrepareHandoff(); err == nil {
+		t.Error("handoff prepared although disabled")
+	}
+	pair.Send(t, Pong, nil)
+}
@@ -91,6 +91,21 @@ func (table *IndexTable) NewIndexForHandshake(peer *Peer, handshake *Handshake)
 	}
 }
 
+// insertKeypair registers a keypair taken over from another process under the index
+// that process assigned to it. It reports whether the index was still free.
+func (table *IndexTable) insertKeypair(index uint32, peer *Peer, keypair *Keypair) bool {
+	table.Lock()
+	defer table.Unlock()
+	if _, found := table.table[index]; found {
+		return false
+	}
+	table.table[index] = IndexTableEntry{
+		peer:    peer,
+		keypair: keypair,
+	}
+	return true
+}
+
 func (table *IndexTable) Lookup(id uint32) IndexTableEntry {
 	table.RLock()
 	defer table.RUnlock()
@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"io"
+	"sync"
+)
+
+// A keyLog writes the secrets of completed handshakes in the key log format of
+// Wireshark's WireGuard dissector, which can then decrypt captured datagrams.
+type keyLog struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// SetKeyLog makes the device write the keys of each handshake it completes to
+// w, in the format Wireshark reads from its wg.keylog_file preference. Anyone
+// who reads w can decrypt the sessions, so this is for debugging only. Each
+// handshake is a single Write, so several devices may share a file opened for
+// appending. A nil w stops logging.
+func (device *Device) SetKeyLog(w io.Writer) {
+	if w == nil {
+		device.keyLog.Store(nil)
+		return
+	}
+	device.keyLog.Store(&keyLog{w: w})
+}
+
+// keyLogEntry holds the secrets of one handshake until they are written.
+type keyLogEntry struct {
+	remoteStatic NoisePublicKey
+	ephemeral    NoisePrivateKey
+	presharedKey NoisePresharedKey
+}
+
+// writeKeyLog writes entry, with the static private key of the device, and then
+// zeroes it. The caller must not hold the handshake lock of any peer, as
+// SetPrivateKey takes those while holding the static identity lock.
+func (device *Device) writeKeyLog(l *keyLog, entry *keyLogEntry) {
+	device.staticIdentity.RLock()
+	static := device.staticIdentity.privateKey
+	device.staticIdentity.RUnlock()
+
+	var b []byte
+	line := func(name string, key []byte) {
+		b = append(b, name...)
+		b = append(b, " = "...)
+		b = base64.StdEncoding.AppendEncode(b, key)
+		b = append(b, '\n')
+	}
+	line("LOCAL_STATIC_PRIVATE_KEY", static[:])
+	line("REMOTE_STATIC_PUBLIC_KEY", entry.remoteStatic[:])
+	line("LOCAL_EPHEMERAL_PRIVATE_KEY", entry.ephemeral[:])
+	if !isZero(entry.presharedKey[:]) {
+		line("PRESHARED_KEY", entry.presharedKey[:])
+	}
+	setZero(static[:])
+	setZero(entry.ephemeral[:])
+	setZero(entry.presharedKey[:])
+
+	l.mu.Lock()
+	_, err := l.w.Write(b)
+	l.mu.Unlock()
+	setZero(b)
+	if err != nil {
+		device.logs.device.Errorf("Failed to write key log: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lockedBuffer is a bytes.Buffer safe to read while the device writes to it.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestKeyLog(t *testing.T) {
+	pair := genTestPair(t, false)
+	var psk NoisePresharedKey
+	psk[0] = 1
+	var logs [2]lockedBuffer
+	for i := range pair {
+		peer := pair[1-i].dev.staticIdentity.publicKey
+		if err := pair[i].dev.IpcSet(fmt.Sprintf("public_key=%x\npreshared_key=%x\n", peer[:], psk[:])); err != nil {
+			t.Fatal(err)
+		}
+		pair[i].dev.SetKeyLog(&logs[i])
+	}
+	pair.Send(t, Ping, nil)
+
+	encode := base64.StdEncoding.EncodeToString
+	for i := range pair {
+		entries := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(logs[i].String()), "\n") {
+			name, key, ok := strings.Cut(line, " = ")
+			if !ok {
+				t.Fatalf("device %d logged %q", i, line)
+			}
+			entries[name] = key
+		}
+		local, remote := pair[i].dev.staticIdentity.privateKey, pair[1-i].dev.staticIdentity.publicKey
+		if entries["LOCAL_STATIC_PRIVATE_KEY"] != encode(local[:]) || entries["REMOTE_STATIC_PUBLIC_KEY"] != encode(remote[:]) {
+			t.Errorf("device %d logged the wrong static keys: %v", i, entries)
+		}
+		if entries["PRESHARED_KEY"] != encode(psk[:]) {
+			t.Errorf("device %d logged the wrong preshared key: %v", i, entries)
+		}
+		ephemeral, err := base64.StdEncoding.DecodeString(entries["LOCAL_EPHEMERAL_PRIVATE_KEY"])
+		if err != nil || len(ephemeral) != NoisePrivateKeySize || isZero(ephemeral) {
+			t.Errorf("device %d logged no ephemeral key: %v", i, entries)
+		}
+	}
+
+	pair[0].dev.SetKeyLog(nil)
+	if pair[0].dev.keyLog.Load() != nil {
+		t.Error("key log still set")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.zx2c4.com/wireguard/replay"
 )
 
@@ -30,6 +31,11 @@ type Keypair struct {
 	created      time.Time
 	localIndex   uint32
 	remoteIndex  uint32
+
+	// The AEADs keep copies of these; they are only set after EnableHandoff,
+	// to hand the session over to another process.
+	sendKey    [chacha20poly1305.KeySize]byte
+	receiveKey [chacha20poly1305.KeySize]byte
 }
 
 type Keypairs struct {
@@ -48,5 +54,7 @@ func (kp *Keypairs) Current() *Keypair {
 func (device *Device) DeleteKeypair(key *Keypair) {
 	if key != nil {
 		device.indexTable.Delete(key.localIndex)
+		setZero(key.sendKey[:])
+		setZero(key.receiveKey[:])
 	}
 }
@@ -8,6 +8,7 @@ package device
 import (
 	"io"
 	"log"
+	"log/slog"
 	"os"
 )
 
@@ -19,6 +20,8 @@ import (
 type Logger struct {
 	Verbosef func(format string, args ...any)
 	Errorf   func(format string, args ...any)
+
+	handler slog.Handler // set by NewSlogLogger, which devices log to directly
 }
 
 // Log levels for use with NewLogger.
@@ -42,7 +45,7 @@ func NewLogger(level int, prepend string) *Logger {
 // It logs at the specified log level and above.
 // It decorates log lines with the log level, date, time, and prepend.
 func NewLoggerWithWriter(level int, prepend string, writer io.Writer) *Logger {
-	logger := &Logger{DiscardLogf, DiscardLogf}
+	logger := &Logger{Verbosef: DiscardLogf, Errorf: DiscardLogf}
 	logf := func(prefix string) func(string, ...any) {
 		return log.New(writer, prefix+": "+prepend, log.Ldate|log.Ltime|log.Lmicroseconds).Printf
 	}
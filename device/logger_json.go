@@ -0,0 +1,34 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"io"
+	"log/slog"
+)
+
+// NewJSONLogger constructs a Logger that writes one JSON record per line to writer.
+// It logs at the specified log level and above, like NewLoggerWithWriter.
+// Each record carries the level, the interface name, the subsystem that logged it
+// and, for peer-related messages, the peer's public key in base64.
+func NewJSONLogger(level int, interfaceName string, writer io.Writer) *Logger {
+	min, ok := slogLevel(level)
+	if !ok {
+		return &Logger{Verbosef: DiscardLogf, Errorf: DiscardLogf}
+	}
+	handler := slog.NewJSONHandler(writer, &slog.HandlerOptions{
+		Level: min,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := a.Value.Any().(slog.Level); ok && level < slog.LevelDebug {
+					a.Value = slog.StringValue("TRACE")
+				}
+			}
+			return a
+		},
+	})
+	return NewSlogLogger(handler.WithAttrs([]slog.Attr{slog.String("interface", interfaceName)}))
+}
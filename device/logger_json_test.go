@@ -0,0 +1,72 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	device := new(Device)
+	device.initLoggers(NewJSONLogger(LogLevelVerbose, "wg0", &buf))
+
+	peer := new(Peer)
+	peer.handshake.remoteStatic[0] = 1
+	device.logs.handshake.Verbosef("%v - Sending handshake initiation", peer)
+	device.logs.uapi.Errorf("UAPI: bad value %d", 42)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 records, got %d:\n%s", len(lines), buf.String())
+	}
+
+	var records [2]map[string]any
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &records[i]); err != nil {
+			t.Fatalf("record %d is not JSON: %v\n%s", i, err, line)
+		}
+	}
+
+	expected := [2]map[string]any{
+		{
+			"level":     "DEBUG",
+			"interface": "wg0",
+			"subsystem": "handshake",
+			"peer":      base64.StdEncoding.EncodeToString(peer.handshake.remoteStatic[:]),
+			"msg":       "Sending handshake initiation",
+		},
+		{
+			"level":     "ERROR",
+			"interface": "wg0",
+			"subsystem": "uapi",
+			"msg":       "UAPI: bad value 42",
+		},
+	}
+	for i := range expected {
+		for key, value := range expected[i] {
+			if records[i][key] != value {
+				t.Errorf("record %d: expected %s=%v, got %v", i, key, value, records[i][key])
+			}
+		}
+	}
+	if _, ok := records[1]["peer"]; ok {
+		t.Errorf("record 1: unexpected peer field")
+	}
+}
+
+func TestJSONLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(LogLevelError, "wg0", &buf)
+	logger.Verbosef("Routine: TUN reader - started")
+	if buf.Len() != 0 {
+		t.Errorf("verbose record logged at error level: %s", buf.String())
+	}
+}
@@ -0,0 +1,160 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelTrace is the level below slog.LevelDebug of messages about every
+// keepalive and every dropped packet.
+const LevelTrace = slog.LevelDebug - 4
+
+// ParseLogLevel parses one of the level names error, warn, info, debug and
+// trace.
+func ParseLogLevel(name string) (slog.Level, error) {
+	switch name {
+	case "error":
+		return slog.LevelError, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "debug":
+		return slog.LevelDebug, nil
+	case "trace":
+		return LevelTrace, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// logLevelName returns the name ParseLogLevel parses into level.
+func logLevelName(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "error"
+	case level >= slog.LevelWarn:
+		return "warn"
+	case level >= slog.LevelInfo:
+		return "info"
+	case level >= slog.LevelDebug:
+		return "debug"
+	}
+	return "trace"
+}
+
+// LogSubsystems are the subsystems that log messages are tagged with.
+var LogSubsystems = []string{"handshake", "timers", "dns", "uapi", "bind", "tun", "data", "routine", "firewall", "capture", "device"}
+
+// NewSlogLogger constructs a Logger that writes to handler. Devices log to it
+// with the level of each message, from LevelTrace to slog.LevelError, a
+// subsystem attribute naming one of LogSubsystems and, for peer messages, a
+// peer attribute with the peer's public key in base64. Messages given to its
+// Verbosef and Errorf functions directly are at slog.LevelInfo and
+// slog.LevelError, from the "device" subsystem. The handler decides which
+// levels it records.
+func NewSlogLogger(handler slog.Handler) *Logger {
+	logf := func(level slog.Level) func(string, ...any) {
+		return func(format string, args ...any) {
+			logSlog(handler, level, "device", format, args)
+		}
+	}
+	return &Logger{Verbosef: logf(slog.LevelInfo), Errorf: logf(slog.LevelError), handler: handler}
+}
+
+// logSlog hands a message to handler, unless it does not record its level.
+func logSlog(handler slog.Handler, level slog.Level, subsystem, format string, args []any) {
+	ctx := context.Background()
+	if !handler.Enabled(ctx, level) {
+		return
+	}
+	peer, format, args := messagePeer(format, args)
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), 0)
+	r.AddAttrs(slog.String("subsystem", subsystem))
+	if peer != nil {
+		r.AddAttrs(slog.String("peer", base64.StdEncoding.EncodeToString(peer.handshake.remoteStatic[:])))
+	}
+	handler.Handle(ctx, r)
+}
+
+// messagePeer splits the peer off a message about one, which is formatted as
+// "%v - message" with the peer first. It returns a nil peer and the message
+// unchanged for other messages.
+func messagePeer(format string, args []any) (*Peer, string, []any) {
+	if len(args) == 0 || !(strings.HasPrefix(format, "%v - ") || strings.HasPrefix(format, "%s - ")) {
+		return nil, format, args
+	}
+	peer, ok := args[0].(*Peer)
+	if !ok || peer == nil {
+		return nil, format, args
+	}
+	return peer, format[len("%v - "):], args[1:]
+}
+
+// slogLevel returns the lowest slog level recorded at a level for use with
+// NewLogger, and false for LogLevelSilent.
+func slogLevel(level int) (slog.Level, bool) {
+	switch {
+	case level >= LogLevelVerbose:
+		return LevelTrace, true
+	case level >= LogLevelError:
+		return slog.LevelError, true
+	}
+	return 0, false
+}
+
+// NewTextLogger constructs a leveled Logger that writes lines like those of
+// NewLoggerWithWriter, but naming the level of each message: ERROR, WARN, INFO,
+// DEBUG or TRACE. It logs at the specified log level and above.
+func NewTextLogger(level int, prepend string, writer io.Writer) *Logger {
+	min, ok := slogLevel(level)
+	if !ok {
+		return &Logger{Verbosef: DiscardLogf, Errorf: DiscardLogf}
+	}
+	return NewSlogLogger(&textHandler{w: writer, prepend: prepend, level: min, mu: new(sync.Mutex)})
+}
+
+// A textHandler writes records in the format of NewLoggerWithWriter, with the
+// peer in front of the message as in the message given to the Logger.
+type textHandler struct {
+	w       io.Writer
+	prepend string
+	level   slog.Level
+	mu      *sync.Mutex
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	var peer string
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "peer" {
+			if key := a.Value.String(); len(key) == 44 {
+				peer = "peer(" + key[0:4] + "…" + key[39:43] + ") - "
+			}
+		}
+		return true
+	})
+	line := fmt.Sprintf("%s: %s%s %s%s\n", strings.ToUpper(logLevelName(r.Level)), h.prepend,
+		r.Time.Format("2006/01/02 15:04:05.000000"), peer, r.Message)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := io.WriteString(h.w, line)
+	return err
+}
+
+func (h *textHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *textHandler) WithGroup(string) slog.Handler { return h }
@@ -0,0 +1,220 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// deviceLogLevels are the levels below which a device drops log messages before
+// they reach its Logger: those of the peer a message is about, else those of
+// its subsystem, else the default level. They are replaced, never modified.
+type deviceLogLevels struct {
+	level      slog.Level
+	subsystems map[string]slog.Level
+	peers      map[NoisePublicKey]slog.Level
+}
+
+func (l *deviceLogLevels) enabled(level slog.Level, subsystem string, peer *Peer) bool {
+	if peer != nil {
+		if min, ok := l.peers[peer.handshake.remoteStatic]; ok {
+			return level >= min
+		}
+	}
+	if min, ok := l.subsystems[subsystem]; ok {
+		return level >= min
+	}
+	return level >= l.level
+}
+
+// clone returns a copy of l, or the levels that drop nothing if l is nil.
+func (l *deviceLogLevels) clone() *deviceLogLevels {
+	if l == nil {
+		return &deviceLogLevels{
+			level:      LevelTrace,
+			subsystems: make(map[string]slog.Level),
+			peers:      make(map[NoisePublicKey]slog.Level),
+		}
+	}
+	return &deviceLogLevels{level: l.level, subsystems: maps.Clone(l.subsystems), peers: maps.Clone(l.peers)}
+}
+
+// subsystemLoggers are the loggers a device logs the messages of each of its
+// subsystems with.
+type subsystemLoggers struct {
+	device, handshake, timers, dns, uapi, bind, tun, data, routine, firewall, capture *subsystemLogger
+}
+
+func (device *Device) initLoggers(logger *Logger) {
+	device.log = logger
+	newLogger := func(subsystem string) *subsystemLogger {
+		return &subsystemLogger{device: device, subsystem: subsystem}
+	}
+	device.logs = subsystemLoggers{
+		device:    newLogger("device"),
+		handshake: newLogger("handshake"),
+		timers:    newLogger("timers"),
+		dns:       newLogger("dns"),
+		uapi:      newLogger("uapi"),
+		bind:      newLogger("bind"),
+		tun:       newLogger("tun"),
+		data:      newLogger("data"),
+		routine:   newLogger("routine"),
+		firewall:  newLogger("firewall"),
+		capture:   newLogger("capture"),
+	}
+}
+
+// A subsystemLogger logs the messages of a subsystem of a device at the level
+// of the function they are logged with. It passes those that the log levels of
+// the device enable to the Logger of the device: with their level to a Logger
+// from NewSlogLogger, else as given to Errorf for errors and to Verbosef for the
+// others. Without levels set, it passes all of them, and the Logger alone
+// decides what it logs. Messages at slog.LevelDebug and above go to the log ring
+// of the device before any filtering.
+type subsystemLogger struct {
+	device    *Device
+	subsystem string
+}
+
+// Tracef logs at LevelTrace, for messages about every keepalive and every
+// dropped packet.
+func (l *subsystemLogger) Tracef(format string, args ...any) {
+	l.logf(LevelTrace, format, args)
+}
+
+// Verbosef logs at slog.LevelDebug.
+func (l *subsystemLogger) Verbosef(format string, args ...any) {
+	l.logf(slog.LevelDebug, format, args)
+}
+
+// Infof logs at slog.LevelInfo, for changes of the state of the device and its
+// peers.
+func (l *subsystemLogger) Infof(format string, args ...any) {
+	l.logf(slog.LevelInfo, format, args)
+}
+
+// Warnf logs at slog.LevelWarn, for failures the device recovers from.
+func (l *subsystemLogger) Warnf(format string, args ...any) {
+	l.logf(slog.LevelWarn, format, args)
+}
+
+// Errorf logs at slog.LevelError.
+func (l *subsystemLogger) Errorf(format string, args ...any) {
+	l.logf(slog.LevelError, format, args)
+}
+
+func (l *subsystemLogger) logf(level slog.Level, format string, args []any) {
+	device := l.device
+	if level >= slog.LevelDebug {
+		device.logRing.add(level, l.subsystem, format, args)
+	}
+	if levels := device.logLevels.Load(); levels != nil {
+		peer, _, _ := messagePeer(format, args)
+		if !levels.enabled(level, l.subsystem, peer) {
+			return
+		}
+	}
+	logger := device.log
+	switch {
+	case logger.handler != nil:
+		logSlog(logger.handler, level, l.subsystem, format, args)
+	case level >= slog.LevelError:
+		if logger.Errorf != nil {
+			logger.Errorf(format, args...)
+		}
+	default:
+		if logger.Verbosef != nil {
+			logger.Verbosef(format, args...)
+		}
+	}
+}
+
+// SetLogLevel sets the level below which the device drops log messages that
+// are neither about a peer nor from a subsystem with levels of their own, as
+// set through the UAPI with log_peer and log_level. Messages at the level and
+// above still only appear if the Logger of the device records them.
+func (device *Device) SetLogLevel(level slog.Level) {
+	device.logLevelsMu.Lock()
+	defer device.logLevelsMu.Unlock()
+	levels := device.logLevels.Load().clone()
+	levels.level = level
+	device.logLevels.Store(levels)
+}
+
+// setLevel applies the value of log_level, LEVEL or SUBSYSTEM:LEVEL, where an
+// empty level removes the level of the subsystem.
+func (l *deviceLogLevels) setLevel(value string) error {
+	subsystem, name, found := strings.Cut(value, ":")
+	if !found {
+		name, subsystem = subsystem, ""
+	} else if !slices.Contains(LogSubsystems, subsystem) {
+		return fmt.Errorf("unknown log subsystem %q", subsystem)
+	}
+	var level slog.Level
+	if name != "" || !found {
+		var err error
+		if level, err = ParseLogLevel(name); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case !found:
+		l.level = level
+	case name == "":
+		delete(l.subsystems, subsystem)
+	default:
+		l.subsystems[subsystem] = level
+	}
+	return nil
+}
+
+// setPeer applies the value of log_peer, KEY:LEVEL with the public key in
+// base64 or hex, where an empty level removes the level of the peer.
+func (l *deviceLogLevels) setPeer(value string) error {
+	text, name, found := strings.Cut(value, ":")
+	if !found {
+		return fmt.Errorf("missing level after peer %q", text)
+	}
+	key, err := parsePublicKeyText(text)
+	if err != nil {
+		return fmt.Errorf("invalid peer %q: %w", text, err)
+	}
+	var level slog.Level
+	if name != "" {
+		if level, err = ParseLogLevel(name); err != nil {
+			return err
+		}
+	}
+
+	if name == "" {
+		delete(l.peers, *key)
+	} else {
+		l.peers[*key] = level
+	}
+	return nil
+}
+
+// writeConfig writes the log levels for the UAPI get operation.
+func (l *deviceLogLevels) writeConfig(sendf func(format string, args ...any)) {
+	sendf("log_level=%s", logLevelName(l.level))
+	for _, subsystem := range slices.Sorted(maps.Keys(l.subsystems)) {
+		sendf("log_level=%s:%s", subsystem, logLevelName(l.subsystems[subsystem]))
+	}
+	peers := slices.SortedFunc(maps.Keys(l.peers), func(a, b NoisePublicKey) int {
+		return bytes.Compare(a[:], b[:])
+	})
+	for _, peer := range peers {
+		sendf("log_peer=%s:%s", base64.StdEncoding.EncodeToString(peer[:]), logLevelName(l.peers[peer]))
+	}
+}
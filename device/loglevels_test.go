@@ -0,0 +1,173 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestMessagePeer(t *testing.T) {
+	peer := new(Peer)
+	for _, test := range []struct {
+		format string
+		args   []any
+		peer   *Peer
+		want   string
+	}{
+		{"%v - Receiving keepalive packet", []any{peer}, peer, "Receiving keepalive packet"},
+		{"%s - Handshake did not complete after %d attempts, giving up", []any{peer, 20}, peer, "Handshake did not complete after %d attempts, giving up"},
+		{"Interface state was %s, requested %s, now %s", []any{"Down", "Up", "Up"}, nil, "Interface state was %s, requested %s, now %s"},
+		{"%v - peer-less", []any{"x"}, nil, "%v - peer-less"},
+	} {
+		p, format, _ := messagePeer(test.format, test.args)
+		if p != test.peer || format != test.want {
+			t.Errorf("%q: peer %v, format %q; want %v, %q", test.format, p, format, test.peer, test.want)
+		}
+	}
+
+	for _, name := range []string{"error", "warn", "info", "debug", "trace"} {
+		level, err := ParseLogLevel(name)
+		if err != nil || logLevelName(level) != name {
+			t.Errorf("level %s parsed as %v, %v", name, level, err)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("unknown level parsed")
+	}
+}
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewTextLogger(LogLevelVerbose, "(wg0) ", &buf)
+	device := new(Device)
+	device.initLoggers(logger)
+	peer := new(Peer)
+	peer.handshake.remoteStatic[0] = 1
+	device.logs.timers.Tracef("%v - Sending keepalive packet", peer)
+	device.logs.tun.Verbosef("Interface up requested")
+	logger.Verbosef("Device started")
+	logger.Errorf("Failed to read packet from TUN device: %v", "EOF")
+
+	want := []string{
+		`TRACE: \(wg0\) \d{4}/\d\d/\d\d \d\d:\d\d:\d\d\.\d{6} ` + regexp.QuoteMeta(peer.String()) + ` - Sending keepalive packet`,
+		`DEBUG: \(wg0\) [0-9/]+ [0-9:.]+ Interface up requested`,
+		`INFO: \(wg0\) [0-9/]+ [0-9:.]+ Device started`,
+		`ERROR: \(wg0\) [0-9/]+ [0-9:.]+ Failed to read packet from TUN device: EOF`,
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("logged %d lines, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, pattern := range want {
+		if !regexp.MustCompile("^" + pattern + "$").MatchString(lines[i]) {
+			t.Errorf("line %q does not match %q", lines[i], pattern)
+		}
+	}
+
+	buf.Reset()
+	logger = NewTextLogger(LogLevelError, "", &buf)
+	logger.Verbosef("Interface up requested")
+	if buf.Len() != 0 {
+		t.Errorf("verbose line logged at error level: %s", buf.String())
+	}
+}
+
+// TestCustomLogger checks that a Logger not from NewSlogLogger gets the messages
+// of a device as they were logged.
+func TestCustomLogger(t *testing.T) {
+	type call struct {
+		errorf bool
+		format string
+		args   []any
+	}
+	var calls []call
+	device := new(Device)
+	device.initLoggers(&Logger{
+		Verbosef: func(format string, args ...any) { calls = append(calls, call{false, format, args}) },
+		Errorf:   func(format string, args ...any) { calls = append(calls, call{true, format, args}) },
+	})
+	peer := new(Peer)
+	device.logs.timers.Tracef("%v - Sending keepalive packet", peer)
+	device.logs.bind.Warnf("Failed to receive %s packet: %v", "v4", "EOF")
+	device.logs.uapi.Errorf("UAPI: bad value %d", 42)
+
+	want := []call{
+		{false, "%v - Sending keepalive packet", []any{peer}},
+		{false, "Failed to receive %s packet: %v", []any{"v4", "EOF"}},
+		{true, "UAPI: bad value %d", []any{42}},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("Logger called with %v, want %v", calls, want)
+	}
+}
+
+func TestDeviceLogLevels(t *testing.T) {
+	var logs lockedBuffer
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewTextLogger(LogLevelVerbose, "", &logs))
+	defer dev.Close()
+	var keys [2]NoisePublicKey
+	var peers [2]*Peer
+	for i := range keys {
+		keys[i][0] = byte(i + 1)
+		if err := dev.IpcSet(fmt.Sprintf("public_key=%x\n", keys[i][:])); err != nil {
+			t.Fatal(err)
+		}
+		peers[i] = dev.LookupPeer(keys[i])
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("log_level=info\nlog_level=handshake:debug\nlog_level=tun:warn\nlog_peer=%x:trace\n", keys[0][:])); err != nil {
+		t.Fatal(err)
+	}
+	config, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("log_level=info\nlog_level=handshake:debug\nlog_level=tun:warn\nlog_peer=%s:trace\n", base64.StdEncoding.EncodeToString(keys[0][:]))
+	if !strings.Contains(config, want) {
+		t.Errorf("get lacks %q:\n%s", want, config)
+	}
+
+	for _, m := range []struct {
+		logf   func(string, ...any)
+		format string
+		args   []any
+		logged bool
+	}{
+		{dev.logs.timers.Tracef, "%v - Sending keepalive packet", []any{peers[0]}, true},
+		{dev.logs.timers.Tracef, "%v - Sending keepalive packet", []any{peers[1]}, false},
+		{dev.logs.handshake.Verbosef, "%v - Sending handshake initiation", []any{peers[1]}, true},
+		{dev.logs.routine.Verbosef, "Routine: TUN reader - started", nil, false},
+		{dev.logs.bind.Infof, "UDP bind has been updated", nil, true},
+		{dev.logs.tun.Verbosef, "Interface up requested", nil, false},
+	} {
+		before := len(logs.String())
+		m.logf(m.format, m.args...)
+		if logged := len(logs.String()) > before; logged != m.logged {
+			t.Errorf("%q with %v: logged %v, want %v", m.format, m.args, logged, m.logged)
+		}
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("log_level=handshake:\nlog_peer=%x:\n", keys[0][:])); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := dev.IpcGet(); !strings.Contains(config, "log_level=info\nlog_level=tun:warn\n") || strings.Contains(config, "log_peer=") {
+		t.Errorf("levels not removed:\n%s", config)
+	}
+	for _, value := range []string{"log_level=verbose", "log_level=nosuch:debug", "log_peer=nokey:debug", fmt.Sprintf("log_peer=%x", keys[0][:])} {
+		if err := dev.IpcSet(value + "\n"); err == nil {
+			t.Errorf("%s accepted", value)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogRingSize is the number of log records a device keeps in memory.
+const LogRingSize = 1024
+
+// LogRecord is a message logged by a device, as kept in its log ring.
+type LogRecord struct {
+	Time      time.Time
+	Level     slog.Level
+	Subsystem string         // one of LogSubsystems
+	Peer      NoisePublicKey // zero unless the message is about a peer
+	Message   string         // on a single line
+	Lost      uint64         // records dropped just before this one, for followers
+}
+
+// A ringRecord is a record in the log ring, whose message is only formatted
+// when it is read.
+type ringRecord struct {
+	LogRecord
+	format string // with args, the message until it is formatted
+	args   []any
+}
+
+func (r *ringRecord) record() LogRecord {
+	if r.args != nil || r.format != "" {
+		r.Message = strings.ReplaceAll(fmt.Sprintf(r.format, r.args...), "\n", " ")
+		r.format, r.args = "", nil
+	}
+	return r.LogRecord
+}
+
+type logFollower struct {
+	c    chan LogRecord
+	lost uint64
+}
+
+// logRing keeps the last LogRingSize records at slog.LevelDebug and above,
+// whatever the log levels of the device and whatever its Logger records, and
+// hands new ones to followers without ever blocking the device. Messages are
+// only formatted when read or followed, so that keeping them costs little.
+type logRing struct {
+	sync.Mutex
+	records   []ringRecord // allocated on the first record
+	next      int
+	full      bool
+	followers map[*logFollower]struct{}
+	closed    bool
+}
+
+func (ring *logRing) add(level slog.Level, subsystem, format string, args []any) {
+	peer, format, args := messagePeer(format, args)
+	record := ringRecord{
+		LogRecord: LogRecord{Time: time.Now(), Level: level, Subsystem: subsystem},
+		format:    format,
+		args:      args,
+	}
+	if peer != nil {
+		record.Peer = peer.handshake.remoteStatic
+	}
+
+	ring.Lock()
+	defer ring.Unlock()
+	if ring.records == nil {
+		ring.records = make([]ringRecord, LogRingSize)
+	}
+	slot := &ring.records[ring.next]
+	*slot = record
+	ring.next = (ring.next + 1) % len(ring.records)
+	ring.full = ring.full || ring.next == 0
+	if len(ring.followers) == 0 {
+		return
+	}
+	formatted := slot.record()
+	for f := range ring.followers {
+		r := formatted
+		r.Lost = f.lost
+		select {
+		case f.c <- r:
+			f.lost = 0
+		default:
+			f.lost++
+		}
+	}
+}
+
+// snapshot returns the records logged at or after since, oldest first. The
+// ring must be locked.
+func (ring *logRing) snapshot(since time.Time) []LogRecord {
+	start, count := 0, ring.next
+	if ring.full {
+		start, count = ring.next, len(ring.records)
+	}
+	var records []LogRecord
+	for i := range count {
+		record := &ring.records[(start+i)%len(ring.records)]
+		if records == nil && record.Time.Before(since) {
+			continue
+		}
+		records = append(records, record.record())
+	}
+	return records
+}
+
+// LogRecords returns the records in the log ring of the device logged at or
+// after since, oldest first.
+func (device *Device) LogRecords(since time.Time) []LogRecord {
+	ring := &device.logRing
+	ring.Lock()
+	defer ring.Unlock()
+	return ring.snapshot(since)
+}
+
+// FollowLog returns the records in the log ring logged at or after since, a
+// channel receiving those logged later, buffered to hold size records, and a
+// function ending the subscription. No record is missed or repeated between
+// the two. The channel is closed when the subscription ends or the device
+// closes. A follower that falls behind loses records, and the next record it
+// receives tells how many in its Lost field.
+func (device *Device) FollowLog(since time.Time, size int) ([]LogRecord, <-chan LogRecord, func()) {
+	f := &logFollower{c: make(chan LogRecord, max(size, 1))}
+	ring := &device.logRing
+	ring.Lock()
+	defer ring.Unlock()
+	records := ring.snapshot(since)
+	if ring.closed {
+		close(f.c)
+		return records, f.c, func() {}
+	}
+	if ring.followers == nil {
+		ring.followers = make(map[*logFollower]struct{})
+	}
+	ring.followers[f] = struct{}{}
+	return records, f.c, func() {
+		ring.Lock()
+		defer ring.Unlock()
+		if _, ok := ring.followers[f]; ok {
+			delete(ring.followers, f)
+			close(f.c)
+		}
+	}
+}
+
+// closeLogRing ends all subscriptions to the log ring, for when the device
+// closes. The records stay readable.
+func (device *Device) closeLogRing() {
+	ring := &device.logRing
+	ring.Lock()
+	defer ring.Unlock()
+	for f := range ring.followers {
+		close(f.c)
+	}
+	ring.followers = nil
+	ring.closed = true
+}
+
+// writeLogRecord writes record as key=value lines ended by an empty line, the
+// record format of the UAPI log operation.
+func writeLogRecord(w io.Writer, record LogRecord) error {
+	var b []byte
+	add := func(key, value string) {
+		b = append(b, key...)
+		b = append(b, '=')
+		b = append(b, value...)
+		b = append(b, '\n')
+	}
+	if record.Lost != 0 {
+		add("lost", strconv.FormatUint(record.Lost, 10))
+	}
+	add("time_sec", strconv.FormatInt(record.Time.Unix(), 10))
+	add("time_nsec", strconv.Itoa(record.Time.Nanosecond()))
+	add("level", logLevelName(record.Level))
+	add("subsystem", record.Subsystem)
+	if !record.Peer.IsZero() {
+		add("public_key", fmt.Sprintf("%x", record.Peer[:]))
+	}
+	add("message", record.Message)
+	b = append(b, '\n')
+	_, err := w.Write(b)
+	return err
+}
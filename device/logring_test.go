@@ -0,0 +1,146 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestLogRing(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	dev.SetLogLevel(slog.LevelError)
+
+	start := time.Now()
+	for i := range LogRingSize + 10 {
+		dev.logs.bind.Infof("UDP bind has been updated %d\nagain", i)
+	}
+	dev.logs.timers.Tracef("Sending keepalive packet")
+	records := dev.LogRecords(time.Time{})
+	if len(records) != LogRingSize {
+		t.Fatalf("ring holds %d records, want %d", len(records), LogRingSize)
+	}
+	// routines starting up may log in between
+	first := -1
+	for _, record := range records {
+		if _, err := fmt.Sscanf(record.Message, "UDP bind has been updated %d again", &first); err == nil {
+			break
+		}
+	}
+	last := records[len(records)-1]
+	if first < 10 || last.Message != fmt.Sprintf("UDP bind has been updated %d again", LogRingSize+9) {
+		t.Errorf("ring holds %d to %q", first, last.Message)
+	}
+	if last.Level != slog.LevelInfo || last.Subsystem != "bind" || last.Time.Before(start) {
+		t.Errorf("record %+v", last)
+	}
+	if records := dev.LogRecords(time.Now()); len(records) != 0 {
+		t.Errorf("%d records after now", len(records))
+	}
+	if records := dev.LogRecords(last.Time); len(records) == 0 || records[len(records)-1] != last {
+		t.Errorf("records since the last one: %v", records)
+	}
+
+	records, c, unsubscribe := dev.FollowLog(time.Now(), 1)
+	if len(records) != 0 {
+		t.Errorf("following from now returned %d records", len(records))
+	}
+	dev.logs.uapi.Errorf("UAPI: one")
+	dev.logs.uapi.Errorf("UAPI: two")
+	dev.logs.uapi.Errorf("UAPI: three")
+	if record := <-c; record.Message != "UAPI: one" || record.Level != slog.LevelError || record.Lost != 0 {
+		t.Errorf("first followed record %+v", record)
+	}
+	dev.logs.uapi.Errorf("UAPI: four")
+	if record := <-c; record.Message != "UAPI: four" || record.Lost != 2 {
+		t.Errorf("record after falling behind %+v", record)
+	}
+	unsubscribe()
+	if _, ok := <-c; ok {
+		t.Error("channel open after unsubscribing")
+	}
+}
+
+func TestIpcLog(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	dev.logs.tun.Infof("Interface state was %s, requested %s, now %s", "Down", "Up", "Up")
+	peer := new(Peer)
+	peer.handshake.remoteStatic[0] = 1
+	dev.logs.handshake.Verbosef("%v - Sending handshake initiation", peer)
+
+	client, server := net.Pipe()
+	go dev.IpcHandle(server)
+	defer client.Close()
+	r := bufio.NewReader(client)
+	readRecord := func() string {
+		var record strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return record.String()
+			}
+			if !strings.HasPrefix(line, "time_") {
+				record.WriteString(line)
+			}
+		}
+	}
+
+	// routines starting up may log too
+	readUntil := func(want string) []string {
+		var records []string
+		for {
+			record := readRecord()
+			if record == want {
+				return records
+			}
+			if !strings.HasPrefix(record, "level=") {
+				t.Fatalf("got %q before %q", record, want)
+			}
+			records = append(records, record)
+		}
+	}
+
+	fmt.Fprintf(client, "log=1\n\n")
+	records := strings.Join(readUntil("errno=0\n"), "\n")
+	want := "level=info\nsubsystem=tun\nmessage=Interface state was Down, requested Up, now Up\n\n" +
+		fmt.Sprintf("level=debug\nsubsystem=handshake\npublic_key=%x\nmessage=Sending handshake initiation\n", peer.handshake.remoteStatic[:])
+	if !strings.Contains(records, want) {
+		t.Errorf("records lack %q:\n%s", want, records)
+	}
+
+	fmt.Fprintf(client, "log=1\nfollow=maybe\n\n")
+	if record := readRecord(); record != "errno=-22\n" {
+		t.Errorf("bad request answered with %q", record)
+	}
+
+	fmt.Fprintf(client, "log=1\nsince_sec=%d\nfollow=true\n\n", time.Now().Add(time.Hour).Unix())
+	// wait for the log to be followed before logging
+	for {
+		dev.logRing.Lock()
+		n := len(dev.logRing.followers)
+		dev.logRing.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	dev.logs.tun.Infof("MTU updated: %v", 1420)
+	readUntil("level=info\nsubsystem=tun\nmessage=MTU updated: 1420\n")
+	go dev.Close()
+	readUntil("errno=0\n")
+}
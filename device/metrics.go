@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"time"
+)
+
+// Metrics is a snapshot of the counters and internals of a device, for export
+// to monitoring systems. Unlike the UAPI, it includes the state of the pools and
+// queues.
+type Metrics struct {
+	Peers []PeerMetrics
+
+	// Pools tells how many items of each pool are in use. Pools shared with
+	// other devices through NewDeviceWithPools count the items of all of them.
+	Pools []PoolMetrics
+	// Queues tells how many elements wait in the encryption, decryption and
+	// handshake queues.
+	Queues []QueueMetrics
+
+	UnderLoad          bool // as reported by IsUnderLoad
+	RatelimiterEntries int  // source addresses tracked by the handshake rate limiter
+}
+
+// PeerMetrics is the part of Metrics for one peer.
+type PeerMetrics struct {
+	PublicKey     NoisePublicKey
+	Endpoint      string // empty if none is known
+	RxBytes       uint64
+	TxBytes       uint64
+	LastHandshake time.Time // zero if none happened
+
+	// DNSHost is the domain name of the endpoint if the DNS monitor follows it,
+	// along with the number of times resolving it failed.
+	DNSHost            string
+	DNSResolveFailures uint64
+}
+
+// PoolMetrics is the usage of one pool of the device.
+type PoolMetrics struct {
+	Name  string // message_buffers, inbound_elements, ...
+	InUse uint32 // items taken and not yet put back
+	Max   uint32 // zero if unlimited
+}
+
+// QueueMetrics is the depth of one queue of the device.
+type QueueMetrics struct {
+	Name     string // encryption, decryption or handshake
+	Depth    int
+	Capacity int
+}
+
+// Metrics returns a snapshot of the counters and internals of the device.
+func (device *Device) Metrics() Metrics {
+	var m Metrics
+
+	var monitoredPeers map[NoisePublicKey]*MonitoredPeerInfo
+	if device.dnsMonitor != nil {
+		monitoredPeers = device.dnsMonitor.GetMonitoredPeers()
+	}
+
+	device.peers.RLock()
+	m.Peers = make([]PeerMetrics, 0, len(device.peers.keyMap))
+	for key, peer := range device.peers.keyMap {
+		pm := PeerMetrics{
+			PublicKey: key,
+			RxBytes:   peer.rxBytes.Load(),
+			TxBytes:   peer.txBytes.Load(),
+		}
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			pm.Endpoint = peer.endpoint.val.DstToString()
+		}
+		peer.endpoint.Unlock()
+		if nano := peer.lastHandshakeNano.Load(); nano != 0 {
+			pm.LastHandshake = time.Unix(0, nano)
+		}
+		if info, ok := monitoredPeers[key]; ok {
+			pm.DNSHost = info.OriginalHost
+			pm.DNSResolveFailures = info.TotalFails
+		}
+		m.Peers = append(m.Peers, pm)
+	}
+	device.peers.RUnlock()
+
+	for _, pool := range []struct {
+		name string
+		pool *WaitPool
+	}{
+		{"message_buffers", device.pool.messageBuffers},
+		{"inbound_elements", device.pool.inboundElements},
+		{"outbound_elements", device.pool.outboundElements},
+		{"inbound_containers", device.pool.inboundElementsContainer},
+		{"outbound_containers", device.pool.outboundElementsContainer},
+	} {
+		m.Pools = append(m.Pools, PoolMetrics{Name: pool.name, InUse: pool.pool.InUse(), Max: pool.pool.max})
+	}
+
+	m.Queues = []QueueMetrics{
+		{"encryption", len(device.queue.encryption.c), cap(device.queue.encryption.c)},
+		{"decryption", len(device.queue.decryption.c), cap(device.queue.decryption.c)},
+		{"handshake", len(device.queue.handshake.c), cap(device.queue.handshake.c)},
+	}
+
+	m.UnderLoad = device.IsUnderLoad()
+	m.RatelimiterEntries = device.rate.limiter.Len()
+	return m
+}
@@ -0,0 +1,58 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+
+	m := pair[0].dev.Metrics()
+	if len(m.Peers) != 1 {
+		t.Fatalf("got %d peers, want 1", len(m.Peers))
+	}
+	peer := m.Peers[0]
+	if peer.PublicKey != pair[1].dev.staticIdentity.publicKey {
+		t.Errorf("peer %v", peer.PublicKey)
+	}
+	if peer.RxBytes == 0 || peer.TxBytes == 0 || peer.LastHandshake.IsZero() || peer.Endpoint == "" {
+		t.Errorf("peer after handshake: %+v", peer)
+	}
+	if len(m.Pools) != 5 {
+		t.Errorf("got %d pools, want 5", len(m.Pools))
+	}
+	for _, pool := range m.Pools {
+		if pool.Max != PreallocatedBuffersPerPool {
+			t.Errorf("pool %s limited to %d, want %d", pool.Name, pool.Max, PreallocatedBuffersPerPool)
+		}
+	}
+	for _, queue := range m.Queues {
+		if queue.Capacity == 0 || queue.Depth > queue.Capacity {
+			t.Errorf("queue %s: %d of %d", queue.Name, queue.Depth, queue.Capacity)
+		}
+	}
+	if m.UnderLoad {
+		t.Error("under load after a single handshake")
+	}
+}
+
+func TestWaitPoolInUse(t *testing.T) {
+	for _, max := range []uint32{0, 2} {
+		p := NewWaitPool(max, func() any { return new(int) })
+		x, y := p.Get(), p.Get()
+		if n := p.InUse(); n != 2 {
+			t.Errorf("max %d: %d in use, want 2", max, n)
+		}
+		p.Put(x)
+		p.Put(y)
+		if n := p.InUse(); n != 0 {
+			t.Errorf("max %d: %d in use after putting back, want 0", max, n)
+		}
+	}
+}
@@ -268,6 +268,16 @@ func init() {
 }
 
 func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, error) {
+	ephemeral, err := newPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return device.createMessageInitiation(peer, ephemeral, tai64n.Now())
+}
+
+// createMessageInitiation is CreateMessageInitiation with the ephemeral key and
+// timestamp given, for known answer tests.
+func (device *Device) createMessageInitiation(peer *Peer, ephemeral NoisePrivateKey, timestamp tai64n.Timestamp) (*MessageInitiation, error) {
 	device.staticIdentity.RLock()
 	defer device.staticIdentity.RUnlock()
 
@@ -279,10 +289,7 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 	var err error
 	handshake.hash = InitialHash
 	handshake.chainKey = InitialChainKey
-	handshake.localEphemeral, err = newPrivateKey()
-	if err != nil {
-		return nil, err
-	}
+	handshake.localEphemeral = ephemeral
 
 	handshake.mixHash(handshake.remoteStatic[:])
 
@@ -320,7 +327,6 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 		handshake.chainKey[:],
 		handshake.precomputedStaticStatic[:],
 	)
-	timestamp := tai64n.Now()
 	aead, _ = chacha20poly1305.New(key[:])
 	aead.Seal(msg.Timestamp[:0], ZeroNonce[:], timestamp[:], handshake.hash[:])
 
@@ -408,11 +414,11 @@ func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
 	flood := time.Since(handshake.lastInitiationConsumption) <= HandshakeInitationRate
 	handshake.mutex.RUnlock()
 	if replay {
-		device.log.Verbosef("%v - ConsumeMessageInitiation: handshake replay @ %v", peer, timestamp)
+		device.logs.handshake.Verbosef("%v - ConsumeMessageInitiation: handshake replay @ %v", peer, timestamp)
 		return nil
 	}
 	if flood {
-		device.log.Verbosef("%v - ConsumeMessageInitiation: handshake flood", peer)
+		device.logs.handshake.Warnf("%v - ConsumeMessageInitiation: handshake flood", peer)
 		return nil
 	}
 
@@ -442,6 +448,16 @@ func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
 }
 
 func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error) {
+	ephemeral, err := newPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return device.createMessageResponse(peer, ephemeral)
+}
+
+// createMessageResponse is CreateMessageResponse with the ephemeral key given,
+// for known answer tests.
+func (device *Device) createMessageResponse(peer *Peer, ephemeral NoisePrivateKey) (*MessageResponse, error) {
 	handshake := &peer.handshake
 	handshake.mutex.Lock()
 	defer handshake.mutex.Unlock()
@@ -466,10 +482,7 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 
 	// create ephemeral key
 
-	handshake.localEphemeral, err = newPrivateKey()
-	if err != nil {
-		return nil, err
-	}
+	handshake.localEphemeral = ephemeral
 	msg.Ephemeral = handshake.localEphemeral.publicKey()
 	handshake.mixHash(msg.Ephemeral[:])
 	handshake.mixKey(msg.Ephemeral[:])
@@ -612,6 +625,18 @@ func (device *Device) ConsumeMessageResponse(msg *MessageResponse) *Peer {
 func (peer *Peer) BeginSymmetricSession() error {
 	device := peer.device
 	handshake := &peer.handshake
+
+	// the key log is written once the locks below are released
+	keyLog := device.keyLog.Load()
+	var logged *keyLogEntry
+	if keyLog != nil {
+		defer func() {
+			if logged != nil {
+				device.writeKeyLog(keyLog, logged)
+			}
+		}()
+	}
+
 	handshake.mutex.Lock()
 	defer handshake.mutex.Unlock()
 
@@ -641,6 +666,14 @@ func (peer *Peer) BeginSymmetricSession() error {
 		return fmt.Errorf("invalid state for keypair derivation: %v", handshake.state)
 	}
 
+	if keyLog != nil {
+		logged = &keyLogEntry{
+			remoteStatic: handshake.remoteStatic,
+			ephemeral:    handshake.localEphemeral,
+			presharedKey: handshake.presharedKey,
+		}
+	}
+
 	// zero handshake
 
 	setZero(handshake.chainKey[:])
@@ -653,6 +686,10 @@ func (peer *Peer) BeginSymmetricSession() error {
 	keypair := new(Keypair)
 	keypair.send, _ = chacha20poly1305.New(sendKey[:])
 	keypair.receive, _ = chacha20poly1305.New(recvKey[:])
+	if device.keepKeys.Load() {
+		keypair.sendKey = sendKey
+		keypair.receiveKey = recvKey
+	}
 
 	setZero(sendKey[:])
 	setZero(recvKey[:])
@@ -688,6 +725,9 @@ func (peer *Peer) BeginSymmetricSession() error {
 		}
 		device.DeleteKeypair(previous)
 		keypairs.current = keypair
+		if current != nil {
+			peer.emit(Event{Type: EventKeypairRotated})
+		}
 	} else {
 		keypairs.next.Store(keypair)
 		device.DeleteKeypair(next)
@@ -712,7 +752,11 @@ func (peer *Peer) ReceivedWithKeypair(receivedKeypair *Keypair) bool {
 	old := keypairs.previous
 	keypairs.previous = keypairs.current
 	peer.device.DeleteKeypair(old)
+	rotated := keypairs.current != nil
 	keypairs.current = keypairs.next.Load()
 	keypairs.next.Store(nil)
+	if rotated {
+		peer.emit(Event{Type: EventKeypairRotated})
+	}
 	return true
 }
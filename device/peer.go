@@ -25,6 +25,39 @@ type Peer struct {
 	rxBytes           atomic.Uint64  // bytes received from peer
 	lastHandshakeNano atomic.Int64   // nano seconds since epoch
 
+	// stats are counters beyond the byte counts, reported by UAPI get.
+	stats struct {
+		txPackets              atomic.Uint64
+		rxPackets              atomic.Uint64
+		lastRxNano             atomic.Int64  // when an authenticated packet last arrived
+		rxDropReplay           atomic.Uint64 // rejected by the replay filter
+		rxDropAllowedIPs       atomic.Uint64 // source address outside the allowed IPs
+		rxDropDecrypt          atomic.Uint64 // failed authentication
+		rxDropMalformed        atomic.Uint64 // invalid IP version or length
+		txDropStagedOverflow   atomic.Uint64 // evicted from the full staged queue
+		txDropNotRunning       atomic.Uint64 // routed to the peer while stopped
+		txDropRateLimit        atomic.Uint64 // beyond the backlog of the send rate limit
+		rxDropRateLimit        atomic.Uint64 // beyond the backlog of the receive rate limit
+		txDropQuota            atomic.Uint64 // sent while suspended by a quota
+		rxDropQuota            atomic.Uint64 // received while suspended by a quota
+		txDropFirewall         atomic.Uint64 // rejected by the firewall on the way to the peer
+		rxDropFirewall         atomic.Uint64 // rejected by the firewall on the way from the peer
+		handshakeInitiations   atomic.Uint64 // initiations sent, retries included
+		handshakeRetries       atomic.Uint64
+		handshakeResponsesRecv atomic.Uint64
+	}
+
+	// shaping limits the bandwidth of the peer, set by tx_rate_limit and
+	// rx_rate_limit.
+	shaping struct {
+		tx tokenBucket
+		rx tokenBucket
+	}
+
+	// quota suspends the peer when it used up its daily_quota or
+	// monthly_quota.
+	quota peerQuota
+
 	endpoint struct {
 		sync.Mutex
 		val            conn.Endpoint
@@ -56,6 +89,19 @@ type Peer struct {
 	cookieGenerator             CookieGenerator
 	trieEntries                 list.List
 	persistentKeepaliveInterval atomic.Uint32
+
+	changes atomic.Uint64 // configuration changes through the UAPI
+
+	// generation is the generation in which a change to the peer was last
+	// seen, and fingerprint what the peer was then; counters and
+	// countersFingerprint are the same for its counters. See
+	// Device.changedSince.
+	generation struct {
+		value               uint64
+		fingerprint         peerFingerprint
+		counters            uint64
+		countersFingerprint peerCounters
+	}
 }
 
 func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
@@ -109,6 +155,7 @@ func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
 
 	// add
 	device.peers.keyMap[pk] = peer
+	device.emit(Event{Type: EventPeerCreated, Peer: pk})
 
 	return peer, nil
 }
@@ -140,6 +187,12 @@ func (peer *Peer) SendBuffers(buffers [][]byte) error {
 			totalLen += uint64(len(b))
 		}
 		peer.txBytes.Add(totalLen)
+		peer.stats.txPackets.Add(uint64(len(buffers)))
+		if c := peer.device.capture.Load(); c != nil {
+			for _, b := range buffers {
+				peer.device.captureOuter(c, peer, b, endpoint, false)
+			}
+		}
 	}
 	return err
 }
@@ -185,7 +238,7 @@ func (peer *Peer) Start() {
 	}
 
 	device := peer.device
-	device.log.Verbosef("%v - Starting", peer)
+	device.logs.device.Verbosef("%v - Starting", peer)
 
 	// reset routine state
 	peer.stopping.Wait()
@@ -257,6 +310,13 @@ func (peer *Peer) ExpireCurrentKeypairs() {
 }
 
 func (peer *Peer) Stop() {
+	peer.stop(true)
+}
+
+// stop stops the peer's routines and, if zero is set, clears its key material.
+// A peer stopped with its keypairs intact sends and receives nothing, so that
+// another process can take over its sessions.
+func (peer *Peer) stop(zero bool) {
 	peer.state.Lock()
 	defer peer.state.Unlock()
 
@@ -264,7 +324,7 @@ func (peer *Peer) Stop() {
 		return
 	}
 
-	peer.device.log.Verbosef("%v - Stopping", peer)
+	peer.device.logs.device.Verbosef("%v - Stopping", peer)
 
 	peer.timersStop()
 	// Signal that RoutineSequentialSender and RoutineSequentialReceiver should exit.
@@ -273,17 +333,31 @@ func (peer *Peer) Stop() {
 	peer.stopping.Wait()
 	peer.device.queue.encryption.wg.Done() // no more writes to encryption queue from us
 
-	peer.ZeroAndFlushAll()
+	if zero {
+		peer.ZeroAndFlushAll()
+	}
 }
 
 func (peer *Peer) SetEndpointFromPacket(endpoint conn.Endpoint) {
 	peer.endpoint.Lock()
-	defer peer.endpoint.Unlock()
 	if peer.endpoint.disableRoaming {
+		peer.endpoint.Unlock()
 		return
 	}
 	peer.endpoint.clearSrcOnTx = false
+	changed := peer.device.hasEventSubscribers() && (peer.endpoint.val == nil || peer.endpoint.val.DstToString() != endpoint.DstToString())
 	peer.endpoint.val = endpoint
+	peer.endpoint.Unlock()
+
+	if changed {
+		peer.emit(Event{Type: EventEndpointChanged, Endpoint: endpoint.DstToString(), Reason: "roaming"})
+	}
+}
+
+// markReceived counts n authenticated packets as just received.
+func (peer *Peer) markReceived(n int) {
+	peer.stats.rxPackets.Add(uint64(n))
+	peer.stats.lastRxNano.Store(time.Now().UnixNano())
 }
 
 func (peer *Peer) markEndpointSrcForClearing() {
@@ -7,6 +7,7 @@ package device
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type WaitPool struct {
@@ -15,6 +16,7 @@ type WaitPool struct {
 	lock  sync.Mutex
 	count uint32 // Get calls not yet Put back
 	max   uint32
+	inUse atomic.Uint32 // like count, but also tracked without max
 }
 
 func NewWaitPool(max uint32, new func() any) *WaitPool {
@@ -24,6 +26,7 @@ func NewWaitPool(max uint32, new func() any) *WaitPool {
 }
 
 func (p *WaitPool) Get() any {
+	p.inUse.Add(1)
 	if p.max != 0 {
 		p.lock.Lock()
 		for p.count >= p.max {
@@ -37,6 +40,7 @@ func (p *WaitPool) Get() any {
 
 func (p *WaitPool) Put(x any) {
 	p.pool.Put(x)
+	p.inUse.Add(^uint32(0))
 	if p.max == 0 {
 		return
 	}
@@ -46,6 +50,37 @@ func (p *WaitPool) Put(x any) {
 	p.cond.Signal()
 }
 
+// InUse returns the number of items taken from the pool and not yet put back.
+func (p *WaitPool) InUse() uint32 {
+	return p.inUse.Load()
+}
+
+// Pools holds the packet buffer and queue element pools that do not depend on
+// a device's batch size, so that several devices in one process can share them.
+// Buffers released by an idle device then serve busy ones instead of each device
+// keeping its own. On platforms with PreallocatedBuffersPerPool set, the limit
+// applies to all devices sharing the pools together. Only buffers are shared:
+// each device still starts its own encryption, decryption and handshake workers.
+type Pools struct {
+	messageBuffers   *WaitPool
+	inboundElements  *WaitPool
+	outboundElements *WaitPool
+}
+
+func NewPools() *Pools {
+	return &Pools{
+		messageBuffers: NewWaitPool(PreallocatedBuffersPerPool, func() any {
+			return new([MaxMessageSize]byte)
+		}),
+		inboundElements: NewWaitPool(PreallocatedBuffersPerPool, func() any {
+			return new(QueueInboundElement)
+		}),
+		outboundElements: NewWaitPool(PreallocatedBuffersPerPool, func() any {
+			return new(QueueOutboundElement)
+		}),
+	}
+}
+
 func (device *Device) PopulatePools() {
 	device.pool.inboundElementsContainer = NewWaitPool(PreallocatedBuffersPerPool, func() any {
 		s := make([]*QueueInboundElement, 0, device.BatchSize())
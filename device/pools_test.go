@@ -12,6 +12,9 @@ import (
 	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
 )
 
 func TestWaitPool(t *testing.T) {
@@ -64,7 +67,7 @@ func TestWaitPool(t *testing.T) {
 	}
 	wg.Wait()
 	if max.Load() != p.max {
-		t.Errorf("Actual maximum count (%d) != ideal maximum count (%d)", max, p.max)
+		t.Errorf("Actual maximum count (%d) != ideal maximum count (%d)", max.Load(), p.max)
 	}
 }
 
@@ -139,3 +142,19 @@ func BenchmarkSyncPool(b *testing.B) {
 	}
 	wg.Wait()
 }
+
+func TestSharedPools(t *testing.T) {
+	pools := NewPools()
+	var devs [2]*Device
+	for i := range devs {
+		devs[i] = NewDeviceWithPools(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[i], NewLogger(LogLevelError, ""), pools)
+		defer devs[i].Close()
+	}
+
+	if devs[0].pool.messageBuffers != devs[1].pool.messageBuffers || devs[0].pool.inboundElements != devs[1].pool.inboundElements {
+		t.Error("devices do not share pools")
+	}
+	if devs[0].pool.inboundElementsContainer == devs[1].pool.inboundElementsContainer {
+		t.Error("batch size dependent pools must not be shared")
+	}
+}
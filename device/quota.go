@@ -0,0 +1,208 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QuotaUsage is the traffic of a peer in its current quota periods, which are
+// the calendar day and month in local time. It is part of PeerState, so that a
+// restart does not reset the balance.
+type QuotaUsage struct {
+	Day     string `json:"day"`     // 2006-01-02
+	Daily   uint64 `json:"daily"`   // bytes sent and received that day
+	Month   string `json:"month"`   // 2006-01
+	Monthly uint64 `json:"monthly"` // bytes sent and received that month
+}
+
+// peerQuota limits the traffic of a peer per day and month, counting the bytes
+// of txBytes and rxBytes together. A peer that used up a quota is suspended: its
+// data is dropped in both directions, while handshakes and keepalives go on, so
+// that it resumes at once when the next period begins or the quota is raised.
+type peerQuota struct {
+	enabled   atomic.Bool // some quota is set
+	suspended atomic.Bool
+	mu        sync.Mutex
+	daily     uint64 // bytes, 0 if unlimited
+	monthly   uint64
+	day       int // current periods, as yyyymmdd and yyyymm
+	month     int
+	dayBase   uint64 // traffic counter when the periods began
+	monthBase uint64
+}
+
+func quotaPeriods(now time.Time) (day, month int) {
+	y, m, d := now.Date()
+	return y*10000 + int(m)*100 + d, y*100 + int(m)
+}
+
+func (peer *Peer) trafficCounter() uint64 {
+	return peer.txBytes.Load() + peer.rxBytes.Load()
+}
+
+// RefuseQuotas makes UAPI set fail with reason to set quotas, or take them
+// again if reason is empty. A daemon that does not save PeerState refuses them,
+// as a restart would reset their usage.
+func (device *Device) RefuseQuotas(reason string) {
+	if reason == "" {
+		device.quotasRefused.Store(nil)
+		return
+	}
+	device.quotasRefused.Store(&reason)
+}
+
+// setQuota sets the daily or monthly quota to bytes, 0 removing it.
+func (peer *Peer) setQuota(monthly bool, bytes uint64) {
+	q := &peer.quota
+	q.mu.Lock()
+	if monthly {
+		q.monthly = bytes
+	} else {
+		q.daily = bytes
+	}
+	q.enabled.Store(q.daily != 0 || q.monthly != 0)
+	q.mu.Unlock()
+	peer.checkQuota()
+}
+
+// quotas returns the daily and monthly quota in bytes.
+func (peer *Peer) quotas() (daily, monthly uint64) {
+	peer.quota.mu.Lock()
+	defer peer.quota.mu.Unlock()
+	return peer.quota.daily, peer.quota.monthly
+}
+
+// quotaExceeded reports whether the peer is suspended for having used up a
+// quota. It is called for every batch of data, so it is cheap without quotas.
+func (peer *Peer) quotaExceeded() bool {
+	if !peer.quota.enabled.Load() {
+		return false
+	}
+	return peer.checkQuota()
+}
+
+// rollQuotaLocked starts new periods if the day or month changed, and returns
+// the usage of the current ones.
+func (peer *Peer) rollQuotaLocked(now time.Time) (daily, monthly uint64) {
+	q := &peer.quota
+	total := peer.trafficCounter()
+	day, month := quotaPeriods(now)
+	if q.day != day {
+		q.day, q.dayBase = day, total
+	}
+	if q.month != month {
+		q.month, q.monthBase = month, total
+	}
+	// the counters only grow, so differences are correct even across a wrap
+	return total - q.dayBase, total - q.monthBase
+}
+
+// checkQuota updates whether the peer is suspended, announcing changes, and
+// returns it.
+func (peer *Peer) checkQuota() bool {
+	q := &peer.quota
+	q.mu.Lock()
+	daily, monthly := peer.rollQuotaLocked(time.Now())
+	var reason string
+	switch {
+	case q.daily != 0 && daily >= q.daily:
+		reason = "daily_quota"
+	case q.monthly != 0 && monthly >= q.monthly:
+		reason = "monthly_quota"
+	}
+	suspend := reason != ""
+	changed := q.suspended.Swap(suspend) != suspend
+	q.mu.Unlock()
+
+	switch {
+	case changed && suspend:
+		peer.device.logs.device.Infof("%v - Suspended: %s used up", peer, reason)
+		peer.emit(Event{Type: EventPeerSuspended, Reason: reason})
+	case changed:
+		peer.device.logs.device.Infof("%v - Resumed: quota available again", peer)
+		peer.emit(Event{Type: EventPeerResumed})
+	}
+	return suspend
+}
+
+// quotaUsage returns the usage of the current periods, or nil without quotas.
+func (peer *Peer) quotaUsage() *QuotaUsage {
+	q := &peer.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.enabled.Load() {
+		return nil
+	}
+	daily, monthly := peer.rollQuotaLocked(time.Now())
+	return &QuotaUsage{
+		Day:     fmt.Sprintf("%d-%02d-%02d", q.day/10000, q.day/100%100, q.day%100),
+		Daily:   daily,
+		Month:   fmt.Sprintf("%d-%02d", q.month/100, q.month%100),
+		Monthly: monthly,
+	}
+}
+
+// restoreTraffic adds saved byte counts to the counters of the peer, without
+// counting them against the current quota periods.
+func (peer *Peer) restoreTraffic(rxBytes, txBytes uint64) {
+	q := &peer.quota
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	peer.rxBytes.Add(rxBytes)
+	peer.txBytes.Add(txBytes)
+	q.dayBase += rxBytes + txBytes
+	q.monthBase += rxBytes + txBytes
+}
+
+// restoreQuotaUsage adds saved usage to the periods it belongs to, if they are
+// still current.
+func (peer *Peer) restoreQuotaUsage(saved *QuotaUsage) {
+	current := peer.quotaUsage()
+	if current == nil {
+		return
+	}
+	q := &peer.quota
+	q.mu.Lock()
+	if saved.Day == current.Day {
+		q.dayBase -= saved.Daily
+	}
+	if saved.Month == current.Month {
+		q.monthBase -= saved.Monthly
+	}
+	q.mu.Unlock()
+	peer.checkQuota()
+}
+
+// writeQuota writes the quotas of the peer and their remaining balance for the
+// UAPI get operation.
+func (peer *Peer) writeQuota(sendf func(format string, args ...any)) {
+	usage := peer.quotaUsage()
+	if usage == nil {
+		return
+	}
+	daily, monthly := peer.quotas()
+	remaining := func(quota, used uint64) uint64 {
+		if used >= quota {
+			return 0
+		}
+		return quota - used
+	}
+	if daily != 0 {
+		sendf("daily_quota=%d", daily)
+		sendf("daily_quota_remaining=%d", remaining(daily, usage.Daily))
+	}
+	if monthly != 0 {
+		sendf("monthly_quota=%d", monthly)
+		sendf("monthly_quota_remaining=%d", remaining(monthly, usage.Monthly))
+	}
+	if peer.quotaExceeded() {
+		sendf("quota_suspended=true")
+	}
+}
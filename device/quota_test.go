@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestQuota(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	events, cancel := pair[1].dev.SubscribeEvents(16)
+	defer cancel()
+	waitEvent := func(typ EventType) Event {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case event := <-events:
+				if event.Type == typ {
+					return event
+				}
+			case <-timeout:
+				t.Fatalf("no %v event", typ)
+			}
+		}
+	}
+
+	// usage counts from when the quota is set, and a ping uses up a single byte
+	receiver := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ndaily_quota=1\nmonthly_quota=1000000000\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	config, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"\ndaily_quota=1\ndaily_quota_remaining=0\n", "\nmonthly_quota=1000000000\n", "\nquota_suspended=true\n"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("get lacks %q:\n%s", want, config)
+		}
+	}
+	if event := waitEvent(EventPeerSuspended); event.Reason != "daily_quota" {
+		t.Errorf("suspended for %q, want daily_quota", event.Reason)
+	}
+
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+	deadline := time.Now().Add(5 * time.Second)
+	for peerStat(t, pair[1].dev, "tx_drop_quota") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no packets dropped while suspended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ndaily_quota=0\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	waitEvent(EventPeerResumed)
+	pair.Send(t, Ping, nil)
+}
+
+func TestQuotaState(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+
+	receiver := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\nmonthly_quota=1000000000\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	states := pair[1].dev.State()
+	if len(states) != 1 || states[0].Quota == nil {
+		t.Fatalf("state without quota usage: %+v", states)
+	}
+	usage := *states[0].Quota
+	if usage.Day != time.Now().Format("2006-01-02") || usage.Month != time.Now().Format("2006-01") {
+		t.Errorf("usage of the wrong periods: %+v", usage)
+	}
+
+	// as after a restart: usage carries over, but not from a past month
+	pair[1].dev.RemoveAllPeers()
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\nmonthly_quota=1000000000\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	peer := pair[1].dev.LookupPeer(receiver)
+	stale := PeerState{PublicKey: states[0].PublicKey, Quota: &QuotaUsage{Day: "2000-01-01", Daily: 5, Month: "2000-01", Monthly: 5}}
+	pair[1].dev.RestoreState([]PeerState{states[0], stale})
+	restored := peer.quotaUsage()
+	if restored.Monthly != usage.Monthly {
+		t.Errorf("restored %d bytes of monthly usage, want %d", restored.Monthly, usage.Monthly)
+	}
+	if restored.Daily != usage.Daily {
+		t.Errorf("restored %d bytes of daily usage, want %d", restored.Daily, usage.Daily)
+	}
+}
+
+func TestRefuseQuotas(t *testing.T) {
+	pair := genTestPair(t, false)
+	receiver := pair[0].dev.staticIdentity.publicKey
+	pair[1].dev.RefuseQuotas("no state")
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ndaily_quota=1000\n", receiver[:])); err == nil || !strings.Contains(err.Error(), "no state") {
+		t.Errorf("quota set with quotas refused: %v", err)
+	}
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ndaily_quota=0\ntx_rate_limit=1000\n", receiver[:])); err != nil {
+		t.Errorf("removing quotas and limiting the rate refused: %v", err)
+	}
+	pair[1].dev.RefuseQuotas("")
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\nmonthly_quota=1000\n", receiver[:])); err != nil {
+		t.Errorf("quota refused once taken again: %v", err)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
@@ -72,13 +73,14 @@ func (peer *Peer) keepKeyFreshReceiving() {
 func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.ReceiveFunc) {
 	recvName := recv.PrettyName()
 	defer func() {
-		device.log.Verbosef("Routine: receive incoming %s - stopped", recvName)
+		device.logs.routine.Verbosef("Routine: receive incoming %s - stopped", recvName)
+		device.health.receivers.Add(-1)
 		device.queue.decryption.wg.Done()
 		device.queue.handshake.wg.Done()
 		device.net.stopping.Done()
 	}()
 
-	device.log.Verbosef("Routine: receive incoming %s - started", recvName)
+	device.logs.routine.Verbosef("Routine: receive incoming %s - started", recvName)
 
 	// receive datagrams until conn is closed
 
@@ -112,7 +114,7 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			device.log.Verbosef("Failed to receive %s packet: %v", recvName, err)
+			device.logs.bind.Warnf("Failed to receive %s packet: %v", recvName, err)
 			if neterr, ok := err.(net.Error); ok && !neterr.Temporary() {
 				return
 			}
@@ -167,6 +169,9 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 
 				// create work element
 				peer := value.peer
+				if c := device.capture.Load(); c != nil {
+					device.captureOuter(c, peer, packet, endpoints[i], true)
+				}
 				elem := device.GetInboundElement()
 				elem.packet = packet
 				elem.buffer = bufsArrs[i]
@@ -203,10 +208,14 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 				}
 
 			default:
-				device.log.Verbosef("Received message with unknown type")
+				device.logs.data.Tracef("Received message with unknown type")
 				continue
 			}
 
+			if c := device.capture.Load(); c != nil {
+				device.captureOuter(c, nil, packet, endpoints[i], true)
+			}
+
 			select {
 			case device.queue.handshake.c <- QueueHandshakeElement{
 				msgType:  msgType,
@@ -238,8 +247,8 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 func (device *Device) RoutineDecryption(id int) {
 	var nonce [chacha20poly1305.NonceSize]byte
 
-	defer device.log.Verbosef("Routine: decryption worker %d - stopped", id)
-	device.log.Verbosef("Routine: decryption worker %d - started", id)
+	defer device.logs.routine.Verbosef("Routine: decryption worker %d - stopped", id)
+	device.logs.routine.Verbosef("Routine: decryption worker %d - started", id)
 
 	for elemsContainer := range device.queue.decryption.c {
 		for _, elem := range elemsContainer.elems {
@@ -270,10 +279,10 @@ func (device *Device) RoutineDecryption(id int) {
  */
 func (device *Device) RoutineHandshake(id int) {
 	defer func() {
-		device.log.Verbosef("Routine: handshake worker %d - stopped", id)
+		device.logs.routine.Verbosef("Routine: handshake worker %d - stopped", id)
 		device.queue.encryption.wg.Done()
 	}()
-	device.log.Verbosef("Routine: handshake worker %d - started", id)
+	device.logs.routine.Verbosef("Routine: handshake worker %d - started", id)
 
 	for elem := range device.queue.handshake.c {
 
@@ -288,7 +297,7 @@ func (device *Device) RoutineHandshake(id int) {
 			var reply MessageCookieReply
 			err := reply.unmarshal(elem.packet)
 			if err != nil {
-				device.log.Verbosef("Failed to decode cookie reply")
+				device.logs.handshake.Warnf("Failed to decode cookie reply")
 				goto skip
 			}
 
@@ -303,9 +312,9 @@ func (device *Device) RoutineHandshake(id int) {
 			// consume reply
 
 			if peer := entry.peer; peer.isRunning.Load() {
-				device.log.Verbosef("Receiving cookie response from %s", elem.endpoint.DstToString())
+				device.logs.handshake.Verbosef("Receiving cookie response from %s", elem.endpoint.DstToString())
 				if !peer.cookieGenerator.ConsumeReply(&reply) {
-					device.log.Verbosef("Could not decrypt invalid cookie response")
+					device.logs.handshake.Verbosef("Could not decrypt invalid cookie response")
 				}
 			}
 
@@ -316,7 +325,7 @@ func (device *Device) RoutineHandshake(id int) {
 			// check mac fields and maybe ratelimit
 
 			if !device.cookieChecker.CheckMAC1(elem.packet) {
-				device.log.Verbosef("Received packet with invalid mac1")
+				device.logs.handshake.Tracef("Received packet with invalid mac1")
 				goto skip
 			}
 
@@ -339,7 +348,7 @@ func (device *Device) RoutineHandshake(id int) {
 			}
 
 		default:
-			device.log.Errorf("Invalid packet ended up in the handshake queue")
+			device.logs.handshake.Errorf("Invalid packet ended up in the handshake queue")
 			goto skip
 		}
 
@@ -353,7 +362,7 @@ func (device *Device) RoutineHandshake(id int) {
 			var msg MessageInitiation
 			err := msg.unmarshal(elem.packet)
 			if err != nil {
-				device.log.Errorf("Failed to decode initiation message")
+				device.logs.handshake.Errorf("Failed to decode initiation message")
 				goto skip
 			}
 
@@ -361,7 +370,7 @@ func (device *Device) RoutineHandshake(id int) {
 
 			peer := device.ConsumeMessageInitiation(&msg)
 			if peer == nil {
-				device.log.Verbosef("Received invalid initiation message from %s", elem.endpoint.DstToString())
+				device.logs.handshake.Verbosef("Received invalid initiation message from %s", elem.endpoint.DstToString())
 				goto skip
 			}
 
@@ -373,8 +382,9 @@ func (device *Device) RoutineHandshake(id int) {
 			// update endpoint
 			peer.SetEndpointFromPacket(elem.endpoint)
 
-			device.log.Verbosef("%v - Received handshake initiation", peer)
+			device.logs.handshake.Verbosef("%v - Received handshake initiation", peer)
 			peer.rxBytes.Add(uint64(len(elem.packet)))
+			peer.markReceived(1)
 
 			peer.SendHandshakeResponse()
 
@@ -385,7 +395,7 @@ func (device *Device) RoutineHandshake(id int) {
 			var msg MessageResponse
 			err := msg.unmarshal(elem.packet)
 			if err != nil {
-				device.log.Errorf("Failed to decode response message")
+				device.logs.handshake.Errorf("Failed to decode response message")
 				goto skip
 			}
 
@@ -393,15 +403,17 @@ func (device *Device) RoutineHandshake(id int) {
 
 			peer := device.ConsumeMessageResponse(&msg)
 			if peer == nil {
-				device.log.Verbosef("Received invalid response message from %s", elem.endpoint.DstToString())
+				device.logs.handshake.Verbosef("Received invalid response message from %s", elem.endpoint.DstToString())
 				goto skip
 			}
 
 			// update endpoint
 			peer.SetEndpointFromPacket(elem.endpoint)
 
-			device.log.Verbosef("%v - Received handshake response", peer)
+			device.logs.handshake.Verbosef("%v - Received handshake response", peer)
 			peer.rxBytes.Add(uint64(len(elem.packet)))
+			peer.markReceived(1)
+			peer.stats.handshakeResponsesRecv.Add(1)
 
 			// update timers
 
@@ -413,12 +425,13 @@ func (device *Device) RoutineHandshake(id int) {
 			err = peer.BeginSymmetricSession()
 
 			if err != nil {
-				device.log.Errorf("%v - Failed to derive keypair: %v", peer, err)
+				device.logs.handshake.Errorf("%v - Failed to derive keypair: %v", peer, err)
 				goto skip
 			}
 
 			peer.timersSessionDerived()
 			peer.timersHandshakeComplete()
+			peer.emit(Event{Type: EventHandshakeCompleted, Reason: "initiator"})
 			peer.SendKeepalive()
 		}
 	skip:
@@ -429,10 +442,10 @@ func (device *Device) RoutineHandshake(id int) {
 func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 	device := peer.device
 	defer func() {
-		device.log.Verbosef("%v - Routine: sequential receiver - stopped", peer)
+		device.logs.routine.Verbosef("%v - Routine: sequential receiver - stopped", peer)
 		peer.stopping.Done()
 	}()
-	device.log.Verbosef("%v - Routine: sequential receiver - started", peer)
+	device.logs.routine.Verbosef("%v - Routine: sequential receiver - started", peer)
 
 	bufs := make([][]byte, 0, maxBatchSize)
 
@@ -444,26 +457,37 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 		validTailPacket := -1
 		dataPacketReceived := false
 		rxBytesLen := uint64(0)
+		rxPackets := 0
+		var holdBack time.Duration
+		suspended := peer.quotaExceeded()
 		for i, elem := range elemsContainer.elems {
 			if elem.packet == nil {
 				// decryption failed
+				peer.stats.rxDropDecrypt.Add(1)
 				continue
 			}
 
 			if !elem.keypair.replayFilter.ValidateCounter(elem.counter, RejectAfterMessages) {
+				peer.stats.rxDropReplay.Add(1)
 				continue
 			}
 
 			validTailPacket = i
+			rxPackets++
 			if peer.ReceivedWithKeypair(elem.keypair) {
 				peer.SetEndpointFromPacket(elem.endpoint)
 				peer.timersHandshakeComplete()
+				peer.emit(Event{Type: EventHandshakeCompleted, Reason: "responder"})
 				peer.SendStagedPackets()
 			}
 			rxBytesLen += uint64(len(elem.packet) + MinMessageSize)
 
 			if len(elem.packet) == 0 {
-				device.log.Verbosef("%v - Receiving keepalive packet", peer)
+				device.logs.timers.Tracef("%v - Receiving keepalive packet", peer)
+				continue
+			}
+			if suspended {
+				peer.stats.rxDropQuota.Add(1)
 				continue
 			}
 			dataPacketReceived = true
@@ -471,47 +495,70 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 			switch elem.packet[0] >> 4 {
 			case 4:
 				if len(elem.packet) < ipv4.HeaderLen {
+					peer.stats.rxDropMalformed.Add(1)
 					continue
 				}
 				field := elem.packet[IPv4offsetTotalLength : IPv4offsetTotalLength+2]
 				length := binary.BigEndian.Uint16(field)
 				if int(length) > len(elem.packet) || int(length) < ipv4.HeaderLen {
+					peer.stats.rxDropMalformed.Add(1)
 					continue
 				}
 				elem.packet = elem.packet[:length]
 				src := elem.packet[IPv4offsetSrc : IPv4offsetSrc+net.IPv4len]
 				if device.allowedips.Lookup(src) != peer {
-					device.log.Verbosef("IPv4 packet with disallowed source address from %v", peer)
+					device.logs.data.Tracef("IPv4 packet with disallowed source address from %v", peer)
+					peer.stats.rxDropAllowedIPs.Add(1)
 					continue
 				}
 
 			case 6:
 				if len(elem.packet) < ipv6.HeaderLen {
+					peer.stats.rxDropMalformed.Add(1)
 					continue
 				}
 				field := elem.packet[IPv6offsetPayloadLength : IPv6offsetPayloadLength+2]
 				length := binary.BigEndian.Uint16(field)
 				length += ipv6.HeaderLen
 				if int(length) > len(elem.packet) {
+					peer.stats.rxDropMalformed.Add(1)
 					continue
 				}
 				elem.packet = elem.packet[:length]
 				src := elem.packet[IPv6offsetSrc : IPv6offsetSrc+net.IPv6len]
 				if device.allowedips.Lookup(src) != peer {
-					device.log.Verbosef("IPv6 packet with disallowed source address from %v", peer)
+					device.logs.data.Tracef("IPv6 packet with disallowed source address from %v", peer)
+					peer.stats.rxDropAllowedIPs.Add(1)
 					continue
 				}
 
 			default:
-				device.log.Verbosef("Packet with invalid IP version from %v", peer)
+				device.logs.data.Tracef("Packet with invalid IP version from %v", peer)
+				peer.stats.rxDropMalformed.Add(1)
+				continue
+			}
+
+			if fw := device.firewall.Load(); fw != nil && !device.filter(fw, peer, elem.packet, true) {
+				peer.stats.rxDropFirewall.Add(1)
+				continue
+			}
+
+			delay, ok := peer.shaping.rx.reserve(len(elem.packet) + MinMessageSize)
+			if !ok {
+				peer.stats.rxDropRateLimit.Add(1)
 				continue
 			}
+			holdBack = max(holdBack, delay)
 
+			if c := device.capture.Load(); c != nil {
+				device.captureInner(c, peer, elem.packet, true)
+			}
 			bufs = append(bufs, elem.buffer[:MessageTransportOffsetContent+len(elem.packet)])
 		}
 
 		peer.rxBytes.Add(rxBytesLen)
 		if validTailPacket >= 0 {
+			peer.markReceived(rxPackets)
 			peer.SetEndpointFromPacket(elemsContainer.elems[validTailPacket].endpoint)
 			peer.keepKeyFreshReceiving()
 			peer.timersAnyAuthenticatedPacketTraversal()
@@ -520,17 +567,31 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 		if dataPacketReceived {
 			peer.timersDataReceived()
 		}
-		if len(bufs) > 0 {
-			_, err := device.tun.device.Write(bufs, MessageTransportOffsetContent)
-			if err != nil && !device.isClosed() {
-				device.log.Errorf("Failed to write packets to TUN device: %v", err)
-			}
-		}
-		for _, elem := range elemsContainer.elems {
-			device.PutMessageBuffer(elem.buffer)
-			device.PutInboundElement(elem)
+		if holdBack > 0 || peer.shaping.rx.pacer.holding() {
+			batch := slices.Clone(bufs)
+			peer.shaping.rx.pacer.hold(holdBack, func() {
+				peer.receiveElements(elemsContainer, batch)
+			})
+		} else {
+			peer.receiveElements(elemsContainer, bufs)
 		}
 		bufs = bufs[:0]
-		device.PutInboundElementsContainer(elemsContainer)
 	}
 }
+
+// receiveElements writes bufs, the packets of elemsContainer that passed, to
+// the TUN device, and recycles the elements.
+func (peer *Peer) receiveElements(elemsContainer *QueueInboundElementsContainer, bufs [][]byte) {
+	device := peer.device
+	if len(bufs) > 0 {
+		_, err := device.tun.device.Write(bufs, MessageTransportOffsetContent)
+		if err != nil && !device.isClosed() {
+			device.logs.tun.Errorf("Failed to write packets to TUN device: %v", err)
+		}
+	}
+	for _, elem := range elemsContainer.elems {
+		device.PutMessageBuffer(elem.buffer)
+		device.PutInboundElement(elem)
+	}
+	device.PutInboundElementsContainer(elemsContainer)
+}
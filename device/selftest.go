@@ -0,0 +1,286 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tai64n"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// Known answers for the primitives are taken from their specifications. Those for
+// the handshake were computed with an independent implementation of the protocol,
+// from keys derived as BLAKE2s("wireguard-go self-test " + role) and clamped.
+var (
+	selfTestAEADKey       = "808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f"
+	selfTestAEADData      = "50515253c0c1c2c3c4c5c6c7"
+	selfTestAEADPlaintext = "Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it."
+
+	// RFC 8439, section 2.8.2
+	selfTestChaCha20Poly1305Nonce  = "070000004041424344454647"
+	selfTestChaCha20Poly1305Sealed = "d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d63dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b3692ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc3ff4def08e4b7a9de576d26586cec64b61161ae10b594f09e26a7e902ecbd0600691"
+
+	// draft-irtf-cfrg-xchacha-03, appendix A.3.1
+	selfTestXChaCha20Poly1305Nonce  = "404142434445464748494a4b4c4d4e4f5051525354555657"
+	selfTestXChaCha20Poly1305Sealed = "bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3fff921f9664c97637da9768812f615c68b13b52ec0875924c1c7987947deafd8780acf49"
+)
+
+var selfTestHandshakeVector = struct {
+	initiatorStatic     string
+	responderStatic     string
+	initiatorEphemeral  string
+	responderEphemeral  string
+	presharedKey        string
+	timestamp           string
+	initiationEphemeral string
+	initiationStatic    string
+	initiationTimestamp string
+	responseEphemeral   string
+	responseEmpty       string
+	initiatorSendKey    string
+	initiatorReceiveKey string
+	transportPlaintext  string
+	transportData       string // first transport message of the initiator, counter 0
+}{
+	initiatorStatic:     "b09289ffc741f4710dcbd5034f69d8587d1fa3831960c2dcdfd764626b706b7f",
+	responderStatic:     "10e2e736bd1c201d1e17517bb6c6006a2a77b5708e01bae8b6e5e980dc5a1e45",
+	initiatorEphemeral:  "e05decb8e0c2c271c9ba5030e75b81cd6ec4dacff146946af80acbf5c48f6575",
+	responderEphemeral:  "f8c93c1cfe42a2a630a4e2fc6b8409b68cd4be528263fdd6346ec9e56d3eed51",
+	presharedKey:        "98a173afd0ea0afe5bbb9f73bad77415995ed4e4d344e5e0ef1deed5f2544214",
+	timestamp:           "400000006553f10012345678",
+	initiationEphemeral: "ead76bd57e2274f3fb373f3f86b287a2e9601137022ecba0464afe828203990b",
+	initiationStatic:    "f819b5f7b066dee0c5b19fba71da47e3f1da0cb6dfeae6607bd1cc0f503c972a4d18d05ae37bbf35a543ce86fdffa351",
+	initiationTimestamp: "0455cc672cde3f3b686d248f413adbb291110d4bba8fff4b74a86e18",
+	responseEphemeral:   "6ef15e9ffd065663b95f4fa83f34daff2b9cdf59bc5568d10eadf3bcdb26af30",
+	responseEmpty:       "699d2c29b5ea128c48a5dfe855e0696b",
+	initiatorSendKey:    "f130c8fc7ec1aed80bb776d6b4f4ea78b53561185e2cb9ccb6061673e6d545c7",
+	initiatorReceiveKey: "1dfefa0c1111b4e15a2b32b0bab6a243b618cf40cb1fab2f3050a1b1731fc12e",
+	transportPlaintext:  "wireguard-go self-test",
+	transportData:       "3a65bcdbe2ef1a929f47514933d6df0ec853414c004b84a7804e1ef0a159e3dfdc504732eb42",
+}
+
+// SelfTestCrypto checks the cryptographic primitives, the Noise handshake and the
+// transport encryption against known answers, so that a broken build or platform
+// is caught before it talks to peers. It returns all mismatches found.
+func SelfTestCrypto() error {
+	var errs []error
+	for _, test := range []struct {
+		name string
+		run  func() error
+	}{
+		{"BLAKE2s", selfTestBLAKE2s},
+		{"HKDF", selfTestKDF},
+		{"X25519", selfTestX25519},
+		{"ChaCha20Poly1305", selfTestChaCha20Poly1305},
+		{"XChaCha20Poly1305", selfTestXChaCha20Poly1305},
+		{"Noise handshake", selfTestHandshake},
+	} {
+		if err := test.run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", test.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func expectBytes(what string, got, want []byte) error {
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%s is %x, want %x", what, got, want)
+	}
+	return nil
+}
+
+// RFC 7693, appendix B
+func selfTestBLAKE2s() error {
+	sum := blake2s.Sum256([]byte("abc"))
+	return expectBytes("hash", sum[:], unhex("508c5e8c327c14e2e1a72ba34eeb452f37458b209ed63a294d999b4c86675982"))
+}
+
+func selfTestKDF() error {
+	var t0, t1, t2 [blake2s.Size]byte
+	KDF3(&t0, &t1, &t2, []byte("test-key"), []byte("test-input"))
+	return errors.Join(
+		expectBytes("t0", t0[:], unhex("6f0e5ad38daba1bea8a0d213688736f19763239305e0f58aba697f9ffc41c633")),
+		expectBytes("t1", t1[:], unhex("df1194df20802a4fe594cde27e92991c8cae66c366e8106aaa937a55fa371e8a")),
+		expectBytes("t2", t2[:], unhex("fac6e2745a325f5dc5d11a5b165aad08b0ada28e7b4e666b7c077934a4d76c24")),
+	)
+}
+
+// RFC 7748, section 5.2
+func selfTestX25519() error {
+	var sk NoisePrivateKey
+	var pk NoisePublicKey
+	copy(sk[:], unhex("a546e36bf0527c9d3b16154b82465edd62144c0ac1fc5a18506a2244ba449ac4"))
+	copy(pk[:], unhex("e6db6867583030db3594c1a424b15f7c726624ec26b3353b10a903a6d0ab1c4c"))
+	ss, err := sk.sharedSecret(pk)
+	if err != nil {
+		return err
+	}
+	return expectBytes("shared secret", ss[:], unhex("c3da55379de9c6908e94ea4df28d084f32eccf03491c71f754b4075577a28552"))
+}
+
+func selfTestChaCha20Poly1305() error {
+	aead, err := chacha20poly1305.New(unhex(selfTestAEADKey))
+	if err != nil {
+		return err
+	}
+	return selfTestAEAD(aead.Seal, aead.Open, unhex(selfTestChaCha20Poly1305Nonce), unhex(selfTestChaCha20Poly1305Sealed))
+}
+
+func selfTestXChaCha20Poly1305() error {
+	aead, err := chacha20poly1305.NewX(unhex(selfTestAEADKey))
+	if err != nil {
+		return err
+	}
+	return selfTestAEAD(aead.Seal, aead.Open, unhex(selfTestXChaCha20Poly1305Nonce), unhex(selfTestXChaCha20Poly1305Sealed))
+}
+
+func selfTestAEAD(
+	seal func(dst, nonce, plaintext, additionalData []byte) []byte,
+	open func(dst, nonce, ciphertext, additionalData []byte) ([]byte, error),
+	nonce, sealed []byte,
+) error {
+	plaintext := []byte(selfTestAEADPlaintext)
+	data := unhex(selfTestAEADData)
+	if err := expectBytes("ciphertext", seal(nil, nonce, plaintext, data), sealed); err != nil {
+		return err
+	}
+	opened, err := open(nil, nonce, sealed, data)
+	if err != nil {
+		return err
+	}
+	if err := expectBytes("plaintext", opened, plaintext); err != nil {
+		return err
+	}
+	sealed[0] ^= 1
+	if _, err := open(nil, nonce, sealed, data); err == nil {
+		return errors.New("forged ciphertext accepted")
+	}
+	return nil
+}
+
+// selfTestHandshake runs a handshake between two devices, with the randomness
+// replaced by fixed values, and checks the messages, the session keys and the
+// first transport message.
+func selfTestHandshake() error {
+	v := &selfTestHandshakeVector
+	var si, sr, ei, er NoisePrivateKey
+	var psk NoisePresharedKey
+	var timestamp tai64n.Timestamp
+	copy(si[:], unhex(v.initiatorStatic))
+	copy(sr[:], unhex(v.responderStatic))
+	copy(ei[:], unhex(v.initiatorEphemeral))
+	copy(er[:], unhex(v.responderEphemeral))
+	copy(psk[:], unhex(v.presharedKey))
+	copy(timestamp[:], unhex(v.timestamp))
+
+	logger := NewLogger(LogLevelSilent, "")
+	binds := bindtest.NewChannelBinds()
+	initiator := NewDevice(tuntest.NewChannelTUN().TUN(), binds[0], logger)
+	defer initiator.Close()
+	responder := NewDevice(tuntest.NewChannelTUN().TUN(), binds[1], logger)
+	defer responder.Close()
+	// keep the session keys to compare them
+	initiator.EnableHandoff()
+	responder.EnableHandoff()
+
+	newPeer := func(device *Device, sk NoisePrivateKey, remote NoisePrivateKey) (*Peer, error) {
+		if err := device.SetPrivateKey(sk); err != nil {
+			return nil, err
+		}
+		peer, err := device.NewPeer(remote.publicKey())
+		if err != nil {
+			return nil, err
+		}
+		peer.handshake.mutex.Lock()
+		peer.handshake.presharedKey = psk
+		peer.handshake.mutex.Unlock()
+		return peer, device.Up()
+	}
+	initiatorPeer, err := newPeer(initiator, si, sr)
+	if err != nil {
+		return err
+	}
+	responderPeer, err := newPeer(responder, sr, si)
+	if err != nil {
+		return err
+	}
+
+	initiation, err := initiator.createMessageInitiation(initiatorPeer, ei, timestamp)
+	if err != nil {
+		return err
+	}
+	if err := errors.Join(
+		expectBytes("initiation ephemeral", initiation.Ephemeral[:], unhex(v.initiationEphemeral)),
+		expectBytes("initiation static", initiation.Static[:], unhex(v.initiationStatic)),
+		expectBytes("initiation timestamp", initiation.Timestamp[:], unhex(v.initiationTimestamp)),
+	); err != nil {
+		return err
+	}
+	if responder.ConsumeMessageInitiation(initiation) != responderPeer {
+		return errors.New("initiation rejected")
+	}
+
+	response, err := responder.createMessageResponse(responderPeer, er)
+	if err != nil {
+		return err
+	}
+	if err := errors.Join(
+		expectBytes("response ephemeral", response.Ephemeral[:], unhex(v.responseEphemeral)),
+		expectBytes("response empty", response.Empty[:], unhex(v.responseEmpty)),
+	); err != nil {
+		return err
+	}
+	if initiator.ConsumeMessageResponse(response) != initiatorPeer {
+		return errors.New("response rejected")
+	}
+
+	if err := initiatorPeer.BeginSymmetricSession(); err != nil {
+		return err
+	}
+	if err := responderPeer.BeginSymmetricSession(); err != nil {
+		return err
+	}
+	sending := initiatorPeer.keypairs.Current()
+	receiving := responderPeer.keypairs.next.Load()
+	if sending == nil || receiving == nil {
+		return errors.New("no session established")
+	}
+	if err := errors.Join(
+		expectBytes("initiator sending key", sending.sendKey[:], unhex(v.initiatorSendKey)),
+		expectBytes("initiator receiving key", sending.receiveKey[:], unhex(v.initiatorReceiveKey)),
+		expectBytes("responder sending key", receiving.sendKey[:], unhex(v.initiatorReceiveKey)),
+		expectBytes("responder receiving key", receiving.receiveKey[:], unhex(v.initiatorSendKey)),
+	); err != nil {
+		return err
+	}
+
+	var nonce [chacha20poly1305.NonceSize]byte // counter 0
+	plaintext := []byte(v.transportPlaintext)
+	data := sending.send.Seal(nil, nonce[:], plaintext, nil)
+	if err := expectBytes("transport data", data, unhex(v.transportData)); err != nil {
+		return err
+	}
+	opened, err := receiving.receive.Open(nil, nonce[:], data, nil)
+	if err != nil {
+		return fmt.Errorf("transport data rejected: %w", err)
+	}
+	return expectBytes("transport plaintext", opened, plaintext)
+}
@@ -0,0 +1,22 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "testing"
+
+func TestSelfTestCrypto(t *testing.T) {
+	if err := SelfTestCrypto(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a wrong answer must not go unnoticed
+	v := selfTestHandshakeVector
+	defer func() { selfTestHandshakeVector = v }()
+	selfTestHandshakeVector.transportData = "00" + v.transportData[2:]
+	if err := SelfTestCrypto(); err == nil {
+		t.Fatal("mismatching transport data not detected")
+	}
+}
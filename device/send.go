@@ -10,6 +10,7 @@ import (
 	"errors"
 	"net"
 	"os"
+	"slices"
 	"sync"
 	"time"
 
@@ -85,7 +86,7 @@ func (peer *Peer) SendKeepalive() {
 		elemsContainer.elems = append(elemsContainer.elems, elem)
 		select {
 		case peer.queue.staged <- elemsContainer:
-			peer.device.log.Verbosef("%v - Sending keepalive packet", peer)
+			peer.device.logs.timers.Tracef("%v - Sending keepalive packet", peer)
 		default:
 			peer.device.PutMessageBuffer(elem.buffer)
 			peer.device.PutOutboundElement(elem)
@@ -115,13 +116,14 @@ func (peer *Peer) SendHandshakeInitiation(isRetry bool) error {
 	peer.handshake.lastSentHandshake = time.Now()
 	peer.handshake.mutex.Unlock()
 
-	peer.device.log.Verbosef("%v - Sending handshake initiation", peer)
+	peer.device.logs.handshake.Verbosef("%v - Sending handshake initiation", peer)
 
 	msg, err := peer.device.CreateMessageInitiation(peer)
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to create initiation message: %v", peer, err)
+		peer.device.logs.handshake.Errorf("%v - Failed to create initiation message: %v", peer, err)
 		return err
 	}
+	peer.emit(Event{Type: EventHandshakeInitiated, Attempt: int(peer.timers.handshakeAttempts.Load()) + 1})
 
 	packet := make([]byte, MessageInitiationSize)
 	_ = msg.marshal(packet)
@@ -132,7 +134,12 @@ func (peer *Peer) SendHandshakeInitiation(isRetry bool) error {
 
 	err = peer.SendBuffers([][]byte{packet})
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to send handshake initiation: %v", peer, err)
+		peer.device.logs.handshake.Errorf("%v - Failed to send handshake initiation: %v", peer, err)
+	} else {
+		peer.stats.handshakeInitiations.Add(1)
+		if isRetry {
+			peer.stats.handshakeRetries.Add(1)
+		}
 	}
 	peer.timersHandshakeInitiated()
 
@@ -144,11 +151,11 @@ func (peer *Peer) SendHandshakeResponse() error {
 	peer.handshake.lastSentHandshake = time.Now()
 	peer.handshake.mutex.Unlock()
 
-	peer.device.log.Verbosef("%v - Sending handshake response", peer)
+	peer.device.logs.handshake.Verbosef("%v - Sending handshake response", peer)
 
 	response, err := peer.device.CreateMessageResponse(peer)
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to create response message: %v", peer, err)
+		peer.device.logs.handshake.Errorf("%v - Failed to create response message: %v", peer, err)
 		return err
 	}
 
@@ -158,7 +165,7 @@ func (peer *Peer) SendHandshakeResponse() error {
 
 	err = peer.BeginSymmetricSession()
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to derive keypair: %v", peer, err)
+		peer.device.logs.handshake.Errorf("%v - Failed to derive keypair: %v", peer, err)
 		return err
 	}
 
@@ -169,18 +176,18 @@ func (peer *Peer) SendHandshakeResponse() error {
 	// TODO: allocation could be avoided
 	err = peer.SendBuffers([][]byte{packet})
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to send handshake response: %v", peer, err)
+		peer.device.logs.handshake.Errorf("%v - Failed to send handshake response: %v", peer, err)
 	}
 	return err
 }
 
 func (device *Device) SendHandshakeCookie(initiatingElem *QueueHandshakeElement) error {
-	device.log.Verbosef("Sending cookie response for denied handshake message for %v", initiatingElem.endpoint.DstToString())
+	device.logs.handshake.Verbosef("Sending cookie response for denied handshake message for %v", initiatingElem.endpoint.DstToString())
 
 	sender := binary.LittleEndian.Uint32(initiatingElem.packet[4:8])
 	reply, err := device.cookieChecker.CreateReply(initiatingElem.packet, sender, initiatingElem.endpoint.DstToBytes())
 	if err != nil {
-		device.log.Errorf("Failed to create cookie reply: %v", err)
+		device.logs.handshake.Errorf("Failed to create cookie reply: %v", err)
 		return err
 	}
 
@@ -205,12 +212,13 @@ func (peer *Peer) keepKeyFreshSending() {
 
 func (device *Device) RoutineReadFromTUN() {
 	defer func() {
-		device.log.Verbosef("Routine: TUN reader - stopped")
+		device.logs.routine.Verbosef("Routine: TUN reader - stopped")
+		device.health.tunReader.Store(false)
 		device.state.stopping.Done()
 		device.queue.encryption.wg.Done()
 	}()
 
-	device.log.Verbosef("Routine: TUN reader - started")
+	device.logs.routine.Verbosef("Routine: TUN reader - started")
 
 	var (
 		batchSize   = device.BatchSize()
@@ -240,6 +248,9 @@ func (device *Device) RoutineReadFromTUN() {
 	for {
 		// read packets
 		count, readErr = device.tun.device.Read(bufs, sizes, offset)
+		// packets read once the device is being handed over are left until it
+		// is not, and to the other process after that
+		device.waitHandoff()
 		for i := 0; i < count; i++ {
 			if sizes[i] < 1 {
 				continue
@@ -266,12 +277,19 @@ func (device *Device) RoutineReadFromTUN() {
 				peer = device.allowedips.Lookup(dst)
 
 			default:
-				device.log.Verbosef("Received packet with unknown IP version")
+				device.logs.data.Tracef("Received packet with unknown IP version")
 			}
 
 			if peer == nil {
 				continue
 			}
+			if fw := device.firewall.Load(); fw != nil && !device.filter(fw, peer, elem.packet, false) {
+				peer.stats.txDropFirewall.Add(1)
+				continue
+			}
+			if c := device.capture.Load(); c != nil {
+				device.captureInner(c, peer, elem.packet, false)
+			}
 			elemsForPeer, ok := elemsByPeer[peer]
 			if !ok {
 				elemsForPeer = device.GetOutboundElementsContainer()
@@ -287,6 +305,7 @@ func (device *Device) RoutineReadFromTUN() {
 				peer.StagePackets(elemsForPeer)
 				peer.SendStagedPackets()
 			} else {
+				peer.stats.txDropNotRunning.Add(uint64(len(elemsForPeer.elems)))
 				for _, elem := range elemsForPeer.elems {
 					device.PutMessageBuffer(elem.buffer)
 					device.PutOutboundElement(elem)
@@ -301,12 +320,12 @@ func (device *Device) RoutineReadFromTUN() {
 				// TODO: record stat for this
 				// This will happen if MSS is surprisingly small (< 576)
 				// coincident with reasonably high throughput.
-				device.log.Verbosef("Dropped some packets from multi-segment read: %v", readErr)
+				device.logs.data.Warnf("Dropped some packets from multi-segment read: %v", readErr)
 				continue
 			}
 			if !device.isClosed() {
 				if !errors.Is(readErr, os.ErrClosed) {
-					device.log.Errorf("Failed to read packet from TUN device: %v", readErr)
+					device.logs.tun.Errorf("Failed to read packet from TUN device: %v", readErr)
 				}
 				go device.Close()
 			}
@@ -324,6 +343,7 @@ func (peer *Peer) StagePackets(elems *QueueOutboundElementsContainer) {
 		}
 		select {
 		case tooOld := <-peer.queue.staged:
+			peer.stats.txDropStagedOverflow.Add(uint64(len(tooOld.elems)))
 			for _, elem := range tooOld.elems {
 				peer.device.PutMessageBuffer(elem.buffer)
 				peer.device.PutOutboundElement(elem)
@@ -385,6 +405,7 @@ top:
 				peer.queue.outbound.c <- elemsContainer
 				peer.device.queue.encryption.c <- elemsContainer
 			} else {
+				peer.stats.txDropNotRunning.Add(uint64(len(elemsContainer.elems)))
 				for _, elem := range elemsContainer.elems {
 					peer.device.PutMessageBuffer(elem.buffer)
 					peer.device.PutOutboundElement(elem)
@@ -440,8 +461,8 @@ func (device *Device) RoutineEncryption(id int) {
 	var paddingZeros [PaddingMultiple]byte
 	var nonce [chacha20poly1305.NonceSize]byte
 
-	defer device.log.Verbosef("Routine: encryption worker %d - stopped", id)
-	device.log.Verbosef("Routine: encryption worker %d - started", id)
+	defer device.logs.routine.Verbosef("Routine: encryption worker %d - stopped", id)
+	device.logs.routine.Verbosef("Routine: encryption worker %d - started", id)
 
 	for elemsContainer := range device.queue.encryption.c {
 		for _, elem := range elemsContainer.elems {
@@ -477,10 +498,10 @@ func (device *Device) RoutineEncryption(id int) {
 func (peer *Peer) RoutineSequentialSender(maxBatchSize int) {
 	device := peer.device
 	defer func() {
-		defer device.log.Verbosef("%v - Routine: sequential sender - stopped", peer)
+		defer device.logs.routine.Verbosef("%v - Routine: sequential sender - stopped", peer)
 		peer.stopping.Done()
 	}()
-	device.log.Verbosef("%v - Routine: sequential sender - started", peer)
+	device.logs.routine.Verbosef("%v - Routine: sequential sender - started", peer)
 
 	bufs := make([][]byte, 0, maxBatchSize)
 
@@ -497,6 +518,7 @@ func (peer *Peer) RoutineSequentialSender(maxBatchSize int) {
 			// TODO: rework peer shutdown order to ensure
 			// that we never accidentally keep timers alive longer than necessary.
 			elemsContainer.Lock()
+			peer.stats.txDropNotRunning.Add(uint64(len(elemsContainer.elems)))
 			for _, elem := range elemsContainer.elems {
 				device.PutMessageBuffer(elem.buffer)
 				device.PutOutboundElement(elem)
@@ -505,38 +527,66 @@ func (peer *Peer) RoutineSequentialSender(maxBatchSize int) {
 			continue
 		}
 		dataSent := false
+		var holdBack time.Duration
+		suspended := peer.quotaExceeded()
 		elemsContainer.Lock()
 		for _, elem := range elemsContainer.elems {
 			if len(elem.packet) != MessageKeepaliveSize {
+				if suspended {
+					peer.stats.txDropQuota.Add(1)
+					continue
+				}
+				delay, ok := peer.shaping.tx.reserve(len(elem.packet))
+				if !ok {
+					peer.stats.txDropRateLimit.Add(1)
+					continue
+				}
+				holdBack = max(holdBack, delay)
 				dataSent = true
 			}
 			bufs = append(bufs, elem.packet)
 		}
+		if holdBack > 0 || peer.shaping.tx.pacer.holding() {
+			batch := slices.Clone(bufs)
+			peer.shaping.tx.pacer.hold(holdBack, func() {
+				peer.sendElements(elemsContainer, batch, dataSent)
+			})
+			continue
+		}
+		peer.sendElements(elemsContainer, bufs, dataSent)
+	}
+}
 
+// sendElements sends bufs, the packets of elemsContainer that passed, and
+// recycles the elements.
+func (peer *Peer) sendElements(elemsContainer *QueueOutboundElementsContainer, bufs [][]byte, dataSent bool) {
+	device := peer.device
+	var err error
+	if len(bufs) > 0 {
 		peer.timersAnyAuthenticatedPacketTraversal()
 		peer.timersAnyAuthenticatedPacketSent()
 
-		err := peer.SendBuffers(bufs)
+		err = peer.SendBuffers(bufs)
 		if dataSent {
 			peer.timersDataSent()
 		}
-		for _, elem := range elemsContainer.elems {
-			device.PutMessageBuffer(elem.buffer)
-			device.PutOutboundElement(elem)
-		}
-		device.PutOutboundElementsContainer(elemsContainer)
-		if err != nil {
-			var errGSO conn.ErrUDPGSODisabled
-			if errors.As(err, &errGSO) {
-				device.log.Verbosef(err.Error())
-				err = errGSO.RetryErr
-			}
-		}
-		if err != nil {
-			device.log.Errorf("%v - Failed to send data packets: %v", peer, err)
-			continue
+	}
+	for _, elem := range elemsContainer.elems {
+		device.PutMessageBuffer(elem.buffer)
+		device.PutOutboundElement(elem)
+	}
+	device.PutOutboundElementsContainer(elemsContainer)
+	if err != nil {
+		var errGSO conn.ErrUDPGSODisabled
+		if errors.As(err, &errGSO) {
+			device.logs.bind.Verbosef(err.Error())
+			err = errGSO.RetryErr
 		}
-
-		peer.keepKeyFreshSending()
 	}
+	if err != nil {
+		device.logs.data.Errorf("%v - Failed to send data packets: %v", peer, err)
+		return
+	}
+
+	peer.keepKeyFreshSending()
 }
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket limits the bandwidth of a peer in one direction. Tokens are bytes,
+// accumulating at the rate up to a burst of RateLimitBurst worth of traffic, but
+// at least MaxMessageSize bytes, so that any packet eventually fits. Traffic
+// beyond that borrows tokens and is held back by the pacer until they are
+// earned, for at most RateLimitBacklog; what would wait longer is dropped.
+type tokenBucket struct {
+	rate   atomic.Uint64 // bits per second, 0 if unlimited
+	mu     sync.Mutex
+	tokens float64 // bytes, negative while traffic is held back
+	last   time.Time
+	pacer  pacer
+}
+
+// setRate limits the bucket to bitsPerSecond, or removes the limit if zero. The
+// bucket starts full.
+func (b *tokenBucket) setRate(bitsPerSecond uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate.Store(bitsPerSecond)
+	b.tokens = burstBytes(bitsPerSecond)
+	b.last = time.Now()
+}
+
+func burstBytes(bitsPerSecond uint64) float64 {
+	return max(float64(bitsPerSecond)/8*RateLimitBurst.Seconds(), MaxMessageSize)
+}
+
+// reserve takes n bytes of tokens, and returns how long to hold the traffic back
+// before sending it. It reports false if the traffic must be dropped.
+func (b *tokenBucket) reserve(n int) (time.Duration, bool) {
+	rate := b.rate.Load()
+	if rate == 0 {
+		return 0, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	bytesPerSecond := float64(rate) / 8
+	now := time.Now()
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*bytesPerSecond, burstBytes(rate))
+	b.last = now
+	if b.tokens-float64(n) < -bytesPerSecond*RateLimitBacklog.Seconds() {
+		return 0, false
+	}
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-b.tokens / bytesPerSecond * float64(time.Second)), true
+}
+
+// A pacer delivers the batches of packets that a tokenBucket holds back, when
+// their time comes and in order, so that the routine handing them over goes on
+// with the packets of the peer that need no tokens, such as keepalives, and
+// with its timers.
+type pacer struct {
+	mu      sync.Mutex
+	batches []pacedBatch
+	pending atomic.Int32 // batches held and not yet delivered
+}
+
+type pacedBatch struct {
+	due     time.Time
+	deliver func()
+}
+
+// holding reports whether batches are held, which a batch handed over later
+// must then wait for, to keep the packets in order. It is only accurate for
+// the single routine handing batches over.
+func (p *pacer) holding() bool {
+	return p.pending.Load() != 0
+}
+
+// hold calls deliver after delay, and after the batches held before.
+func (p *pacer) hold(delay time.Duration, deliver func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = append(p.batches, pacedBatch{time.Now().Add(delay), deliver})
+	if p.pending.Add(1) == 1 {
+		go p.run()
+	}
+}
+
+// run delivers the batches held, until there are none left.
+func (p *pacer) run() {
+	for {
+		p.mu.Lock()
+		batch := p.batches[0]
+		p.mu.Unlock()
+		time.Sleep(time.Until(batch.due))
+		batch.deliver()
+		// only dequeued now, so that holding reports it until delivered
+		p.mu.Lock()
+		p.batches[0] = pacedBatch{}
+		p.batches = p.batches[1:]
+		p.mu.Unlock()
+		if p.pending.Add(-1) == 0 {
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var b tokenBucket
+	if delay, ok := b.reserve(1 << 20); !ok || delay != 0 {
+		t.Fatalf("unlimited bucket: %v, %v", delay, ok)
+	}
+
+	b.setRate(8_000_000) // 1 MB/s: 65535 bytes of burst, 250 kB of backlog
+	if delay, ok := b.reserve(MaxMessageSize); !ok || delay != 0 {
+		t.Errorf("burst: %v, %v", delay, ok)
+	}
+	if delay, ok := b.reserve(100_000); !ok || delay < 90*time.Millisecond || delay > 110*time.Millisecond {
+		t.Errorf("held back %v, %v, want 100ms", delay, ok)
+	}
+	if delay, ok := b.reserve(140_000); !ok || delay > RateLimitBacklog {
+		t.Errorf("held back %v, %v, want up to the backlog", delay, ok)
+	}
+	if _, ok := b.reserve(20_000); ok {
+		t.Error("not dropped beyond the backlog")
+	}
+
+	b.setRate(0)
+	if delay, ok := b.reserve(1 << 20); !ok || delay != 0 {
+		t.Errorf("limit removed: %v, %v", delay, ok)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	var p pacer
+	delivered := make(chan int, 2)
+	start := time.Now()
+	p.hold(20*time.Millisecond, func() { delivered <- 1 })
+	if !p.holding() {
+		t.Fatal("pacer not holding a batch")
+	}
+	p.hold(0, func() { delivered <- 2 })
+	if elapsed := time.Since(start); elapsed >= 20*time.Millisecond {
+		t.Errorf("hold took %v", elapsed)
+	}
+	if first, second := <-delivered, <-delivered; first != 1 || second != 2 {
+		t.Errorf("delivered %d, %d", first, second)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("delivered after %v", elapsed)
+	}
+	for p.holding() {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+
+	// 1 bit/s leaves room for the burst only
+	receiver := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ntx_rate_limit=1\nrx_rate_limit=8000000\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	config, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(config, "\ntx_rate_limit=1\nrx_rate_limit=8000000\n") {
+		t.Errorf("rate limits missing from get:\n%s", config)
+	}
+
+	ping := tuntest.Ping(pair[0].ip, pair[1].ip)
+	sent := 2 * MaxMessageSize / len(ping)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-pair[0].tun.Inbound:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for range sent {
+		pair[1].tun.Outbound <- ping
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for peerStat(t, pair[1].dev, "tx_drop_rate_limit") == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no packets dropped by the send rate limit")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pair[1].dev.IpcSet(fmt.Sprintf("public_key=%x\ntx_rate_limit=0\nrx_rate_limit=0\n", receiver[:])); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := pair[1].dev.IpcGet(); strings.Contains(config, "\ntx_rate_limit=") || strings.Contains(config, "\nrx_rate_limit=") {
+		t.Errorf("rate limits still set:\n%s", config)
+	}
+}
@@ -0,0 +1,105 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+)
+
+// PeerState is the runtime state of a peer that the device learns rather than
+// being configured with. It carries no private, preshared or session keys, so it
+// can be persisted across restarts.
+type PeerState struct {
+	PublicKey  string `json:"public_key"`                // base64, identifies the peer
+	Endpoint   string `json:"endpoint,omitempty"`        // last known endpoint, possibly roamed to
+	ResolvedIP string `json:"dns_resolved_ip,omitempty"` // last address the DNS monitor resolved
+	RxBytes    uint64 `json:"rx_bytes"`
+	TxBytes    uint64 `json:"tx_bytes"`
+
+	Quota *QuotaUsage `json:"quota,omitempty"` // nil if the peer has no quota
+}
+
+// State returns the learned state of every peer.
+func (device *Device) State() []PeerState {
+	var monitored map[NoisePublicKey]*MonitoredPeerInfo
+	if device.dnsMonitor != nil {
+		monitored = device.dnsMonitor.GetMonitoredPeers()
+	}
+
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+
+	states := make([]PeerState, 0, len(device.peers.keyMap))
+	for key, peer := range device.peers.keyMap {
+		state := PeerState{
+			PublicKey: base64.StdEncoding.EncodeToString(key[:]),
+			RxBytes:   peer.rxBytes.Load(),
+			TxBytes:   peer.txBytes.Load(),
+			Quota:     peer.quotaUsage(),
+		}
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			state.Endpoint = peer.endpoint.val.DstToString()
+		}
+		peer.endpoint.Unlock()
+		if info, ok := monitored[key]; ok {
+			state.ResolvedIP = info.LastResolvedIP
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// RestoreState applies previously saved peer state to the configured peers and
+// returns how many peers it matched. Counters are added to the current ones,
+// as is quota usage for periods that have not ended yet.
+// A saved endpoint is only restored for a peer that has none, so that endpoints
+// from the configuration take precedence over what a peer last roamed to, and a
+// saved DNS address only fills in a monitored peer that has not resolved yet.
+// State of peers that no longer exist is ignored.
+func (device *Device) RestoreState(states []PeerState) int {
+	restored := 0
+	for _, state := range states {
+		b, err := base64.StdEncoding.DecodeString(state.PublicKey)
+		if err != nil || len(b) != NoisePublicKeySize {
+			device.logs.device.Errorf("Invalid public key in saved state: %q", state.PublicKey)
+			continue
+		}
+		var key NoisePublicKey
+		copy(key[:], b)
+
+		peer := device.LookupPeer(key)
+		if peer == nil {
+			continue
+		}
+		restored++
+
+		peer.restoreTraffic(state.RxBytes, state.TxBytes)
+		if state.Quota != nil {
+			peer.restoreQuotaUsage(state.Quota)
+		}
+
+		if state.ResolvedIP != "" && device.dnsMonitor != nil {
+			device.dnsMonitor.restoreResolvedIP(key, state.ResolvedIP)
+		}
+
+		if state.Endpoint == "" {
+			continue
+		}
+		endpoint, err := device.net.bind.ParseEndpoint(state.Endpoint)
+		if err != nil {
+			device.logs.device.Errorf("%v - Invalid endpoint in saved state %q: %v", peer, state.Endpoint, err)
+			continue
+		}
+		peer.endpoint.Lock()
+		if peer.endpoint.val == nil {
+			peer.endpoint.val = endpoint
+			device.logs.device.Verbosef("%v - Restored endpoint %s", peer, state.Endpoint)
+		}
+		peer.endpoint.Unlock()
+	}
+	return restored
+}
@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestPeerStateRestore(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	states := pair[0].dev.State()
+	if len(states) != 1 {
+		t.Fatalf("expected state of 1 peer, got %d", len(states))
+	}
+	saved := states[0]
+	if saved.Endpoint == "" || saved.RxBytes == 0 || saved.TxBytes == 0 {
+		t.Fatalf("incomplete peer state %+v", saved)
+	}
+	key, err := base64.StdEncoding.DecodeString(saved.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted device that only knows the peer's key
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelError, ""))
+	defer dev.Close()
+	if err := dev.IpcSet("public_key=" + hex.EncodeToString(key) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	dev.LookupPeer(NoisePublicKey(key)).rxBytes.Add(1)
+
+	unknown := PeerState{PublicKey: base64.StdEncoding.EncodeToString(make([]byte, NoisePublicKeySize)), Endpoint: "127.0.0.1:1"}
+	if restored := dev.RestoreState([]PeerState{saved, unknown}); restored != 1 {
+		t.Errorf("expected 1 restored peer, got %d", restored)
+	}
+
+	states = dev.State()
+	if len(states) != 1 {
+		t.Fatalf("expected state of 1 peer, got %d", len(states))
+	}
+	if states[0].Endpoint != saved.Endpoint {
+		t.Errorf("expected restored endpoint %s, got %s", saved.Endpoint, states[0].Endpoint)
+	}
+	if states[0].RxBytes != saved.RxBytes+1 || states[0].TxBytes != saved.TxBytes {
+		t.Errorf("expected counters to accumulate, got rx %d tx %d from saved rx %d tx %d",
+			states[0].RxBytes, states[0].TxBytes, saved.RxBytes, saved.TxBytes)
+	}
+
+	// a configured endpoint wins over a restored one
+	if err := dev.IpcSet("public_key=" + hex.EncodeToString(key) + "\nendpoint=127.0.0.1:2\n"); err != nil {
+		t.Fatal(err)
+	}
+	dev.RestoreState([]PeerState{saved})
+	if endpoint := dev.State()[0].Endpoint; endpoint != "127.0.0.1:2" {
+		t.Errorf("configured endpoint overwritten by saved state: %s", endpoint)
+	}
+}
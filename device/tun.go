@@ -14,17 +14,17 @@ import (
 const DefaultMTU = 1420
 
 func (device *Device) RoutineTUNEventReader() {
-	device.log.Verbosef("Routine: event worker - started")
+	device.logs.routine.Verbosef("Routine: event worker - started")
 
 	for event := range device.tun.device.Events() {
 		if event&tun.EventMTUUpdate != 0 {
 			mtu, err := device.tun.device.MTU()
 			if err != nil {
-				device.log.Errorf("Failed to load updated MTU of device: %v", err)
+				device.logs.tun.Errorf("Failed to load updated MTU of device: %v", err)
 				continue
 			}
 			if mtu < 0 {
-				device.log.Errorf("MTU not updated to negative value: %v", mtu)
+				device.logs.tun.Errorf("MTU not updated to negative value: %v", mtu)
 				continue
 			}
 			var tooLarge string
@@ -34,20 +34,21 @@ func (device *Device) RoutineTUNEventReader() {
 			}
 			old := device.tun.mtu.Swap(int32(mtu))
 			if int(old) != mtu {
-				device.log.Verbosef("MTU updated: %v%s", mtu, tooLarge)
+				device.logs.tun.Infof("MTU updated: %v%s", mtu, tooLarge)
+				device.emit(Event{Type: EventMTUUpdated, MTU: mtu})
 			}
 		}
 
 		if event&tun.EventUp != 0 {
-			device.log.Verbosef("Interface up requested")
+			device.logs.tun.Verbosef("Interface up requested")
 			device.Up()
 		}
 
 		if event&tun.EventDown != 0 {
-			device.log.Verbosef("Interface down requested")
+			device.logs.tun.Verbosef("Interface down requested")
 			device.Down()
 		}
 	}
 
-	device.log.Verbosef("Routine: event worker - stopped")
+	device.logs.routine.Verbosef("Routine: event worker - stopped")
 }
@@ -9,11 +9,16 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -50,28 +55,36 @@ var byteBufferPool = &sync.Pool{
 // IpcGetOperation implements the WireGuard configuration protocol "get" operation.
 // See https://www.wireguard.com/xplatform/#configuration-protocol for details.
 func (device *Device) IpcGetOperation(w io.Writer) error {
-	device.ipcMutex.RLock()
-	defer device.ipcMutex.RUnlock()
-
 	buf := byteBufferPool.Get().(*bytes.Buffer)
 	buf.Reset()
 	defer byteBufferPool.Put(buf)
-	sendf := func(format string, args ...any) {
-		fmt.Fprintf(buf, format, args...)
-		buf.WriteByte('\n')
-	}
-	keyf := func(prefix string, key *[32]byte) {
-		buf.Grow(len(key)*2 + 2 + len(prefix))
-		buf.WriteString(prefix)
-		buf.WriteByte('=')
-		const hex = "0123456789abcdef"
-		for i := 0; i < len(key); i++ {
-			buf.WriteByte(hex[key[i]>>4])
-			buf.WriteByte(hex[key[i]&0xf])
-		}
-		buf.WriteByte('\n')
+	query := new(ipcGetQuery)
+	device.ipcMutex.RLock()
+	peers := device.ipcGetDevice(buf, query)
+	device.ipcMutex.RUnlock()
+	device.ipcGetPeers(buf, query, peers)
+
+	// send lines (does not require resource locks)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
 	}
+	return nil
+}
 
+// ipcGetOperation serializes what query selects to buf, for callers holding the
+// ipcMutex throughout.
+func (device *Device) ipcGetOperation(buf *bytes.Buffer, query *ipcGetQuery) {
+	device.ipcGetPeers(buf, query, device.ipcGetDevice(buf, query))
+}
+
+// ipcGetDevice serializes the device section of what query selects to buf, and
+// returns the peers selected, for ipcGetPeers. The caller must hold the
+// ipcMutex, and may release it before ipcGetPeers, so that serializing a large
+// peer set does not hold up set operations.
+func (device *Device) ipcGetDevice(buf *bytes.Buffer, query *ipcGetQuery) []*Peer {
+	sendf, keyf := ipcLineWriters(buf, query.vendorPrefix)
+
+	var peers []*Peer
 	func() {
 		// lock required resources
 
@@ -98,77 +111,341 @@ func (device *Device) IpcGetOperation(w io.Writer) error {
 			sendf("fwmark=%d", device.net.fwmark)
 		}
 
+		if fw := device.firewall.Load(); fw != nil {
+			fw.writeConfig(sendf)
+		}
+
+		if c := device.capture.Load(); c != nil {
+			c.writeConfig(sendf)
+		}
+
+		if levels := device.logLevels.Load(); levels != nil {
+			levels.writeConfig(sendf)
+		}
+
 		// Output DNS monitoring information
 		if device.dnsMonitor != nil {
 			sendf("dns_monitor_interval=%d", int(device.dnsMonitor.GetMonitorInterval().Seconds()))
 
-			monitoredPeers := device.dnsMonitor.GetMonitoredPeers()
-			if len(monitoredPeers) > 0 {
+			if monitoredPeers := device.dnsMonitor.GetMonitoredPeers(); len(monitoredPeers) > 0 {
 				sendf("dns_monitored_peers=%d", len(monitoredPeers))
 			}
 		}
 
-		for _, peer := range device.peers.keyMap {
-			// Serialize peer state.
+		var remaining int
+		peers, remaining = device.selectPeers(query)
+		if query.filtered {
+			sendf("generation=%d", device.generation())
+			sendf("peer_count=%d", len(device.peers.keyMap))
+			if query.limited {
+				sendf("remaining_peers=%d", remaining)
+			}
+		}
+	}()
+	return peers
+}
+
+// ipcLineWriters returns functions writing to buf a line of a get operation,
+// with vendorPrefix before extension keys if vendorPrefixed, and a line with a
+// key in hex.
+func ipcLineWriters(buf *bytes.Buffer, vendorPrefixed bool) (sendf func(format string, args ...any), keyf func(prefix string, key *[32]byte)) {
+	sendf = func(format string, args ...any) {
+		if key, _, _ := strings.Cut(format, "="); vendorPrefixed && isExtensionKey(key) {
+			buf.WriteString(vendorPrefix)
+		}
+		fmt.Fprintf(buf, format, args...)
+		buf.WriteByte('\n')
+	}
+	keyf = func(prefix string, key *[32]byte) {
+		buf.Grow(len(key)*2 + 2 + len(prefix))
+		buf.WriteString(prefix)
+		buf.WriteByte('=')
+		const hex = "0123456789abcdef"
+		for i := 0; i < len(key); i++ {
+			buf.WriteByte(hex[key[i]>>4])
+			buf.WriteByte(hex[key[i]&0xf])
+		}
+		buf.WriteByte('\n')
+	}
+	return sendf, keyf
+}
+
+// ipcGetPeers serializes peers to buf, each under its own locks, so that a
+// large peer set does not hold up others needing device.peers or the
+// ipcMutex. A peer removed in the meantime is serialized as it was left.
+func (device *Device) ipcGetPeers(buf *bytes.Buffer, query *ipcGetQuery, peers []*Peer) {
+	sendf, keyf := ipcLineWriters(buf, query.vendorPrefix)
+
+	var monitoredPeers map[NoisePublicKey]*MonitoredPeerInfo
+	if device.dnsMonitor != nil && len(peers) > 0 {
+		monitoredPeers = device.dnsMonitor.GetMonitoredPeers()
+	}
+	for _, peer := range peers {
+		if query.countersOnly[peer] {
 			peer.handshake.mutex.RLock()
 			keyf("public_key", (*[32]byte)(&peer.handshake.remoteStatic))
-			keyf("preshared_key", (*[32]byte)(&peer.handshake.presharedKey))
 			peer.handshake.mutex.RUnlock()
-			sendf("protocol_version=1")
-			peer.endpoint.Lock()
-			if peer.endpoint.val != nil {
-				sendf("endpoint=%s", peer.endpoint.val.DstToString())
+			sendf("counters_only=true")
+			sendf("tx_bytes=%d", peer.txBytes.Load())
+			sendf("rx_bytes=%d", peer.rxBytes.Load())
+			peer.writeQuota(sendf)
+			if !query.omitStats {
+				peer.writeStats(sendf)
 			}
-			peer.endpoint.Unlock()
+			continue
+		}
 
-			nano := peer.lastHandshakeNano.Load()
-			secs := nano / time.Second.Nanoseconds()
-			nano %= time.Second.Nanoseconds()
+		// Serialize peer state.
+		peer.handshake.mutex.RLock()
+		keyf("public_key", (*[32]byte)(&peer.handshake.remoteStatic))
+		keyf("preshared_key", (*[32]byte)(&peer.handshake.presharedKey))
+		peer.handshake.mutex.RUnlock()
+		sendf("protocol_version=1")
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			sendf("endpoint=%s", peer.endpoint.val.DstToString())
+		}
+		peer.endpoint.Unlock()
+
+		nano := peer.lastHandshakeNano.Load()
+		secs := nano / time.Second.Nanoseconds()
+		nano %= time.Second.Nanoseconds()
+
+		sendf("last_handshake_time_sec=%d", secs)
+		sendf("last_handshake_time_nsec=%d", nano)
+		sendf("tx_bytes=%d", peer.txBytes.Load())
+		sendf("rx_bytes=%d", peer.rxBytes.Load())
+		sendf("persistent_keepalive_interval=%d", peer.persistentKeepaliveInterval.Load())
+		if rate := peer.shaping.tx.rate.Load(); rate != 0 {
+			sendf("tx_rate_limit=%d", rate)
+		}
+		if rate := peer.shaping.rx.rate.Load(); rate != 0 {
+			sendf("rx_rate_limit=%d", rate)
+		}
+		peer.writeQuota(sendf)
+		if !query.omitStats {
+			peer.writeStats(sendf)
+		}
 
-			sendf("last_handshake_time_sec=%d", secs)
-			sendf("last_handshake_time_nsec=%d", nano)
-			sendf("tx_bytes=%d", peer.txBytes.Load())
-			sendf("rx_bytes=%d", peer.rxBytes.Load())
-			sendf("persistent_keepalive_interval=%d", peer.persistentKeepaliveInterval.Load())
+		if info, ok := monitoredPeers[peer.handshake.remoteStatic]; ok {
+			sendf("dns_hostname=%s", info.OriginalHost)
+			sendf("dns_port=%s", info.Port)
+			if info.LastResolvedIP != "" {
+				sendf("dns_last_resolved_ip=%s", info.LastResolvedIP)
+			}
+			if !info.LastCheckTime.IsZero() {
+				sendf("dns_last_check_time_sec=%d", info.LastCheckTime.Unix())
+			}
+			sendf("dns_resolution_failures=%d", info.ResolutionFails)
+		}
 
+		if !query.omitAllowedIPs {
 			device.allowedips.EntriesForPeer(peer, func(prefix netip.Prefix) bool {
 				sendf("allowed_ip=%s", prefix.String())
 				return true
 			})
 		}
-	}()
-
-	// send lines (does not require resource locks)
-	if _, err := w.Write(buf.Bytes()); err != nil {
-		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
 	}
+}
 
-	return nil
+// writeStats serializes the counters of the peer beyond tx_bytes and rx_bytes.
+func (peer *Peer) writeStats(sendf func(format string, args ...any)) {
+	stats := &peer.stats
+	sendf("tx_packets=%d", stats.txPackets.Load())
+	sendf("rx_packets=%d", stats.rxPackets.Load())
+	if nano := stats.lastRxNano.Load(); nano != 0 {
+		sendf("last_rx_time_sec=%d", nano/time.Second.Nanoseconds())
+		sendf("last_rx_time_nsec=%d", nano%time.Second.Nanoseconds())
+	}
+	sendf("rx_drop_replay=%d", stats.rxDropReplay.Load())
+	sendf("rx_drop_allowed_ips=%d", stats.rxDropAllowedIPs.Load())
+	sendf("rx_drop_decrypt=%d", stats.rxDropDecrypt.Load())
+	sendf("rx_drop_malformed=%d", stats.rxDropMalformed.Load())
+	sendf("tx_drop_staged_overflow=%d", stats.txDropStagedOverflow.Load())
+	sendf("tx_drop_not_running=%d", stats.txDropNotRunning.Load())
+	sendf("tx_drop_rate_limit=%d", stats.txDropRateLimit.Load())
+	sendf("rx_drop_rate_limit=%d", stats.rxDropRateLimit.Load())
+	sendf("tx_drop_quota=%d", stats.txDropQuota.Load())
+	sendf("rx_drop_quota=%d", stats.rxDropQuota.Load())
+	sendf("tx_drop_firewall=%d", stats.txDropFirewall.Load())
+	sendf("rx_drop_firewall=%d", stats.rxDropFirewall.Load())
+	sendf("handshake_initiations=%d", stats.handshakeInitiations.Load())
+	sendf("handshake_retries=%d", stats.handshakeRetries.Load())
+	sendf("handshake_responses=%d", stats.handshakeResponsesRecv.Load())
+	if keypair := peer.keypairs.Current(); keypair != nil {
+		sendf("keypair_age_sec=%d", int64(time.Since(keypair.created).Seconds()))
+	}
 }
 
 // IpcSetOperation implements the WireGuard configuration protocol "set" operation.
 // See https://www.wireguard.com/xplatform/#configuration-protocol for details.
+// The whole operation is parsed and validated before any of it takes effect,
+// and if binding the new listen_port or fwmark fails, what took effect is
+// rolled back. With dry_run=true, nothing takes effect.
 func (device *Device) IpcSetOperation(r io.Reader) (err error) {
+	return device.ipcSetOperation(r, io.Discard, "internal", nil)
+}
+
+// ipcSetOperation is IpcSetOperation writing the changes of a dry run to w, and
+// recording its source, "uapi" or "internal", and caller, if known, in the
+// audit log.
+func (device *Device) ipcSetOperation(r io.Reader, w io.Writer, source string, caller *AuditCaller) (err error) {
+	// read the request and resolve its domain endpoints before holding up
+	// other operations
+	lines, readErr := readIpcSet(r)
+	resolved := resolveEndpoints(lines)
+
 	device.ipcMutex.Lock()
 	defer device.ipcMutex.Unlock()
+	if device.handingOver() {
+		return ipcErrorf(ipc.IpcErrorIO, "device is being handed over to another process")
+	}
+
+	tx := device.newIpcSet()
+	tx.resolved = resolved
+	// the configuration tx touches, and what its steps make of it, for dry_run
+	// and the audit log; nil if nothing changes
+	var before, after auditSnapshot
+	audit := device.auditLog.Load()
+	if audit != nil {
+		defer func() {
+			audit.write(device, caller, source, tx.dryRun, before, after, err)
+		}()
+	}
 
 	defer func() {
 		if err != nil {
-			device.log.Errorf("%v", err)
+			device.logs.uapi.Errorf("%v", err)
 		}
 	}()
 
-	peer := new(ipcSetPeer)
-	deviceConfig := true
+	if err := device.parseIpcSet(tx, lines); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if tx.dryRun {
+		before = device.auditSnapshot(tx.touchedPeers())
+		after = tx.simulate(before)
+		if err := writeDryRun(w, auditChanges(before, after)); err != nil {
+			return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+		}
+		return nil
+	}
+	var snapshot auditSnapshot
+	if audit != nil {
+		snapshot = device.auditSnapshot(tx.touchedPeers())
+	}
+	err = device.applyIpcSet(tx)
+	if audit != nil && (err == nil || err == tx.applyErr) {
+		// the steps took effect, unlike failures rolled back
+		before, after = snapshot, tx.simulate(snapshot)
+	}
+	return err
+}
+
+// An ipcSet is a parsed and validated IPC set operation. Most of it is a list of
+// steps taking effect in order. Binding to listen_port and fwmark and creating
+// peers, which may fail, take effect first, and the keys that must take effect
+// together, at the end.
+type ipcSet struct {
+	dryRun     bool
+	listenPort *uint16
+	fwmark     *uint32
+	steps      []ipcSetStep
+	firewall   ipcSetFirewall
+	capture    ipcSetCapture
+	logLevels  *deviceLogLevels // nil unless changed
+	resolved   map[string]resolvedEndpoint
+	created    []*ipcSetPeer           // the peers created, in order
+	kept       map[NoisePublicKey]bool // peers created before the steps, which replace_peers keeps
+	applyErr   error                   // a step that failed
+	touched    map[NoisePublicKey]bool // the peers the steps change, unless replaced
+
+	// what the steps so far change, for validating the next ones
+	privateKey NoisePrivateKey
+	publicKey  NoisePublicKey
+	peers      map[NoisePublicKey]bool // whether each peer exists, if changed
+	replaced   bool                    // peers not in peers were removed
+	peerCount  int
+}
+
+// An ipcSetStep takes effect with apply, and describes its effect on the
+// configuration of the device with simulate, for dry runs. Either may be nil.
+type ipcSetStep struct {
+	apply    func()
+	simulate func(auditSnapshot)
+}
+
+func (device *Device) newIpcSet() *ipcSet {
+	tx := &ipcSet{peers: make(map[NoisePublicKey]bool), touched: make(map[NoisePublicKey]bool)}
+	device.staticIdentity.RLock()
+	tx.privateKey = device.staticIdentity.privateKey
+	tx.publicKey = device.staticIdentity.publicKey
+	device.staticIdentity.RUnlock()
+	device.peers.RLock()
+	tx.peerCount = len(device.peers.keyMap)
+	device.peers.RUnlock()
+	return tx
+}
+
+func (tx *ipcSet) step(apply func(), simulate func(auditSnapshot)) {
+	tx.steps = append(tx.steps, ipcSetStep{apply, simulate})
+}
+
+// peerExists reports whether a peer exists once the steps so far take effect.
+func (tx *ipcSet) peerExists(device *Device, publicKey NoisePublicKey) bool {
+	if exists, ok := tx.peers[publicKey]; ok {
+		return exists
+	}
+	return !tx.replaced && device.LookupPeer(publicKey) != nil
+}
+
+// touchedPeers returns the peers whose configuration tx may change, or nil if
+// it replaces all.
+func (tx *ipcSet) touchedPeers() map[NoisePublicKey]bool {
+	if tx.replaced {
+		return nil
+	}
+	return tx.touched
+}
+
+func (tx *ipcSet) setPeerExists(publicKey NoisePublicKey, exists bool) {
+	if exists {
+		tx.peerCount++
+	} else {
+		tx.peerCount--
+	}
+	tx.peers[publicKey] = exists
+}
 
+// readIpcSet reads the lines of an IPC set operation, up to a blank line, which
+// means the operation ends, or the end of r. On a read error, it returns the
+// lines read so far, which are parsed before the error is reported.
+func readIpcSet(r io.Reader) ([]string, error) {
+	var lines []string
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if line == "" {
-			// Blank line means terminate operation.
-			peer.handlePostConfig()
-			return nil
+			return lines, nil
 		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return lines, ipcErrorf(ipc.IpcErrorIO, "failed to read input: %w", err)
+	}
+	return lines, nil
+}
+
+// parseIpcSet parses the lines of an IPC set operation into tx, validating
+// them all without changing the device.
+func (device *Device) parseIpcSet(tx *ipcSet, lines []string) error {
+	peer := &ipcSetPeer{dummy: true}
+	deviceConfig := true
+
+	for _, line := range lines {
 		key, value, ok := strings.Cut(line, "=")
 		if !ok {
 			return ipcErrorf(ipc.IpcErrorProtocol, "failed to parse line %q", line)
@@ -178,9 +455,10 @@ func (device *Device) IpcSetOperation(r io.Reader) (err error) {
 			if deviceConfig {
 				deviceConfig = false
 			}
-			peer.handlePostConfig()
+			tx.handlePostConfig(peer)
 			// Load/create the peer we are now configuring.
-			err := device.handlePublicKeyLine(peer, value)
+			var err error
+			peer, err = device.handlePublicKeyLine(tx, value)
 			if err != nil {
 				return err
 			}
@@ -189,23 +467,310 @@ func (device *Device) IpcSetOperation(r io.Reader) (err error) {
 
 		var err error
 		if deviceConfig {
-			err = device.handleDeviceLine(key, value)
+			err = device.handleDeviceLine(tx, unprefixedKey(key, deviceExtensions), value)
 		} else {
-			err = device.handlePeerLine(peer, key, value)
+			err = device.handlePeerLine(tx, peer, unprefixedKey(key, peerExtensions), value)
 		}
 		if err != nil {
 			return err
 		}
 	}
-	peer.handlePostConfig()
+	tx.handlePostConfig(peer)
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return ipcErrorf(ipc.IpcErrorIO, "failed to read input: %w", err)
+// applyIpcSet makes tx take effect.
+func (device *Device) applyIpcSet(tx *ipcSet) error {
+	// take the steps that may fail first, undoing them if one does
+	var undo []func()
+	fail := func(err error) error {
+		for i := len(undo) - 1; i >= 0; i-- {
+			undo[i]()
+		}
+		return err
 	}
-	return nil
+	if tx.listenPort != nil {
+		device.logs.uapi.Verbosef("UAPI: Updating listen port")
+		device.net.Lock()
+		old := device.net.port
+		device.net.port = *tx.listenPort
+		device.net.Unlock()
+		rebind := func() {
+			device.net.Lock()
+			device.net.port = old
+			device.net.Unlock()
+			if err := device.BindUpdate(); err != nil {
+				device.logs.uapi.Errorf("UAPI: Failed to rebind to listen port %d: %v", old, err)
+			}
+		}
+		if err := device.BindUpdate(); err != nil {
+			rebind()
+			if errors.Is(err, os.ErrPermission) && *tx.listenPort < 1024 {
+				return fail(ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen_port: binding port %d needs CAP_NET_BIND_SERVICE: %w", *tx.listenPort, err))
+			}
+			return fail(ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen_port: %w", err))
+		}
+		undo = append(undo, rebind)
+	}
+	if tx.fwmark != nil {
+		device.logs.uapi.Verbosef("UAPI: Updating fwmark")
+		device.net.RLock()
+		old := device.net.fwmark
+		device.net.RUnlock()
+		remark := func() {
+			if err := device.BindSetMark(old); err != nil {
+				device.logs.uapi.Errorf("UAPI: Failed to restore fwmark %d: %v", old, err)
+			}
+		}
+		if err := device.BindSetMark(*tx.fwmark); err != nil {
+			remark()
+			if errors.Is(err, os.ErrPermission) {
+				return fail(ipcErrorf(ipc.IpcErrorPortInUse, "failed to update fwmark: setting it needs CAP_NET_ADMIN: %w", err))
+			}
+			return fail(ipcErrorf(ipc.IpcErrorPortInUse, "failed to update fwmark: %w", err))
+		}
+		undo = append(undo, remark)
+	}
+	// create the new peers, unless they replace peers the steps remove
+	for _, peer := range tx.created {
+		if peer.discarded || device.LookupPeer(peer.publicKey) != nil {
+			continue
+		}
+		created, err := device.NewPeer(peer.publicKey)
+		if err != nil {
+			return fail(ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: %w", err))
+		}
+		device.logs.uapi.Infof("%v - UAPI: Created", created)
+		peer.Peer = created
+		if tx.kept == nil {
+			tx.kept = make(map[NoisePublicKey]bool)
+		}
+		tx.kept[peer.publicKey] = true
+		undo = append(undo, func() { device.RemovePeer(peer.publicKey) })
+	}
+	var capture *packetCapture
+	if c := &tx.capture; c.changed && c.file != "" {
+		var err error
+		if capture, err = device.openCapture(c.file, c.filter, c.maxBytes); err != nil {
+			return fail(ipcErrorf(ipc.IpcErrorIO, "failed to start capture: %w", err))
+		}
+	}
+
+	for _, step := range tx.steps {
+		if step.apply != nil {
+			step.apply()
+		}
+	}
+
+	if firewall := &tx.firewall; firewall.changed {
+		device.logs.uapi.Verbosef("UAPI: Updating firewall")
+		device.setFirewall(firewall.accept, firewall.rules)
+	}
+	if tx.capture.changed {
+		if capture != nil {
+			device.installCapture(capture)
+		} else {
+			device.stopCapture()
+		}
+	}
+	if tx.logLevels != nil {
+		device.logLevelsMu.Lock()
+		device.logLevels.Store(tx.logLevels)
+		device.logLevelsMu.Unlock()
+	}
+	return tx.applyErr
 }
 
-func (device *Device) handleDeviceLine(key, value string) error {
+// simulate returns the configuration of the device after tx, given before.
+func (tx *ipcSet) simulate(before auditSnapshot) auditSnapshot {
+	s := make(auditSnapshot, len(before))
+	for peer, values := range before {
+		s[peer] = make(map[string][]string, len(values))
+		for key, value := range values {
+			s[peer][key] = slices.Clone(value)
+		}
+	}
+	config := s[NoisePublicKey{}]
+	setUint := func(key string, value uint64) {
+		if value == 0 {
+			delete(config, key)
+		} else {
+			config[key] = []string{strconv.FormatUint(value, 10)}
+		}
+	}
+
+	if tx.listenPort != nil {
+		setUint("listen_port", uint64(*tx.listenPort))
+	}
+	if tx.fwmark != nil {
+		setUint("fwmark", uint64(*tx.fwmark))
+	}
+	for _, step := range tx.steps {
+		if step.simulate != nil {
+			step.simulate(s)
+		}
+	}
+	if firewall := &tx.firewall; firewall.changed {
+		delete(config, "firewall_policy")
+		delete(config, "firewall_rule")
+		if !firewall.accept || len(firewall.rules) > 0 {
+			config["firewall_policy"] = []string{map[bool]string{true: "accept", false: "drop"}[firewall.accept]}
+			for i := range firewall.rules {
+				config["firewall_rule"] = append(config["firewall_rule"], fmt.Sprintf("%v", &firewall.rules[i]))
+			}
+		}
+	}
+	if c := &tx.capture; c.changed {
+		delete(config, "capture_file")
+		delete(config, "capture_filter")
+		delete(config, "capture_max_bytes")
+		if c.file != "" {
+			config["capture_file"] = []string{c.file}
+			if filter := c.filter.String(); filter != "" {
+				config["capture_filter"] = []string{filter}
+			}
+			setUint("capture_max_bytes", c.maxBytes)
+		}
+	}
+	if tx.logLevels != nil {
+		delete(config, "log_level")
+		delete(config, "log_peer")
+		tx.logLevels.writeConfig(func(format string, args ...any) {
+			key, value, _ := strings.Cut(fmt.Sprintf(format, args...), "=")
+			config[key] = append(config[key], value)
+		})
+	}
+	return s
+}
+
+// writeDryRun writes the changes a dry run would make, each starting with a
+// change line naming the key, then public_key for peer keys, then old and new
+// unless unset.
+func writeDryRun(w io.Writer, changes []auditChange) error {
+	var b []byte
+	add := func(key, value string) {
+		b = append(b, key...)
+		b = append(b, '=')
+		b = append(b, value...)
+		b = append(b, '\n')
+	}
+	for _, change := range changes {
+		add("change", change.Key)
+		if change.Peer != "" {
+			peer, _ := base64.StdEncoding.DecodeString(change.Peer)
+			add("public_key", hex.EncodeToString(peer))
+		}
+		if change.Old != "" {
+			add("old", change.Old)
+		}
+		if change.New != "" {
+			add("new", change.New)
+		}
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// deviceExtensions and peerExtensions parse the keys of the set operation
+// beyond those of the protocol, which the capabilities operation announces.
+var (
+	deviceExtensions = map[string]func(device *Device, tx *ipcSet, key, value string) error{
+		"dns_monitor_interval": func(device *Device, tx *ipcSet, key, value string) error {
+			// Set DNS monitoring interval in seconds
+			interval, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "invalid DNS monitor interval: %w", err)
+			}
+
+			intervalDuration := time.Duration(interval) * time.Second
+			if intervalDuration < 10*time.Second {
+				return ipcErrorf(ipc.IpcErrorInvalid, "DNS monitor interval must be at least 10 seconds")
+			}
+
+			tx.step(func() {
+				device.SetDNSMonitorInterval(intervalDuration)
+				device.logs.uapi.Verbosef("UAPI: DNS monitor interval set to %d seconds", interval)
+			}, func(s auditSnapshot) {
+				if device.dnsMonitor != nil {
+					s[NoisePublicKey{}]["dns_monitor_interval"] = []string{strconv.FormatUint(interval, 10)}
+				}
+			})
+			return nil
+		},
+		"dns_resolve": func(device *Device, tx *ipcSet, key, value string) error {
+			// Have the DNS monitor re-check all monitored peers, without waiting
+			if value != "true" {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set dns_resolve, invalid value: %v", value)
+			}
+			if device.dnsMonitor == nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "DNS monitoring is not enabled")
+			}
+			tx.step(func() {
+				device.logs.uapi.Verbosef("UAPI: Re-resolving all DNS monitored peers")
+				device.dnsMonitor.ResolveAllNow()
+			}, nil)
+			return nil
+		},
+		"firewall_policy":        (*Device).handleFirewallLine,
+		"replace_firewall_rules": (*Device).handleFirewallLine,
+		"firewall_rule":          (*Device).handleFirewallLine,
+		"capture_file": func(device *Device, tx *ipcSet, key, value string) error {
+			tx.capture.changed = true
+			tx.capture.file = value
+			return nil
+		},
+		"capture_filter": func(device *Device, tx *ipcSet, key, value string) error {
+			filter, err := ParseCaptureFilter(value)
+			if err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set capture_filter: %w", err)
+			}
+			tx.capture.filter = filter
+			return nil
+		},
+		"capture_max_bytes": func(device *Device, tx *ipcSet, key, value string) error {
+			maxBytes, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set capture_max_bytes: %w", err)
+			}
+			tx.capture.maxBytes = maxBytes
+			return nil
+		},
+		"log_level": (*Device).handleLogLevelLine,
+		"log_peer":  (*Device).handleLogLevelLine,
+		"dry_run": func(device *Device, tx *ipcSet, key, value string) error {
+			if value != "true" {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set dry_run, invalid value: %v", value)
+			}
+			tx.dryRun = true
+			return nil
+		},
+	}
+	peerExtensions = map[string]func(device *Device, tx *ipcSet, peer *ipcSetPeer, key, value string) error{
+		"dns_resolve": func(device *Device, tx *ipcSet, peer *ipcSetPeer, key, value string) error {
+			// Have the DNS monitor re-check this peer, without waiting
+			if value != "true" {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set dns_resolve, invalid value: %v", value)
+			}
+			if peer.dummy || device.dnsMonitor == nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "peer %s has no DNS monitored endpoint", base64.StdEncoding.EncodeToString(peer.publicKey[:]))
+			}
+			if _, ok := device.dnsMonitor.GetMonitoredPeers()[peer.publicKey]; !ok && !peer.domain {
+				return ipcErrorf(ipc.IpcErrorInvalid, "peer %s has no DNS monitored endpoint", base64.StdEncoding.EncodeToString(peer.publicKey[:]))
+			}
+			tx.peerStep(peer, func() {
+				device.dnsMonitor.ResolveNow(peer.publicKey)
+				device.logs.uapi.Verbosef("%v - UAPI: Requested DNS re-resolution", peer.Peer)
+			}, nil)
+			return nil
+		},
+		"tx_rate_limit": (*Device).handleLimitLine,
+		"rx_rate_limit": (*Device).handleLimitLine,
+		"daily_quota":   (*Device).handleLimitLine,
+		"monthly_quota": (*Device).handleLimitLine,
+	}
+)
+
+func (device *Device) handleDeviceLine(tx *ipcSet, key, value string) error {
 	switch key {
 	case "private_key":
 		var sk NoisePrivateKey
@@ -213,121 +778,309 @@ func (device *Device) handleDeviceLine(key, value string) error {
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set private_key: %w", err)
 		}
-		device.log.Verbosef("UAPI: Updating private key")
-		device.SetPrivateKey(sk)
-
-	case "dns_monitor_interval":
-		// Set DNS monitoring interval in seconds
-		interval, err := strconv.ParseUint(value, 10, 32)
-		if err != nil {
-			return ipcErrorf(ipc.IpcErrorInvalid, "invalid DNS monitor interval: %w", err)
-		}
-
-		intervalDuration := time.Duration(interval) * time.Second
-		if intervalDuration < 10*time.Second {
-			return ipcErrorf(ipc.IpcErrorInvalid, "DNS monitor interval must be at least 10 seconds")
+		publicKey := sk.publicKey()
+		tx.touched[publicKey] = true
+		if !sk.Equals(tx.privateKey) && tx.peerExists(device, publicKey) {
+			// SetPrivateKey removes the peer with the new public key
+			tx.setPeerExists(publicKey, false)
 		}
-
-		device.SetDNSMonitorInterval(intervalDuration)
-		device.log.Verbosef("UAPI: DNS monitor interval set to %d seconds", interval)
+		tx.privateKey, tx.publicKey = sk, publicKey
+		tx.step(func() {
+			device.logs.uapi.Verbosef("UAPI: Updating private key")
+			device.SetPrivateKey(sk)
+		}, func(s auditSnapshot) {
+			delete(s, publicKey)
+			if sk.IsZero() {
+				delete(s[NoisePublicKey{}], "private_key")
+			} else {
+				s[NoisePublicKey{}]["private_key"] = []string{hex.EncodeToString(sk[:])}
+			}
+		})
 
 	case "listen_port":
 		port, err := strconv.ParseUint(value, 10, 16)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to parse listen_port: %w", err)
 		}
-
-		// update port and rebind
-		device.log.Verbosef("UAPI: Updating listen port")
-
-		device.net.Lock()
-		device.net.port = uint16(port)
-		device.net.Unlock()
-
-		if err := device.BindUpdate(); err != nil {
-			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen_port: %w", err)
-		}
+		tx.listenPort = new(uint16)
+		*tx.listenPort = uint16(port)
 
 	case "fwmark":
 		mark, err := strconv.ParseUint(value, 10, 32)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "invalid fwmark: %w", err)
 		}
-
-		device.log.Verbosef("UAPI: Updating fwmark")
-		if err := device.BindSetMark(uint32(mark)); err != nil {
-			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to update fwmark: %w", err)
-		}
+		tx.fwmark = new(uint32)
+		*tx.fwmark = uint32(mark)
 
 	case "replace_peers":
 		if value != "true" {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set replace_peers, invalid value: %v", value)
 		}
-		device.log.Verbosef("UAPI: Removing all peers")
-		device.RemoveAllPeers()
+		clear(tx.peers)
+		tx.replaced = true
+		tx.peerCount = 0
+		tx.step(func() {
+			device.logs.uapi.Infof("UAPI: Removing all peers")
+			device.peers.Lock()
+			for key, peer := range device.peers.keyMap {
+				if !tx.kept[key] {
+					removePeerLocked(device, peer, key)
+				}
+			}
+			device.peers.Unlock()
+		}, func(s auditSnapshot) {
+			maps.DeleteFunc(s, func(peer NoisePublicKey, _ map[string][]string) bool {
+				return !peer.IsZero()
+			})
+		})
 
 	default:
-		return ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI device key: %v", key)
+		handle, ok := deviceExtensions[key]
+		if !ok {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI device key: %v", key)
+		}
+		return handle(device, tx, key, value)
+	}
+
+	return nil
+}
+
+func (device *Device) handleLogLevelLine(tx *ipcSet, key, value string) error {
+	if tx.logLevels == nil {
+		tx.logLevels = device.logLevels.Load().clone()
+	}
+	set := tx.logLevels.setLevel
+	if key == "log_peer" {
+		set = tx.logLevels.setPeer
+	}
+	if err := set(value); err != nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+	}
+	tx.step(func() {
+		if key == "log_peer" {
+			device.logs.uapi.Verbosef("UAPI: Updating log level of peer %s", value)
+		} else {
+			device.logs.uapi.Verbosef("UAPI: Updating log level %s", value)
+		}
+	}, nil)
+	return nil
+}
+
+// An ipcSetFirewall collects the firewall keys, so that no packet sees half of
+// the rules.
+type ipcSetFirewall struct {
+	changed bool
+	accept  bool
+	rules   []FirewallRule
+}
+
+func (device *Device) handleFirewallLine(tx *ipcSet, key, value string) error {
+	firewall := &tx.firewall
+	if !firewall.changed {
+		firewall.changed = true
+		firewall.accept = true
+		if fw := device.firewall.Load(); fw != nil {
+			firewall.accept = fw.accept
+			for i := range fw.rules {
+				firewall.rules = append(firewall.rules, fw.rules[i].FirewallRule)
+			}
+		}
 	}
 
+	switch key {
+	case "firewall_policy":
+		if value != "accept" && value != "drop" {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set firewall_policy, invalid value: %v", value)
+		}
+		firewall.accept = value == "accept"
+
+	case "replace_firewall_rules":
+		if value != "true" {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to replace firewall rules, invalid value: %v", value)
+		}
+		firewall.rules = nil
+
+	case "firewall_rule":
+		rule, err := ParseFirewallRule(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to add firewall rule: %w", err)
+		}
+		firewall.rules = append(firewall.rules, rule)
+	}
 	return nil
 }
 
+// An ipcSetCapture collects the capture keys, which configure the capture that
+// capture_file starts, or stops if empty.
+type ipcSetCapture struct {
+	changed  bool
+	file     string
+	filter   CaptureFilter
+	maxBytes uint64
+}
+
 // An ipcSetPeer is the current state of an IPC set operation on a peer.
 type ipcSetPeer struct {
-	*Peer        // Peer is the current peer being operated on
-	dummy   bool // dummy reports whether this peer is a temporary, placeholder peer
-	created bool // new reports whether this is a newly created peer
-	pkaOn   bool // pkaOn reports whether the peer had the persistent keepalive turn on
+	*Peer                    // Peer is the peer being operated on, once the steps take effect
+	publicKey NoisePublicKey // publicKey is the public key of the peer
+	dummy     bool           // dummy reports whether the lines for this peer are ignored
+	created   bool           // created reports whether this is a newly created peer
+	discarded bool           // discarded reports whether update_only undid the creation
+	domain    bool           // domain reports whether the endpoint is set to a domain name
+	pkaOn     bool           // pkaOn reports whether the peer had the persistent keepalive turn on
 }
 
-func (peer *ipcSetPeer) handlePostConfig() {
-	if peer.Peer == nil || peer.dummy {
-		return
-	}
-	if peer.created {
-		peer.endpoint.disableRoaming = peer.device.net.brokenRoaming && peer.endpoint.val != nil
+// peerStep adds a step on peer, which only takes effect on a peer that exists.
+func (tx *ipcSet) peerStep(peer *ipcSetPeer, apply func(), simulate func(map[string][]string)) {
+	var simulatePeer func(auditSnapshot)
+	if simulate != nil {
+		simulatePeer = func(s auditSnapshot) {
+			if values, ok := s[peer.publicKey]; ok && !peer.discarded {
+				simulate(values)
+			}
+		}
 	}
-	if peer.device.isUp() {
-		peer.Start()
-		if peer.pkaOn {
-			peer.SendKeepalive()
+	tx.step(func() {
+		if peer.Peer != nil && !peer.discarded {
+			apply()
 		}
-		peer.SendStagedPackets()
+	}, simulatePeer)
+}
+
+func (tx *ipcSet) handlePostConfig(peer *ipcSetPeer) {
+	if peer.dummy {
+		return
 	}
+	tx.peerStep(peer, func() {
+		peer.changes.Add(1)
+		if peer.created {
+			peer.endpoint.disableRoaming = peer.device.net.brokenRoaming && peer.endpoint.val != nil
+		}
+		if peer.device.isUp() {
+			peer.Start()
+			if peer.pkaOn {
+				peer.SendKeepalive()
+			}
+			peer.SendStagedPackets()
+		}
+	}, nil)
 }
 
-func (device *Device) handlePublicKeyLine(peer *ipcSetPeer, value string) error {
+func (device *Device) handlePublicKeyLine(tx *ipcSet, value string) (*ipcSetPeer, error) {
 	// Load/create the peer we are configuring.
-	var publicKey NoisePublicKey
-	err := publicKey.FromHex(value)
+	peer := new(ipcSetPeer)
+	err := peer.publicKey.FromHex(value)
 	if err != nil {
-		return ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
+		return nil, ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
 	}
 
 	// Ignore peer with the same public key as this device.
-	device.staticIdentity.RLock()
-	peer.dummy = device.staticIdentity.publicKey.Equals(publicKey)
-	device.staticIdentity.RUnlock()
-
+	peer.dummy = tx.publicKey.Equals(peer.publicKey)
 	if peer.dummy {
-		peer.Peer = &Peer{}
-	} else {
-		peer.Peer = device.LookupPeer(publicKey)
+		return peer, nil
 	}
+	tx.touched[peer.publicKey] = true
 
-	peer.created = peer.Peer == nil
+	peer.created = !tx.peerExists(device, peer.publicKey)
 	if peer.created {
-		peer.Peer, err = device.NewPeer(publicKey)
-		if err != nil {
-			return ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: %w", err)
+		if tx.peerCount >= MaxPeers {
+			return nil, ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: too many peers")
 		}
-		device.log.Verbosef("%v - UAPI: Created", peer.Peer)
+		tx.setPeerExists(peer.publicKey, true)
+		tx.created = append(tx.created, peer)
 	}
-	return nil
+	tx.step(func() {
+		switch {
+		case peer.discarded:
+		case peer.created && peer.Peer == nil:
+			// replacing a peer removed by an earlier step, which leaves room
+			peer.Peer, tx.applyErr = device.NewPeer(peer.publicKey)
+			if tx.applyErr != nil {
+				tx.applyErr = ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: %w", tx.applyErr)
+				return
+			}
+			device.logs.uapi.Infof("%v - UAPI: Created", peer.Peer)
+		case peer.created:
+		default:
+			peer.Peer = device.LookupPeer(peer.publicKey)
+		}
+	}, func(s auditSnapshot) {
+		if peer.created && !peer.discarded {
+			s[peer.publicKey] = map[string][]string{"persistent_keepalive_interval": {"0"}}
+		}
+	})
+	return peer, nil
+}
+
+// A resolvedEndpoint is a domain endpoint resolved to an IP address, or the
+// error resolving it.
+type resolvedEndpoint struct {
+	endpoint string
+	err      error
+}
+
+// resolveEndpoint resolves the domain name of endpoint, preferring IPv4.
+func resolveEndpoint(endpoint string) resolvedEndpoint {
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return resolvedEndpoint{err: ipcErrorf(ipc.IpcErrorInvalid, "invalid endpoint format %v: %w", endpoint, err)}
+	}
+
+	// Resolve domain name
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return resolvedEndpoint{err: ipcErrorf(ipc.IpcErrorInvalid, "failed to resolve domain %v: %w", host, err)}
+	}
+
+	if len(ips) == 0 {
+		return resolvedEndpoint{err: ipcErrorf(ipc.IpcErrorInvalid, "no IP addresses found for domain %v", host)}
+	}
+
+	// Prefer IPv4
+	selectedIP := ips[0]
+	for _, ip := range ips {
+		if net.ParseIP(ip).To4() != nil {
+			selectedIP = ip
+			break
+		}
+	}
+	return resolvedEndpoint{endpoint: net.JoinHostPort(selectedIP, port)}
 }
 
-func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error {
+// resolveEndpoints resolves the domain endpoints of the lines of a set
+// operation, all at once, so that parsing them does not wait on DNS.
+func resolveEndpoints(lines []string) map[string]resolvedEndpoint {
+	var endpoints []string
+	for _, line := range lines {
+		if value, ok := strings.CutPrefix(line, "endpoint="); ok && IsDomainEndpoint(value) && !slices.Contains(endpoints, value) {
+			endpoints = append(endpoints, value)
+		}
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	results := make([]resolvedEndpoint, len(endpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = resolveEndpoint(endpoint)
+		}()
+	}
+	wg.Wait()
+	resolved := make(map[string]resolvedEndpoint, len(endpoints))
+	for i, endpoint := range endpoints {
+		resolved[endpoint] = results[i]
+	}
+	return resolved
+}
+
+func (device *Device) handlePeerLine(tx *ipcSet, peer *ipcSetPeer, key, value string) error {
 	switch key {
 	case "update_only":
 		// allow disabling of creation
@@ -335,8 +1088,8 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set update only, invalid value: %v", value)
 		}
 		if peer.created && !peer.dummy {
-			device.RemovePeer(peer.handshake.remoteStatic)
-			peer.Peer = &Peer{}
+			tx.setPeerExists(peer.publicKey, false)
+			peer.discarded = true
 			peer.dummy = true
 		}
 
@@ -346,109 +1099,126 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set remove, invalid value: %v", value)
 		}
 		if !peer.dummy {
-			device.log.Verbosef("%v - UAPI: Removing", peer.Peer)
-			device.RemovePeer(peer.handshake.remoteStatic)
+			tx.setPeerExists(peer.publicKey, false)
+			tx.peerStep(peer, func() {
+				device.logs.uapi.Infof("%v - UAPI: Removing", peer.Peer)
+				device.RemovePeer(peer.publicKey)
+			}, nil)
+			tx.step(nil, func(s auditSnapshot) {
+				if !peer.discarded {
+					delete(s, peer.publicKey)
+				}
+			})
 		}
-		peer.Peer = &Peer{}
 		peer.dummy = true
 
 	case "preshared_key":
-		device.log.Verbosef("%v - UAPI: Updating preshared key", peer.Peer)
-
-		peer.handshake.mutex.Lock()
-		err := peer.handshake.presharedKey.FromHex(value)
-		peer.handshake.mutex.Unlock()
-
-		if err != nil {
+		var psk NoisePresharedKey
+		if err := psk.FromHex(value); err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set preshared key: %w", err)
 		}
+		if peer.dummy {
+			return nil
+		}
+		tx.peerStep(peer, func() {
+			device.logs.uapi.Verbosef("%v - UAPI: Updating preshared key", peer.Peer)
+			peer.handshake.mutex.Lock()
+			peer.handshake.presharedKey = psk
+			peer.handshake.mutex.Unlock()
+		}, func(values map[string][]string) {
+			if psk == (NoisePresharedKey{}) {
+				delete(values, "preshared_key")
+			} else {
+				values["preshared_key"] = []string{hex.EncodeToString(psk[:])}
+			}
+		})
 
 	case "endpoint":
-		device.log.Verbosef("%v - UAPI: Updating endpoint", peer.Peer)
-
 		var endpointValue = value
 		var isDomain = false
 
 		// Check if this is a domain-based endpoint
 		if IsDomainEndpoint(value) {
 			isDomain = true
-			device.log.Verbosef("%v - UAPI: Domain endpoint detected: %s", peer.Peer, value)
 
-			// Resolve domain to IP for bind layer
-			host, port, err := net.SplitHostPort(value)
-			if err != nil {
-				return ipcErrorf(ipc.IpcErrorInvalid, "invalid endpoint format %v: %w", value, err)
+			// Resolved to IP for bind layer before taking the ipcMutex
+			resolved, ok := tx.resolved[value]
+			if !ok {
+				resolved = resolveEndpoint(value)
 			}
-
-			// Resolve domain name
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
-			ips, err := net.DefaultResolver.LookupHost(ctx, host)
-			if err != nil {
-				return ipcErrorf(ipc.IpcErrorInvalid, "failed to resolve domain %v: %w", host, err)
-			}
-
-			if len(ips) == 0 {
-				return ipcErrorf(ipc.IpcErrorInvalid, "no IP addresses found for domain %v", host)
-			}
-
-			// Prefer IPv4
-			var selectedIP string
-			for _, ip := range ips {
-				if net.ParseIP(ip).To4() != nil {
-					selectedIP = ip
-					break
-				}
+			if resolved.err != nil {
+				return resolved.err
 			}
-			if selectedIP == "" {
-				selectedIP = ips[0]
-			}
-
-			endpointValue = net.JoinHostPort(selectedIP, port)
-			device.log.Verbosef("%v - UAPI: Resolved %s to %s for bind layer", peer.Peer, value, endpointValue)
+			endpointValue = resolved.endpoint
 		}
 
 		endpoint, err := device.net.bind.ParseEndpoint(endpointValue)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set endpoint %v: %w", endpointValue, err)
 		}
-		peer.endpoint.Lock()
-		defer peer.endpoint.Unlock()
-		peer.endpoint.val = endpoint
+		if peer.dummy {
+			return nil
+		}
+		peer.domain = peer.domain || isDomain
+		tx.peerStep(peer, func() {
+			device.logs.uapi.Verbosef("%v - UAPI: Updating endpoint", peer.Peer)
+			if isDomain {
+				device.logs.uapi.Verbosef("%v - UAPI: Resolved %s to %s for bind layer", peer.Peer, value, endpointValue)
+			}
+			peer.endpoint.Lock()
+			peer.endpoint.val = endpoint
+			peer.endpoint.Unlock()
 
-		// If this is a domain-based endpoint, add it to DNS monitoring
-		if isDomain && device.dnsMonitor != nil {
-			err := device.dnsMonitor.AddPeer(peer.handshake.remoteStatic, value)
-			if err != nil {
-				device.log.Verbosef("%v - UAPI: Failed to add domain endpoint to DNS monitor: %v", peer.Peer, err)
-			} else {
-				device.log.Verbosef("%v - UAPI: Added domain endpoint %s to DNS monitor", peer.Peer, value)
+			// If this is a domain-based endpoint, add it to DNS monitoring
+			if isDomain && device.dnsMonitor != nil {
+				err := device.dnsMonitor.AddPeer(peer.publicKey, value)
+				if err != nil {
+					device.logs.uapi.Warnf("%v - UAPI: Failed to add domain endpoint to DNS monitor: %v", peer.Peer, err)
+				} else {
+					device.logs.uapi.Verbosef("%v - UAPI: Added domain endpoint %s to DNS monitor", peer.Peer, value)
+				}
 			}
-		}
+		}, func(values map[string][]string) {
+			values["endpoint"] = []string{endpoint.DstToString()}
+			if isDomain && device.dnsMonitor != nil {
+				host, port, _ := net.SplitHostPort(value)
+				values["dns_hostname"] = []string{host}
+				values["dns_port"] = []string{port}
+			}
+		})
 
 	case "persistent_keepalive_interval":
-		device.log.Verbosef("%v - UAPI: Updating persistent keepalive interval", peer.Peer)
-
 		secs, err := strconv.ParseUint(value, 10, 16)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set persistent keepalive interval: %w", err)
 		}
+		if peer.dummy {
+			return nil
+		}
+		tx.peerStep(peer, func() {
+			device.logs.uapi.Verbosef("%v - UAPI: Updating persistent keepalive interval", peer.Peer)
 
-		old := peer.persistentKeepaliveInterval.Swap(uint32(secs))
+			old := peer.persistentKeepaliveInterval.Swap(uint32(secs))
 
-		// Send immediate keepalive if we're turning it on and before it wasn't on.
-		peer.pkaOn = old == 0 && secs != 0
+			// Send immediate keepalive if we're turning it on and before it wasn't on.
+			peer.pkaOn = old == 0 && secs != 0
+		}, func(values map[string][]string) {
+			values["persistent_keepalive_interval"] = []string{strconv.FormatUint(secs, 10)}
+		})
 
 	case "replace_allowed_ips":
-		device.log.Verbosef("%v - UAPI: Removing all allowedips", peer.Peer)
 		if value != "true" {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to replace allowedips, invalid value: %v", value)
 		}
 		if peer.dummy {
 			return nil
 		}
-		device.allowedips.RemoveByPeer(peer.Peer)
+		tx.peerStep(peer, func() {
+			device.logs.uapi.Infof("%v - UAPI: Removing all allowedips", peer.Peer)
+			device.allowedips.RemoveByPeer(peer.Peer)
+		}, func(values map[string][]string) {
+			delete(values, "allowed_ip")
+		})
 
 	case "allowed_ip":
 		add := true
@@ -458,7 +1228,6 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 			verb = "Removing"
 			value = value[1:]
 		}
-		device.log.Verbosef("%v - UAPI: %s allowedip", peer.Peer, verb)
 		prefix, err := netip.ParsePrefix(value)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set allowed ip: %w", err)
@@ -466,11 +1235,38 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 		if peer.dummy {
 			return nil
 		}
-		if add {
-			device.allowedips.Insert(prefix, peer.Peer)
-		} else {
-			device.allowedips.Remove(prefix, peer.Peer)
+		if owner := device.allowedips.owner(prefix); add && owner != nil {
+			tx.touched[owner.handshake.remoteStatic] = true
 		}
+		// a peerStep, except that the simulation also moves the allowed IP
+		// from any other peer
+		tx.step(func() {
+			if peer.Peer == nil || peer.discarded {
+				return
+			}
+			device.logs.uapi.Verbosef("%v - UAPI: %s allowedip", peer.Peer, verb)
+			if add {
+				if owner := device.allowedips.owner(prefix); owner != nil && owner != peer.Peer {
+					owner.changes.Add(1)
+				}
+				device.allowedips.Insert(prefix, peer.Peer)
+			} else {
+				device.allowedips.Remove(prefix, peer.Peer)
+			}
+		}, func(s auditSnapshot) {
+			if _, ok := s[peer.publicKey]; !ok || peer.discarded {
+				return
+			}
+			entry := prefix.Masked().String()
+			for other, values := range s {
+				if add || other == peer.publicKey {
+					values["allowed_ip"] = slices.DeleteFunc(values["allowed_ip"], func(e string) bool { return e == entry })
+				}
+			}
+			if add {
+				s[peer.publicKey]["allowed_ip"] = append(s[peer.publicKey]["allowed_ip"], entry)
+			}
+		})
 
 	case "protocol_version":
 		if value != "1" {
@@ -478,12 +1274,48 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 		}
 
 	default:
-		return ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI peer key: %v", key)
+		handle, ok := peerExtensions[key]
+		if !ok {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI peer key: %v", key)
+		}
+		return handle(device, tx, peer, key, value)
 	}
 
 	return nil
 }
 
+func (device *Device) handleLimitLine(tx *ipcSet, peer *ipcSetPeer, key, value string) error {
+	name := strings.ReplaceAll(key, "_", " ")
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", name, err)
+	}
+	if reason := device.quotasRefused.Load(); reason != nil && n != 0 && strings.HasSuffix(key, "_quota") {
+		return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %s", name, *reason)
+	}
+	if peer.dummy {
+		return nil
+	}
+	tx.peerStep(peer, func() {
+		device.logs.uapi.Verbosef("%v - UAPI: Updating %s", peer.Peer, name)
+		switch key {
+		case "tx_rate_limit":
+			peer.shaping.tx.setRate(n)
+		case "rx_rate_limit":
+			peer.shaping.rx.setRate(n)
+		default:
+			peer.setQuota(key == "monthly_quota", n)
+		}
+	}, func(values map[string][]string) {
+		if n == 0 {
+			delete(values, key)
+		} else {
+			values[key] = []string{strconv.FormatUint(n, 10)}
+		}
+	})
+	return nil
+}
+
 func (device *Device) IpcGet() (string, error) {
 	buf := new(strings.Builder)
 	if err := device.IpcGetOperation(buf); err != nil {
@@ -496,6 +1328,136 @@ func (device *Device) IpcSet(uapiConf string) error {
 	return device.IpcSetOperation(strings.NewReader(uapiConf))
 }
 
+// IpcSubscribeOperation streams the events of the device to w, one record per
+// event, until reading from r fails, as when the client hangs up, or the device
+// closes. It consumes r.
+func (device *Device) IpcSubscribeOperation(r io.Reader, w *bufio.Writer) error {
+	events, unsubscribe := device.SubscribeEvents(256)
+	defer unsubscribe()
+
+	hangup := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(hangup)
+	}()
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := writeEventRecord(w, event); err != nil {
+				return ipcErrorf(ipc.IpcErrorIO, "failed to write event: %w", err)
+			}
+			// write out what is pending as a whole before waiting again
+			if len(events) == 0 {
+				if err := w.Flush(); err != nil {
+					return ipcErrorf(ipc.IpcErrorIO, "failed to write event: %w", err)
+				}
+			}
+		case <-hangup:
+			return nil
+		}
+	}
+}
+
+// IpcLogOperation reads the request of the UAPI log operation from r, key=value
+// lines ended by an empty line, and writes the matching records of the log
+// ring to w, one record each. With since_sec and since_nsec, it skips records
+// logged before then. With follow=true, it goes on writing records as they are
+// logged, until reading from r fails, as when the client hangs up, or the device
+// closes, and reports follow as true; the stream then consumes r.
+func (device *Device) IpcLogOperation(r *bufio.Reader, w *bufio.Writer) (follow bool, err error) {
+	var sinceSec, sinceNsec int64
+	// read the whole request before failing, to stay in step with the client
+	var requestErr error
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return false, ipcErrorf(ipc.IpcErrorIO, "failed to read log request: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		if requestErr != nil {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			requestErr = ipcErrorf(ipc.IpcErrorProtocol, "failed to parse line %q", line)
+			continue
+		}
+		switch key {
+		case "since_sec":
+			sinceSec, err = strconv.ParseInt(value, 10, 64)
+		case "since_nsec":
+			sinceNsec, err = strconv.ParseInt(value, 10, 64)
+		case "follow":
+			follow, err = strconv.ParseBool(value)
+		default:
+			requestErr = ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI log key: %v", key)
+		}
+		if err != nil {
+			requestErr = ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI log %s %q: %w", key, value, err)
+		}
+	}
+	if requestErr != nil {
+		return false, requestErr
+	}
+	var since time.Time
+	if sinceSec != 0 || sinceNsec != 0 {
+		since = time.Unix(sinceSec, sinceNsec)
+	}
+
+	if !follow {
+		for _, record := range device.LogRecords(since) {
+			if err := writeLogRecord(w, record); err != nil {
+				return false, ipcErrorf(ipc.IpcErrorIO, "failed to write log record: %w", err)
+			}
+		}
+		return false, nil
+	}
+
+	records, c, unsubscribe := device.FollowLog(since, 256)
+	defer unsubscribe()
+	for _, record := range records {
+		if err := writeLogRecord(w, record); err != nil {
+			return true, ipcErrorf(ipc.IpcErrorIO, "failed to write log record: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return true, ipcErrorf(ipc.IpcErrorIO, "failed to write log record: %w", err)
+	}
+
+	hangup := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(hangup)
+	}()
+
+	for {
+		select {
+		case record, ok := <-c:
+			if !ok {
+				return true, nil
+			}
+			if err := writeLogRecord(w, record); err != nil {
+				return true, ipcErrorf(ipc.IpcErrorIO, "failed to write log record: %w", err)
+			}
+			// write out what is pending as a whole before waiting again
+			if len(c) == 0 {
+				if err := w.Flush(); err != nil {
+					return true, ipcErrorf(ipc.IpcErrorIO, "failed to write log record: %w", err)
+				}
+			}
+		case <-hangup:
+			return true, nil
+		}
+	}
+}
+
 func (device *Device) IpcHandle(socket net.Conn) {
 	defer socket.Close()
 
@@ -505,6 +1467,12 @@ func (device *Device) IpcHandle(socket net.Conn) {
 		return bufio.NewReadWriter(reader, writer)
 	}(socket)
 
+	// identify the caller when it connects, before its pid can be reused
+	var caller *AuditCaller
+	if device.auditLog.Load() != nil {
+		caller = ipcCaller(socket)
+	}
+
 	for {
 		op, err := buffered.ReadString('\n')
 		if err != nil {
@@ -514,7 +1482,7 @@ func (device *Device) IpcHandle(socket net.Conn) {
 		// handle operation
 		switch op {
 		case "set=1\n":
-			err = device.IpcSetOperation(buffered.Reader)
+			err = device.ipcSetOperation(buffered.Reader, buffered.Writer, "uapi", caller)
 		case "get=1\n":
 			var nextByte byte
 			nextByte, err = buffered.ReadByte()
@@ -522,12 +1490,53 @@ func (device *Device) IpcHandle(socket net.Conn) {
 				return
 			}
 			if nextByte != '\n' {
-				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI get: %q", nextByte)
+				// lines selecting what to get follow
+				buffered.UnreadByte()
+				err = device.IpcGetFilteredOperation(buffered.Reader, buffered.Writer)
 				break
 			}
 			err = device.IpcGetOperation(buffered.Writer)
+		case "subscribe=1\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI subscribe: %q", nextByte)
+				break
+			}
+			// the stream takes over the connection; errno follows once it ends
+			err = device.IpcSubscribeOperation(buffered.Reader, buffered.Writer)
+			if err == nil {
+				fmt.Fprintf(buffered, "errno=0\n\n")
+				buffered.Flush()
+			}
+			return
+		case "capabilities=1\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI capabilities: %q", nextByte)
+				break
+			}
+			err = device.IpcCapabilitiesOperation(buffered.Writer)
+		case "log=1\n":
+			var follow bool
+			follow, err = device.IpcLogOperation(buffered.Reader, buffered.Writer)
+			if follow {
+				// as with subscribe, the stream took over the connection
+				if err == nil {
+					fmt.Fprintf(buffered, "errno=0\n\n")
+					buffered.Flush()
+				}
+				return
+			}
 		default:
-			device.log.Errorf("invalid UAPI operation: %v", op)
+			device.logs.uapi.Errorf("invalid UAPI operation: %v", op)
 			return
 		}
 
@@ -538,7 +1547,7 @@ func (device *Device) IpcHandle(socket net.Conn) {
 			status = ipcErrorf(ipc.IpcErrorUnknown, "other UAPI error: %w", err)
 		}
 		if status != nil {
-			device.log.Errorf("%v", status)
+			device.logs.uapi.Errorf("%v", status)
 			fmt.Fprintf(buffered, "errno=%d\n\n", status.ErrorCode())
 		} else {
 			fmt.Fprintf(buffered, "errno=0\n\n")
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+// vendorPrefix marks the keys this implementation adds to the get and set
+// operations, so that other tools can tell them from those of the protocol and
+// ignore them. Get only prefixes them when asked to, since clients read them
+// as they were first named; set takes them either way.
+const vendorPrefix = "wggo_"
+
+// deviceStatusKeys and peerStatusKeys are the extension keys that get reports
+// and set does not take; those set takes are in deviceExtensions and
+// peerExtensions.
+var (
+	deviceStatusKeys = []string{
+		"capture_bytes", "capture_packets", "dns_monitored_peers", "firewall_flows",
+		"firewall_policy_packets", "firewall_rule_bytes", "firewall_rule_packets",
+		"generation", "peer_count", "remaining_peers",
+	}
+	peerStatusKeys = []string{
+		"counters_only", "daily_quota_remaining", "dns_hostname", "dns_last_check_time_sec",
+		"dns_last_resolved_ip", "dns_port", "dns_resolution_failures",
+		"handshake_initiations", "handshake_responses", "handshake_retries", "keypair_age_sec",
+		"last_rx_time_nsec", "last_rx_time_sec", "monthly_quota_remaining",
+		"quota_suspended", "rx_drop_allowed_ips", "rx_drop_decrypt", "rx_drop_firewall",
+		"rx_drop_malformed", "rx_drop_quota", "rx_drop_rate_limit", "rx_drop_replay",
+		"rx_packets", "tx_drop_firewall", "tx_drop_not_running", "tx_drop_quota",
+		"tx_drop_rate_limit", "tx_drop_staged_overflow", "tx_packets",
+	}
+)
+
+// extensionKeys returns the extension keys set takes, in extensions, and those
+// get reports besides, in order.
+func extensionKeys[V any](extensions map[string]V, status []string) []string {
+	keys := slices.AppendSeq(slices.Clone(status), maps.Keys(extensions))
+	slices.Sort(keys)
+	return slices.Compact(keys)
+}
+
+// isExtensionKey reports whether get writes key with vendorPrefix when asked
+// to.
+func isExtensionKey(key string) bool {
+	_, device := deviceExtensions[key]
+	_, peer := peerExtensions[key]
+	return device || peer || slices.Contains(deviceStatusKeys, key) || slices.Contains(peerStatusKeys, key)
+}
+
+// unprefixedKey returns key without vendorPrefix, if it names one of
+// extensions, and key unchanged otherwise.
+func unprefixedKey[V any](key string, extensions map[string]V) string {
+	if name, ok := strings.CutPrefix(key, vendorPrefix); ok {
+		if _, ok := extensions[name]; ok {
+			return name
+		}
+	}
+	return key
+}
+
+// SetVersion sets the version of the program running the device, reported by
+// the UAPI capabilities operation.
+func (device *Device) SetVersion(version string) {
+	device.ipcMutex.Lock()
+	defer device.ipcMutex.Unlock()
+	device.version = version
+}
+
+// IpcCapabilitiesOperation implements the "capabilities" operation, which
+// describes what the device understands beyond the configuration protocol:
+// version=, if set with SetVersion, protocol_version=, vendor_prefix=, then
+// operation= for each operation, get_filter= for each line a get may be
+// followed by, and device_key= and peer_key= for each extension key, with
+// the vendor prefix, as get reports them with vendor_prefix=.
+func (device *Device) IpcCapabilitiesOperation(w io.Writer) error {
+	device.ipcMutex.RLock()
+	version := device.version
+	device.ipcMutex.RUnlock()
+
+	var buf bytes.Buffer
+	if version != "" {
+		fmt.Fprintf(&buf, "version=%s\n", version)
+	}
+	fmt.Fprintf(&buf, "protocol_version=1\nvendor_prefix=%s\n", vendorPrefix)
+	for _, operation := range []string{"get", "set", "subscribe", "log", "capabilities"} {
+		fmt.Fprintf(&buf, "operation=%s\n", operation)
+	}
+	for _, filter := range []string{"public_key", "since_generation", "after_public_key", "limit", "omit", "vendor_prefix"} {
+		fmt.Fprintf(&buf, "get_filter=%s\n", filter)
+	}
+	for _, key := range extensionKeys(deviceExtensions, deviceStatusKeys) {
+		fmt.Fprintf(&buf, "device_key=%s%s\n", vendorPrefix, key)
+	}
+	for _, key := range extensionKeys(peerExtensions, peerStatusKeys) {
+		fmt.Fprintf(&buf, "peer_key=%s%s\n", vendorPrefix, key)
+	}
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
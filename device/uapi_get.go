@@ -0,0 +1,271 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+// An ipcGetQuery selects what a UAPI get operation returns, for reading large
+// peer sets a piece at a time. The zero ipcGetQuery selects everything.
+type ipcGetQuery struct {
+	filtered       bool                    // the reply says the generation and how many peers there are
+	peers          map[NoisePublicKey]bool // only these peers, if not nil
+	since          uint64                  // only peers changed after this generation, if sinceSet
+	sinceSet       bool
+	after          *NoisePublicKey // only peers with greater public keys, if not nil
+	limit          int             // at most this many peers, in the order of their public keys, if limited
+	limited        bool
+	omitAllowedIPs bool
+	omitStats      bool
+	vendorPrefix   bool // extension keys have vendorPrefix
+
+	countersOnly map[*Peer]bool // peers whose counters alone changed since, set by selectPeers
+}
+
+// IpcGetFilteredOperation implements the "get" operation followed by lines
+// selecting what it returns, up to a blank line:
+//
+//   - public_key=KEY (hex) only returns that peer, and may be repeated;
+//   - since_generation=N only returns peers changed after generation N;
+//   - after_public_key=KEY only returns peers with greater public keys;
+//   - limit=N returns at most N peers, in the order of their public keys;
+//   - omit=allowed_ips or omit=stats leaves out allowed_ip lines or the
+//     counters of the peers beyond tx_bytes and rx_bytes, and may be repeated;
+//   - vendor_prefix=wggo_ writes the extension keys with the vendor prefix, as
+//     the capabilities operation announces them.
+//
+// The device section then also has generation=N, the generation to pass as
+// since_generation later, and peer_count=N, the number of peers of the device;
+// with limit, remaining_peers=N counts the peers after the last one returned.
+// A peer changes with its configuration, endpoint, latest handshake or quota
+// suspension; removed peers are not returned, but change peer_count. A peer
+// whose counters alone changed is returned with counters_only=true and only
+// its counters: tx_bytes, rx_bytes, its quotas and, unless omitted, its stats.
+func (device *Device) IpcGetFilteredOperation(r *bufio.Reader, w io.Writer) error {
+	query := ipcGetQuery{filtered: true}
+	var requestErr error
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorIO, "failed to read input: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			break
+		}
+		// read the whole request before failing, so that the next one can follow
+		if requestErr == nil {
+			requestErr = query.parse(line)
+		}
+	}
+	if requestErr != nil {
+		return requestErr
+	}
+
+	buf := byteBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer byteBufferPool.Put(buf)
+	device.ipcMutex.RLock()
+	peers := device.ipcGetDevice(buf, &query)
+	device.ipcMutex.RUnlock()
+	device.ipcGetPeers(buf, &query, peers)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
+
+func (query *ipcGetQuery) parse(line string) error {
+	key, value, ok := strings.Cut(line, "=")
+	if !ok {
+		return ipcErrorf(ipc.IpcErrorProtocol, "failed to parse line %q", line)
+	}
+	switch key {
+	case "public_key":
+		var publicKey NoisePublicKey
+		if err := publicKey.FromHex(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
+		}
+		if query.peers == nil {
+			query.peers = make(map[NoisePublicKey]bool)
+		}
+		query.peers[publicKey] = true
+	case "since_generation":
+		since, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid since_generation: %w", err)
+		}
+		query.since, query.sinceSet = since, true
+	case "after_public_key":
+		query.after = new(NoisePublicKey)
+		if err := query.after.FromHex(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid after_public_key: %w", err)
+		}
+	case "limit":
+		limit, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid limit: %w", err)
+		}
+		query.limit, query.limited = int(limit), true
+	case "omit":
+		switch value {
+		case "allowed_ips":
+			query.omitAllowedIPs = true
+		case "stats":
+			query.omitStats = true
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "cannot omit %q", value)
+		}
+	case "vendor_prefix":
+		if value != vendorPrefix {
+			return ipcErrorf(ipc.IpcErrorInvalid, "unknown vendor prefix %q", value)
+		}
+		query.vendorPrefix = true
+	default:
+		return ipcErrorf(ipc.IpcErrorInvalid, "invalid UAPI get key: %v", key)
+	}
+	return nil
+}
+
+// selectPeers returns the peers of the device that query selects, and the
+// number left out by its limit. The caller must hold device.peers.
+func (device *Device) selectPeers(query *ipcGetQuery) (peers []*Peer, remaining int) {
+	if query.peers != nil {
+		for publicKey := range query.peers {
+			if peer := device.peers.keyMap[publicKey]; peer != nil {
+				peers = append(peers, peer)
+			}
+		}
+	} else {
+		peers = make([]*Peer, 0, len(device.peers.keyMap))
+		for _, peer := range device.peers.keyMap {
+			peers = append(peers, peer)
+		}
+	}
+	if query.after != nil {
+		peers = slices.DeleteFunc(peers, func(peer *Peer) bool {
+			return bytes.Compare(peer.handshake.remoteStatic[:], query.after[:]) <= 0
+		})
+	}
+	if query.sinceSet {
+		peers, query.countersOnly = device.changedSince(peers, query.since)
+	}
+	if query.after == nil && !query.limited {
+		return peers, 0
+	}
+	slices.SortFunc(peers, func(a, b *Peer) int {
+		return bytes.Compare(a.handshake.remoteStatic[:], b.handshake.remoteStatic[:])
+	})
+	if query.limited && len(peers) > query.limit {
+		return peers[:query.limit], len(peers) - query.limit
+	}
+	return peers, 0
+}
+
+// changedSince starts a new generation, giving it to those of peers changed
+// since they were last looked at, and returns the peers changed after the
+// generation since, with those whose counters alone changed. A peer changed
+// when its fingerprint did: this costs a look at each peer when asked, rather
+// than any work when packets flow. Counters have a fingerprint and generation
+// of their own, so that a peer passing traffic does not look reconfigured. A
+// change is seen in the first generation to look after it, so it may be
+// returned again. As counters only grow, the only changes missed are those
+// undone in between, as of the endpoint or the latest packet received.
+func (device *Device) changedSince(peers []*Peer, since uint64) (changed []*Peer, countersOnly map[*Peer]bool) {
+	g := &device.generations
+	g.Lock()
+	defer g.Unlock()
+	g.current++
+	countersOnly = make(map[*Peer]bool)
+	changed = slices.DeleteFunc(peers, func(peer *Peer) bool {
+		gen := &peer.generation
+		fingerprint, counters := peer.fingerprint(), peer.countersFingerprint()
+		if gen.value == 0 || fingerprint != gen.fingerprint {
+			gen.value, gen.fingerprint = g.current, fingerprint
+		}
+		if gen.counters == 0 || counters != gen.countersFingerprint {
+			gen.counters, gen.countersFingerprint = g.current, counters
+		}
+		if gen.value > since {
+			return false
+		}
+		if gen.counters > since {
+			countersOnly[peer] = true
+			return false
+		}
+		return true
+	})
+	return changed, countersOnly
+}
+
+// generation returns the current generation: every change to a peer before it
+// started is seen in it or an earlier one.
+func (device *Device) generation() uint64 {
+	g := &device.generations
+	g.Lock()
+	defer g.Unlock()
+	return g.current
+}
+
+// A peerFingerprint is what may change about the configuration and state of a
+// peer: its configuration changes, endpoint, latest handshake and whether its
+// quota suspends it.
+type peerFingerprint struct {
+	changes           uint64
+	endpoint          string
+	lastHandshakeNano int64
+	quotaExceeded     bool
+}
+
+func (peer *Peer) fingerprint() (f peerFingerprint) {
+	f.changes, f.lastHandshakeNano = peer.changes.Load(), peer.lastHandshakeNano.Load()
+	peer.endpoint.Lock()
+	if peer.endpoint.val != nil {
+		f.endpoint = peer.endpoint.val.DstToString()
+	}
+	peer.endpoint.Unlock()
+	f.quotaExceeded = peer.quotaExceeded()
+	return f
+}
+
+// A peerCounters is what may change about the counters of a peer: those that
+// only grow, its latest packet received, and its quota periods.
+type peerCounters struct {
+	txBytes, rxBytes     uint64
+	lastRxNano           int64
+	stats                [17]uint64
+	quotaDay, quotaMonth int
+}
+
+func (peer *Peer) countersFingerprint() (f peerCounters) {
+	stats := &peer.stats
+	f.txBytes, f.rxBytes, f.lastRxNano = peer.txBytes.Load(), peer.rxBytes.Load(), stats.lastRxNano.Load()
+	for i, counter := range [len(f.stats)]*atomic.Uint64{
+		&stats.txPackets, &stats.rxPackets, &stats.rxDropReplay, &stats.rxDropAllowedIPs,
+		&stats.rxDropDecrypt, &stats.rxDropMalformed, &stats.txDropStagedOverflow, &stats.txDropNotRunning,
+		&stats.txDropRateLimit, &stats.rxDropRateLimit, &stats.txDropQuota, &stats.rxDropQuota,
+		&stats.txDropFirewall, &stats.rxDropFirewall, &stats.handshakeInitiations, &stats.handshakeRetries,
+		&stats.handshakeResponsesRecv,
+	} {
+		f.stats[i] = counter.Load()
+	}
+	if peer.quota.enabled.Load() {
+		q := &peer.quota
+		q.mu.Lock()
+		f.quotaDay, f.quotaMonth = q.day, q.month
+		q.mu.Unlock()
+	}
+	return f
+}
@@ -0,0 +1,371 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// ipcSetConfig returns the configuration of the device in a stable order.
+func ipcSetConfig(dev *Device) string {
+	dev.ipcMutex.Lock()
+	defer dev.ipcMutex.Unlock()
+	return fmt.Sprint(dev.auditSnapshot(nil))
+}
+
+func TestIpcSetAtomic(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), failingBind{bindtest.NewChannelBinds()[0]}, NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	if err := dev.IpcSet(fmt.Sprintf("private_key=%064x\npublic_key=%064x\nallowed_ip=10.0.0.1/32\n", 7, 1)); err != nil {
+		t.Fatal(err)
+	}
+	before := ipcSetConfig(dev)
+
+	for _, config := range []string{
+		// the routes of the peer were removed before the bad allowed_ip
+		fmt.Sprintf("public_key=%064x\nreplace_allowed_ips=true\nallowed_ip=10.0.0.2\n", 1),
+		fmt.Sprintf("replace_peers=true\npublic_key=%064x\nendpoint=nowhere\n", 2),
+		fmt.Sprintf("private_key=%064x\nlisten_port=70000\n", 8),
+		fmt.Sprintf("public_key=%064x\nremove=true\npublic_key=%064x\ndns_resolve=true\n", 1, 2),
+	} {
+		if err := dev.IpcSet(config); err == nil {
+			t.Errorf("%q accepted", config)
+		}
+		if after := ipcSetConfig(dev); after != before {
+			t.Errorf("%q changed the configuration to:\n%s", config, after)
+		}
+	}
+}
+
+// failingBind fails to open port 1, is not permitted to open port 3, and opens
+// any other port asked for, where a ChannelBind picks one at random.
+type failingBind struct {
+	conn.Bind
+}
+
+func (b failingBind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	if port == 1 {
+		return nil, 0, errors.New("port 1 in use")
+	}
+	if port == 3 {
+		return nil, 0, fmt.Errorf("bind port 3: %w", os.ErrPermission)
+	}
+	fns, _, err := b.Bind.Open(port)
+	return fns, port, err
+}
+
+func TestIpcSetPermission(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), failingBind{bindtest.NewChannelBinds()[0]}, NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.IpcSet("listen_port=3\n"); err == nil || !strings.Contains(err.Error(), "needs CAP_NET_BIND_SERVICE") {
+		t.Errorf("unprivileged bind failed with %v", err)
+	}
+}
+
+func TestIpcSetRollback(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), failingBind{bindtest.NewChannelBinds()[0]}, NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.IpcSet(fmt.Sprintf("private_key=%064x\nlisten_port=2\n", 7)); err != nil {
+		t.Fatal(err)
+	}
+	before := ipcSetConfig(dev)
+
+	err := dev.IpcSet(fmt.Sprintf("private_key=%064x\nlisten_port=1\npublic_key=%064x\nallowed_ip=10.0.0.1/32\n", 8, 1))
+	var status *IPCError
+	if !errors.As(err, &status) || status.ErrorCode() != ipc.IpcErrorPortInUse {
+		t.Fatalf("failing bind returned %v", err)
+	}
+	if after := ipcSetConfig(dev); after != before {
+		t.Errorf("configuration after rollback:\n%s\nwant:\n%s", after, before)
+	}
+	dev.net.RLock()
+	open := dev.net.bind != nil && dev.net.port != 0
+	dev.net.RUnlock()
+	if !open {
+		t.Error("bind not reopened after rollback")
+	}
+}
+
+// TestIpcSetNewPeerFailure checks that a peer that cannot be created fails the
+// set operation, instead of leaving the rest of its lines without a peer.
+func TestIpcSetNewPeerFailure(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	dev.Close()
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%064x\nallowed_ip=10.0.0.1/32\n", 1)); err == nil {
+		t.Error("peer created on a closed device")
+	}
+}
+
+func TestIpcSetDryRun(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), failingBind{bindtest.NewChannelBinds()[0]}, NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	var audit lockedBuffer
+	dev.SetAuditLog(&audit, "wg0")
+	if err := dev.IpcSet(fmt.Sprintf("private_key=%064x\npublic_key=%064x\nallowed_ip=10.0.0.1/32\nallowed_ip=10.0.0.2/32\npublic_key=%064x\n", 7, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+	before := ipcSetConfig(dev)
+
+	client, server := net.Pipe()
+	go dev.IpcHandle(server)
+	defer client.Close()
+	r := bufio.NewReader(client)
+	config := fmt.Sprintf("fwmark=5\nfirewall_policy=drop\nlog_level=handshake:error\n"+
+		"public_key=%064x\nreplace_allowed_ips=true\nallowed_ip=10.0.0.2/32\nallowed_ip=10.0.0.3/32\npersistent_keepalive_interval=25\n"+
+		"public_key=%064x\nallowed_ip=10.0.0.1/32\nendpoint=127.0.0.1:5\n"+
+		"public_key=%064x\nremove=true\n"+
+		"public_key=%064x\nupdate_only=true\nallowed_ip=10.0.0.4/32\n", 1, 3, 2, 4)
+	fmt.Fprintf(client, "set=1\ndry_run=true\n%s\n", config)
+	var output strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			break
+		}
+		output.WriteString(line)
+	}
+	if after := ipcSetConfig(dev); after != before {
+		t.Errorf("dry run changed the configuration to:\n%s", after)
+	}
+	want := fmt.Sprintf("change=firewall_policy\nnew=drop\nchange=fwmark\nnew=5\nchange=log_level\nnew=trace\nchange=log_level\nnew=handshake:error\n"+
+		"change=allowed_ip\npublic_key=%064x\nold=10.0.0.1/32\nchange=allowed_ip\npublic_key=%064x\nnew=10.0.0.3/32\nchange=persistent_keepalive_interval\npublic_key=%064x\nold=0\nnew=25\n"+
+		"change=public_key\npublic_key=%064x\nold=", 1, 1, 1, 2)
+	if !strings.HasPrefix(output.String(), want) || !strings.HasSuffix(output.String(), "errno=0\n") {
+		t.Errorf("dry run wrote:\n%s", output.String())
+	}
+
+	// the dry run predicts what the change does
+	if err := dev.IpcSet(config); err != nil {
+		t.Fatal(err)
+	}
+	entries := strings.Split(strings.TrimSpace(audit.String()), "\n")
+	if len(entries) != 3 {
+		t.Fatalf("%d audit entries, want 3", len(entries))
+	}
+	var dryRun, set auditEntry
+	json.Unmarshal([]byte(entries[1]), &dryRun)
+	json.Unmarshal([]byte(entries[2]), &set)
+	if !dryRun.DryRun || set.DryRun {
+		t.Errorf("audit entries %+v and %+v", dryRun, set)
+	}
+	if fmt.Sprint(dryRun.Changes) != fmt.Sprint(set.Changes) {
+		t.Errorf("dry run predicted %v, set changed %v", dryRun.Changes, set.Changes)
+	}
+}
+
+func TestPeerFingerprint(t *testing.T) {
+	peer := new(Peer)
+	peer.stats.lastRxNano.Store(10)
+	before, counters := peer.fingerprint(), peer.countersFingerprint()
+	// changes that would cancel out in a sum
+	peer.txBytes.Add(1)
+	peer.stats.lastRxNano.Store(9)
+	if peer.countersFingerprint() == counters {
+		t.Error("counters fingerprint unchanged")
+	}
+	// traffic alone leaves the peer as configured
+	if peer.fingerprint() != before {
+		t.Error("fingerprint changed by counters")
+	}
+	peer.lastHandshakeNano.Store(1)
+	if peer.fingerprint() == before {
+		t.Error("fingerprint unchanged by a handshake")
+	}
+}
+
+func TestIpcGetFiltered(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	var config strings.Builder
+	for i := 1; i <= 5; i++ {
+		fmt.Fprintf(&config, "public_key=%064x\nallowed_ip=10.0.0.%d/32\n", i, i)
+	}
+	if err := dev.IpcSet(config.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	client, server := net.Pipe()
+	go dev.IpcHandle(server)
+	defer client.Close()
+	r := bufio.NewReader(client)
+	// get returns the device keys asked for and the peers, by their last byte
+	get := func(request string, keys ...string) (map[string]string, []int, string) {
+		fmt.Fprintf(client, "get=1\n%s\n", request)
+		device := make(map[string]string)
+		var peers []int
+		var reply strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return device, peers, reply.String()
+			}
+			reply.WriteString(line)
+			key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+			switch {
+			case key == "public_key":
+				n, _ := strconv.ParseUint(value[56:], 16, 32)
+				peers = append(peers, int(n))
+			case key == "errno" || slices.Contains(keys, key):
+				device[key] = value
+			}
+		}
+	}
+
+	device, peers, _ := get(fmt.Sprintf("public_key=%064x\n", 3), "generation", "peer_count", "remaining_peers")
+	if fmt.Sprint(peers) != "[3]" || device["generation"] != "0" || device["peer_count"] != "5" || device["remaining_peers"] != "" {
+		t.Errorf("single peer: %v, %v", device, peers)
+	}
+	device, peers, _ = get("limit=2\n", "remaining_peers")
+	if fmt.Sprint(peers) != "[1 2]" || device["remaining_peers"] != "3" {
+		t.Errorf("first page: %v, %v", device, peers)
+	}
+	device, peers, _ = get(fmt.Sprintf("after_public_key=%064x\nlimit=2\n", 4), "remaining_peers")
+	if fmt.Sprint(peers) != "[5]" || device["remaining_peers"] != "0" {
+		t.Errorf("last page: %v, %v", device, peers)
+	}
+	device, peers, _ = get("limit=0\n", "remaining_peers", "peer_count")
+	if len(peers) != 0 || device["remaining_peers"] != "5" {
+		t.Errorf("empty page: %v, %v", device, peers)
+	}
+	_, peers, reply := get("omit=allowed_ips\nomit=stats\n")
+	if len(peers) != 5 || strings.Contains(reply, "allowed_ip=") || strings.Contains(reply, "tx_packets=") || !strings.Contains(reply, "tx_bytes=") {
+		t.Errorf("omitting allowed IPs and stats:\n%s", reply)
+	}
+
+	device, peers, _ = get("since_generation=0\n", "generation")
+	generation := device["generation"]
+	if len(peers) != 5 || generation == "0" {
+		t.Errorf("all changed: %v, %v", device, peers)
+	}
+	if _, peers, _ = get("since_generation=" + generation + "\n"); len(peers) != 0 {
+		t.Errorf("changed since the last generation: %v", peers)
+	}
+	// configuration changes peers, and so does losing an allowed IP, while
+	// traffic returns only the counters of a peer
+	dev.LookupPeer(NoisePublicKey{31: 4}).txBytes.Add(100)
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%064x\npersistent_keepalive_interval=25\npublic_key=%064x\nallowed_ip=10.0.0.5/32\n", 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	device, peers, reply = get("since_generation="+generation+"\n", "generation")
+	if slices.Sort(peers); fmt.Sprint(peers) != "[1 2 4 5]" {
+		t.Errorf("changed: %v", peers)
+	}
+	if counters := strings.SplitAfter(reply, fmt.Sprintf("public_key=%064x\n", 4))[1]; strings.Count(reply, "counters_only=true") != 1 ||
+		!strings.HasPrefix(counters, "counters_only=true\ntx_bytes=100\n") || strings.Contains(strings.Split(counters, "public_key=")[0], "allowed_ip=") {
+		t.Errorf("counters only of peer 4:\n%s", reply)
+	}
+	if _, peers, _ = get("since_generation=" + device["generation"] + "\n"); len(peers) != 0 {
+		t.Errorf("changed since the last generation: %v", peers)
+	}
+
+	if device, _, _ = get("limit=x\nomit=everything\n"); device["errno"] != "-22" {
+		t.Errorf("bad request answered with %v", device)
+	}
+	if _, peers, _ = get(""); len(peers) != 5 {
+		t.Errorf("get after a bad request returned %v", peers)
+	}
+}
+
+func TestIpcCapabilities(t *testing.T) {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], NewLogger(LogLevelSilent, ""))
+	defer dev.Close()
+	dev.SetVersion("0.0.1")
+	// extension keys are taken with and without the vendor prefix, but the
+	// keys of the protocol only without
+	if err := dev.IpcSet(fmt.Sprintf("wggo_firewall_policy=drop\nlog_level=info\npublic_key=%064x\nwggo_tx_rate_limit=1000\ndaily_quota=1000000\nallowed_ip=10.0.0.1/32\n", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.IpcSet("wggo_listen_port=1\n"); err == nil {
+		t.Error("wggo_listen_port accepted")
+	}
+
+	client, server := net.Pipe()
+	go dev.IpcHandle(server)
+	defer client.Close()
+	fmt.Fprintf(client, "capabilities=1\n\n")
+	r := bufio.NewReader(client)
+	capabilities := make(map[string][]string)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			break
+		}
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		capabilities[key] = append(capabilities[key], value)
+	}
+	if fmt.Sprint(capabilities["version"], capabilities["vendor_prefix"], capabilities["errno"]) != "[0.0.1] [wggo_] [0]" {
+		t.Errorf("capabilities: %v", capabilities)
+	}
+	if !slices.Contains(capabilities["operation"], "capabilities") || !slices.Contains(capabilities["get_filter"], "since_generation") {
+		t.Errorf("operations %v, get filters %v", capabilities["operation"], capabilities["get_filter"])
+	}
+
+	// get reports extension keys as first named, unless asked for the vendor
+	// prefix, and then as announced
+	config, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(strings.Split(config, "\n"), "firewall_policy=drop") || strings.Contains(config, vendorPrefix) {
+		t.Errorf("get without vendor_prefix:\n%s", config)
+	}
+	fmt.Fprintf(client, "get=1\nvendor_prefix=wggo_\n\n")
+	var prefixed strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			break
+		}
+		prefixed.WriteString(line)
+	}
+	announced := capabilities["device_key"]
+	for _, line := range strings.Split(strings.TrimSpace(prefixed.String()), "\n") {
+		key, _, _ := strings.Cut(line, "=")
+		switch key {
+		case "private_key", "listen_port", "fwmark":
+		case "public_key":
+			announced = capabilities["peer_key"]
+		case "preshared_key", "protocol_version", "endpoint", "last_handshake_time_sec", "last_handshake_time_nsec",
+			"tx_bytes", "rx_bytes", "persistent_keepalive_interval", "allowed_ip", "errno":
+		default:
+			if !slices.Contains(announced, key) {
+				t.Errorf("get reports %s, not announced", key)
+			}
+		}
+	}
+}
@@ -51,6 +51,17 @@ func (l *UAPIListener) Addr() net.Addr {
 }
 
 func UAPIListen(name string, file *os.File) (net.Listener, error) {
+	return uapiListen(name, file, true)
+}
+
+// UAPIListenInherited is like UAPIListen, but for a listening socket created by
+// another process, such as a socket-activating service manager. The socket file
+// belongs to that process, so it is left in place when the listener is closed.
+func UAPIListenInherited(name string, file *os.File) (net.Listener, error) {
+	return uapiListen(name, file, false)
+}
+
+func uapiListen(name string, file *os.File, unlinkOnClose bool) (net.Listener, error) {
 	// wrap file in listener
 
 	listener, err := net.FileListener(file)
@@ -59,7 +70,7 @@ func UAPIListen(name string, file *os.File) (net.Listener, error) {
 	}
 
 	if unixListener, ok := listener.(*net.UnixListener); ok {
-		unixListener.SetUnlinkOnClose(true)
+		unixListener.SetUnlinkOnClose(unlinkOnClose)
 	}
 
 	uapi := &UAPIListener{
@@ -0,0 +1,49 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// ENV_WG_KEYLOG_FILE names the file that session keys are appended to. There is
+// deliberately no command line flag or configuration key for it.
+const ENV_WG_KEYLOG_FILE = "WG_KEYLOG_FILE"
+
+// keyLogWarning warns on stderr, before the daemon detaches from the terminal,
+// that WG_KEYLOG_FILE is set.
+func keyLogWarning() {
+	if os.Getenv(ENV_WG_KEYLOG_FILE) == "" {
+		return
+	}
+	fmt.Fprintln(os.Stderr, "┌──────────────────────────────────────────────────────┐")
+	fmt.Fprintln(os.Stderr, "│                                                      │")
+	fmt.Fprintln(os.Stderr, "│   WARNING: WG_KEYLOG_FILE is set. The keys of every  │")
+	fmt.Fprintln(os.Stderr, "│   handshake are written to that file, and anyone     │")
+	fmt.Fprintln(os.Stderr, "│   who can read it can decrypt all traffic of this    │")
+	fmt.Fprintln(os.Stderr, "│   tunnel. Use this for debugging only.               │")
+	fmt.Fprintln(os.Stderr, "│                                                      │")
+	fmt.Fprintln(os.Stderr, "└──────────────────────────────────────────────────────┘")
+}
+
+// openKeyLog opens the file named in WG_KEYLOG_FILE for appending the keys of
+// every handshake, in the format of Wireshark's WireGuard dissector. It returns
+// nil if the variable is not set.
+func openKeyLog(logger *device.Logger) (*os.File, error) {
+	path := os.Getenv(ENV_WG_KEYLOG_FILE)
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	logger.Errorf("Writing the session keys of every handshake to %s: all traffic can be decrypted with them", path)
+	return file, nil
+}
@@ -0,0 +1,103 @@
+//go:build linux || darwin
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+// #include <stdint.h>
+import "C"
+
+import (
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// wgNetstackDial connects through a device created by wgNewNetstackDevice to
+// address, a host and port, over network, "tcp" or "udp" optionally suffixed
+// with 4 or 6. Returns a file descriptor for the connection, one end of a stream
+// socket pair for TCP or of a sequenced packet socket pair for UDP, where each
+// packet is a datagram. Closing it closes the connection.
+//
+//export wgNetstackDial
+func wgNetstackDial(handle C.int32_t, network *C.char, address *C.char) C.int32_t {
+	tunnel := lookupHandle(handle)
+	if tunnel == nil {
+		return -C.int32_t(syscall.EBADF)
+	}
+	if tunnel.net == nil {
+		return -C.int32_t(syscall.EOPNOTSUPP)
+	}
+	netw := C.GoString(network)
+	var sotype int
+	switch {
+	case strings.HasPrefix(netw, "tcp"):
+		sotype = syscall.SOCK_STREAM
+	case strings.HasPrefix(netw, "udp"):
+		sotype = syscall.SOCK_SEQPACKET
+	default:
+		return -C.int32_t(syscall.EPROTONOSUPPORT)
+	}
+	c, err := tunnel.net.Dial(netw, C.GoString(address))
+	if err != nil {
+		tunnel.logger.Verbosef("Unable to dial %s %s: %v", netw, C.GoString(address), err)
+		return errnoOf(err)
+	}
+	fd, err := bridge(c, sotype)
+	if err != nil {
+		c.Close()
+		tunnel.logger.Errorf("Unable to create socket pair: %v", err)
+		return errnoOf(err)
+	}
+	return C.int32_t(fd)
+}
+
+// bridge returns one end of a socket pair of sotype, with the other end spliced to
+// c, so that a connection of the netstack is available as a file descriptor.
+func bridge(c net.Conn, sotype int) (int, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, sotype, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return -1, err
+	}
+	file := os.NewFile(uintptr(fds[1]), "libwg")
+	local, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		syscall.Close(fds[0])
+		return -1, err
+	}
+
+	done := make(chan struct{}, 2)
+	go splice(c, local, done)
+	go splice(local, c, done)
+	go func() {
+		<-done
+		<-done
+		c.Close()
+		local.Close()
+	}()
+	return fds[0], nil
+}
+
+// splice copies src to dst, and then shuts down writing to dst, or closes it if
+// it cannot be half closed, as with UDP, which also ends the other direction.
+func splice(dst, src net.Conn, done chan<- struct{}) {
+	io.CopyBuffer(dst, src, make([]byte, 65535))
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+	done <- struct{}{}
+}
@@ -0,0 +1,277 @@
+//go:build linux || darwin
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command libwg is built with -buildmode=c-shared into a library embedding the
+// WireGuard device in programs written in other languages. The C interface is
+// declared in the generated libwg.h.
+//
+// Devices are referred to by handles, which are non-negative. Functions return
+// a negative errno on failure; the reason is also logged through the callback of
+// the device, if any.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+// Devices are referred to by non-negative handles. Functions returning int32_t
+// return a negative errno on failure. The functions are documented in the Go
+// sources of libwg.
+
+// Levels of log messages.
+enum {
+	WG_LOG_ERROR = 1,
+	WG_LOG_VERBOSE = 2,
+};
+
+// wg_logger_fn receives the log messages of a device, without trailing newline,
+// along with the context given when creating it. It is called from any thread,
+// and the message is only valid for the duration of the call.
+typedef void (*wg_logger_fn)(void *context, int32_t level, const char *msg);
+
+static inline void wg_call_logger(wg_logger_fn fn, void *context, int32_t level, const char *msg)
+{
+	fn(context, level, msg);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+)
+
+type tunnelHandle struct {
+	device *device.Device
+	logger *device.Logger
+	net    *netstack.Net // nil unless backed by netstack
+}
+
+var (
+	tunnelHandlesMu sync.Mutex
+	tunnelHandles   = make(map[int32]*tunnelHandle)
+)
+
+// newLogger forwards the messages of a device to fn, or discards them if fn is
+// NULL.
+func newLogger(fn C.wg_logger_fn, context unsafe.Pointer) *device.Logger {
+	if fn == nil {
+		return device.NewLogger(device.LogLevelSilent, "")
+	}
+	logf := func(level C.int32_t) func(format string, args ...any) {
+		return func(format string, args ...any) {
+			msg := C.CString(fmt.Sprintf(format, args...))
+			C.wg_call_logger(fn, context, level, msg)
+			C.free(unsafe.Pointer(msg))
+		}
+	}
+	return &device.Logger{
+		Verbosef: logf(C.WG_LOG_VERBOSE),
+		Errorf:   logf(C.WG_LOG_ERROR),
+	}
+}
+
+// errnoOf maps err to a negative errno for the return value of the C functions.
+func errnoOf(err error) C.int32_t {
+	var ipcErr *device.IPCError
+	if errors.As(err, &ipcErr) {
+		return C.int32_t(ipcErr.ErrorCode()) // already negative, as in errno= of UAPI
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return C.int32_t(-errno)
+	}
+	return -C.int32_t(syscall.EIO)
+}
+
+func addHandle(handle *tunnelHandle) C.int32_t {
+	tunnelHandlesMu.Lock()
+	defer tunnelHandlesMu.Unlock()
+	var i int32
+	for i = 0; i < math.MaxInt32; i++ {
+		if _, exists := tunnelHandles[i]; !exists {
+			break
+		}
+	}
+	if i == math.MaxInt32 {
+		handle.device.Close()
+		return -C.int32_t(syscall.EMFILE)
+	}
+	tunnelHandles[i] = handle
+	return C.int32_t(i)
+}
+
+func lookupHandle(handle C.int32_t) *tunnelHandle {
+	tunnelHandlesMu.Lock()
+	defer tunnelHandlesMu.Unlock()
+	return tunnelHandles[int32(handle)]
+}
+
+func newTunnel(tdev tun.Device, tnet *netstack.Net, logger *device.Logger) C.int32_t {
+	dev := device.NewDevice(tdev, conn.NewDefaultBind(), logger)
+	logger.Verbosef("Device started")
+	return addHandle(&tunnelHandle{device: dev, logger: logger, net: tnet})
+}
+
+// wgNewTUNDevice creates a device on the TUN interface open as fd, which is
+// duplicated, so the caller keeps its own descriptor. If mtu is positive, the
+// MTU of the interface is set to it; otherwise it is left alone. The device is
+// down until wgUp. logger may be NULL. Returns a handle.
+//
+//export wgNewTUNDevice
+func wgNewTUNDevice(fd C.int32_t, mtu C.int32_t, logger C.wg_logger_fn, context unsafe.Pointer) C.int32_t {
+	log := newLogger(logger, context)
+	dupFd, err := syscall.Dup(int(fd))
+	if err != nil {
+		log.Errorf("Unable to dup TUN file descriptor: %v", err)
+		return errnoOf(err)
+	}
+	tdev, err := createTUNFromFD(dupFd, int(mtu))
+	if err != nil {
+		syscall.Close(dupFd)
+		log.Errorf("Unable to create TUN device: %v", err)
+		return errnoOf(err)
+	}
+	return newTunnel(tdev, nil, log)
+}
+
+// wgNewNetstackDevice creates a device backed by a TCP/IP stack in userspace, with
+// the comma-separated addresses, resolving names through the comma-separated dns
+// servers, which may be empty. If mtu is not positive, the default is used.
+// Connections are made with wgNetstackDial. The device is down until wgUp.
+// logger may be NULL. Returns a handle.
+//
+//export wgNewNetstackDevice
+func wgNewNetstackDevice(addresses *C.char, dns *C.char, mtu C.int32_t, logger C.wg_logger_fn, context unsafe.Pointer) C.int32_t {
+	log := newLogger(logger, context)
+	localAddresses, err := parseAddrs(C.GoString(addresses))
+	if err != nil || len(localAddresses) == 0 {
+		log.Errorf("Invalid addresses: %q", C.GoString(addresses))
+		return -C.int32_t(syscall.EINVAL)
+	}
+	dnsServers, err := parseAddrs(C.GoString(dns))
+	if err != nil {
+		log.Errorf("Invalid DNS servers: %q", C.GoString(dns))
+		return -C.int32_t(syscall.EINVAL)
+	}
+	if mtu <= 0 {
+		mtu = device.DefaultMTU
+	}
+	tdev, tnet, err := netstack.CreateNetTUN(localAddresses, dnsServers, int(mtu))
+	if err != nil {
+		log.Errorf("Unable to create netstack device: %v", err)
+		return errnoOf(err)
+	}
+	return newTunnel(tdev, tnet, log)
+}
+
+func parseAddrs(list string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// wgSetConfig applies settings in the format of a UAPI set operation, without the
+// leading set=1 line. Returns 0, or the errno the UAPI would report.
+//
+//export wgSetConfig
+func wgSetConfig(handle C.int32_t, settings *C.char) C.int32_t {
+	tunnel := lookupHandle(handle)
+	if tunnel == nil {
+		return -C.int32_t(syscall.EBADF)
+	}
+	if err := tunnel.device.IpcSet(C.GoString(settings)); err != nil {
+		return errnoOf(err)
+	}
+	return 0
+}
+
+// wgGetConfig returns the configuration and state of the device in the format of
+// a UAPI get operation, to be released with free, or NULL on failure.
+//
+//export wgGetConfig
+func wgGetConfig(handle C.int32_t) *C.char {
+	tunnel := lookupHandle(handle)
+	if tunnel == nil {
+		return nil
+	}
+	settings, err := tunnel.device.IpcGet()
+	if err != nil {
+		tunnel.logger.Errorf("Unable to get config: %v", err)
+		return nil
+	}
+	return C.CString(settings)
+}
+
+// wgUp brings the device up. Returns 0 on success.
+//
+//export wgUp
+func wgUp(handle C.int32_t) C.int32_t {
+	tunnel := lookupHandle(handle)
+	if tunnel == nil {
+		return -C.int32_t(syscall.EBADF)
+	}
+	if err := tunnel.device.Up(); err != nil {
+		tunnel.logger.Errorf("Unable to bring device up: %v", err)
+		return errnoOf(err)
+	}
+	return 0
+}
+
+// wgDown brings the device down. Returns 0 on success.
+//
+//export wgDown
+func wgDown(handle C.int32_t) C.int32_t {
+	tunnel := lookupHandle(handle)
+	if tunnel == nil {
+		return -C.int32_t(syscall.EBADF)
+	}
+	if err := tunnel.device.Down(); err != nil {
+		tunnel.logger.Errorf("Unable to bring device down: %v", err)
+		return errnoOf(err)
+	}
+	return 0
+}
+
+// wgClose shuts the device down and releases its handle, along with its TUN
+// interface. Connections of wgNetstackDial are closed. Returns 0 on success.
+//
+//export wgClose
+func wgClose(handle C.int32_t) C.int32_t {
+	tunnelHandlesMu.Lock()
+	tunnel, ok := tunnelHandles[int32(handle)]
+	delete(tunnelHandles, int32(handle))
+	tunnelHandlesMu.Unlock()
+	if !ok {
+		return -C.int32_t(syscall.EBADF)
+	}
+	tunnel.device.Close()
+	return 0
+}
+
+func main() {}
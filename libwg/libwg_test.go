@@ -0,0 +1,49 @@
+//go:build linux || darwin
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestCHarness builds the shared library and runs testdata/harness.c against it.
+func TestCHarness(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the shared library")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler")
+	}
+	dir := t.TempDir()
+	library := "libwg.so"
+	if runtime.GOOS == "darwin" {
+		library = "libwg.dylib"
+	}
+	harness := filepath.Join(dir, "harness")
+	for _, cmd := range []*exec.Cmd{
+		exec.Command(goTool, "build", "-buildmode=c-shared", "-o", filepath.Join(dir, library), "."),
+		exec.Command(cc, "-Wall", "-Werror", "-o", harness, "testdata/harness.c", "-I", dir, "-L", dir, "-lwg", "-Wl,-rpath,"+dir),
+	} {
+		if output, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", cmd, err, output)
+		}
+	}
+	output, err := exec.Command(harness).CombinedOutput()
+	t.Logf("%s", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,147 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ *
+ * Exercises the C interface of libwg: two netstack devices peered over loopback
+ * exchange a datagram, and a TUN device is created from a file descriptor if
+ * the process may open /dev/net/tun.
+ */
+
+#include <errno.h>
+#include <fcntl.h>
+#include <stdatomic.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+#include <sys/ioctl.h>
+#include <time.h>
+#include <unistd.h>
+#ifdef __linux__
+#include <linux/if.h>
+#include <linux/if_tun.h>
+#endif
+
+#include "libwg.h"
+
+static const char *private_keys[] = {
+	"486d96157581cd24a3fb804c6fd396a1823be9a70877d47045b335c93d67d050",
+	"e0b62c958e8077963c988c806da74850f64e819c1c6a73b436348710bded997c",
+};
+static const char *public_keys[] = {
+	"d309aabeece981391dff91cd5fb510002536aafe60f015b9b090efa9c9c67d56",
+	"54716de0beaa4c88d13502f39577b064909cb3f90029b6201c16d572361efe3e",
+};
+static const char *addresses[] = { "10.9.0.1", "10.9.0.2" };
+
+static atomic_int log_lines;
+
+static void logger(void *context, int32_t level, const char *msg)
+{
+	atomic_fetch_add(&log_lines, 1);
+	printf("[%s] %s: %s\n", (const char *)context, level == WG_LOG_ERROR ? "ERROR" : "DEBUG", msg);
+}
+
+#define check(cond, ...) do { \
+	if (!(cond)) { \
+		fprintf(stderr, "%s:%d: ", __FILE__, __LINE__); \
+		fprintf(stderr, __VA_ARGS__); \
+		fputc('\n', stderr); \
+		exit(1); \
+	} \
+} while (0)
+
+/* config_value returns the first value of key in the configuration of the device. */
+static long long config_value(int32_t handle, const char *key)
+{
+	char *config = wgGetConfig(handle), *line, *save;
+	long long value = -1;
+	size_t len = strlen(key);
+
+	check(config, "wgGetConfig(%d) failed", handle);
+	for (line = strtok_r(config, "\n", &save); line; line = strtok_r(NULL, "\n", &save)) {
+		if (!strncmp(line, key, len) && line[len] == '=') {
+			value = atoll(line + len + 1);
+			break;
+		}
+	}
+	free(config);
+	return value;
+}
+
+static void test_netstack(void)
+{
+	char *names[] = { "a", "b" }, settings[512];
+	int32_t handles[2], ret;
+	int fd, i;
+
+	for (i = 0; i < 2; ++i) {
+		handles[i] = wgNewNetstackDevice((char *)addresses[i], "", 0, logger, names[i]);
+		check(handles[i] >= 0, "wgNewNetstackDevice: %d", handles[i]);
+		snprintf(settings, sizeof(settings), "private_key=%s\nlisten_port=0\n", private_keys[i]);
+		check(!(ret = wgSetConfig(handles[i], settings)), "wgSetConfig: %d", ret);
+		check(!(ret = wgUp(handles[i])), "wgUp: %d", ret);
+	}
+	for (i = 0; i < 2; ++i) {
+		snprintf(settings, sizeof(settings), "public_key=%s\nendpoint=127.0.0.1:%lld\nallowed_ip=%s/32\n",
+			 public_keys[!i], config_value(handles[!i], "listen_port"), addresses[!i]);
+		check(!(ret = wgSetConfig(handles[i], settings)), "wgSetConfig: %d", ret);
+	}
+
+	ret = wgSetConfig(handles[0], "private_key=invalid\n");
+	check(ret < 0, "wgSetConfig accepted an invalid key");
+	check(wgNewNetstackDevice("not an address", "", 0, NULL, NULL) == -EINVAL, "invalid address accepted");
+
+	fd = wgNetstackDial(handles[0], "udp", "10.9.0.2:9");
+	check(fd >= 0, "wgNetstackDial: %d", fd);
+	check(write(fd, "hello", 5) == 5, "write: %s", strerror(errno));
+	for (i = 0; i < 50 && config_value(handles[1], "rx_bytes") <= 0; ++i)
+		nanosleep(&(struct timespec){ .tv_nsec = 100000000 }, NULL);
+	check(config_value(handles[0], "last_handshake_time_sec") > 0, "no handshake");
+	check(config_value(handles[1], "rx_bytes") > 0, "datagram not received");
+	close(fd);
+
+	check(wgNetstackDial(handles[0], "sctp", "10.9.0.2:9") == -EPROTONOSUPPORT, "unsupported network accepted");
+	check(!(ret = wgDown(handles[0])), "wgDown: %d", ret);
+	for (i = 0; i < 2; ++i)
+		check(!(ret = wgClose(handles[i])), "wgClose: %d", ret);
+	check(wgUp(handles[0]) == -EBADF, "closed handle still valid");
+	check(wgClose(handles[0]) == -EBADF, "closed twice");
+	check(!wgGetConfig(handles[1]), "config of closed handle");
+	check(atomic_load(&log_lines) > 0, "nothing logged");
+	printf("netstack: ok\n");
+}
+
+static void test_tun(void)
+{
+#ifdef __linux__
+	struct ifreq ifr = { .ifr_flags = IFF_TUN | IFF_NO_PI };
+	int32_t handle, ret;
+	int fd;
+
+	fd = open("/dev/net/tun", O_RDWR | O_CLOEXEC);
+	strncpy(ifr.ifr_name, "wglibwg0", IFNAMSIZ - 1);
+	if (fd < 0 || ioctl(fd, TUNSETIFF, &ifr) < 0) {
+		printf("tun: skipped: %s\n", strerror(errno));
+		if (fd >= 0)
+			close(fd);
+		return;
+	}
+	handle = wgNewTUNDevice(fd, 0, logger, "tun");
+	close(fd);
+	check(handle >= 0, "wgNewTUNDevice: %d", handle);
+	check(!(ret = wgSetConfig(handle, "listen_port=0\n")), "wgSetConfig: %d", ret);
+	check(!(ret = wgUp(handle)), "wgUp: %d", ret);
+	check(!(ret = wgClose(handle)), "wgClose: %d", ret);
+	check(wgNewTUNDevice(-1, 0, NULL, NULL) == -EBADF, "invalid file descriptor accepted");
+	printf("tun: ok\n");
+#else
+	printf("tun: skipped: only tested on Linux\n");
+#endif
+}
+
+int main(void)
+{
+	test_netstack();
+	test_tun();
+	return 0;
+}
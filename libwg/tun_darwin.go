@@ -0,0 +1,19 @@
+//go:build cgo
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"os"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// createTUNFromFD takes over fd.
+func createTUNFromFD(fd int, mtu int) (tun.Device, error) {
+	return tun.CreateTUNFromFile(os.NewFile(uintptr(fd), "/dev/tun"), max(mtu, 0))
+}
@@ -0,0 +1,24 @@
+//go:build cgo
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"os"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// createTUNFromFD takes over fd. Without an MTU to set, the interface is left
+// unmonitored, as the embedding process may not be allowed to use netlink.
+func createTUNFromFD(fd int, mtu int) (tun.Device, error) {
+	if mtu <= 0 {
+		tdev, _, err := tun.CreateUnmonitoredTUNFromFD(fd)
+		return tdev, err
+	}
+	return tun.CreateTUNFromFile(os.NewFile(uintptr(fd), "/dev/net/tun"), mtu)
+}
@@ -0,0 +1,348 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	defaultLogMaxSize    = 10 << 20 // rotate after 10 MiB
+	defaultLogMaxBackups = 5
+
+	// logCompressRetryInterval is how long after a failed compression of a
+	// rotated file it is tried again
+	logCompressRetryInterval = time.Minute
+)
+
+// A rotatingFile is an append-only log file that rotates itself once it grows past
+// maxSize bytes or once it has been written to for longer than maxAge.
+// Rotated files are gzip compressed in the background and kept as path.1.gz
+// (newest) up to path.<maxBackups>.gz (oldest). A zero maxSize or maxAge
+// disables that trigger. Until the last rotated file is compressed, the file
+// is not rotated again but grows on, so that nothing is renamed over it.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	file       *os.File
+	size       int64
+	opened     time.Time
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	compressing     sync.WaitGroup // the compression of the last rotated file
+	compressPending atomic.Bool    // that compression has not finished
+	compressStarted time.Time      // when it was last tried
+}
+
+func openRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	f := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file at f.path. The caller must hold f.mu or have exclusive access.
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if (f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize) ||
+		(f.maxAge > 0 && f.size > 0 && time.Since(f.opened) > f.maxAge) {
+		if err := f.rotate(); err != nil {
+			// Keep logging into the current file rather than losing records.
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file %s: %v\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Reopen closes and reopens the log file, for use after an external tool such as
+// logrotate has moved it away.
+func (f *rotatingFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	return f.open()
+}
+
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer f.compressing.Wait()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+func (f *rotatingFile) backupPath(n int) string {
+	return fmt.Sprintf("%s.%d.gz", f.path, n)
+}
+
+// rotate moves the current file into the backups and starts a new one, leaving
+// its compression to a goroutine so that writers are not held up. While the
+// last rotated file is not compressed, it leaves the current file as it is and
+// retries the compression if it failed. The caller must hold f.mu.
+func (f *rotatingFile) rotate() error {
+	rotated := f.path + ".1"
+	if f.maxBackups > 0 {
+		if f.compressPending.Load() {
+			return nil
+		}
+		if _, err := os.Lstat(rotated); err == nil {
+			// its compression failed, and left the newest backup free
+			if time.Since(f.compressStarted) >= logCompressRetryInterval {
+				f.compress(rotated)
+			}
+			return nil
+		}
+	}
+
+	f.file.Close()
+	f.file = nil
+
+	if f.maxBackups > 0 {
+		// shift the existing backups, dropping the oldest
+		os.Remove(f.backupPath(f.maxBackups))
+		for n := f.maxBackups - 1; n > 0; n-- {
+			if err := os.Rename(f.backupPath(n), f.backupPath(n+1)); err != nil && !os.IsNotExist(err) {
+				f.open()
+				return err
+			}
+		}
+		if err := os.Rename(f.path, rotated); err != nil {
+			f.open()
+			return err
+		}
+		f.compress(rotated)
+	} else if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		f.open()
+		return err
+	}
+	return f.open()
+}
+
+// compress compresses the rotated file into the newest backup in the
+// background, and removes it once done. The caller must hold f.mu.
+func (f *rotatingFile) compress(rotated string) {
+	f.compressStarted = time.Now()
+	f.compressPending.Store(true)
+	f.compressing.Add(1)
+	go func() {
+		defer f.compressing.Done()
+		defer f.compressPending.Store(false)
+		if err := compressFile(rotated, f.backupPath(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to compress rotated log file %s: %v\n", rotated, err)
+			return
+		}
+		os.Remove(rotated)
+	}()
+}
+
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if err2 := zw.Close(); err == nil {
+		err = err2
+	}
+	if err2 := out.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
+
+// logLevelFromEnv returns the level of the daemon's logger set by LOG_LEVEL:
+// verbose (default), unless error or silent.
+func logLevelFromEnv() int {
+	switch os.Getenv("LOG_LEVEL") {
+	case "error":
+		return device.LogLevelError
+	case "silent":
+		return device.LogLevelSilent
+	}
+	// Default to verbose level; the devices drop messages below their own level
+	return device.LogLevelVerbose
+}
+
+// deviceLogLevelFromEnv returns the level set by LOG_LEVEL below which devices
+// drop log messages, which the UAPI can change at runtime: info, warn or error.
+// As before there were levels, verbose and debug log everything, like trace, and
+// so does the default.
+func deviceLogLevelFromEnv() slog.Level {
+	switch name := os.Getenv("LOG_LEVEL"); name {
+	case "", "verbose", "debug", "silent":
+		return device.LevelTrace
+	default:
+		level, err := device.ParseLogLevel(name)
+		if err != nil {
+			log.Printf("Invalid LOG_LEVEL %q, logging everything", name)
+			return device.LevelTrace
+		}
+		return level
+	}
+}
+
+// openLogger sets up the daemon's logger from the environment:
+//
+//	LOG_LEVEL        verbose, debug or trace (default, all three log
+//	                 everything), info, warn, error or silent
+//	LOG_FORMAT       text (default) or json
+//	LOG_FILE         log file path (default wireguard-go.log), "-" for stderr only
+//	LOG_FILE_ONLY    "false" to also log to stderr
+//	LOG_MAX_SIZE     rotate after this many MiB (default 10, 0 disables)
+//	LOG_MAX_AGE      rotate after this duration, e.g. 24h (default 0, disabled)
+//	LOG_MAX_BACKUPS  number of compressed rotated files to keep (default 5)
+//
+// It returns the opened log file, if any, so that it can be reopened on request.
+func openLogger(level int, interfaceName string) (*device.Logger, *rotatingFile) {
+	logWriter, logFile := openLogWriter()
+	return newLogger(level, interfaceName, logWriter), logFile
+}
+
+// openLogWriter opens the log destination configured by the LOG_FILE* variables.
+func openLogWriter() (io.Writer, *rotatingFile) {
+	var logWriter io.Writer = os.Stderr
+	var logFile *rotatingFile
+
+	logPath := os.Getenv("LOG_FILE")
+	if logPath == "" {
+		// Default log file in current directory
+		logPath = "wireguard-go.log"
+	}
+
+	// Check if we should log only to file (not to console)
+	// Default to file-only logging unless explicitly set to "false"
+	logFileOnly := os.Getenv("LOG_FILE_ONLY") != "false"
+
+	// Create log file
+	if logPath != "-" {
+		// Ensure directory exists
+		logDir := filepath.Dir(logPath)
+		if logDir != "." {
+			os.MkdirAll(logDir, 0755)
+		}
+
+		maxSize := int64(defaultLogMaxSize)
+		if s := os.Getenv("LOG_MAX_SIZE"); s != "" {
+			if mib, err := strconv.ParseUint(s, 10, 32); err == nil {
+				maxSize = int64(mib) << 20
+			} else {
+				log.Printf("Invalid LOG_MAX_SIZE %q, using default: %v", s, err)
+			}
+		}
+		var maxAge time.Duration
+		if s := os.Getenv("LOG_MAX_AGE"); s != "" {
+			if d, err := time.ParseDuration(s); err == nil {
+				maxAge = d
+			} else {
+				log.Printf("Invalid LOG_MAX_AGE %q, not rotating by age: %v", s, err)
+			}
+		}
+		maxBackups := defaultLogMaxBackups
+		if s := os.Getenv("LOG_MAX_BACKUPS"); s != "" {
+			if n, err := strconv.ParseUint(s, 10, 16); err == nil {
+				maxBackups = int(n)
+			} else {
+				log.Printf("Invalid LOG_MAX_BACKUPS %q, using default: %v", s, err)
+			}
+		}
+
+		file, err := openRotatingFile(logPath, maxSize, maxAge, maxBackups)
+		if err != nil {
+			log.Printf("Failed to open log file %s: %v, using stderr", logPath, err)
+			logWriter = os.Stderr
+		} else {
+			logFile = file
+			if logFileOnly {
+				// Only write to file, not to console (default behavior)
+				logWriter = file
+				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to file only: %s\n", logPath)
+			} else {
+				// Use both file and stderr for logging (when explicitly enabled)
+				logWriter = io.MultiWriter(os.Stderr, file)
+				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to both console and file: %s\n", logPath)
+			}
+		}
+	}
+
+	return logWriter, logFile
+}
+
+// newLogger returns a logger for interfaceName in the format set by LOG_FORMAT.
+func newLogger(level int, interfaceName string, logWriter io.Writer) *device.Logger {
+	if os.Getenv("LOG_FORMAT") == "json" {
+		return device.NewJSONLogger(level, interfaceName, logWriter)
+	}
+	return device.NewTextLogger(
+		level,
+		fmt.Sprintf("(%s) ", interfaceName),
+		logWriter,
+	)
+}
@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readLog(t *testing.T, path string) string {
+	t.Helper()
+	if strings.HasSuffix(path, ".gz") {
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireguard-go.log")
+	f, err := openRotatingFile(path, 16, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first line 01\n", "second line 2\n", "third line 03\n", "fourth line 4\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		// the file is not rotated again until the last rotated one is compressed
+		f.compressing.Wait()
+	}
+
+	if got := readLog(t, path); got != "fourth line 4\n" {
+		t.Errorf("unexpected current log %q", got)
+	}
+	if got := readLog(t, path+".1.gz"); got != "third line 03\n" {
+		t.Errorf("unexpected newest backup %q", got)
+	}
+	if got := readLog(t, path+".2.gz"); got != "second line 2\n" {
+		t.Errorf("unexpected oldest backup %q", got)
+	}
+	if _, err := os.Stat(path + ".3.gz"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups, stat error: %v", err)
+	}
+}
+
+func TestRotatingFileAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireguard-go.log")
+	f, err := openRotatingFile(path, 0, time.Hour, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("old\n"))
+	f.opened = f.opened.Add(-2 * time.Hour)
+	f.Write([]byte("new\n"))
+
+	f.compressing.Wait()
+	if got := readLog(t, path); got != "new\n" {
+		t.Errorf("unexpected current log %q", got)
+	}
+	if got := readLog(t, path+".1.gz"); got != "old\n" {
+		t.Errorf("unexpected backup %q", got)
+	}
+}
+
+func TestRotatingFileCompressFailed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireguard-go.log")
+	f, err := openRotatingFile(path, 8, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// a rotated file left uncompressed is compressed again rather than renamed over
+	if err := os.WriteFile(path+".1", []byte("left\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("first\n"))
+	f.Write([]byte("second\n"))
+	f.compressing.Wait()
+	if got := readLog(t, path); got != "first\nsecond\n" {
+		t.Errorf("unexpected current log %q", got)
+	}
+	if got := readLog(t, path+".1.gz"); got != "left\n" {
+		t.Errorf("unexpected newest backup %q", got)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Errorf("expected the rotated file removed, stat error: %v", err)
+	}
+
+	f.Write([]byte("third\n"))
+	f.compressing.Wait()
+	if got := readLog(t, path+".1.gz"); got != "first\nsecond\n" {
+		t.Errorf("unexpected newest backup %q", got)
+	}
+	if got := readLog(t, path+".2.gz"); got != "left\n" {
+		t.Errorf("unexpected oldest backup %q", got)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wireguard-go.log")
+	f, err := openRotatingFile(path, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	f.Write([]byte("before\n"))
+
+	// what logrotate does before signalling the daemon
+	moved := filepath.Join(dir, "wireguard-go.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("after\n"))
+
+	if got := readLog(t, moved); got != "before\n" {
+		t.Errorf("unexpected moved log %q", got)
+	}
+	if got := readLog(t, path); got != "after\n" {
+		t.Errorf("unexpected reopened log %q", got)
+	}
+}
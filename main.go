@@ -9,13 +9,13 @@ package main
 
 import (
 	"fmt"
-	"io"
-	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"golang.zx2c4.com/wireguard/conn"
@@ -33,10 +33,14 @@ const (
 	ENV_WG_TUN_FD             = "WG_TUN_FD"
 	ENV_WG_UAPI_FD            = "WG_UAPI_FD"
 	ENV_WG_PROCESS_FOREGROUND = "WG_PROCESS_FOREGROUND"
+	ENV_WG_UPGRADE_FD         = "WG_UPGRADE_FD"
 )
 
 func printUsage() {
-	fmt.Printf("Usage: %s [-f/--foreground] INTERFACE-NAME\n", os.Args[0])
+	fmt.Printf("Usage: %s [-f/--foreground] [--config FILE] [--state FILE [--state-interval DURATION]] [--user USER [--group GROUP]] [--seccomp] [--tun-netns NETNS] [--bind-netns NETNS] INTERFACE-NAME\n", os.Args[0])
+	fmt.Printf("       %s --multi [-f/--foreground] [--control SOCKET] [--seccomp] [INTERFACE-NAME[=CONFIG]...]\n", os.Args[0])
+	fmt.Printf("           (interfaces share packet buffers, but each runs its own encryption, decryption and handshake workers)\n")
+	fmt.Printf("       %s --selftest\n", os.Args[0])
 }
 
 func warning() {
@@ -60,143 +64,301 @@ func warning() {
 	fmt.Fprintln(os.Stderr, "└──────────────────────────────────────────────────────┘")
 }
 
+// watchdog pings the service manager's watchdog at half its interval for as long as
+// check finds the packet routines alive, until done is closed. Once a check fails,
+// or blocks on a deadlocked device, the pings stop and the service manager restarts
+// the daemon.
+func watchdog(check func() error, done <-chan struct{}, logger *device.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if err := check(); err != nil {
+			logger.Errorf("Watchdog: liveness check failed: %v", err)
+			continue
+		}
+		if err := sdNotify("WATCHDOG=1"); err != nil {
+			logger.Errorf("Watchdog: failed to notify service manager: %v", err)
+		}
+	}
+}
+
+// childEnv returns the environment of the current process without the variables
+// that pass descriptors to it, followed by env, for another instance of the daemon.
+func childEnv(env ...string) []string {
+	inherited := make([]string, 0, len(os.Environ())+len(env))
+	for _, e := range os.Environ() {
+		name, _, _ := strings.Cut(e, "=")
+		switch name {
+		case ENV_WG_TUN_FD, ENV_WG_UAPI_FD, ENV_WG_UPGRADE_FD, ENV_WG_PROCESS_FOREGROUND:
+		default:
+			inherited = append(inherited, e)
+		}
+	}
+	return append(inherited, env...)
+}
+
+// daemonize starts the daemon again in the background, passing it files as
+// descriptors 3 and up and env in addition to the current environment.
+func daemonize(files []*os.File, env []string, logLevel int) error {
+	env = append(os.Environ(), env...)
+	env = append(env, fmt.Sprintf("%s=1", ENV_WG_PROCESS_FOREGROUND))
+	stdio := [3]*os.File{}
+	if os.Getenv("LOG_LEVEL") != "" && logLevel != device.LogLevelSilent {
+		stdio[0], _ = os.Open(os.DevNull)
+		stdio[1] = os.Stdout
+		stdio[2] = os.Stderr
+	} else {
+		stdio[0], _ = os.Open(os.DevNull)
+		stdio[1], _ = os.Open(os.DevNull)
+		stdio[2], _ = os.Open(os.DevNull)
+	}
+	attr := &os.ProcAttr{
+		Files: append(stdio[:], files...),
+		Dir:   ".",
+		Env:   env,
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to determine executable: %w", err)
+	}
+
+	process, err := os.StartProcess(
+		path,
+		os.Args,
+		attr,
+	)
+	if err != nil {
+		return err
+	}
+	return process.Release()
+}
+
+// createTUN creates the TUN device, or uses the one passed by the process that
+// started this one.
+func createTUN(interfaceName string) (tun.Device, error) {
+	tunFdStr := os.Getenv(ENV_WG_TUN_FD)
+	if tunFdStr == "" {
+		return tun.CreateTUN(interfaceName, device.DefaultMTU)
+	}
+
+	// construct tun device from supplied fd
+
+	fd, err := strconv.ParseUint(tunFdStr, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.SetNonblock(int(fd), true)
+	if err != nil {
+		return nil, err
+	}
+
+	file := os.NewFile(uintptr(fd), "")
+	return tun.CreateTUNFromFile(file, device.DefaultMTU)
+}
+
 func main() {
 	if len(os.Args) == 2 && os.Args[1] == "--version" {
 		fmt.Printf("wireguard-go v%s\n\nUserspace WireGuard daemon for %s-%s.\nInformation available at https://www.wireguard.com.\nCopyright (C) Jason A. Donenfeld <Jason@zx2c4.com>.\n", Version, runtime.GOOS, runtime.GOARCH)
 		return
 	}
+	if len(os.Args) == 2 && os.Args[1] == "--selftest" {
+		os.Exit(runSelfTest())
+	}
 
 	warning()
+	keyLogWarning()
 
 	var foreground bool
+	var seccomp bool
+	var multi bool
 	var interfaceName string
-	if len(os.Args) < 2 || len(os.Args) > 3 {
-		printUsage()
-		return
+	var interfaceArgs []string
+	var configFile string
+	var controlSocket string
+	var userName, groupName string
+	var stateFile, stateInterval string
+	var tunNetns, bindNetns string
+
+	valueOptions := map[string]*string{
+		"--config":         &configFile,
+		"--state":          &stateFile,
+		"--state-interval": &stateInterval,
+		"--user":           &userName,
+		"--group":          &groupName,
+		"--control":        &controlSocket,
+		"--tun-netns":      &tunNetns,
+		"--bind-netns":     &bindNetns,
 	}
 
-	switch os.Args[1] {
-
-	case "-f", "--foreground":
-		foreground = true
-		if len(os.Args) != 3 {
+	for i := 1; i < len(os.Args); i++ {
+		name, value, hasValue := strings.Cut(os.Args[i], "=")
+		switch arg := os.Args[i]; {
+		case arg == "-f" || arg == "--foreground":
+			foreground = true
+		case arg == "--seccomp":
+			seccomp = true
+		case arg == "--multi":
+			multi = true
+		case valueOptions[arg] != nil:
+			if i+1 == len(os.Args) {
+				printUsage()
+				return
+			}
+			i++
+			*valueOptions[arg] = os.Args[i]
+		case hasValue && valueOptions[name] != nil:
+			*valueOptions[name] = value
+		case !strings.HasPrefix(arg, "-"):
+			interfaceArgs = append(interfaceArgs, arg)
+		default:
 			printUsage()
 			return
 		}
-		interfaceName = os.Args[2]
+	}
 
-	default:
-		foreground = false
-		if len(os.Args) != 2 {
+	if multi {
+		if configFile != "" || stateFile != "" || stateInterval != "" || userName != "" || groupName != "" || tunNetns != "" || bindNetns != "" {
 			printUsage()
 			return
 		}
-		interfaceName = os.Args[1]
+		if controlSocket == "" {
+			controlSocket = defaultControlSocket
+		}
+		runMulti(interfaceArgs, controlSocket, foreground, seccomp)
+		return
 	}
 
-	if !foreground {
-		foreground = os.Getenv(ENV_WG_PROCESS_FOREGROUND) == "1"
+	if len(interfaceArgs) != 1 || controlSocket != "" || (groupName != "" && userName == "") || (stateInterval != "" && stateFile == "") {
+		printUsage()
+		return
 	}
+	interfaceName = interfaceArgs[0]
 
-	// get log level (default: debug/verbose)
-
-	logLevel := func() int {
-		switch os.Getenv("LOG_LEVEL") {
-		case "verbose", "debug":
-			return device.LogLevelVerbose
-		case "error":
-			return device.LogLevelError
-		case "silent":
-			return device.LogLevelSilent
-		}
-		// Default to verbose/debug level
-		return device.LogLevelVerbose
-	}()
+	stateSaveInterval := defaultStateSaveInterval
+	if stateInterval != "" {
+		d, err := time.ParseDuration(stateInterval)
+		if err != nil || d <= 0 {
+			fmt.Fprintf(os.Stderr, "Invalid state save interval %q\n", stateInterval)
+			os.Exit(ExitSetupFailed)
+		}
+		stateSaveInterval = d
+	}
 
-	// open TUN device (or use supplied fd)
+	// An unprivileged process cannot switch namespaces, which it needs to set up a
+	// TUN device it inherits.
 
-	tdev, err := func() (tun.Device, error) {
-		tunFdStr := os.Getenv(ENV_WG_TUN_FD)
-		if tunFdStr == "" {
-			return tun.CreateTUN(interfaceName, device.DefaultMTU)
-		}
+	if tunNetns != "" && userName != "" {
+		fmt.Fprintln(os.Stderr, "--tun-netns cannot be combined with --user")
+		os.Exit(ExitSetupFailed)
+	}
 
-		// construct tun device from supplied fd
+	// The process lives in the namespace of its UDP sockets, so that they, the
+	// sticky socket route listener and name resolution all use it, while the TUN
+	// device is created in its own namespace.
 
-		fd, err := strconv.ParseUint(tunFdStr, 10, 32)
-		if err != nil {
-			return nil, err
+	if bindNetns != "" {
+		ns, err := openNetns(bindNetns)
+		if err == nil {
+			err = enterNetns(ns)
+			ns.Close()
 		}
-
-		err = unix.SetNonblock(int(fd), true)
 		if err != nil {
-			return nil, err
+			fmt.Fprintf(os.Stderr, "Failed to enter network namespace %s: %v\n", bindNetns, err)
+			os.Exit(ExitSetupFailed)
 		}
-
-		file := os.NewFile(uintptr(fd), "")
-		return tun.CreateTUNFromFile(file, device.DefaultMTU)
-	}()
-
-	if err == nil {
-		realInterfaceName, err2 := tdev.Name()
-		if err2 == nil {
-			interfaceName = realInterfaceName
+	}
+	var tunNS *os.File
+	if tunNetns != "" {
+		var err error
+		tunNS, err = openNetns(tunNetns)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open network namespace %s: %v\n", tunNetns, err)
+			os.Exit(ExitSetupFailed)
 		}
 	}
 
-	// Setup log file if specified
-	var logWriter io.Writer = os.Stderr
-	logFile := os.Getenv("LOG_FILE")
-	if logFile == "" {
-		// Default log file in current directory
-		logFile = "wireguard-go.log"
+	if !foreground {
+		foreground = os.Getenv(ENV_WG_PROCESS_FOREGROUND) == "1"
 	}
 
-	// Check if we should log only to file (not to console)
-	// Default to file-only logging unless explicitly set to "false"
-	logFileOnly := os.Getenv("LOG_FILE_ONLY") != "false"
+	// Under systemd, the service manager tracks this process, so never daemonize.
 
-	// Create log file
-	if logFile != "" && logFile != "-" {
-		// Ensure directory exists
-		logDir := filepath.Dir(logFile)
-		if logDir != "." {
-			os.MkdirAll(logDir, 0755)
-		}
+	activatedUAPI := sdListenFile()
+	if activatedUAPI != nil || sdNotifyEnabled() {
+		foreground = true
+	}
+
+	// load configuration file, so that errors are reported before daemonizing
 
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	var config *daemonConfig
+	if configFile != "" {
+		var err error
+		config, err = loadConfig(configFile)
 		if err != nil {
-			log.Printf("Failed to open log file %s: %v, using stderr", logFile, err)
-			logWriter = os.Stderr
-		} else {
-			if logFileOnly {
-				// Only write to file, not to console (default behavior)
-				logWriter = file
-				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to file only: %s\n", logFile)
-			} else {
-				// Use both file and stderr for logging (when explicitly enabled)
-				logWriter = io.MultiWriter(os.Stderr, file)
-				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to both console and file: %s\n", logFile)
-			}
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			os.Exit(ExitSetupFailed)
 		}
 	}
 
-	logger := device.NewLoggerWithWriter(
-		logLevel,
-		fmt.Sprintf("(%s) ", interfaceName),
-		logWriter,
-	)
+	// get log level (default: debug/verbose)
+
+	logLevel := logLevelFromEnv()
+
+	// open TUN device (or use supplied fd) in its network namespace, along with
+	// the sockets the TUN implementation watches and configures it through
 
+	var tdev tun.Device
+	err := inNetns(tunNS, func() (err error) {
+		tdev, err = createTUN(interfaceName)
+		return err
+	})
+	if tunNS != nil {
+		tunNS.Close()
+	}
+
+	if err == nil {
+		realInterfaceName, err2 := tdev.Name()
+		if err2 == nil {
+			interfaceName = realInterfaceName
+		}
+	}
+
+	logger, logFile := openLogger(logLevel, interfaceName)
 	logger.Verbosef("Starting wireguard-go version %s", Version)
 
 	if err != nil {
 		logger.Errorf("Failed to create TUN device: %v", err)
 		os.Exit(ExitSetupFailed)
 	}
+	if tunNetns != "" {
+		logger.Verbosef("TUN device in network namespace %s", tunNetns)
+	}
+	if bindNetns != "" {
+		logger.Verbosef("UDP sockets in network namespace %s", bindNetns)
+	}
+
+	// a running instance may have started this one to hand its device over
+
+	takeover, err := openUpgradeConn()
+	if err != nil {
+		logger.Errorf("Failed to open upgrade socket: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
 
 	// open UAPI file (or use supplied fd)
 
-	fileUAPI, err := func() (*os.File, error) {
+	openUAPI := func() (*os.File, error) {
+		if activatedUAPI != nil {
+			return activatedUAPI, nil
+		}
+
 		uapiFdStr := os.Getenv(ENV_WG_UAPI_FD)
 		if uapiFdStr == "" {
 			return ipc.UAPIOpen(interfaceName)
@@ -210,73 +372,157 @@ func main() {
 		}
 
 		return os.NewFile(uintptr(fd), ""), nil
-	}()
-	if err != nil {
-		logger.Errorf("UAPI listen error: %v", err)
-		os.Exit(ExitSetupFailed)
-		return
 	}
-	// daemonize the process
 
-	if !foreground {
-		env := os.Environ()
-		env = append(env, fmt.Sprintf("%s=3", ENV_WG_TUN_FD))
-		env = append(env, fmt.Sprintf("%s=4", ENV_WG_UAPI_FD))
-		env = append(env, fmt.Sprintf("%s=1", ENV_WG_PROCESS_FOREGROUND))
-		files := [3]*os.File{}
-		if os.Getenv("LOG_LEVEL") != "" && logLevel != device.LogLevelSilent {
-			files[0], _ = os.Open(os.DevNull)
-			files[1] = os.Stdout
-			files[2] = os.Stderr
-		} else {
-			files[0], _ = os.Open(os.DevNull)
-			files[1], _ = os.Open(os.DevNull)
-			files[2], _ = os.Open(os.DevNull)
-		}
-		attr := &os.ProcAttr{
-			Files: []*os.File{
-				files[0], // stdin
-				files[1], // stdout
-				files[2], // stderr
-				tdev.File(),
-				fileUAPI,
-			},
-			Dir: ".",
-			Env: env,
-		}
-
-		path, err := os.Executable()
+	// With a configuration file, the UAPI socket is only opened once the
+	// configuration has been applied, so that it never exposes a device
+	// without keys. Dropping privileges needs it before, but then nothing
+	// accepts connections until the configuration is applied.
+
+	var fileUAPI *os.File
+	if config == nil || userName != "" {
+		fileUAPI, err = openUAPI()
 		if err != nil {
-			logger.Errorf("Failed to determine executable: %v", err)
+			logger.Errorf("UAPI listen error: %v", err)
 			os.Exit(ExitSetupFailed)
+			return
 		}
+	}
 
-		process, err := os.StartProcess(
-			path,
-			os.Args,
-			attr,
-		)
-		if err != nil {
+	// daemonize the process
+
+	if !foreground {
+		files := []*os.File{tdev.File()}
+		env := []string{fmt.Sprintf("%s=3", ENV_WG_TUN_FD)}
+		if fileUAPI != nil {
+			files = append(files, fileUAPI)
+			env = append(env, fmt.Sprintf("%s=4", ENV_WG_UAPI_FD))
+		}
+		if err := daemonize(files, env, logLevel); err != nil {
 			logger.Errorf("Failed to daemonize: %v", err)
 			os.Exit(ExitSetupFailed)
 		}
-		process.Release()
 		return
 	}
 
-	device := device.NewDevice(tdev, conn.NewDefaultBind(), logger)
+	// switch to an unprivileged account now that the TUN device and UAPI socket are open
+
+	if userName != "" && os.Geteuid() == 0 {
+		err := dropPrivileges(userName, groupName, requiredCapabilities(config), tdev.File(), fileUAPI, logFile, logger)
+		logger.Errorf("Failed to drop privileges: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
+	bind := conn.NewDefaultBind()
+	var handoff *upgradeState
+	if takeover != nil {
+		var files []*os.File
+		handoff, files, err = takeover.receive()
+		if err == nil {
+			bind, err = conn.NewStdNetBindFromFiles(files)
+			for _, file := range files {
+				file.Close()
+			}
+		}
+		if err != nil {
+			logger.Errorf("Failed to receive device from the running process: %v", err)
+			takeover.fail(err)
+			os.Exit(ExitSetupFailed)
+		}
+	}
+
+	device := device.NewDevice(tdev, bind, logger)
+	device.SetLogLevel(deviceLogLevelFromEnv())
+	device.SetVersion(Version)
+	if stateFile == "" {
+		device.RefuseQuotas("quota usage is only kept across restarts with --state")
+	}
+	if !seccomp {
+		// live upgrades hand the sessions over
+		device.EnableHandoff()
+	}
 
 	logger.Verbosef("Device started")
 
+	keyLog, err := openKeyLog(logger)
+	if err != nil {
+		logger.Errorf("Failed to open key log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+	if keyLog != nil {
+		device.SetKeyLog(keyLog)
+	}
+	auditLog, err := openAuditLog()
+	if err != nil {
+		logger.Errorf("Failed to open audit log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+	if auditLog != nil {
+		device.SetAuditLog(auditLog, interfaceName)
+	}
+
+	// Until it has taken the device over, this instance must not close it: on some
+	// platforms, that would destroy the interface the running instance still uses.
+
+	switch {
+	case handoff != nil:
+		if err := device.ApplyHandoff(handoff.Device); err != nil {
+			logger.Errorf("Failed to take over device: %v", err)
+			takeover.fail(err)
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Device taken over from the running process")
+	case config != nil:
+		if err := device.IpcSet(config.uapi(nil, nil)); err != nil {
+			logger.Errorf("Failed to apply configuration from %s: %v", configFile, err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Configuration applied from %s", configFile)
+	}
+
+	if fileUAPI == nil {
+		fileUAPI, err = openUAPI()
+		if err != nil {
+			logger.Errorf("UAPI listen error: %v", err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+	}
+
+	// restore what the previous run learned about the configured peers, unless
+	// the running process handed it over
+
+	var saveTicker <-chan time.Time
+	if stateFile != "" {
+		if handoff == nil {
+			if err := restoreState(device, logger, stateFile); err != nil {
+				logger.Errorf("Failed to restore state: %v", err)
+			}
+		}
+		ticker := time.NewTicker(stateSaveInterval)
+		defer ticker.Stop()
+		saveTicker = ticker.C
+	}
+
 	errs := make(chan error)
 	term := make(chan os.Signal, 1)
-
-	uapi, err := ipc.UAPIListen(interfaceName, fileUAPI)
+	hup := make(chan os.Signal, 1)
+	usr1 := make(chan os.Signal, 1)
+	usr2 := make(chan os.Signal, 1)
+
+	activated := activatedUAPI != nil || (handoff != nil && handoff.ActivatedUAPI)
+	uapiListen := ipc.UAPIListen
+	if activated {
+		uapiListen = sdUAPIListen
+	}
+	uapi, err := uapiListen(interfaceName, fileUAPI)
 	if err != nil {
 		logger.Errorf("Failed to listen on uapi socket: %v", err)
 		os.Exit(ExitSetupFailed)
 	}
 
+	var uapiPaused sync.Mutex // held while a live upgrade hands the device over
 	go func() {
 		for {
 			conn, err := uapi.Accept()
@@ -284,27 +530,134 @@ func main() {
 				errs <- err
 				return
 			}
+			uapiPaused.Lock()
+			uapiPaused.Unlock()
 			go device.IpcHandle(conn)
 		}
 	}()
 
 	logger.Verbosef("UAPI listener started")
 
+	// serve metrics before the sandbox is in place
+
+	metricsDevices := func() []metricsDevice {
+		return []metricsDevice{{interfaceName, device}}
+	}
+	metrics, err := startMetrics(metricsDevices, logger)
+	if err != nil {
+		logger.Errorf("Failed to serve metrics: %v", err)
+		if takeover != nil {
+			takeover.fail(err)
+		}
+		os.Exit(ExitSetupFailed)
+	}
+
+	if takeover != nil {
+		if err := takeover.done(); err != nil {
+			logger.Errorf("Failed to report takeover to the previous process: %v", err)
+		}
+	}
+
+	if seccomp {
+		if err := enableSeccomp(); err != nil {
+			logger.Errorf("Failed to enable seccomp sandbox: %v", err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Seccomp sandbox enabled")
+	}
+
+	// tell the service manager that the device is configured and manageable
+
+	if err := sdNotify("READY=1\nSTATUS=Device " + interfaceName + " running"); err != nil {
+		logger.Errorf("Failed to notify service manager: %v", err)
+	}
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go watchdog(device.CheckLiveness, device.Wait(), logger, interval)
+	}
+
 	// wait for program to terminate
 
 	signal.Notify(term, unix.SIGTERM)
 	signal.Notify(term, os.Interrupt)
-
-	select {
-	case <-term:
-	case <-errs:
-	case <-device.Wait():
+	if config != nil {
+		signal.Notify(hup, unix.SIGHUP)
+	}
+	if logFile != nil {
+		signal.Notify(usr1, unix.SIGUSR1)
+	}
+	signal.Notify(usr2, unix.SIGUSR2)
+
+	upgraded := false
+wait:
+	for {
+		select {
+		case <-term:
+			break wait
+		case <-errs:
+			break wait
+		case <-device.Wait():
+			break wait
+		case <-hup:
+			config = reloadConfig(device, logger, configFile, config)
+		case <-saveTicker:
+			if err := saveState(device, interfaceName, stateFile); err != nil {
+				logger.Errorf("Failed to save state: %v", err)
+			}
+		case <-usr1:
+			if err := logFile.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				continue
+			}
+			logger.Verbosef("Log file reopened")
+		case <-usr2:
+			if seccomp {
+				logger.Errorf("Live upgrade failed: not possible in the seccomp sandbox")
+				continue
+			}
+			// the new process listens on the metrics address in turn
+			if metrics != nil {
+				metrics.Close()
+			}
+			if err := upgrade(device, tdev.File(), fileUAPI, activated, &uapiPaused, logger); err != nil {
+				logger.Errorf("Live upgrade failed: %v", err)
+				if metrics != nil {
+					if metrics, err = startMetrics(metricsDevices, logger); err != nil {
+						logger.Errorf("Failed to serve metrics: %v", err)
+					}
+				}
+				continue
+			}
+			upgraded = true
+			break wait
+		}
 	}
 
 	// clean up
 
+	if upgraded {
+		// the new process owns the device, the UAPI socket and the state file now
+		logger.Verbosef("Shutting down after live upgrade")
+		if logFile != nil {
+			logFile.Close()
+		}
+		return
+	}
+
+	sdNotify("STOPPING=1")
 	uapi.Close()
+	if metrics != nil {
+		metrics.Close()
+	}
+	if stateFile != "" {
+		if err := saveState(device, interfaceName, stateFile); err != nil {
+			logger.Errorf("Failed to save state: %v", err)
+		}
+	}
 	device.Close()
 
 	logger.Verbosef("Shutting down")
+	if logFile != nil {
+		logFile.Close()
+	}
 }
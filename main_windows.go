@@ -7,11 +7,8 @@ package main
 
 import (
 	"fmt"
-	"io"
-	"log"
 	"os"
 	"os/signal"
-	"path/filepath"
 
 	"golang.org/x/sys/windows"
 
@@ -28,69 +25,37 @@ const (
 )
 
 func main() {
-	if len(os.Args) != 2 {
+	var interfaceName, configFile string
+	switch {
+	case len(os.Args) == 2 && os.Args[1] == "--selftest":
+		os.Exit(runSelfTest())
+	case len(os.Args) == 2:
+		interfaceName = os.Args[1]
+	case len(os.Args) == 4 && os.Args[1] == "--config":
+		configFile = os.Args[2]
+		interfaceName = os.Args[3]
+	default:
 		os.Exit(ExitSetupFailed)
 	}
-	interfaceName := os.Args[1]
 
-	// fmt.Fprintln(os.Stderr, "Warning: this is a test program for Windows, mainly used for debugging this Go package. For a real WireGuard for Windows client, the repo you want is <https://git.zx2c4.com/wireguard-windows/>, which includes this code as a module.")
-
-	// Setup log file if specified
-	var logWriter io.Writer = os.Stderr
-	logFile := os.Getenv("LOG_FILE")
-	if logFile == "" {
-		// Default log file in current directory
-		logFile = "wireguard-go.log"
-	}
-
-	// Check if we should log only to file (not to console)
-	// Default to file-only logging unless explicitly set to "false"
-	logFileOnly := os.Getenv("LOG_FILE_ONLY") != "false"
-
-	// Create log file
-	if logFile != "" && logFile != "-" {
-		// Ensure directory exists
-		logDir := filepath.Dir(logFile)
-		if logDir != "." {
-			os.MkdirAll(logDir, 0755)
-		}
-
-		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	var config *daemonConfig
+	if configFile != "" {
+		var err error
+		config, err = loadConfig(configFile)
 		if err != nil {
-			log.Printf("Failed to open log file %s: %v, using stderr", logFile, err)
-			logWriter = os.Stderr
-		} else {
-			if logFileOnly {
-				// Only write to file, not to console (default behavior)
-				logWriter = file
-				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to file only: %s\n", logFile)
-			} else {
-				// Use both file and stderr for logging (when explicitly enabled)
-				logWriter = io.MultiWriter(os.Stderr, file)
-				fmt.Fprintf(os.Stderr, "Debug logging enabled, output to both console and file: %s\n", logFile)
-			}
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			os.Exit(ExitSetupFailed)
 		}
 	}
 
+	// fmt.Fprintln(os.Stderr, "Warning: this is a test program for Windows, mainly used for debugging this Go package. For a real WireGuard for Windows client, the repo you want is <https://git.zx2c4.com/wireguard-windows/>, which includes this code as a module.")
+
 	// get log level (default: debug/verbose)
-	logLevel := func() int {
-		switch os.Getenv("LOG_LEVEL") {
-		case "verbose", "debug":
-			return device.LogLevelVerbose
-		case "error":
-			return device.LogLevelError
-		case "silent":
-			return device.LogLevelSilent
-		}
-		// Default to verbose/debug level
-		return device.LogLevelVerbose
-	}()
+	logLevel := logLevelFromEnv()
 
-	logger := device.NewLoggerWithWriter(
-		logLevel,
-		fmt.Sprintf("(%s) ", interfaceName),
-		logWriter,
-	)
+	keyLogWarning()
+
+	logger, logFile := openLogger(logLevel, interfaceName)
 	logger.Verbosef("Starting wireguard-go version %s", Version)
 
 	tun, err := tun.CreateTUN(interfaceName, 0)
@@ -105,6 +70,25 @@ func main() {
 	}
 
 	device := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	device.SetLogLevel(deviceLogLevelFromEnv())
+	device.SetVersion(Version)
+	device.RefuseQuotas("quota usage would not be kept across restarts, as there is no state file")
+	keyLog, err := openKeyLog(logger)
+	if err != nil {
+		logger.Errorf("Failed to open key log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+	if keyLog != nil {
+		device.SetKeyLog(keyLog)
+	}
+	auditLog, err := openAuditLog()
+	if err != nil {
+		logger.Errorf("Failed to open audit log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+	if auditLog != nil {
+		device.SetAuditLog(auditLog, interfaceName)
+	}
 	err = device.Up()
 	if err != nil {
 		logger.Errorf("Failed to bring up device: %v", err)
@@ -118,6 +102,16 @@ func main() {
 		logger.Verbosef("DNS monitoring started")
 	}
 
+	// apply the configuration file before the UAPI pipe is opened
+	if config != nil {
+		if err := device.IpcSet(config.uapi(nil, nil)); err != nil {
+			logger.Errorf("Failed to apply configuration from %s: %v", configFile, err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Configuration applied from %s", configFile)
+	}
+
 	uapi, err := ipc.UAPIListen(interfaceName)
 	if err != nil {
 		logger.Errorf("Failed to listen on uapi socket: %v", err)
@@ -139,6 +133,14 @@ func main() {
 	}()
 	logger.Verbosef("UAPI listener started")
 
+	metrics, err := startMetrics(func() []metricsDevice {
+		return []metricsDevice{{interfaceName, device}}
+	}, logger)
+	if err != nil {
+		logger.Errorf("Failed to serve metrics: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
 	// wait for program to terminate
 
 	signal.Notify(term, os.Interrupt)
@@ -160,7 +162,13 @@ func main() {
 	}
 
 	uapi.Close()
+	if metrics != nil {
+		metrics.Close()
+	}
 	device.Close()
 
 	logger.Verbosef("Shutting down")
+	if logFile != nil {
+		logFile.Close()
+	}
 }
@@ -0,0 +1,221 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// metricsDevice is a device whose metrics are served, along with the name of its
+// interface, which labels them.
+type metricsDevice struct {
+	name   string
+	device *device.Device
+}
+
+// startMetrics serves the metrics of the devices returned by devices in the
+// OpenMetrics text format at /metrics, on the address in METRICS_LISTEN. It
+// returns nil if METRICS_LISTEN is not set.
+func startMetrics(devices func() []metricsDevice, logger *device.Logger) (*http.Server, error) {
+	addr := os.Getenv("METRICS_LISTEN")
+	if addr == "" {
+		return nil, nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", openMetricsContentType)
+		w.Write(formatMetrics(devices()))
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("Metrics server failed: %v", err)
+		}
+	}()
+	logger.Verbosef("Serving metrics on http://%s/metrics", ln.Addr())
+	return server, nil
+}
+
+// metricsWriter builds an exposition in the OpenMetrics text format.
+type metricsWriter struct {
+	bytes.Buffer
+}
+
+// family starts the metric family name. Counters get their samples named with
+// a _total suffix, and info families with _info.
+func (w *metricsWriter) family(name, typ, unit, help string) {
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+	if unit != "" {
+		w.WriteString("# UNIT " + name + " " + unit + "\n")
+	}
+	w.WriteString("# HELP " + name + " " + help + "\n")
+}
+
+// sample adds a sample with labels given as name and value pairs.
+func (w *metricsWriter) sample(name string, value float64, labels ...string) {
+	w.WriteString(name)
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i == 0 {
+			w.WriteByte('{')
+		} else {
+			w.WriteByte(',')
+		}
+		w.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		if i+2 >= len(labels) {
+			w.WriteByte('}')
+		}
+	}
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	w.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+func boolMetric(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formatMetrics returns the exposition of the metrics of devices and of the Go
+// runtime.
+func formatMetrics(devices []metricsDevice) []byte {
+	type snapshot struct {
+		name    string
+		metrics device.Metrics
+	}
+	snapshots := make([]snapshot, len(devices))
+	for i, d := range devices {
+		m := d.device.Metrics()
+		slices.SortFunc(m.Peers, func(a, b device.PeerMetrics) int {
+			return bytes.Compare(a.PublicKey[:], b.PublicKey[:])
+		})
+		snapshots[i] = snapshot{d.name, m}
+	}
+	forEachPeer := func(f func(iface, key string, peer *device.PeerMetrics)) {
+		for _, s := range snapshots {
+			for i := range s.metrics.Peers {
+				peer := &s.metrics.Peers[i]
+				f(s.name, base64.StdEncoding.EncodeToString(peer.PublicKey[:]), peer)
+			}
+		}
+	}
+
+	var w metricsWriter
+
+	w.family("wireguard_peer_receive_bytes", "counter", "bytes", "Bytes received from the peer.")
+	forEachPeer(func(iface, key string, peer *device.PeerMetrics) {
+		w.sample("wireguard_peer_receive_bytes_total", float64(peer.RxBytes), "interface", iface, "public_key", key)
+	})
+	w.family("wireguard_peer_transmit_bytes", "counter", "bytes", "Bytes sent to the peer.")
+	forEachPeer(func(iface, key string, peer *device.PeerMetrics) {
+		w.sample("wireguard_peer_transmit_bytes_total", float64(peer.TxBytes), "interface", iface, "public_key", key)
+	})
+	w.family("wireguard_peer_last_handshake_timestamp_seconds", "gauge", "seconds", "Time of the last completed handshake with the peer, 0 if none.")
+	forEachPeer(func(iface, key string, peer *device.PeerMetrics) {
+		var ts float64
+		if !peer.LastHandshake.IsZero() {
+			ts = float64(peer.LastHandshake.UnixNano()) / 1e9
+		}
+		w.sample("wireguard_peer_last_handshake_timestamp_seconds", ts, "interface", iface, "public_key", key)
+	})
+	w.family("wireguard_peer_endpoint", "info", "", "Current endpoint of the peer, and the domain name it was resolved from if the DNS monitor follows it.")
+	forEachPeer(func(iface, key string, peer *device.PeerMetrics) {
+		if peer.Endpoint == "" {
+			return
+		}
+		labels := []string{"interface", iface, "public_key", key, "endpoint", peer.Endpoint}
+		if peer.DNSHost != "" {
+			labels = append(labels, "host", peer.DNSHost)
+		}
+		w.sample("wireguard_peer_endpoint_info", 1, labels...)
+	})
+	w.family("wireguard_peer_dns_resolve_failures", "counter", "", "Failed resolutions of the domain name of the endpoint by the DNS monitor.")
+	forEachPeer(func(iface, key string, peer *device.PeerMetrics) {
+		if peer.DNSHost != "" {
+			w.sample("wireguard_peer_dns_resolve_failures_total", float64(peer.DNSResolveFailures), "interface", iface, "public_key", key, "host", peer.DNSHost)
+		}
+	})
+
+	w.family("wireguard_pool_in_use", "gauge", "", "Items taken from a buffer or queue element pool and not yet returned. Pools shared by several interfaces count the items of all of them.")
+	for _, s := range snapshots {
+		for _, pool := range s.metrics.Pools {
+			w.sample("wireguard_pool_in_use", float64(pool.InUse), "interface", s.name, "pool", pool.Name)
+		}
+	}
+	w.family("wireguard_pool_limit", "gauge", "", "Maximum number of items of a pool in use; absent if unlimited.")
+	for _, s := range snapshots {
+		for _, pool := range s.metrics.Pools {
+			if pool.Max != 0 {
+				w.sample("wireguard_pool_limit", float64(pool.Max), "interface", s.name, "pool", pool.Name)
+			}
+		}
+	}
+	w.family("wireguard_queue_depth", "gauge", "", "Elements waiting in the encryption, decryption or handshake queue.")
+	for _, s := range snapshots {
+		for _, queue := range s.metrics.Queues {
+			w.sample("wireguard_queue_depth", float64(queue.Depth), "interface", s.name, "queue", queue.Name)
+		}
+	}
+	w.family("wireguard_queue_capacity", "gauge", "", "Capacity of the encryption, decryption or handshake queue.")
+	for _, s := range snapshots {
+		for _, queue := range s.metrics.Queues {
+			w.sample("wireguard_queue_capacity", float64(queue.Capacity), "interface", s.name, "queue", queue.Name)
+		}
+	}
+	w.family("wireguard_under_load", "gauge", "", "Whether the interface is under load and answers handshake initiations with cookies.")
+	for _, s := range snapshots {
+		w.sample("wireguard_under_load", boolMetric(s.metrics.UnderLoad), "interface", s.name)
+	}
+	w.family("wireguard_ratelimiter_entries", "gauge", "", "Source addresses tracked by the handshake rate limiter.")
+	for _, s := range snapshots {
+		w.sample("wireguard_ratelimiter_entries", float64(s.metrics.RatelimiterEntries), "interface", s.name)
+	}
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	w.family("go", "info", "", "Version of the Go runtime.")
+	w.sample("go_info", 1, "version", runtime.Version())
+	w.family("go_goroutines", "gauge", "", "Number of goroutines.")
+	w.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.family("go_memstats_heap_alloc_bytes", "gauge", "bytes", "Bytes of allocated heap objects.")
+	w.sample("go_memstats_heap_alloc_bytes", float64(mem.HeapAlloc))
+	w.family("go_memstats_heap_objects", "gauge", "", "Number of allocated heap objects.")
+	w.sample("go_memstats_heap_objects", float64(mem.HeapObjects))
+	w.family("go_memstats_sys_bytes", "gauge", "bytes", "Bytes of memory obtained from the operating system.")
+	w.sample("go_memstats_sys_bytes", float64(mem.Sys))
+	w.family("go_gc_cycles", "counter", "", "Completed garbage collection cycles.")
+	w.sample("go_gc_cycles_total", float64(mem.NumGC))
+	w.family("go_gc_pause_seconds", "counter", "seconds", "Time the program was paused for garbage collection.")
+	w.sample("go_gc_pause_seconds_total", float64(mem.PauseTotalNs)/1e9)
+
+	w.WriteString("# EOF\n")
+	return w.Bytes()
+}
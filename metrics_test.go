@@ -0,0 +1,47 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	binds := bindtest.NewChannelBinds()
+	dev := device.NewDevice(tuntest.NewChannelTUN().TUN(), binds[0], device.NewLogger(device.LogLevelSilent, ""))
+	defer dev.Close()
+	if err := dev.IpcSet("private_key=e84b5a6d2717c1003a13b431570353dbaca9146cf150c5f8575680feba52027a\n" +
+		"public_key=c4c8e984c5322c8184c72265b92b250fdb63688705f504ba003c88f03393cf28\n" +
+		"endpoint=127.0.0.1:51820\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(formatMetrics([]metricsDevice{{"wg\"0", dev}}))
+	for _, want := range []string{
+		"# TYPE wireguard_peer_receive_bytes counter\n# UNIT wireguard_peer_receive_bytes bytes\n",
+		`wireguard_peer_receive_bytes_total{interface="wg\"0",public_key="xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg="} 0` + "\n",
+		`wireguard_peer_last_handshake_timestamp_seconds{interface="wg\"0",public_key="xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg="} 0` + "\n",
+		`wireguard_peer_endpoint_info{interface="wg\"0",public_key="xMjphMUyLIGExyJluSslD9tjaIcF9QS6ADyI8DOTzyg=",endpoint="127.0.0.1:51820"} 1` + "\n",
+		`wireguard_queue_capacity{interface="wg\"0",queue="handshake"} 1024` + "\n",
+		`wireguard_under_load{interface="wg\"0"} 0` + "\n",
+		"\ngo_goroutines ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("exposition lacks %q:\n%s", want, got)
+		}
+	}
+	if !strings.HasSuffix(got, "\n# EOF\n") {
+		t.Error("exposition not terminated by # EOF")
+	}
+	if strings.Contains(got, "wireguard_peer_dns_resolve_failures_total") {
+		t.Error("DNS failures reported for a peer with an IP endpoint")
+	}
+}
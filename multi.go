@@ -0,0 +1,477 @@
+//go:build !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/ipc"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// defaultControlSocket lives next to the UAPI sockets, but without their .sock
+// suffix, so that tools enumerating interfaces do not mistake it for one.
+const defaultControlSocket = "/var/run/wireguard/wireguard-go.ctl"
+
+// A supervisor runs several interfaces in one process. Each interface has its own
+// TUN device, device.Device and standard UAPI socket; their packet buffers come
+// from pools shared by all of them, but each device runs its own workers.
+// Interfaces are created and destroyed at runtime through a control socket.
+type supervisor struct {
+	mu         sync.Mutex
+	interfaces map[string]*managedInterface
+	creating   map[string]bool // names of the interfaces being created
+	pools      *device.Pools
+	logLevel   int
+	logWriter  io.Writer
+	logger     *device.Logger
+	keyLog     *os.File // nil unless WG_KEYLOG_FILE is set
+	auditLog   *os.File // nil unless AUDIT_LOG_FILE is set
+}
+
+type managedInterface struct {
+	name       string
+	device     *device.Device
+	uapi       net.Listener
+	configFile string
+	config     *daemonConfig
+	logger     *device.Logger
+	closeOnce  sync.Once
+}
+
+func (iface *managedInterface) close() {
+	iface.closeOnce.Do(func() {
+		iface.uapi.Close()
+		iface.device.Close()
+	})
+}
+
+// create brings up an interface, applies configFile to it if set, and returns its
+// actual name, which may differ from the requested one on some platforms.
+func (s *supervisor) create(name, configFile string) (string, error) {
+	if strings.ContainsRune(name, '/') {
+		return "", fmt.Errorf("invalid interface name %q", name)
+	}
+
+	var config *daemonConfig
+	if configFile != "" {
+		var err error
+		if config, err = loadConfig(configFile); err != nil {
+			return "", err
+		}
+	}
+
+	// reserve the name, but leave other requests free to run while the device
+	// is created and configured
+	s.mu.Lock()
+	if _, exists := s.interfaces[name]; exists || s.creating[name] {
+		s.mu.Unlock()
+		return "", fmt.Errorf("interface %s already exists", name)
+	}
+	s.creating[name] = true
+	s.mu.Unlock()
+	defer func(requested string) {
+		s.mu.Lock()
+		delete(s.creating, requested)
+		s.mu.Unlock()
+	}(name)
+
+	tdev, err := tun.CreateTUN(name, device.DefaultMTU)
+	if err != nil {
+		return "", fmt.Errorf("failed to create TUN device: %w", err)
+	}
+	if realName, err := tdev.Name(); err == nil {
+		name = realName
+	}
+
+	logger := newLogger(s.logLevel, name, s.logWriter)
+	dev := device.NewDeviceWithPools(tdev, conn.NewDefaultBind(), logger, s.pools)
+	dev.SetLogLevel(deviceLogLevelFromEnv())
+	dev.SetVersion(Version)
+	dev.RefuseQuotas("quota usage would not be kept across restarts, as there is no state file")
+	if s.keyLog != nil {
+		dev.SetKeyLog(s.keyLog)
+	}
+	if s.auditLog != nil {
+		dev.SetAuditLog(s.auditLog, name)
+	}
+	if config != nil {
+		if err := dev.IpcSet(config.uapi(nil, nil)); err != nil {
+			dev.Close()
+			return "", fmt.Errorf("failed to apply configuration from %s: %w", configFile, err)
+		}
+	}
+
+	fileUAPI, err := ipc.UAPIOpen(name)
+	if err != nil {
+		dev.Close()
+		return "", fmt.Errorf("UAPI listen error: %w", err)
+	}
+	uapi, err := ipc.UAPIListen(name, fileUAPI)
+	if err != nil {
+		fileUAPI.Close()
+		dev.Close()
+		return "", fmt.Errorf("failed to listen on uapi socket: %w", err)
+	}
+
+	iface := &managedInterface{
+		name:       name,
+		device:     dev,
+		uapi:       uapi,
+		configFile: configFile,
+		config:     config,
+		logger:     logger,
+	}
+	s.mu.Lock()
+	if _, exists := s.interfaces[name]; exists {
+		// the TUN device got the name of another interface
+		s.mu.Unlock()
+		iface.close()
+		return "", fmt.Errorf("interface %s already exists", name)
+	}
+	s.interfaces[name] = iface
+	s.mu.Unlock()
+
+	// Like a single interface daemon, an interface goes away when its TUN device
+	// or its UAPI socket does.
+	uapiErr := make(chan error, 1)
+	go func() {
+		for {
+			conn, err := uapi.Accept()
+			if err != nil {
+				uapiErr <- err
+				return
+			}
+			go dev.IpcHandle(conn)
+		}
+	}()
+	go func() {
+		select {
+		case <-dev.Wait():
+		case <-uapiErr:
+		}
+		s.remove(iface)
+	}()
+
+	logger.Verbosef("Interface created")
+	return name, nil
+}
+
+// remove forgets iface, unless it has already been replaced, and closes it.
+func (s *supervisor) remove(iface *managedInterface) {
+	s.mu.Lock()
+	if s.interfaces[iface.name] == iface {
+		delete(s.interfaces, iface.name)
+	}
+	s.mu.Unlock()
+	iface.close()
+}
+
+func (s *supervisor) destroy(name string) error {
+	s.mu.Lock()
+	iface, ok := s.interfaces[name]
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("interface %s does not exist", name)
+	}
+	s.remove(iface)
+	iface.logger.Verbosef("Interface destroyed")
+	return nil
+}
+
+func (s *supervisor) list() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.interfaces))
+	for name := range s.interfaces {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (s *supervisor) snapshot() []*managedInterface {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ifaces := make([]*managedInterface, 0, len(s.interfaces))
+	for _, iface := range s.interfaces {
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces
+}
+
+// reload re-reads the configuration file of every interface that has one.
+func (s *supervisor) reload() {
+	for _, iface := range s.snapshot() {
+		if iface.configFile == "" {
+			continue
+		}
+		config := reloadConfig(iface.device, iface.logger, iface.configFile, iface.config)
+		s.mu.Lock()
+		iface.config = config
+		s.mu.Unlock()
+	}
+}
+
+func (s *supervisor) checkLiveness() error {
+	for _, iface := range s.snapshot() {
+		if err := iface.device.CheckLiveness(); err != nil {
+			return fmt.Errorf("%s: %w", iface.name, err)
+		}
+	}
+	return nil
+}
+
+// metricsDevices returns the devices whose metrics are served, sorted by name.
+func (s *supervisor) metricsDevices() []metricsDevice {
+	var devices []metricsDevice
+	for _, iface := range s.snapshot() {
+		devices = append(devices, metricsDevice{iface.name, iface.device})
+	}
+	slices.SortFunc(devices, func(a, b metricsDevice) int {
+		return strings.Compare(a.name, b.name)
+	})
+	return devices
+}
+
+func (s *supervisor) closeAll() {
+	for _, iface := range s.snapshot() {
+		s.remove(iface)
+	}
+}
+
+// handleControl serves one control request. Requests use the UAPI framing of
+// key=value lines terminated by an empty line:
+//
+//	create=NAME      create an interface, optionally with config=FILE
+//	destroy=NAME     destroy an interface
+//	list=1           list the interfaces
+//
+// The response holds interface=NAME lines for create and list, then error=MESSAGE
+// on failure, and finally errno=N and an empty line, with N being 0 on success.
+func (s *supervisor) handleControl(c net.Conn) {
+	defer c.Close()
+
+	request := make(map[string]string)
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			fmt.Fprintf(c, "error=invalid line %q\nerrno=%d\n\n", line, ipc.IpcErrorProtocol)
+			return
+		}
+		request[key] = value
+	}
+
+	var names []string
+	var err error
+	switch {
+	case request["create"] != "":
+		var name string
+		if name, err = s.create(request["create"], request["config"]); err == nil {
+			names = []string{name}
+			s.logger.Verbosef("Control: created interface %s", name)
+		}
+	case request["destroy"] != "":
+		if err = s.destroy(request["destroy"]); err == nil {
+			s.logger.Verbosef("Control: destroyed interface %s", request["destroy"])
+		}
+	case request["list"] == "1":
+		names = s.list()
+	default:
+		err = errors.New("unknown request")
+	}
+
+	w := bufio.NewWriter(c)
+	for _, name := range names {
+		fmt.Fprintf(w, "interface=%s\n", name)
+	}
+	if err != nil {
+		s.logger.Errorf("Control: %v", err)
+		fmt.Fprintf(w, "error=%s\nerrno=%d\n\n", err, ipc.IpcErrorInvalid)
+	} else {
+		fmt.Fprint(w, "errno=0\n\n")
+	}
+	w.Flush()
+}
+
+// listenControl listens on the control socket at path, replacing a stale socket
+// left behind by a previous process.
+func listenControl(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	oldUmask := unix.Umask(0o077)
+	defer unix.Umask(oldUmask)
+
+	listener, err := net.Listen("unix", path)
+	if err == nil {
+		return listener, nil
+	}
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return nil, errors.New("control socket in use")
+	}
+	if err := os.Remove(path); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// runMulti runs the daemon in multi-interface mode, creating the interfaces given
+// as NAME or NAME=CONFIG arguments at startup.
+func runMulti(interfaceArgs []string, controlSocket string, foreground, seccomp bool) {
+	logLevel := logLevelFromEnv()
+
+	if !foreground && !sdNotifyEnabled() && os.Getenv(ENV_WG_PROCESS_FOREGROUND) != "1" {
+		if err := daemonize(nil, nil, logLevel); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to daemonize: %v\n", err)
+			os.Exit(ExitSetupFailed)
+		}
+		return
+	}
+
+	logWriter, logFile := openLogWriter()
+	logger := newLogger(logLevel, "multi", logWriter)
+	logger.Verbosef("Starting wireguard-go version %s in multi-interface mode", Version)
+
+	s := &supervisor{
+		interfaces: make(map[string]*managedInterface),
+		creating:   make(map[string]bool),
+		pools:      device.NewPools(),
+		logLevel:   logLevel,
+		logWriter:  logWriter,
+		logger:     logger,
+	}
+	keyLog, err := openKeyLog(logger)
+	if err != nil {
+		logger.Errorf("Failed to open key log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+	s.keyLog = keyLog
+	if s.auditLog, err = openAuditLog(); err != nil {
+		logger.Errorf("Failed to open audit log: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
+	control, err := listenControl(controlSocket)
+	if err != nil {
+		logger.Errorf("Failed to listen on control socket %s: %v", controlSocket, err)
+		os.Exit(ExitSetupFailed)
+	}
+
+	for _, arg := range interfaceArgs {
+		name, configFile, _ := strings.Cut(arg, "=")
+		if _, err := s.create(name, configFile); err != nil {
+			logger.Errorf("Failed to create interface %s: %v", name, err)
+			control.Close()
+			s.closeAll()
+			os.Exit(ExitSetupFailed)
+		}
+	}
+
+	errs := make(chan error)
+	go func() {
+		for {
+			c, err := control.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			go s.handleControl(c)
+		}
+	}()
+	logger.Verbosef("Control socket listening on %s", controlSocket)
+
+	metrics, err := startMetrics(s.metricsDevices, logger)
+	if err != nil {
+		logger.Errorf("Failed to serve metrics: %v", err)
+		control.Close()
+		s.closeAll()
+		os.Exit(ExitSetupFailed)
+	}
+
+	if seccomp {
+		if err := enableSeccomp(); err != nil {
+			logger.Errorf("Failed to enable seccomp sandbox: %v", err)
+			control.Close()
+			s.closeAll()
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Seccomp sandbox enabled")
+	}
+
+	if err := sdNotify(fmt.Sprintf("READY=1\nSTATUS=Managing %d interfaces", len(interfaceArgs))); err != nil {
+		logger.Errorf("Failed to notify service manager: %v", err)
+	}
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go watchdog(s.checkLiveness, nil, logger, interval)
+	}
+
+	term := make(chan os.Signal, 1)
+	hup := make(chan os.Signal, 1)
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(term, unix.SIGTERM)
+	signal.Notify(term, os.Interrupt)
+	signal.Notify(hup, unix.SIGHUP)
+	if logFile != nil {
+		signal.Notify(usr1, unix.SIGUSR1)
+	}
+
+wait:
+	for {
+		select {
+		case <-term:
+			break wait
+		case err := <-errs:
+			logger.Errorf("Control socket failed: %v", err)
+			break wait
+		case <-hup:
+			s.reload()
+		case <-usr1:
+			if err := logFile.Reopen(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to reopen log file: %v\n", err)
+				continue
+			}
+			logger.Verbosef("Log file reopened")
+		}
+	}
+
+	// clean up
+
+	sdNotify("STOPPING=1")
+	control.Close()
+	if metrics != nil {
+		metrics.Close()
+	}
+	s.closeAll()
+
+	logger.Verbosef("Shutting down")
+	if logFile != nil {
+		logFile.Close()
+	}
+}
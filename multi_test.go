@@ -0,0 +1,85 @@
+//go:build !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func controlRequest(t *testing.T, s *supervisor, request string) string {
+	t.Helper()
+	client, server := net.Pipe()
+	go s.handleControl(server)
+	defer client.Close()
+	if _, err := io.WriteString(client, request); err != nil {
+		t.Fatal(err)
+	}
+	response, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(response)
+}
+
+func TestSupervisorControl(t *testing.T) {
+	s := &supervisor{
+		interfaces: make(map[string]*managedInterface),
+		pools:      device.NewPools(),
+		logLevel:   device.LogLevelError,
+		logWriter:  io.Discard,
+		logger:     device.NewLogger(device.LogLevelSilent, ""),
+	}
+
+	tests := []struct {
+		request, expected string
+	}{
+		{"list=1\n\n", "errno=0\n\n"},
+		{"destroy=wg0\n\n", "error=interface wg0 does not exist\nerrno=-22\n\n"},
+		{"create=../wg0\n\n", "error=invalid interface name \"../wg0\"\nerrno=-22\n\n"},
+		{"bogus\n\n", "error=invalid line \"bogus\"\nerrno=-71\n\n"},
+		{"frobnicate=1\n\n", "error=unknown request\nerrno=-22\n\n"},
+	}
+	for _, test := range tests {
+		if got := controlRequest(t, s, test.request); got != test.expected {
+			t.Errorf("request %q: expected %q, got %q", test.request, test.expected, got)
+		}
+	}
+
+	// configuration errors are reported before any TUN device is created
+	missing := filepath.Join(t.TempDir(), "missing.conf")
+	got := controlRequest(t, s, "create=wg0\nconfig="+missing+"\n\n")
+	if !strings.HasPrefix(got, "error=") || !strings.Contains(got, missing) || !strings.HasSuffix(got, "errno=-22\n\n") {
+		t.Errorf("unexpected response to create with missing configuration: %q", got)
+	}
+}
+
+func TestListenControlStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wireguard-go.ctl")
+	first, err := listenControl(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenControl(path); err == nil {
+		t.Error("expected error listening on a control socket in use")
+	}
+
+	// a socket file left behind by a crashed process is replaced
+	first.(*net.UnixListener).SetUnlinkOnClose(false)
+	first.Close()
+	second, err := listenControl(path)
+	if err != nil {
+		t.Fatalf("failed to replace stale control socket: %v", err)
+	}
+	second.Close()
+}
@@ -0,0 +1,30 @@
+//go:build !linux && !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+var errNetnsUnsupported = errors.New("network namespaces are not supported on this platform")
+
+func openNetns(name string) (*os.File, error) {
+	return nil, errNetnsUnsupported
+}
+
+func inNetns(ns *os.File, fn func() error) error {
+	if ns == nil {
+		return fn()
+	}
+	return errNetnsUnsupported
+}
+
+func enterNetns(ns *os.File) error {
+	return errNetnsUnsupported
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netnsDir is where ip-netns(8) keeps named network namespaces.
+const netnsDir = "/run/netns"
+
+// openNetns opens a network namespace given either by the name ip-netns(8) knows
+// it under or by a path such as /proc/PID/ns/net.
+func openNetns(name string) (*os.File, error) {
+	path := name
+	if !strings.ContainsRune(name, '/') {
+		path = filepath.Join(netnsDir, name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	var stat unix.Statfs_t
+	if err := unix.Fstatfs(int(file.Fd()), &stat); err != nil || stat.Type != unix.NSFS_MAGIC {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a network namespace", path)
+	}
+	return file, nil
+}
+
+func sameNetns(a, b *os.File) bool {
+	var sa, sb unix.Stat_t
+	if unix.Fstat(int(a.Fd()), &sa) != nil || unix.Fstat(int(b.Fd()), &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev && sa.Ino == sb.Ino
+}
+
+// inNetns calls fn on a thread switched to the network namespace ns, so that the
+// sockets and devices fn creates belong to it, and switches back. Namespaces are
+// per thread, so fn must not rely on goroutines it starts to run in ns. With a
+// nil ns, fn is just called.
+func inNetns(ns *os.File, fn func() error) error {
+	if ns == nil {
+		return fn()
+	}
+
+	// A thread that cannot be switched back must not run anything else. A goroutine
+	// that exits while locked to its thread takes the thread with it.
+	done := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		orig, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			runtime.UnlockOSThread()
+			done <- err
+			return
+		}
+		defer orig.Close()
+		if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			done <- fmt.Errorf("failed to enter network namespace: %w", err)
+			return
+		}
+		fnErr := fn()
+		if err := unix.Setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+			done <- fmt.Errorf("failed to leave network namespace: %w", err)
+			return
+		}
+		runtime.UnlockOSThread()
+		done <- fnErr
+	}()
+	return <-done
+}
+
+// enterNetns moves the process to the network namespace ns, unless it is already
+// there. Like credentials, namespaces are per thread and the Go runtime runs many
+// threads, so this switches the current thread and replaces the process image from
+// it, whose threads then all start in ns. On success it does not return if the
+// process had to move.
+func enterNetns(ns *os.File) error {
+	current, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		return err
+	}
+	same := sameNetns(current, ns)
+	current.Close()
+	if same {
+		return nil
+	}
+	if executableErr != nil {
+		return fmt.Errorf("failed to determine executable: %w", executableErr)
+	}
+
+	runtime.LockOSThread()
+	if err := unix.Setns(int(ns.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter network namespace: %w", err)
+	}
+
+	// If this fails, the caller exits on the switched thread, which stays locked.
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
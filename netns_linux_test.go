@@ -0,0 +1,95 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// newTestNetns creates an anonymous network namespace, kept alive by the returned
+// file only.
+func newTestNetns(t *testing.T) *os.File {
+	result := make(chan any, 1)
+	go func() {
+		// the thread is left in the new namespace and discarded
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			result <- err
+			return
+		}
+		ns, err := os.Open("/proc/thread-self/ns/net")
+		if err != nil {
+			result <- err
+			return
+		}
+		result <- ns
+	}()
+	switch r := (<-result).(type) {
+	case *os.File:
+		t.Cleanup(func() { r.Close() })
+		return r
+	case error:
+		t.Skipf("cannot create network namespace: %v", r)
+	}
+	return nil
+}
+
+func TestOpenNetns(t *testing.T) {
+	if _, err := openNetns("/proc/self/status"); err == nil {
+		t.Error("opened a file that is not a network namespace")
+	}
+	ns, err := openNetns("/proc/self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ns.Close()
+	current, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+	if !sameNetns(ns, current) {
+		t.Error("namespace of the process differs from that of the thread")
+	}
+}
+
+func TestInNetns(t *testing.T) {
+	ns := newTestNetns(t)
+	const name = "wgnetnstest0"
+
+	var tdev tun.Device
+	err := inNetns(ns, func() (err error) {
+		tdev, err = tun.CreateTUN(name, 1380)
+		return err
+	})
+	if err != nil {
+		t.Skipf("cannot create TUN device: %v", err)
+	}
+	defer tdev.Close()
+
+	if _, err := net.InterfaceByName(name); err == nil {
+		t.Error("TUN device was created outside of the namespace")
+	}
+	// the ioctls of the device keep going to its namespace
+	if mtu, err := tdev.MTU(); err != nil || mtu != 1380 {
+		t.Errorf("MTU() = %d, %v; want 1380", mtu, err)
+	}
+
+	current, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+	if sameNetns(ns, current) {
+		t.Error("still in the namespace after inNetns returned")
+	}
+}
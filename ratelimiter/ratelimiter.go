@@ -135,3 +135,10 @@ func (rate *Ratelimiter) Allow(ip netip.Addr) bool {
 	entry.mu.Unlock()
 	return false
 }
+
+// Len returns the number of source addresses currently tracked.
+func (rate *Ratelimiter) Len() int {
+	rate.mu.RLock()
+	defer rate.mu.RUnlock()
+	return len(rate.table)
+}
@@ -6,6 +6,11 @@
 // Package replay implements an efficient anti-replay algorithm as specified in RFC 6479.
 package replay
 
+import (
+	"encoding/binary"
+	"errors"
+)
+
 type block uint64
 
 const (
@@ -60,3 +65,26 @@ func (f *Filter) ValidateCounter(counter, limit uint64) bool {
 	f.ring[indexBlock] = new
 	return old != new
 }
+
+// MarshalBinary encodes the state of the filter, so that the session it protects
+// can be taken over by another process.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, 8*(1+ringBlocks))
+	b = binary.LittleEndian.AppendUint64(b, f.last)
+	for _, block := range f.ring {
+		b = binary.LittleEndian.AppendUint64(b, uint64(block))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary restores a filter state encoded by MarshalBinary.
+func (f *Filter) UnmarshalBinary(b []byte) error {
+	if len(b) != 8*(1+ringBlocks) {
+		return errors.New("replay: invalid filter state length")
+	}
+	f.last = binary.LittleEndian.Uint64(b)
+	for i := range f.ring {
+		f.ring[i] = block(binary.LittleEndian.Uint64(b[8*(i+1):]))
+	}
+	return nil
+}
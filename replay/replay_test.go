@@ -117,3 +117,33 @@ func TestReplay(t *testing.T) {
 	T(0, true)
 	T(windowSize+1, true)
 }
+
+func TestFilterMarshal(t *testing.T) {
+	var filter Filter
+	for _, n := range []uint64{1, 2, 5, 64, 100, windowSize + 3} {
+		filter.ValidateCounter(n, RejectAfterMessages)
+	}
+
+	b, err := filter.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var restored Filter
+	if err := restored.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range []uint64{5, 100, windowSize + 3} {
+		if restored.ValidateCounter(n, RejectAfterMessages) {
+			t.Errorf("counter %d replayed after restoring", n)
+		}
+	}
+	for _, n := range []uint64{99, windowSize + 2, windowSize + 4} {
+		if !restored.ValidateCounter(n, RejectAfterMessages) {
+			t.Errorf("counter %d rejected after restoring", n)
+		}
+	}
+
+	if err := restored.UnmarshalBinary(b[1:]); err == nil {
+		t.Error("truncated filter state accepted")
+	}
+}
@@ -171,10 +171,14 @@ start_wireguard() {
     print_info "日志文件: $(pwd)/wireguard-go.log"
     
     # 启动守护进程
-    ./wireguard-go utun11 &
+    ./wireguard-go --config wg0.conf utun11 &
     
     print_info "等待接口创建..."
-    sleep 3
+    # 配置应用完成后才会创建 UAPI socket
+    for _ in $(seq 1 15); do
+        [[ -S "/var/run/wireguard/utun11.sock" ]] && break
+        sleep 1
+    done
     
     # 检查进程是否正常运行
     if ! pgrep -l wireguard-go >/dev/null 2>&1; then
@@ -244,8 +248,7 @@ configure_network() {
 apply_config() {
     print_step "4. 应用 WireGuard 配置"
     
-    print_info "应用 wg0.conf 到 utun11..."
-    ./$WG_GO_PATH setconf utun11 wg0.conf
+    print_info "wg0.conf 已在守护进程启动时应用"
     
     print_info "等待握手建立..."
     sleep 2
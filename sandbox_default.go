@@ -0,0 +1,29 @@
+//go:build !linux && !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+var errSandboxUnsupported = errors.New("not supported on this platform")
+
+func requiredCapabilities(config *daemonConfig) []uintptr {
+	return nil
+}
+
+func dropPrivileges(userName, groupName string, caps []uintptr, tunFile, uapiFile *os.File, logFile *rotatingFile, logger *device.Logger) error {
+	return errSandboxUnsupported
+}
+
+func enableSeccomp() error {
+	return errSandboxUnsupported
+}
@@ -0,0 +1,239 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// requiredCapabilities returns the capabilities the daemon still needs once the TUN
+// device and UAPI socket are open: CAP_NET_BIND_SERVICE to rebind a privileged
+// listen port, and CAP_NET_ADMIN to set a fwmark on the sockets. They follow the
+// startup configuration only, so a later set moving to a privileged port or
+// setting a fwmark fails, saying which capability it lacks.
+func requiredCapabilities(config *daemonConfig) []uintptr {
+	var caps []uintptr
+	if config == nil {
+		return caps
+	}
+	if config.listenPort != nil && *config.listenPort != 0 && *config.listenPort < 1024 {
+		caps = append(caps, unix.CAP_NET_BIND_SERVICE)
+	}
+	if config.fwmark != nil && *config.fwmark != 0 {
+		caps = append(caps, unix.CAP_NET_ADMIN)
+	}
+	return caps
+}
+
+func capabilityNames(caps []uintptr) string {
+	if len(caps) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(caps))
+	for _, c := range caps {
+		switch c {
+		case unix.CAP_NET_ADMIN:
+			names = append(names, "CAP_NET_ADMIN")
+		case unix.CAP_NET_BIND_SERVICE:
+			names = append(names, "CAP_NET_BIND_SERVICE")
+		default:
+			names = append(names, strconv.Itoa(int(c)))
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+func lookupAccount(userName, groupName string) (uid, gid int, err error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return 0, 0, err
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, err
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return 0, 0, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, err
+		}
+	}
+	if uid == 0 {
+		return 0, 0, fmt.Errorf("user %s is root", userName)
+	}
+	return uid, gid, nil
+}
+
+// dropPrivileges switches the process to the given account, keeping only caps, and
+// re-executes the daemon with the TUN and UAPI descriptors, like daemonizing does.
+// Credentials are per thread on Linux and the Go runtime runs many threads, so the
+// capabilities are made ambient and only the fresh process image, whose threads all
+// start from the switched thread, is guaranteed to hold exactly them.
+// On success it does not return.
+func dropPrivileges(userName, groupName string, caps []uintptr, tunFile, uapiFile *os.File, logFile *rotatingFile, logger *device.Logger) error {
+	uid, gid, err := lookupAccount(userName, groupName)
+	if err != nil {
+		return err
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var env []string
+	for _, pass := range []struct {
+		name string
+		file *os.File
+	}{{ENV_WG_TUN_FD, tunFile}, {ENV_WG_UAPI_FD, uapiFile}} {
+		fd := pass.file.Fd()
+		if _, err := unix.FcntlInt(fd, unix.F_SETFD, 0); err != nil {
+			return err
+		}
+		env = append(env, fmt.Sprintf("%s=%d", pass.name, fd))
+	}
+	env = childEnv(append(env, ENV_WG_PROCESS_FOREGROUND+"=1")...)
+
+	// The UAPI listener watches its socket file, which needs read access to it.
+	if sa, err := unix.Getsockname(int(uapiFile.Fd())); err == nil {
+		if sa, ok := sa.(*unix.SockaddrUnix); ok && sa.Name != "" && sa.Name[0] != '@' {
+			if err := os.Chown(sa.Name, uid, gid); err != nil {
+				return err
+			}
+		}
+	}
+
+	// let the unprivileged daemon keep appending to its log file
+	if logFile != nil {
+		if err := os.Chown(logFile.path, uid, gid); err != nil {
+			logger.Errorf("Failed to hand log file over to user %s: %v", userName, err)
+		}
+	}
+
+	logger.Verbosef("Dropping privileges to uid %d, gid %d, keeping capabilities: %s", uid, gid, capabilityNames(caps))
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if len(caps) > 0 {
+		if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+			return fmt.Errorf("keep capabilities: %w", err)
+		}
+	}
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("clear supplementary groups: %w", err)
+	}
+	if err := syscall.Setresgid(gid, gid, gid); err != nil {
+		return fmt.Errorf("set group: %w", err)
+	}
+	if err := syscall.Setresuid(uid, uid, uid); err != nil {
+		return fmt.Errorf("set user: %w", err)
+	}
+
+	if len(caps) > 0 {
+		header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+		var data [2]unix.CapUserData
+		for _, c := range caps {
+			data[c/32].Effective |= 1 << (c % 32)
+			data[c/32].Permitted |= 1 << (c % 32)
+			data[c/32].Inheritable |= 1 << (c % 32)
+		}
+		if err := unix.Capset(&header, &data[0]); err != nil {
+			return fmt.Errorf("set capabilities: %w", err)
+		}
+		for _, c := range caps {
+			if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_RAISE, c, 0, 0); err != nil {
+				return fmt.Errorf("raise ambient capability %s: %w", capabilityNames([]uintptr{c}), err)
+			}
+		}
+	}
+
+	return syscall.Exec(path, os.Args, env)
+}
+
+// seccompData mirrors struct seccomp_data.
+type seccompData struct {
+	nr                 int32
+	arch               uint32
+	instructionPointer uint64
+	args               [6]uint64
+}
+
+// seccompFilter builds a classic BPF program that allows the syscalls in allowed and
+// fails every other one, including any from a foreign ABI, with EPERM.
+func seccompFilter(arch uint32, allowed []uintptr) []unix.SockFilter {
+	const (
+		offsetNr   = uint32(unsafe.Offsetof(seccompData{}.nr))
+		offsetArch = uint32(unsafe.Offsetof(seccompData{}.arch))
+	)
+	n := len(allowed)
+	filter := []unix.SockFilter{
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offsetArch},
+		{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: arch, Jt: 0, Jf: uint8(n + 1)},
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: offsetNr},
+	}
+	for i, nr := range allowed {
+		filter = append(filter, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: uint32(nr), Jt: uint8(n - i), Jf: 0})
+	}
+	return append(filter,
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM)},
+		unix.SockFilter{Code: unix.BPF_RET | unix.BPF_K, K: unix.SECCOMP_RET_ALLOW},
+	)
+}
+
+// enableSeccomp confines every thread of the process to seccompAllowed. A syscall
+// outside the list is not fatal: it fails with EPERM, and the kernel records it in
+// the audit log (dmesg or journalctl -k, as type=1326 with the syscall number), so
+// that a missing entry shows up instead of killing the daemon. Denials are not
+// handled by a listener in the process, since a listener thread could need a lock
+// of the Go runtime held by the very thread whose syscall it is to answer. This
+// needs Linux 4.14 or later.
+func enableSeccomp() error {
+	if seccompArch == 0 {
+		return fmt.Errorf("seccomp sandbox not supported on %s", runtime.GOARCH)
+	}
+	if len(seccompAllowed) > 254 {
+		return errors.New("seccomp allowlist too long")
+	}
+
+	filter := seccompFilter(seccompArch, seccompAllowed)
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	// no_new_privs and the filter are set on this thread and synchronized to the others
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("set no_new_privs: %w", err)
+	}
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER,
+		unix.SECCOMP_FILTER_FLAG_TSYNC|unix.SECCOMP_FILTER_FLAG_LOG,
+		uintptr(unsafe.Pointer(&prog)))
+	runtime.KeepAlive(filter)
+	if errno != 0 {
+		return fmt.Errorf("install seccomp filter: %w", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("install seccomp filter: thread %d cannot be synchronized", tid)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRequiredCapabilities(t *testing.T) {
+	port := func(p uint16) *uint16 { return &p }
+	mark := func(m uint32) *uint32 { return &m }
+	tests := []struct {
+		config   *daemonConfig
+		expected string
+	}{
+		{nil, "none"},
+		{&daemonConfig{listenPort: port(51820)}, "none"},
+		{&daemonConfig{listenPort: port(443)}, "CAP_NET_BIND_SERVICE"},
+		{&daemonConfig{listenPort: port(0), fwmark: mark(0)}, "none"},
+		{&daemonConfig{listenPort: port(53), fwmark: mark(0x1234)}, "CAP_NET_BIND_SERVICE, CAP_NET_ADMIN"},
+	}
+	for _, test := range tests {
+		if got := capabilityNames(requiredCapabilities(test.config)); got != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, got)
+		}
+	}
+}
+
+// TestSeccompDenied runs itself in a subprocess, because the filter cannot be removed
+// once installed.
+func TestSeccompDenied(t *testing.T) {
+	if seccompArch == 0 {
+		t.Skip("no seccomp allowlist for this architecture")
+	}
+
+	if os.Getenv("WG_TEST_SECCOMP") == "1" {
+		if err := enableSeccomp(); err != nil {
+			fmt.Printf("unsupported: %v\n", err)
+			return
+		}
+		// getppid is not used by the daemon after setup
+		_, _, errno := unix.Syscall(unix.SYS_GETPPID, 0, 0, 0)
+		fmt.Printf("errno: %v\n", errno)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSeccompDenied$")
+	cmd.Env = append(os.Environ(), "WG_TEST_SECCOMP=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("sandboxed process failed: %v\n%s", err, out)
+	}
+	if strings.Contains(string(out), "unsupported:") {
+		t.Skipf("seccomp not available:\n%s", out)
+	}
+	if !strings.Contains(string(out), "errno: "+unix.EPERM.Error()) {
+		t.Errorf("expected the denied syscall to fail with EPERM:\n%s", out)
+	}
+}
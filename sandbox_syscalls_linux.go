@@ -0,0 +1,103 @@
+//go:build linux && (amd64 || arm64)
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// seccompCommon lists the syscalls made after setup on all supported architectures:
+// by the Go runtime, the TUN and UDP data plane, the UAPI socket, DNS resolution,
+// configuration reloads, log rotation and service manager notifications.
+var seccompCommon = []uintptr{
+	// Go runtime
+	unix.SYS_BRK,
+	unix.SYS_CLONE,
+	unix.SYS_CLONE3,
+	unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP,
+	unix.SYS_FUTEX,
+	unix.SYS_GETPID,
+	unix.SYS_GETTID,
+	unix.SYS_MADVISE,
+	unix.SYS_MMAP,
+	unix.SYS_MPROTECT,
+	unix.SYS_MUNMAP,
+	unix.SYS_NANOSLEEP,
+	unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_NANOSLEEP,
+	unix.SYS_RESTART_SYSCALL,
+	unix.SYS_RSEQ,
+	unix.SYS_RT_SIGACTION,
+	unix.SYS_RT_SIGPROCMASK,
+	unix.SYS_RT_SIGRETURN,
+	unix.SYS_SCHED_GETAFFINITY,
+	unix.SYS_SCHED_YIELD,
+	unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_SIGALTSTACK,
+	unix.SYS_TGKILL,
+	unix.SYS_GETRLIMIT,
+	unix.SYS_PRLIMIT64,
+	unix.SYS_GETRANDOM,
+	unix.SYS_UNAME,
+
+	// polling and descriptors
+	unix.SYS_CLOSE,
+	unix.SYS_DUP3,
+	unix.SYS_EPOLL_CREATE1,
+	unix.SYS_EPOLL_CTL,
+	unix.SYS_EPOLL_PWAIT,
+	unix.SYS_EPOLL_PWAIT2,
+	unix.SYS_EVENTFD2,
+	unix.SYS_FCNTL,
+	unix.SYS_IOCTL,
+	unix.SYS_PIPE2,
+	unix.SYS_PPOLL,
+	unix.SYS_PSELECT6,
+
+	// TUN, UDP, netlink and UAPI sockets
+	unix.SYS_READ,
+	unix.SYS_READV,
+	unix.SYS_WRITE,
+	unix.SYS_WRITEV,
+	unix.SYS_ACCEPT4,
+	unix.SYS_BIND,
+	unix.SYS_CONNECT,
+	unix.SYS_GETPEERNAME,
+	unix.SYS_GETSOCKNAME,
+	unix.SYS_GETSOCKOPT,
+	unix.SYS_SETSOCKOPT,
+	unix.SYS_RECVFROM,
+	unix.SYS_RECVMSG,
+	unix.SYS_RECVMMSG,
+	unix.SYS_SENDTO,
+	unix.SYS_SENDMSG,
+	unix.SYS_SENDMMSG,
+	unix.SYS_SHUTDOWN,
+	unix.SYS_SOCKET,
+
+	// configuration reloads, DNS resolver files and log rotation
+	unix.SYS_OPENAT,
+	unix.SYS_FSTAT,
+	unix.SYS_NEWFSTATAT,
+	unix.SYS_STATX,
+	unix.SYS_LSEEK,
+	unix.SYS_PREAD64,
+	unix.SYS_FADVISE64,
+	unix.SYS_FSYNC,
+	unix.SYS_MKDIRAT,
+	unix.SYS_RENAMEAT,
+	unix.SYS_RENAMEAT2,
+	unix.SYS_UNLINKAT,
+	unix.SYS_READLINKAT,
+	unix.SYS_GETDENTS64,
+	unix.SYS_INOTIFY_ADD_WATCH,
+	unix.SYS_GETUID,
+	unix.SYS_GETEUID,
+	unix.SYS_GETGID,
+	unix.SYS_GETEGID,
+	unix.SYS_SYSINFO,
+}
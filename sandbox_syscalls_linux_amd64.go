@@ -0,0 +1,22 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import "golang.org/x/sys/unix"
+
+const seccompArch = unix.AUDIT_ARCH_X86_64
+
+// seccompAllowed adds the legacy syscalls that only exist on amd64 and that the Go
+// runtime and libc still use there.
+var seccompAllowed = append(seccompCommon,
+	unix.SYS_ARCH_PRCTL,
+	unix.SYS_EPOLL_WAIT,
+	unix.SYS_OPEN,
+	unix.SYS_POLL,
+	unix.SYS_STAT,
+	unix.SYS_LSTAT,
+	unix.SYS_TIME,
+)
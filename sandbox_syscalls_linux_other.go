@@ -0,0 +1,13 @@
+//go:build linux && !amd64 && !arm64
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+// The seccomp sandbox has no allowlist for this architecture.
+const seccompArch = 0
+
+var seccompAllowed []uintptr
@@ -0,0 +1,261 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// selfTestTimeout bounds the wait for packets in each check.
+const selfTestTimeout = 5 * time.Second
+
+// selfTestReport collects the outcome of the checks and what was found out about
+// the platform along the way.
+type selfTestReport struct {
+	checks       []string
+	features     [][2]string
+	capabilities [][2]string
+	failed       int
+}
+
+func (r *selfTestReport) check(name string, err error) {
+	if err != nil {
+		r.failed++
+		r.checks = append(r.checks, fmt.Sprintf("FAIL  %s: %v", name, strings.ReplaceAll(err.Error(), "\n", "; ")))
+		return
+	}
+	r.checks = append(r.checks, "ok    "+name)
+}
+
+func (r *selfTestReport) skip(name, reason string) {
+	r.checks = append(r.checks, fmt.Sprintf("skip  %s: %s", name, reason))
+}
+
+func (r *selfTestReport) feature(name string, value any) {
+	r.features = append(r.features, [2]string{name, yesNo(value)})
+}
+
+func (r *selfTestReport) capability(name string, value any) {
+	r.capabilities = append(r.capabilities, [2]string{name, yesNo(value)})
+}
+
+func yesNo(value any) string {
+	switch value := value.(type) {
+	case bool:
+		if value {
+			return "yes"
+		}
+		return "no"
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func (r *selfTestReport) print(w io.Writer) {
+	fmt.Fprintf(w, "wireguard-go v%s self-test (%s-%s, %s)\n", Version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+	fmt.Fprintln(w, "\nChecks:")
+	for _, check := range r.checks {
+		fmt.Fprintf(w, "  %s\n", check)
+	}
+	for _, section := range []struct {
+		title string
+		rows  [][2]string
+	}{{"Features", r.features}, {"Capabilities", r.capabilities}} {
+		if len(section.rows) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", section.title)
+		for _, row := range section.rows {
+			fmt.Fprintf(w, "  %-32s %s\n", row[0], row[1])
+		}
+	}
+	if r.failed > 0 {
+		fmt.Fprintf(w, "\nSelf-test FAILED: %d of %d checks\n", r.failed, len(r.checks))
+	} else {
+		fmt.Fprintln(w, "\nSelf-test passed")
+	}
+}
+
+// runSelfTest checks the cryptography, the device and, as far as permissions allow,
+// the TUN and UDP offloads of the platform, prints a report and returns the exit
+// code: ExitSetupFailed if any check failed.
+func runSelfTest() int {
+	var r selfTestReport
+	r.check("crypto known answers: BLAKE2s, HKDF, X25519, (X)ChaCha20Poly1305, Noise handshake", device.SelfTestCrypto())
+	r.check("device pair over channel bind: handshake and packets both ways", selfTestDevicePair())
+	selfTestLoopbackBind(&r)
+	selfTestPlatform(&r)
+	r.print(os.Stdout)
+	if r.failed > 0 {
+		return ExitSetupFailed
+	}
+	return ExitSetupSuccess
+}
+
+// selfTestDevicePair brings up two devices connected by a channel bind, with a
+// channel TUN each, and pings across them in both directions.
+func selfTestDevicePair() error {
+	var private, public [2][32]byte
+	var tuns [2]*tuntest.ChannelTUN
+	var devs [2]*device.Device
+	addrs := [2]netip.Addr{netip.AddrFrom4([4]byte{10, 255, 0, 1}), netip.AddrFrom4([4]byte{10, 255, 0, 2})}
+
+	binds := bindtest.NewChannelBinds()
+	logger := device.NewLogger(device.LogLevelSilent, "")
+	for i := range devs {
+		var err error
+		if private[i], public[i], err = newKeyPair(); err != nil {
+			return err
+		}
+		tuns[i] = tuntest.NewChannelTUN()
+		devs[i] = device.NewDevice(tuns[i].TUN(), binds[i], logger)
+		defer devs[i].Close()
+	}
+	for i, dev := range devs {
+		if err := dev.IpcSet(fmt.Sprintf("private_key=%s\nlisten_port=0\n", hex.EncodeToString(private[i][:]))); err != nil {
+			return err
+		}
+		if err := dev.Up(); err != nil {
+			return err
+		}
+	}
+	for i, dev := range devs {
+		port, err := listenPort(devs[i^1])
+		if err != nil {
+			return err
+		}
+		if err := dev.IpcSet(fmt.Sprintf("public_key=%s\nendpoint=127.0.0.1:%s\nallowed_ip=%s/32\n", hex.EncodeToString(public[i^1][:]), port, addrs[i^1])); err != nil {
+			return err
+		}
+	}
+
+	for round := range 2 {
+		for from := range 2 {
+			to := from ^ 1
+			msg := tuntest.Ping(addrs[to], addrs[from])
+			tuns[from].Outbound <- msg
+			select {
+			case got := <-tuns[to].Inbound:
+				if !bytes.Equal(got, msg) {
+					return fmt.Errorf("packet from device %d corrupted in round %d", from, round)
+				}
+			case <-time.After(selfTestTimeout):
+				return fmt.Errorf("packet from device %d not delivered in round %d", from, round)
+			}
+		}
+	}
+	return nil
+}
+
+func newKeyPair() (private, public [32]byte, err error) {
+	if _, err = rand.Read(private[:]); err != nil {
+		return
+	}
+	private[0] &= 248
+	private[31] = (private[31] & 127) | 64
+	b, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	copy(public[:], b)
+	return
+}
+
+// listenPort returns the port the device listens on, as seen through UAPI.
+func listenPort(dev *device.Device) (string, error) {
+	config, err := dev.IpcGet()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(config, "\n") {
+		if port, ok := strings.CutPrefix(line, "listen_port="); ok {
+			return port, nil
+		}
+	}
+	return "", errors.New("no listen port")
+}
+
+// selfTestLoopbackBind sends a batch of datagrams to itself over the standard bind,
+// which uses UDP segmentation offload where the platform supports it.
+func selfTestLoopbackBind(r *selfTestReport) {
+	const name = "UDP over loopback bind: batch of datagrams"
+	bind := conn.NewStdNetBind()
+	fns, port, err := bind.Open(0)
+	if err != nil {
+		r.check(name, err)
+		return
+	}
+	defer bind.Close()
+	std := bind.(*conn.StdNetBind)
+
+	const count, size = 8, 1200
+	ep, err := bind.ParseEndpoint(fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		r.check(name, err)
+		return
+	}
+	bufs := make([][]byte, count)
+	for i := range bufs {
+		bufs[i] = bytes.Repeat([]byte{byte(i)}, size)
+	}
+	txOffload, _ := std.UDPOffloads()
+	err = bind.Send(bufs, ep)
+	var disabled conn.ErrUDPGSODisabled
+	if errors.As(err, &disabled) {
+		// sent again without segmentation offload
+		err = disabled.RetryErr
+	}
+	if err != nil {
+		r.check(name, err)
+		return
+	}
+	txOffloadKept, rxOffload := std.UDPOffloads()
+	r.feature("UDP segmentation offload (GSO)", txOffload && txOffloadKept)
+	r.feature("UDP receive offload (GRO)", rxOffload)
+
+	// the bind is closed, unblocking the receive function, when time runs out
+	timer := time.AfterFunc(selfTestTimeout, func() { bind.Close() })
+	defer timer.Stop()
+	batch := bind.BatchSize()
+	packets := make([][]byte, batch)
+	for i := range packets {
+		packets[i] = make([]byte, device.MaxMessageSize)
+	}
+	sizes := make([]int, batch)
+	eps := make([]conn.Endpoint, batch)
+	seen := make([]bool, count)
+	for received := 0; received < count; {
+		n, err := fns[0](packets, sizes, eps)
+		if err != nil {
+			r.check(name, fmt.Errorf("received %d of %d datagrams: %w", received, count, err))
+			return
+		}
+		for i := range n {
+			b := packets[i][:sizes[i]]
+			if len(b) != size || int(b[0]) >= count || seen[b[0]] || !bytes.Equal(b, bytes.Repeat(b[:1], size)) {
+				r.check(name, errors.New("unexpected datagram"))
+				return
+			}
+			seen[b[0]] = true
+			received++
+		}
+	}
+	r.check(name, nil)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+func selfTestPlatform(r *selfTestReport) {
+	r.skip("TUN offloads", "only tested on Linux")
+}
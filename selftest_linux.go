@@ -0,0 +1,332 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+const selfTestInterface = "wgselftest0"
+
+var (
+	selfTestLocal  = netip.AddrFrom4([4]byte{10, 255, 0, 1}) // address of the TUN device
+	selfTestRemote = netip.AddrFrom4([4]byte{10, 255, 0, 2}) // routed through it
+)
+
+func selfTestPlatform(r *selfTestReport) {
+	var uts unix.Utsname
+	if unix.Uname(&uts) == nil {
+		r.feature("kernel", unix.ByteSliceToString(uts.Release[:]))
+	}
+	r.feature("sticky sockets", conn.StdNetSupportsStickySockets)
+	r.feature("seccomp sandbox", seccompArch != 0)
+
+	effective := effectiveCapabilities()
+	for _, c := range []struct {
+		name string
+		cap  uintptr
+	}{
+		{"CAP_NET_ADMIN", unix.CAP_NET_ADMIN},
+		{"CAP_NET_BIND_SERVICE", unix.CAP_NET_BIND_SERVICE},
+		{"CAP_SYS_ADMIN", unix.CAP_SYS_ADMIN},
+	} {
+		r.capability(c.name, effective&(1<<c.cap) != 0)
+	}
+	r.capability("/dev/net/tun", unix.Access("/dev/net/tun", unix.R_OK|unix.W_OK) == nil)
+
+	selfTestTUN(r)
+}
+
+// effectiveCapabilities returns the effective capability set of the process.
+func effectiveCapabilities() uint64 {
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			caps, _ := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+			return caps
+		}
+	}
+	return 0
+}
+
+// selfTestTUN creates a TUN device in a network namespace of its own, so that the
+// host is left alone, and passes UDP through it in both directions: a send with
+// segmentation offload that the device splits when reading, and datagrams that it
+// coalesces when writing.
+func selfTestTUN(r *selfTestReport) {
+	ns, err := newSelfTestNetns()
+	if err != nil {
+		r.skip("TUN offloads", fmt.Sprintf("cannot create a network namespace: %v", err))
+		return
+	}
+	defer ns.Close()
+
+	var tdev tun.Device
+	err = inNetns(ns, func() (err error) {
+		tdev, err = tun.CreateTUN(selfTestInterface, device.DefaultMTU)
+		if err != nil {
+			return err
+		}
+		return configureSelfTestInterface(selfTestInterface)
+	})
+	if err != nil {
+		if tdev != nil {
+			tdev.Close()
+		}
+		r.check("TUN device", err)
+		return
+	}
+	defer tdev.Close()
+	vnetHdr, udpGSO := tdev.(*tun.NativeTun).Offloads()
+	r.feature("TUN virtio header (TCP offload)", vnetHdr)
+	r.feature("TUN UDP offload", udpGSO)
+
+	var sock *net.UDPConn
+	err = inNetns(ns, func() (err error) {
+		sock, err = net.ListenUDP("udp4", net.UDPAddrFromAddrPort(netip.AddrPortFrom(selfTestLocal, 0)))
+		return err
+	})
+	if err != nil {
+		r.check("TUN device", err)
+		return
+	}
+	defer sock.Close()
+
+	r.check("TUN read: segmented UDP send split into datagrams", selfTestTUNRead(tdev, sock))
+	r.check("TUN write: UDP datagrams coalesced", selfTestTUNWrite(tdev, sock))
+}
+
+// newSelfTestNetns creates an anonymous network namespace, which lives as long as
+// the returned file.
+func newSelfTestNetns() (*os.File, error) {
+	type result struct {
+		ns  *os.File
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// the thread stays in the new namespace and goes away with this goroutine
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+			runtime.UnlockOSThread()
+			done <- result{nil, err}
+			return
+		}
+		ns, err := os.Open("/proc/thread-self/ns/net")
+		done <- result{ns, err}
+	}()
+	r := <-done
+	return r.ns, r.err
+}
+
+// configureSelfTestInterface assigns selfTestLocal/24 to the interface and brings it
+// up. It must run in the namespace of the interface.
+func configureSelfTestInterface(name string) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	for _, set := range []struct {
+		request uint
+		addr    netip.Addr
+	}{
+		{unix.SIOCSIFADDR, selfTestLocal},
+		{unix.SIOCSIFNETMASK, netip.AddrFrom4([4]byte{255, 255, 255, 0})},
+	} {
+		ifr, err := unix.NewIfreq(name)
+		if err != nil {
+			return err
+		}
+		if err := ifr.SetInet4Addr(set.addr.AsSlice()); err != nil {
+			return err
+		}
+		if err := unix.IoctlIfreq(fd, set.request, ifr); err != nil {
+			return fmt.Errorf("failed to set address: %w", err)
+		}
+	}
+
+	ifr, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("failed to bring interface up: %w", err)
+	}
+	return nil
+}
+
+// selfTestTUNRead sends one buffer with UDP_SEGMENT, which reaches the TUN device as
+// a single packet if it supports UDP offload, and checks that reading yields the
+// individual datagrams.
+func selfTestTUNRead(tdev tun.Device, sock *net.UDPConn) error {
+	const segments, segmentSize = 3, 100
+	const port = 9
+
+	rc, err := sock.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT, segmentSize)
+	}); err != nil {
+		return err
+	}
+	payload := make([]byte, segments*segmentSize)
+	for i := range payload {
+		payload[i] = byte(i / segmentSize)
+	}
+	dst := net.UDPAddrFromAddrPort(netip.AddrPortFrom(selfTestRemote, port))
+	if sockErr == nil {
+		_, err = sock.WriteToUDP(payload, dst)
+	} else {
+		// without UDP_SEGMENT, the kernel predates UDP offload on TUN devices too
+		for i := 0; i < segments && err == nil; i++ {
+			_, err = sock.WriteToUDP(payload[i*segmentSize:(i+1)*segmentSize], dst)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	tdev.File().SetReadDeadline(time.Now().Add(selfTestTimeout))
+	defer tdev.File().SetReadDeadline(time.Time{})
+	const offset = device.MessageTransportHeaderSize
+	bufs := make([][]byte, tdev.BatchSize())
+	for i := range bufs {
+		bufs[i] = make([]byte, device.MaxMessageSize)
+	}
+	sizes := make([]int, len(bufs))
+	var received []byte
+	for datagrams := 0; datagrams < segments; {
+		n, err := tdev.Read(bufs, sizes, offset)
+		if err != nil {
+			return fmt.Errorf("read %d of %d datagrams: %w", datagrams, segments, err)
+		}
+		for i := range n {
+			pkt := bufs[i][offset : offset+sizes[i]]
+			// skip anything else the kernel sends, such as IPv6 router solicitations
+			if len(pkt) < 28 || pkt[0] != 0x45 || pkt[9] != unix.IPPROTO_UDP || binary.BigEndian.Uint16(pkt[22:]) != port {
+				continue
+			}
+			if len(pkt[28:]) != segmentSize {
+				return fmt.Errorf("datagram of %d bytes, want %d", len(pkt[28:]), segmentSize)
+			}
+			received = append(received, pkt[28:]...)
+			datagrams++
+		}
+	}
+	if !bytes.Equal(received, payload) {
+		return errors.New("datagrams corrupted")
+	}
+	return nil
+}
+
+// selfTestTUNWrite writes datagrams of one flow to the TUN device in one batch,
+// which it coalesces into a single packet if it supports UDP offload, and checks
+// that the socket receives them all.
+func selfTestTUNWrite(tdev tun.Device, sock *net.UDPConn) error {
+	const datagrams, size = 3, 100
+	const offset = device.MessageTransportHeaderSize
+	dst := netip.MustParseAddrPort(sock.LocalAddr().String())
+	bufs := make([][]byte, datagrams)
+	for i := range bufs {
+		payload := bytes.Repeat([]byte{byte(i)}, size)
+		pkt := selfTestUDPPacket(netip.AddrPortFrom(selfTestRemote, 9), dst, uint16(i), payload)
+		// leave room for coalescing
+		bufs[i] = make([]byte, offset+len(pkt), device.MaxMessageSize)
+		copy(bufs[i][offset:], pkt)
+	}
+	if _, err := tdev.Write(bufs, offset); err != nil {
+		return err
+	}
+
+	sock.SetReadDeadline(time.Now().Add(selfTestTimeout))
+	b := make([]byte, 2*size)
+	for i := range datagrams {
+		n, err := sock.Read(b)
+		if err != nil {
+			return fmt.Errorf("received %d of %d datagrams: %w", i, datagrams, err)
+		}
+		if !bytes.Equal(b[:n], bytes.Repeat([]byte{byte(i)}, size)) {
+			return errors.New("datagrams corrupted or reordered")
+		}
+	}
+	return nil
+}
+
+// selfTestUDPPacket builds an IPv4 UDP packet with valid checksums.
+func selfTestUDPPacket(src, dst netip.AddrPort, id uint16, payload []byte) []byte {
+	const ipHeaderLen, udpHeaderLen = 20, 8
+	pkt := make([]byte, ipHeaderLen+udpHeaderLen+len(payload))
+	srcIP, dstIP := src.Addr().As4(), dst.Addr().As4()
+
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+	binary.BigEndian.PutUint16(pkt[4:], id)
+	pkt[8] = 64
+	pkt[9] = unix.IPPROTO_UDP
+	copy(pkt[12:], srcIP[:])
+	copy(pkt[16:], dstIP[:])
+	binary.BigEndian.PutUint16(pkt[10:], internetChecksum(pkt[:ipHeaderLen], 0))
+
+	udp := pkt[ipHeaderLen:]
+	binary.BigEndian.PutUint16(udp[0:], src.Port())
+	binary.BigEndian.PutUint16(udp[2:], dst.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+	pseudo := uint32(binary.BigEndian.Uint16(srcIP[:2])) + uint32(binary.BigEndian.Uint16(srcIP[2:])) +
+		uint32(binary.BigEndian.Uint16(dstIP[:2])) + uint32(binary.BigEndian.Uint16(dstIP[2:])) +
+		unix.IPPROTO_UDP + uint32(len(udp))
+	sum := internetChecksum(udp, pseudo)
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+	return pkt
+}
+
+// internetChecksum is the checksum of RFC 1071 over b, starting from sum.
+func internetChecksum(b []byte, sum uint32) uint16 {
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(binary.BigEndian.Uint16(b))
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
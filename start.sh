@@ -99,10 +99,15 @@ start_daemon() {
     print_info "启动 wireguard-go (默认启用 debug 日志)..."
     print_info "日志文件: $(pwd)/wireguard-go.log"
     print_info "日志仅写入文件，不会污染控制台输出"
-    ./wireguard-go utun11 &
+    print_info "启动时直接应用 wg0.conf (修改后可执行 sudo pkill -HUP wireguard-go 重新加载)"
+    ./wireguard-go --config wg0.conf utun11 &
     
     print_info "等待接口创建..."
-    sleep 3
+    # 配置应用完成后才会创建 UAPI socket
+    for _ in $(seq 1 15); do
+        [[ -S "/var/run/wireguard/utun11.sock" ]] && break
+        sleep 1
+    done
     
     # 检查 utun11 接口是否创建成功
     if [[ ! -S "/var/run/wireguard/utun11.sock" ]]; then
@@ -135,8 +140,7 @@ start_daemon() {
 apply_config() {
     print_step "4. 应用配置文件"
     
-    print_info "应用 wg0.conf 到 utun11..."
-    ./$WG_GO_PATH setconf utun11 wg0.conf
+    print_info "wg0.conf 已在守护进程启动时应用"
     
     print_info "检查配置应用结果..."
     CONFIG_OUTPUT=$(./$WG_GO_PATH show utun11)
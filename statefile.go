@@ -0,0 +1,85 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	stateFileVersion         = 1
+	defaultStateSaveInterval = time.Minute
+)
+
+// stateFile is the on-disk format of the learned runtime state, see device.PeerState.
+type stateFile struct {
+	Version   int                `json:"version"`
+	Interface string             `json:"interface"`
+	Saved     time.Time          `json:"saved"`
+	Peers     []device.PeerState `json:"peers"`
+}
+
+// saveState writes the device's learned state to path. The file is replaced
+// atomically, so that a crash while saving leaves the previous state intact.
+func saveState(dev *device.Device, interfaceName, path string) error {
+	data, err := json.MarshalIndent(stateFile{
+		Version:   stateFileVersion,
+		Interface: interfaceName,
+		Saved:     time.Now().UTC(),
+		Peers:     dev.State(),
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(data, '\n'))
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// restoreState applies the state saved at path to the configured peers.
+// A missing file is not an error, as on the very first start.
+func restoreState(dev *device.Device, logger *device.Logger, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var state stateFile
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if state.Version != stateFileVersion {
+		return fmt.Errorf("%s: unsupported version %d", path, state.Version)
+	}
+
+	restored := dev.RestoreState(state.Peers)
+	logger.Verbosef("Restored state of %d of %d peers saved %v", restored, len(state.Peers), state.Saved.Local().Format(time.DateTime))
+	return nil
+}
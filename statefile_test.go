@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestStateFile(t *testing.T) {
+	dir := t.TempDir()
+	config, err := loadConfig(writeTestConfig(t, dir, `
+[Interface]
+PrivateKey = `+testKeyBase64(1)+`
+
+[Peer]
+PublicKey = `+testKeyBase64(2)+`
+PresharedKey = `+testKeyBase64(3)+`
+Endpoint = 127.0.0.1:51820
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := device.NewLogger(device.LogLevelError, "")
+	newDevice := func() *device.Device {
+		dev := device.NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], logger)
+		t.Cleanup(dev.Close)
+		return dev
+	}
+
+	path := filepath.Join(dir, "wg0.state")
+	if err := restoreState(newDevice(), logger, path); err != nil {
+		t.Errorf("missing state file: %v", err)
+	}
+
+	dev := newDevice()
+	if err := dev.IpcSet(config.uapi(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveState(dev, "wg0", path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{testKeyBase64(1), testKeyBase64(3), testKeyHex(1), testKeyHex(3)} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("state file contains key material:\n%s", data)
+		}
+	}
+	if !strings.Contains(string(data), testKeyBase64(2)) || !strings.Contains(string(data), "127.0.0.1:51820") {
+		t.Errorf("state file lacks peer state:\n%s", data)
+	}
+
+	// the peer without a configured endpoint gets it back after a restart
+	restarted := newDevice()
+	if err := restarted.IpcSet("public_key=" + testKeyHex(2) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := restoreState(restarted, logger, path); err != nil {
+		t.Fatal(err)
+	}
+	if states := restarted.State(); len(states) != 1 || states[0].Endpoint != "127.0.0.1:51820" {
+		t.Errorf("unexpected restored state %+v", states)
+	}
+
+	os.WriteFile(path, []byte(`{"version": 99}`), 0o600)
+	if err := restoreState(restarted, logger, path); err == nil {
+		t.Error("expected error restoring unsupported state version")
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !linux && !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"time"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+func sdNotify(state string) error {
+	return nil
+}
+
+func sdNotifyEnabled() bool {
+	return false
+}
+
+func sdWatchdogInterval() time.Duration {
+	return 0
+}
+
+func sdListenFile() *os.File {
+	return nil
+}
+
+func sdUAPIListen(name string, file *os.File) (net.Listener, error) {
+	return ipc.UAPIListen(name, file)
+}
@@ -0,0 +1,82 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+// This file implements the parts of the systemd service protocol that the daemon
+// uses, without linking libsystemd: readiness and watchdog notifications, see
+// sd_notify(3), and socket activation, see sd_listen_fds(3).
+
+const sdListenFDsStart = 3 // SD_LISTEN_FDS_START
+
+// sdNotify sends state, e.g. "READY=1", to the service manager. It does nothing
+// if the daemon was not started by one.
+func sdNotify(state string) error {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return nil
+	}
+	// A leading '@' denotes an abstract socket, which net handles for us.
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdNotifyEnabled reports whether the service manager expects notifications.
+func sdNotifyEnabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// sdWatchdogInterval returns the interval within which the service manager expects
+// WATCHDOG=1 notifications, or zero if the watchdog is disabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 63)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdListenFile returns the UAPI socket passed by the service manager through socket
+// activation, or nil if there is none. The activation variables are removed from the
+// environment, so that they are not inherited by child processes.
+func sdListenFile() *os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil
+	}
+	// Only a single UAPI socket is expected; any further descriptors are ignored.
+	return os.NewFile(sdListenFDsStart, "uapi")
+}
+
+// sdUAPIListen wraps a socket-activated UAPI socket.
+func sdUAPIListen(name string, file *os.File) (net.Listener, error) {
+	return ipc.UAPIListenInherited(name, file)
+}
@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotifySocket emulates the service manager's notification socket.
+func listenNotifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotification(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var buf [256]byte
+	n, err := conn.Read(buf[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func TestSdNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sdNotifyEnabled() {
+		t.Error("notifications enabled without NOTIFY_SOCKET")
+	}
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("notifying without a service manager failed: %v", err)
+	}
+
+	conn := listenNotifySocket(t)
+	if !sdNotifyEnabled() {
+		t.Error("notifications not enabled with NOTIFY_SOCKET")
+	}
+	for _, state := range []string{"READY=1\nSTATUS=Device wg0 running", "WATCHDOG=1"} {
+		if err := sdNotify(state); err != nil {
+			t.Fatal(err)
+		}
+		if got := readNotification(t, conn); got != state {
+			t.Errorf("expected notification %q, got %q", state, got)
+		}
+	}
+}
+
+func TestSdNotifyAbstract(t *testing.T) {
+	name := "@wireguard-go-test-" + strconv.Itoa(os.Getpid())
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", name)
+
+	if err := sdNotify("READY=1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readNotification(t, conn); got != "READY=1" {
+		t.Errorf("unexpected notification %q", got)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec, pid string
+		expected  time.Duration
+	}{
+		{"", "", 0},
+		{"0", "", 0},
+		{"bogus", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", pid, 30 * time.Second},
+		{"30000000", "1", 0},
+	}
+	for _, test := range tests {
+		t.Setenv("WATCHDOG_USEC", test.usec)
+		t.Setenv("WATCHDOG_PID", test.pid)
+		if got := sdWatchdogInterval(); got != test.expected {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: expected %v, got %v", test.usec, test.pid, test.expected, got)
+		}
+	}
+}
+
+func TestSdListenFile(t *testing.T) {
+	// descriptors meant for another process are ignored, but still consumed
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if file := sdListenFile(); file != nil {
+		t.Error("accepted descriptors passed to another process")
+	}
+	if _, ok := os.LookupEnv("LISTEN_FDS"); ok {
+		t.Error("LISTEN_FDS left in the environment")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if file := sdListenFile(); file != nil {
+		t.Error("accepted LISTEN_FDS=0")
+	}
+}
@@ -34,6 +34,7 @@ type NativeTun struct {
 	events                  chan Event // device related events
 	netlinkSock             int
 	netlinkCancel           *rwcancel.RWCancel
+	ioctlSock               int // datagram socket in the network namespace of the interface
 	hackListenerClosed      sync.Mutex
 	statusListenersShutdown chan struct{}
 	batchSize               int
@@ -200,18 +201,7 @@ func (tun *NativeTun) routineNetlinkListener() {
 	}
 }
 
-func getIFIndex(name string) (int32, error) {
-	fd, err := unix.Socket(
-		unix.AF_INET,
-		unix.SOCK_DGRAM|unix.SOCK_CLOEXEC,
-		0,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	defer unix.Close(fd)
-
+func getIFIndex(fd int, name string) (int32, error) {
 	var ifr [ifReqSize]byte
 	copy(ifr[:], name)
 	_, _, errno := unix.Syscall(
@@ -228,23 +218,31 @@ func getIFIndex(name string) (int32, error) {
 	return *(*int32)(unsafe.Pointer(&ifr[unix.IFNAMSIZ])), nil
 }
 
-func (tun *NativeTun) setMTU(n int) error {
-	name, err := tun.Name()
-	if err != nil {
-		return err
-	}
-
-	// open datagram socket
-	fd, err := unix.Socket(
+// createIoctlSocket opens the socket for interface ioctls. Interface names are
+// resolved in the network namespace of the socket, not of the calling thread,
+// so opening it along with the device keeps the ioctls working when the
+// device lives in another namespace than the rest of the process.
+func createIoctlSocket() (int, error) {
+	return unix.Socket(
 		unix.AF_INET,
 		unix.SOCK_DGRAM|unix.SOCK_CLOEXEC,
 		0,
 	)
+}
+
+func (tun *NativeTun) setMTU(n int) error {
+	// Setting the MTU needs CAP_NET_ADMIN, which an unprivileged process handed
+	// the descriptor may lack, so leave an MTU that is already right alone.
+	if mtu, err := tun.MTU(); err == nil && mtu == n {
+		return nil
+	}
+
+	name, err := tun.Name()
 	if err != nil {
 		return err
 	}
 
-	defer unix.Close(fd)
+	fd := tun.ioctlSock
 
 	// do ioctl call
 	var ifr [ifReqSize]byte
@@ -270,17 +268,7 @@ func (tun *NativeTun) MTU() (int, error) {
 		return 0, err
 	}
 
-	// open datagram socket
-	fd, err := unix.Socket(
-		unix.AF_INET,
-		unix.SOCK_DGRAM|unix.SOCK_CLOEXEC,
-		0,
-	)
-	if err != nil {
-		return 0, err
-	}
-
-	defer unix.Close(fd)
+	fd := tun.ioctlSock
 
 	// do ioctl call
 
@@ -491,6 +479,7 @@ func (tun *NativeTun) Close() error {
 			close(tun.events)
 		}
 		err2 = tun.tunFile.Close()
+		unix.Close(tun.ioctlSock)
 	})
 	if err1 != nil {
 		return err1
@@ -502,6 +491,13 @@ func (tun *NativeTun) BatchSize() int {
 	return tun.batchSize
 }
 
+// Offloads reports whether packets are exchanged with the kernel along with a
+// virtio header, which carries TCP segmentation and checksum offload, and
+// whether UDP segmentation offload is enabled on top of it.
+func (tun *NativeTun) Offloads() (vnetHdr, udpGSO bool) {
+	return tun.vnetHdr, tun.udpGSO
+}
+
 const (
 	// TODO: support TSO with ECN bits
 	tunTCPOffloads = unix.TUN_F_CSUM | unix.TUN_F_TSO4 | unix.TUN_F_TSO6
@@ -593,29 +589,40 @@ func CreateTUNFromFile(file *os.File, mtu int) (Device, error) {
 		toWrite:                 make([]int, 0, conn.IdealBatchSize),
 	}
 
+	var err error
+	tun.ioctlSock, err = createIoctlSocket()
+	if err != nil {
+		return nil, err
+	}
+
 	name, err := tun.Name()
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 
 	err = tun.initFromFlags(name)
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 
 	// start event listener
-	tun.index, err = getIFIndex(name)
+	tun.index, err = getIFIndex(tun.ioctlSock, name)
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 
 	tun.netlinkSock, err = createNetlinkSocket()
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 	tun.netlinkCancel, err = rwcancel.NewRWCancel(tun.netlinkSock)
 	if err != nil {
 		unix.Close(tun.netlinkSock)
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 
@@ -626,6 +633,7 @@ func CreateTUNFromFile(file *os.File, mtu int) (Device, error) {
 	err = tun.setMTU(mtu)
 	if err != nil {
 		unix.Close(tun.netlinkSock)
+		unix.Close(tun.ioctlSock)
 		return nil, err
 	}
 
@@ -648,12 +656,18 @@ func CreateUnmonitoredTUNFromFD(fd int) (Device, string, error) {
 		udpGROTable: newUDPGROTable(),
 		toWrite:     make([]int, 0, conn.IdealBatchSize),
 	}
+	tun.ioctlSock, err = createIoctlSocket()
+	if err != nil {
+		return nil, "", err
+	}
 	name, err := tun.Name()
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, "", err
 	}
 	err = tun.initFromFlags(name)
 	if err != nil {
+		unix.Close(tun.ioctlSock)
 		return nil, "", err
 	}
 	return tun, name, err
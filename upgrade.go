@@ -0,0 +1,287 @@
+//go:build !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// upgradeTimeout bounds each step of a live upgrade, so that a new executable that
+// hangs cannot keep the running daemon waiting.
+const upgradeTimeout = 30 * time.Second
+
+// executable is the path the daemon was started from, where a live upgrade finds
+// the new executable. Once the running one is renamed, os.Executable follows it.
+var executable, executableErr = os.Executable()
+
+// upgradeReply is what the new instance reports to the running one.
+type upgradeReply struct {
+	Ready bool   `json:"ready,omitempty"` // started, waiting for the device
+	Done  bool   `json:"done,omitempty"`  // runs the device now
+	Error string `json:"error,omitempty"`
+}
+
+// upgradeState is what the running instance hands over besides the descriptors.
+type upgradeState struct {
+	Device        *device.Handoff `json:"device"`
+	ActivatedUAPI bool            `json:"activated_uapi"` // the UAPI socket belongs to the service manager
+}
+
+// upgrade replaces the daemon with a new instance started from the path of its
+// executable, which may have been replaced since, and hands the device over to it.
+// The TUN device and the UAPI socket are inherited like when daemonizing. Once the
+// new instance is up, the device stops here, and its UDP sockets and state, session
+// keys included, follow over a socket pair, so that peers need no new handshake.
+// Nothing is written to disk. While the device is handed over, uapiPaused is held,
+// so that the UAPI connections accepted from then on are left waiting, and the
+// new instance accepts the following ones. upgrade returns nil once the new
+// instance runs the device, and the caller must then exit without closing the
+// device or the UAPI listener. Otherwise, the device keeps running here.
+func upgrade(dev *device.Device, tunFile, uapiFile *os.File, activatedUAPI bool, uapiPaused *sync.Mutex, logger *device.Logger) error {
+	if executableErr != nil {
+		return fmt.Errorf("failed to determine executable: %w", executableErr)
+	}
+
+	// like os.StartProcess, keep the descriptors from leaking into other children
+	syscall.ForkLock.RLock()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err == nil {
+		unix.CloseOnExec(fds[0])
+		unix.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to create socket pair: %w", err)
+	}
+	remote := os.NewFile(uintptr(fds[1]), "upgrade")
+	defer remote.Close()
+	conn, err := fileUnixConn(os.NewFile(uintptr(fds[0]), "upgrade"))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	env := childEnv(
+		fmt.Sprintf("%s=3", ENV_WG_TUN_FD),
+		fmt.Sprintf("%s=4", ENV_WG_UAPI_FD),
+		fmt.Sprintf("%s=5", ENV_WG_UPGRADE_FD),
+		fmt.Sprintf("%s=1", ENV_WG_PROCESS_FOREGROUND),
+	)
+	// the watchdog applies to whichever process is the main one
+	env = slices.DeleteFunc(env, func(e string) bool {
+		return strings.HasPrefix(e, "WATCHDOG_PID=")
+	})
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devNull.Close()
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Files: []*os.File{devNull, os.Stdout, os.Stderr, tunFile, uapiFile, remote},
+		Dir:   ".",
+		Env:   env,
+	})
+	if err != nil {
+		return err
+	}
+	remote.Close()
+	logger.Verbosef("Live upgrade: started %s as process %d", executable, process.Pid)
+
+	// From the moment it has started, the new instance must not touch the device
+	// unless it takes it over completely.
+	abort := func(err error) error {
+		process.Kill()
+		process.Wait()
+		return err
+	}
+
+	replies := json.NewDecoder(conn)
+	if reply, err := receiveReply(conn, replies); err != nil || !reply.Ready {
+		return abort(fmt.Errorf("new process did not start: %w", unexpectedReply(err)))
+	}
+
+	uapiPaused.Lock()
+	handoff, files, err := dev.PrepareHandoff()
+	if err != nil {
+		uapiPaused.Unlock()
+		return abort(err)
+	}
+	err = sendHandoff(conn, files, &upgradeState{Device: handoff, ActivatedUAPI: activatedUAPI})
+	for _, file := range files {
+		file.Close()
+	}
+	if err == nil {
+		var reply upgradeReply
+		if reply, err = receiveReply(conn, replies); err == nil && !reply.Done {
+			err = unexpectedReply(nil)
+		}
+	}
+	if err != nil {
+		err = abort(fmt.Errorf("new process did not take over: %w", err))
+		if err := dev.ResumeAfterHandoff(); err != nil {
+			logger.Errorf("Failed to resume the device: %v", err)
+		}
+		uapiPaused.Unlock()
+		return err
+	}
+
+	pid := process.Pid
+	process.Release()
+	if err := sdNotify(fmt.Sprintf("MAINPID=%d", pid)); err != nil {
+		logger.Errorf("Failed to notify service manager: %v", err)
+	}
+	logger.Verbosef("Live upgrade: process %d took over the device", pid)
+	return nil
+}
+
+func fileUnixConn(file *os.File) (*net.UnixConn, error) {
+	defer file.Close()
+	c, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	conn, ok := c.(*net.UnixConn)
+	if !ok {
+		c.Close()
+		return nil, errors.New("upgrade descriptor is not a unix socket")
+	}
+	return conn, nil
+}
+
+// receiveReply waits for the next report of the new instance.
+func receiveReply(conn *net.UnixConn, replies *json.Decoder) (upgradeReply, error) {
+	var reply upgradeReply
+	conn.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	if err := replies.Decode(&reply); err != nil {
+		if errors.Is(err, io.EOF) {
+			return reply, errors.New("process exited")
+		}
+		return reply, err
+	}
+	if reply.Error != "" {
+		return reply, errors.New(reply.Error)
+	}
+	return reply, nil
+}
+
+func unexpectedReply(err error) error {
+	if err == nil {
+		return errors.New("unexpected reply")
+	}
+	return err
+}
+
+// sendHandoff passes the UDP sockets along with a single byte, followed by state.
+func sendHandoff(conn *net.UnixConn, files []*os.File, state *upgradeState) error {
+	fds := make([]int, 0, len(files))
+	for _, file := range files {
+		fds = append(fds, int(file.Fd()))
+	}
+	conn.SetWriteDeadline(time.Now().Add(upgradeTimeout))
+	if _, _, err := conn.WriteMsgUnix([]byte{0}, unix.UnixRights(fds...), nil); err != nil {
+		return err
+	}
+	return json.NewEncoder(conn).Encode(state)
+}
+
+// upgradeConn is the end of a live upgrade in the new instance.
+type upgradeConn struct {
+	*net.UnixConn
+}
+
+// openUpgradeConn returns the socket to the running instance that started this one
+// to hand its device over, or nil if this instance was started otherwise.
+func openUpgradeConn() (*upgradeConn, error) {
+	fdStr := os.Getenv(ENV_WG_UPGRADE_FD)
+	if fdStr == "" {
+		return nil, nil
+	}
+	os.Unsetenv(ENV_WG_UPGRADE_FD)
+	fd, err := strconv.ParseUint(fdStr, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := fileUnixConn(os.NewFile(uintptr(fd), "upgrade"))
+	if err != nil {
+		return nil, err
+	}
+	return &upgradeConn{conn}, nil
+}
+
+func (u *upgradeConn) reply(reply upgradeReply) error {
+	u.SetWriteDeadline(time.Now().Add(upgradeTimeout))
+	return json.NewEncoder(u).Encode(reply)
+}
+
+// receive tells the running instance that this one is ready, and returns the state
+// and the UDP sockets it hands over.
+func (u *upgradeConn) receive() (*upgradeState, []*os.File, error) {
+	if err := u.reply(upgradeReply{Ready: true}); err != nil {
+		return nil, nil, err
+	}
+
+	u.SetReadDeadline(time.Now().Add(upgradeTimeout))
+	oob := make([]byte, unix.CmsgSpace(8*4))
+	_, oobn, _, _, err := u.ReadMsgUnix(make([]byte, 1), oob)
+	if err != nil {
+		return nil, nil, err
+	}
+	var files []*os.File
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	for _, msg := range msgs {
+		fds, err := unix.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			unix.CloseOnExec(fd)
+			files = append(files, os.NewFile(uintptr(fd), "udp"))
+		}
+	}
+
+	var state upgradeState
+	if err == nil {
+		err = json.NewDecoder(u).Decode(&state)
+	}
+	if err == nil && state.Device == nil {
+		err = errors.New("no device state")
+	}
+	if err != nil {
+		for _, file := range files {
+			file.Close()
+		}
+		return nil, nil, err
+	}
+	return &state, files, nil
+}
+
+// fail reports to the running instance that this one cannot take the device over.
+func (u *upgradeConn) fail(err error) {
+	u.reply(upgradeReply{Error: err.Error()})
+	u.Close()
+}
+
+// done reports to the running instance that this one runs the device now.
+func (u *upgradeConn) done() error {
+	defer u.Close()
+	return u.reply(upgradeReply{Done: true})
+}